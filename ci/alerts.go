@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	defaultAlertCPUThreshold    = 80
+	defaultAlertMemoryThreshold = 85
+	defaultAlertDiskThreshold   = 90
+	defaultAlertWindow          = "5m"
+	defaultAlertSlackChannel    = "#alerts"
+	maxAlertThreshold           = 100
+	alertPoliciesPerPage        = 200
+)
+
+// alertWindows are the evaluation windows the monitoring API accepts.
+var alertWindows = []string{"5m", "10m", "30m", "1h"}
+
+// alertPolicy describes one utilization alert managed by the pipeline.
+type alertPolicy struct {
+	metricType string
+	label      string
+	threshold  int
+}
+
+func dropletAlertPolicies(config *Config) []alertPolicy {
+	return []alertPolicy{
+		{godo.DropletCPUUtilizationPercent, "CPU utilization", config.alertCPUThreshold},
+		{godo.DropletMemoryUtilizationPercent, "memory utilization", config.alertMemoryThreshold},
+		{godo.DropletDiskUtilizationPercent, "disk utilization", config.alertDiskThreshold},
+	}
+}
+
+// ensureAlertPolicies creates or updates CPU, memory and disk alert policies
+// scoped to the droplet tag, notifying ALERT_EMAIL and SLACK_WEBHOOK_URL.
+func ensureAlertPolicies(ctx context.Context, client *godo.Client, config *Config, droplet *godo.Droplet) error {
+	if config.alertEmail == "" && config.slackWebhook == "" {
+		fmt.Println("Skipping alert policies: neither ALERT_EMAIL nor SLACK_WEBHOOK_URL is set")
+
+		return nil
+	}
+
+	tag := config.resourceName(resourceTag)
+
+	if err := tagAlertedDroplet(ctx, client, droplet, tag); err != nil {
+		return err
+	}
+
+	existing, _, err := client.Monitoring.ListAlertPolicies(ctx, &godo.ListOptions{PerPage: alertPoliciesPerPage})
+	if err != nil {
+		return fmt.Errorf("failed to list alert policies: %w", err)
+	}
+
+	enabled := true
+
+	for _, policy := range dropletAlertPolicies(config) {
+		// Policies are matched by description, which embeds the tag
+		request := &godo.AlertPolicyUpdateRequest{
+			Type:        policy.metricType,
+			Description: fmt.Sprintf("%s %s", tag, policy.label),
+			Compare:     godo.GreaterThan,
+			Value:       float32(policy.threshold),
+			Window:      config.alertWindow,
+			Tags:        []string{tag},
+			Alerts:      alertDestinations(config),
+			Enabled:     &enabled,
+		}
+
+		if err := upsertAlertPolicy(ctx, client, existing, request); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tagAlertedDroplet adds the policies' tag to a droplet created before it
+// was applied at creation, which the policies would otherwise not watch.
+func tagAlertedDroplet(ctx context.Context, client *godo.Client, droplet *godo.Droplet, tag string) error {
+	if slices.Contains(droplet.Tags, tag) {
+		return nil
+	}
+
+	if err := ensureTag(ctx, client, tag); err != nil {
+		return err
+	}
+
+	resource := []godo.Resource{{ID: strconv.Itoa(droplet.ID), Type: godo.DropletResourceType}}
+	if _, err := client.Tags.TagResources(ctx, tag, &godo.TagResourcesRequest{Resources: resource}); err != nil {
+		return fmt.Errorf("failed to tag droplet with %s: %w", tag, err)
+	}
+
+	droplet.Tags = append(droplet.Tags, tag)
+
+	fmt.Printf("Tagged droplet %s with %s for its alert policies\n", droplet.Name, tag)
+
+	return nil
+}
+
+func upsertAlertPolicy(ctx context.Context, client *godo.Client, existing []godo.AlertPolicy,
+	request *godo.AlertPolicyUpdateRequest,
+) error {
+	for i := range existing {
+		if existing[i].Description != request.Description {
+			continue
+		}
+
+		if _, _, err := client.Monitoring.UpdateAlertPolicy(ctx, existing[i].UUID, request); err != nil {
+			return fmt.Errorf("failed to update alert policy %q: %w", request.Description, err)
+		}
+
+		return nil
+	}
+
+	createRequest := godo.AlertPolicyCreateRequest(*request)
+	if _, _, err := client.Monitoring.CreateAlertPolicy(ctx, &createRequest); err != nil {
+		return fmt.Errorf("failed to create alert policy %q: %w", request.Description, err)
+	}
+
+	fmt.Printf("Created alert policy %q\n", request.Description)
+
+	return nil
+}
+
+func alertDestinations(config *Config) godo.Alerts {
+	// The API rejects null lists, so always send empty ones
+	alerts := godo.Alerts{
+		Email: []string{},
+		Slack: []godo.SlackDetails{},
+	}
+
+	if config.alertEmail != "" {
+		alerts.Email = append(alerts.Email, config.alertEmail)
+	}
+
+	if config.slackWebhook != "" {
+		alerts.Slack = append(alerts.Slack, godo.SlackDetails{
+			URL:     config.slackWebhook,
+			Channel: config.alertSlackChannel,
+		})
+	}
+
+	return alerts
+}
+
+func validateAlertConfig(config *Config) error {
+	for _, policy := range dropletAlertPolicies(config) {
+		if policy.threshold <= 0 || policy.threshold > maxAlertThreshold {
+			return fmt.Errorf("%w: %s alert threshold must be between 1 and %d percent, got %d",
+				ErrInvalidConfig, policy.label, maxAlertThreshold, policy.threshold)
+		}
+	}
+
+	if !slices.Contains(alertWindows, config.alertWindow) {
+		return fmt.Errorf("%w: ALERT_WINDOW must be one of %v, got %q", ErrInvalidConfig, alertWindows, config.alertWindow)
+	}
+
+	return nil
+}
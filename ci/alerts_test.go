@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestEnsureAlertPoliciesTagsDroplet(t *testing.T) {
+	tests := []struct {
+		name    string
+		tagged  bool
+		wantTag bool
+	}{
+		{name: "existing droplet without the tag", wantTag: true},
+		{name: "droplet created with the tag", tagged: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := defaultTestConfig(t)
+			config.alertEmail = "ops@example.com"
+			tag := config.resourceName(resourceTag)
+			tagResources := "POST /v2/tags/" + tag + "/resources"
+
+			droplet := &godo.Droplet{ID: 42, Name: config.resourceName(resourceDroplet), Tags: []string{managedTag}}
+			if test.tagged {
+				droplet.Tags = append(droplet.Tags, tag)
+			}
+
+			fake, client := newFakeDO(t, config, map[string]string{
+				"GET /v2/tags/" + tag:        `{"tag":{"name":"` + tag + `"}}`,
+				tagResources:                 ``,
+				"GET /v2/monitoring/alerts":  `{"policies":[]}`,
+				"POST /v2/monitoring/alerts": `{"policy":{}}`,
+			})
+
+			if err := ensureAlertPolicies(context.Background(), client, config, droplet); err != nil {
+				t.Fatal(err)
+			}
+
+			if tagged := slices.Contains(fake.mutations(), tagResources); tagged != test.wantTag {
+				t.Fatalf("tagged the droplet = %t, want %t (requests %v)", tagged, test.wantTag, fake.mutations())
+			}
+
+			if test.wantTag && !strings.Contains(fake.body(tagResources), `"resource_id":"42"`) {
+				t.Errorf("tag request = %s, want droplet 42", fake.body(tagResources))
+			}
+
+			if !slices.Contains(droplet.Tags, tag) {
+				t.Errorf("droplet tags = %v, want %s", droplet.Tags, tag)
+			}
+
+			if !strings.Contains(fake.body("POST /v2/monitoring/alerts"), `"tags":["`+tag+`"]`) {
+				t.Errorf("alert policy = %s, want it scoped to %s", fake.body("POST /v2/monitoring/alerts"), tag)
+			}
+		})
+	}
+}
+
+func TestEnsureAlertPoliciesSkippedWithoutDestination(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.alertEmail = ""
+	config.slackWebhook = ""
+
+	fake, client := newFakeDO(t, config, map[string]string{})
+
+	if err := ensureAlertPolicies(context.Background(), client, config, &godo.Droplet{ID: 42}); err != nil {
+		t.Fatal(err)
+	}
+
+	if mutations := fake.mutations(); len(mutations) != 0 {
+		t.Errorf("requests = %v, want none", mutations)
+	}
+}
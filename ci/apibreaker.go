@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	defaultAPIFailureThreshold = 5
+
+	// exitAPIUnhealthy tells a calling workflow the run stopped because of
+	// DigitalOcean rather than the deployment.
+	exitAPIUnhealthy = 4
+)
+
+var ErrAPIUnhealthy = errors.New("DigitalOcean API appears unhealthy")
+
+// apiBreaker stops a run from grinding through every step's retries during a
+// DigitalOcean outage. Once DO_API_FAILURE_THRESHOLD calls in a row fail with
+// a network error, 429 or 5xx, every further call fails immediately. godo
+// retries each call itself first, so a failure here is already persistent.
+type apiBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	failures  int
+	last      string
+}
+
+func (b *apiBreaker) check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+
+	return &exitCodeError{code: exitAPIUnhealthy, err: fmt.Errorf(
+		"%w: %d consecutive API calls failed, last with %s; check https://status.digitalocean.com",
+		ErrAPIUnhealthy, b.failures, b.last)}
+}
+
+func (b *apiBreaker) record(resp *http.Response, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case err != nil:
+		b.failures++
+		b.last = err.Error()
+	case isRetryableStatus(resp.StatusCode):
+		b.failures++
+		b.last = resp.Status
+	default:
+		b.failures = 0
+	}
+}
+
+// breakerTransport routes a client's requests through the run's breaker.
+type breakerTransport struct {
+	breaker *apiBreaker
+	next    http.RoundTripper
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.check(); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	t.breaker.record(resp, err)
+
+	return resp, err
+}
+
+// newDOClient returns a DigitalOcean client whose calls count towards the
+// run's circuit breaker. Every client of a run shares it. Under DRY_RUN the
+// client can only read.
+func newDOClient(config *Config) *godo.Client {
+	client := godo.NewFromToken(config.doToken)
+
+	next := client.HTTPClient.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	if config.dryRun {
+		next = &readOnlyTransport{next: next}
+	}
+
+	client.HTTPClient.Transport = &breakerTransport{breaker: config.apiBreaker, next: next}
+
+	return client
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/felipepimentel/n8n-digitalocean-cicd/ci/ssh"
+)
+
+const (
+	backupDir           = "/opt/n8n/backups"
+	snapshotPollDelay   = 15 * time.Second
+	snapshotTimeout     = 30 * time.Minute
+	actionStatusDone    = "completed"
+	actionStatusFailure = "errored"
+)
+
+var ErrSnapshotFailed = errors.New("droplet snapshot failed")
+
+// deployBackup references what backupBeforeDeploy captured.
+type deployBackup struct {
+	dumpPath     string
+	snapshotName string
+}
+
+// backupBeforeDeploy dumps the database (and optionally snapshots the droplet)
+// so the deploy that follows can be reverted. It returns nil on fresh installs
+// where there is no database to back up yet. Each backup is recorded in the
+// deploy state as soon as it exists, see recordPreDeployBackup.
+func backupBeforeDeploy(ctx context.Context, sshClient *ssh.Client, config *Config,
+	previous *deployState,
+) (*deployBackup, error) {
+	if !config.backupBeforeDeploy {
+		return nil, nil
+	}
+
+	output, err := sshClient.ExecuteCommand(generatePreDeployBackupScript(config))
+	if err != nil {
+		return nil, fmt.Errorf("pre-deploy backup failed: %w\nOutput: %s", err, output)
+	}
+
+	dumpPath := strings.TrimSpace(output)
+	if dumpPath == "" {
+		fmt.Println("Skipping pre-deploy backup: no database yet")
+
+		return nil, nil
+	}
+
+	fmt.Printf("Pre-deploy database backup written to %s\n", dumpPath)
+
+	backup := &deployBackup{dumpPath: dumpPath}
+
+	if err := recordPreDeployBackup(sshClient, previous, backup); err != nil {
+		return nil, err
+	}
+
+	if config.backupSnapshot {
+		if backup.snapshotName, err = snapshotDroplet(ctx, config); err != nil {
+			return nil, err
+		}
+
+		if err := recordPreDeployBackup(sshClient, previous, backup); err != nil {
+			return nil, err
+		}
+	}
+
+	return backup, nil
+}
+
+// recordPreDeployBackup writes the backup into the last deploy's state right
+// away, so a deploy that fails or is interrupted afterwards still leaves a
+// pointer to it. A successful deploy replaces the state with its own, which
+// keeps the backup.
+func recordPreDeployBackup(sshClient *ssh.Client, previous *deployState, backup *deployBackup) error {
+	state := deployState{}
+	if previous != nil {
+		state = *previous
+	}
+
+	state.BackupPath = backup.dumpPath
+	state.BackupSnapshot = backup.snapshotName
+
+	return writeDeployState(sshClient, &state)
+}
+
+// generatePreDeployBackupScript prints the dump path on success and nothing
+// when the database container does not exist.
+func generatePreDeployBackupScript(config *Config) string {
+	return "set -e -o pipefail\n" + generateDatabaseDumpScript(config, "pre-deploy") + "\necho \"$FILE\""
+}
+
+// snapshotDroplet takes a droplet snapshot and waits for it to complete.
+func snapshotDroplet(ctx context.Context, config *Config) (string, error) {
+	client := newDOClient(config)
+
+	droplet, err := findDroplet(ctx, client, config.region, config.resourceName(resourceDroplet))
+	if err != nil {
+		return "", err
+	}
+
+	if droplet == nil {
+		return "", fmt.Errorf("%w: %s", ErrDropletNotFound, config.resourceName(resourceDroplet))
+	}
+
+	name := fmt.Sprintf("%s-pre-deploy-%s", droplet.Name, time.Now().UTC().Format("20060102T150405Z"))
+
+	action, _, err := client.DropletActions.Snapshot(ctx, droplet.ID, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to snapshot droplet: %w", err)
+	}
+
+	fmt.Printf("Taking pre-deploy snapshot %s...\n", name)
+
+	deadline := time.Now().Add(snapshotTimeout)
+
+	for action.Status != actionStatusDone {
+		if action.Status == actionStatusFailure {
+			return "", fmt.Errorf("%w: %s", ErrSnapshotFailed, name)
+		}
+
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("%w: %s did not complete within %s", ErrSnapshotFailed, name, snapshotTimeout)
+		}
+
+		time.Sleep(snapshotPollDelay)
+
+		if action, _, err = client.Actions.Get(ctx, action.ID); err != nil {
+			return "", fmt.Errorf("failed to check snapshot status: %w", err)
+		}
+	}
+
+	return name, nil
+}
+
+func validateBackupConfig(config *Config) error {
+	if config.backupRetentionDays < 1 {
+		return fmt.Errorf("%w: BACKUP_RETENTION_DAYS must be at least 1, got %d", ErrInvalidConfig, config.backupRetentionDays)
+	}
+
+	if config.backupSnapshot && !config.backupBeforeDeploy {
+		return fmt.Errorf("%w: BACKUP_SNAPSHOT requires BACKUP_BEFORE_DEPLOY=true", ErrInvalidConfig)
+	}
+
+	return validateBackupSchedule(config)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Scheduled backups run from root's cron on the droplet. The credentials file
+// lives outside /opt/n8n, which the deploy hands over to the n8n user.
+const (
+	backupScriptPath = "/usr/local/bin/n8n-backup"
+	backupCronPath   = "/etc/cron.d/n8n-backup"
+	backupEnvPath    = "/etc/n8n-backup.env"
+	backupLogPath    = "/var/log/n8n-backup.log"
+
+	defaultSpacesRegion = "nyc3"
+
+	// spacesCLIImage uploads to Spaces through its S3-compatible API, which
+	// expects requests signed for us-east-1 whatever the Spaces region
+	spacesCLIImage      = "amazon/aws-cli:2.17.0"
+	spacesSigningRegion = "us-east-1"
+)
+
+// cronSchedulePattern accepts five cron fields or a macro such as @daily.
+var cronSchedulePattern = regexp.MustCompile(`^(@(hourly|daily|weekly|monthly|yearly|annually)|` +
+	`[0-9A-Za-z*,/-]+( [0-9A-Za-z*,/-]+){4})$`)
+
+// generateDatabaseDumpScript dumps the database to backupDir as
+// PREFIX-TIMESTAMP.sql.gz, leaving the path in $FILE, and prunes dumps with
+// the same prefix past the retention. It exits quietly when there is no
+// database container yet.
+func generateDatabaseDumpScript(config *Config, prefix string) string {
+	return fmt.Sprintf(`DB=$(docker ps -q %[1]s | head -n 1)
+if [ -z "$DB" ]; then
+	exit 0
+fi
+mkdir -p %[2]s
+FILE=%[2]s/%[3]s-$(date -u +%%Y%%m%%dT%%H%%M%%SZ).sql.gz
+docker exec "$DB" pg_dump -U n8n -d n8n | gzip > "$FILE"
+find %[2]s -name '%[3]s-*.sql.gz' -mtime +%[4]d -delete`,
+		serviceContainerFilter(config, "db"), backupDir, prefix, config.backupRetentionDays)
+}
+
+// generateScheduledBackupScript is the script cron runs: the deploy's dump
+// routine, then an upload to Spaces and a prune of the bucket when
+// SPACES_BUCKET is set.
+func generateScheduledBackupScript(config *Config) string {
+	script := fmt.Sprintf(`#!/bin/bash
+set -e -o pipefail
+echo "[$(date -u +%%FT%%TZ)] starting backup"
+%s
+echo "[$(date -u +%%FT%%TZ)] wrote $FILE"
+`, generateDatabaseDumpScript(config, "scheduled"))
+
+	if config.spacesBucket == "" {
+		return script
+	}
+
+	return script + fmt.Sprintf(`
+aws() {
+	docker run --rm --env-file %[1]s -v %[2]s:%[2]s:ro %[3]s --endpoint-url %[4]s "$@"
+}
+DEST=s3://%[5]s/%[6]s
+aws s3 cp "$FILE" "$DEST/$(basename "$FILE")"
+echo "[$(date -u +%%FT%%TZ)] uploaded to $DEST"
+CUTOFF=$(date -u -d '%[7]d days ago' +%%Y-%%m-%%d)
+aws s3 ls "$DEST/" | while read -r day _ _ name; do
+	if [[ "$name" == scheduled-*.sql.gz && "$day" < "$CUTOFF" ]]; then
+		aws s3 rm "$DEST/$name"
+	fi
+done
+`, backupEnvPath, backupDir, spacesCLIImage, spacesEndpoint(config), config.spacesBucket, config.composeProject,
+		config.backupRetentionDays)
+}
+
+// generateBackupCronFile schedules the backup as root, logging to
+// backupLogPath.
+func generateBackupCronFile(config *Config) string {
+	return fmt.Sprintf("%s root %s >> %s 2>&1\n", config.backupCron, backupScriptPath, backupLogPath)
+}
+
+// generateBackupSchedule installs or removes the scheduled backup.
+func generateBackupSchedule(config *Config) string {
+	if config.backupCron == "" {
+		return fmt.Sprintf("\n# No BACKUP_CRON configured\nrm -f %s %s %s", backupCronPath, backupScriptPath, backupEnvPath)
+	}
+
+	schedule := fmt.Sprintf(`
+# Install the scheduled backup
+cat > %[1]s << 'N8N_BACKUP'
+%[2]sN8N_BACKUP
+chmod 700 %[1]s
+cat > %[3]s << 'N8N_BACKUP'
+%[4]sN8N_BACKUP
+chmod 644 %[3]s`, backupScriptPath, generateScheduledBackupScript(config), backupCronPath, generateBackupCronFile(config))
+
+	if config.spacesBucket == "" {
+		return schedule + "\nrm -f " + backupEnvPath
+	}
+
+	return schedule + fmt.Sprintf(`
+# Spaces credentials, restricted before they are written
+touch %[1]s
+chmod 600 %[1]s
+cat > %[1]s << 'N8N_BACKUP'
+AWS_ACCESS_KEY_ID=%[2]s
+AWS_SECRET_ACCESS_KEY=%[3]s
+AWS_DEFAULT_REGION=%[4]s
+N8N_BACKUP`, backupEnvPath, config.spacesAccessKey, config.spacesSecretKey, spacesSigningRegion)
+}
+
+func validateBackupSchedule(config *Config) error {
+	if config.backupCron == "" {
+		if config.spacesBucket != "" {
+			return fmt.Errorf("%w: SPACES_BUCKET requires BACKUP_CRON", ErrInvalidConfig)
+		}
+
+		return nil
+	}
+
+	if !cronSchedulePattern.MatchString(config.backupCron) {
+		return fmt.Errorf("%w: BACKUP_CRON %q must be five cron fields or a macro such as @daily",
+			ErrInvalidConfig, config.backupCron)
+	}
+
+	if config.spacesBucket == "" {
+		return nil
+	}
+
+	if config.spacesAccessKey == "" || config.spacesSecretKey == "" {
+		return fmt.Errorf("%w: SPACES_BUCKET requires SPACES_ACCESS_KEY_ID and SPACES_SECRET_ACCESS_KEY", ErrInvalidConfig)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateBackupSchedule(t *testing.T) {
+	tests := []struct {
+		name      string
+		cron      string
+		bucket    string
+		accessKey string
+		secretKey string
+		valid     bool
+	}{
+		{"no schedule", "", "", "", "", true},
+		{"daily at three", "0 3 * * *", "", "", "", true},
+		{"steps and lists", "*/15 1,13 * * mon-fri", "", "", "", true},
+		{"macro", "@daily", "", "", "", true},
+		{"annually", "@annually", "", "", "", true},
+		{"with Spaces", "@weekly", "n8n-backups", "key", "secret", true},
+		{"four fields", "0 3 * *", "", "", "", false},
+		{"six fields", "0 0 3 * * *", "", "", "", false},
+		{"unknown macro", "@reboot", "", "", "", false},
+		{"shell injection", "0 3 * * *; rm -rf /", "", "", "", false},
+		{"newline", "0 3 * * *\n* * * * * root id", "", "", "", false},
+		{"Spaces without a schedule", "", "n8n-backups", "key", "secret", false},
+		{"Spaces without a secret key", "@daily", "n8n-backups", "key", "", false},
+		{"Spaces without an access key", "@daily", "n8n-backups", "", "secret", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := defaultTestConfig(t)
+			config.backupCron = test.cron
+			config.spacesBucket = test.bucket
+			config.spacesAccessKey = test.accessKey
+			config.spacesSecretKey = test.secretKey
+
+			err := validateBackupSchedule(config)
+			if test.valid && err != nil {
+				t.Errorf("validateBackupSchedule() = %v, want nil", err)
+			}
+
+			if !test.valid && !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("validateBackupSchedule() = %v, want ErrInvalidConfig", err)
+			}
+		})
+	}
+}
+
+func TestBackupScheduleInstallsOrRemovesTheCronJob(t *testing.T) {
+	config := defaultTestConfig(t)
+
+	if schedule := generateBackupSchedule(config); !strings.Contains(schedule, "rm -f "+backupCronPath) {
+		t.Errorf("without BACKUP_CRON the cron job is not removed:\n%s", schedule)
+	}
+
+	config.backupCron = "0 3 * * *"
+
+	want := "0 3 * * * root " + backupScriptPath + " >> " + backupLogPath + " 2>&1\n"
+	if cron := generateBackupCronFile(config); cron != want {
+		t.Errorf("cron file = %q, want %q", cron, want)
+	}
+
+	if schedule := generateBackupSchedule(config); !strings.Contains(schedule, "rm -f "+backupEnvPath) {
+		t.Errorf("without SPACES_BUCKET the Spaces credentials are not removed:\n%s", schedule)
+	}
+}
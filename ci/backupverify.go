@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+const (
+	verifyDumpPath      = "/backup.sql.gz"
+	verifyDBPassword    = "verify-backup"
+	postgresPort        = 5432
+	scheduledDumpPrefix = "scheduled-"
+	scheduledDumpExt    = ".sql.gz"
+	verifyDBHost        = "db"
+	verifyDBReadyLimit  = 60
+)
+
+var ErrBackupNotFound = errors.New("backup not found")
+
+// verifyBackupTables are the n8n tables every restorable dump contains; their
+// row counts are the sanity check.
+var verifyBackupTables = []string{"workflow_entity", "credentials_entity", "execution_entity"}
+
+// runVerifyBackup restores a scheduled backup from Spaces into a throwaway
+// Postgres started by Dagger and counts the rows of the key n8n tables. The
+// database only lives for the Dagger session, so nothing is left behind.
+func runVerifyBackup(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("verify-backup", flag.ExitOnError)
+	backup := flags.String("backup", "", "backup object to verify, such as scheduled-20240101T000000Z.sql.gz (default: newest)")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config := loadConfig()
+	if config.spacesBucket == "" || config.spacesAccessKey == "" || config.spacesSecretKey == "" {
+		return fmt.Errorf("%w: SPACES_BUCKET, SPACES_ACCESS_KEY_ID and SPACES_SECRET_ACCESS_KEY", ErrEnvVarNotSet)
+	}
+
+	client, err := connectDagger(ctx, &config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	spaces := spacesCLI(client, &config)
+
+	name := *backup
+	if name == "" {
+		if name, err = newestScheduledBackup(ctx, spaces, &config); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Verifying %s/%s/%s\n", config.spacesBucket, config.composeProject, name)
+
+	dump := spaces.
+		WithExec([]string{"s3", "cp", spacesBackupPrefix(&config) + name, verifyDumpPath}).
+		File(verifyDumpPath)
+
+	counts, err := restoreBackup(ctx, client, dump)
+	if err != nil {
+		fmt.Printf("[FAIL] %s: %v\n", name, err)
+
+		return &exitCodeError{code: 1}
+	}
+
+	fmt.Printf("[PASS] %s restored: %s\n", name, counts)
+
+	return nil
+}
+
+// spacesCLI is the aws-cli the scheduled backups upload with, pointed at
+// Spaces. Every run gets a fresh value so Dagger does not serve a cached
+// listing or download.
+func spacesCLI(client *dagger.Client, config *Config) *dagger.Container {
+	return client.Container().
+		From(spacesCLIImage).
+		WithSecretVariable("AWS_ACCESS_KEY_ID", client.SetSecret("spaces_access_key", config.spacesAccessKey)).
+		WithSecretVariable("AWS_SECRET_ACCESS_KEY", client.SetSecret("spaces_secret_key", config.spacesSecretKey)).
+		WithEnvVariable("AWS_DEFAULT_REGION", spacesSigningRegion).
+		WithEnvVariable(cacheBusterVar, strconv.FormatInt(time.Now().UnixNano(), 10)).
+		WithEntrypoint([]string{"aws", "--endpoint-url", spacesEndpoint(config)})
+}
+
+func spacesEndpoint(config *Config) string {
+	return fmt.Sprintf("https://%s.digitaloceanspaces.com", config.spacesRegion)
+}
+
+// spacesBackupPrefix is where the scheduled backups of this deployment are
+// uploaded.
+func spacesBackupPrefix(config *Config) string {
+	return fmt.Sprintf("s3://%s/%s/", config.spacesBucket, config.composeProject)
+}
+
+// newestScheduledBackup picks the latest scheduled dump in the deployment's
+// Spaces prefix.
+func newestScheduledBackup(ctx context.Context, spaces *dagger.Container, config *Config) (string, error) {
+	listing, err := spaces.WithExec([]string{"s3", "ls", spacesBackupPrefix(config)}).Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list backups in %s: %w", spacesBackupPrefix(config), err)
+	}
+
+	newest := newestScheduledDump(listing)
+	if newest == "" {
+		return "", fmt.Errorf("%w: no scheduled backups in %s", ErrBackupNotFound, spacesBackupPrefix(config))
+	}
+
+	return newest, nil
+}
+
+// newestScheduledDump returns the latest scheduled dump named in an aws s3 ls
+// listing, or "". Their UTC timestamps sort lexically.
+func newestScheduledDump(listing string) string {
+	newest := ""
+
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		name := fields[len(fields)-1]
+		if strings.HasPrefix(name, scheduledDumpPrefix) && strings.HasSuffix(name, scheduledDumpExt) && name > newest {
+			newest = name
+		}
+	}
+
+	return newest
+}
+
+// restoreBackup loads dump into a fresh Postgres service, stopping at the
+// first SQL error, and returns the row counts of verifyBackupTables.
+func restoreBackup(ctx context.Context, client *dagger.Client, dump *dagger.File) (string, error) {
+	db := client.Container().
+		From(postgresImage).
+		WithEnvVariable("POSTGRES_DB", "n8n").
+		WithEnvVariable("POSTGRES_USER", "n8n").
+		WithEnvVariable("POSTGRES_PASSWORD", verifyDBPassword).
+		WithExposedPort(postgresPort).
+		AsService()
+
+	counts := make([]string, 0, len(verifyBackupTables))
+	for _, table := range verifyBackupTables {
+		counts = append(counts, fmt.Sprintf("SELECT '%[1]s=' || count(*) FROM %[1]s", table))
+	}
+
+	script := fmt.Sprintf(`set -e -o pipefail
+for i in $(seq %[1]d); do pg_isready -q -h %[2]s -U n8n && break; sleep 1; done
+gunzip -c %[3]s | psql -q -v ON_ERROR_STOP=1 -h %[2]s -U n8n -d n8n > /dev/null
+psql -At -v ON_ERROR_STOP=1 -h %[2]s -U n8n -d n8n -c "%[4]s"`,
+		verifyDBReadyLimit, verifyDBHost, verifyDumpPath, strings.Join(counts, " UNION ALL "))
+
+	output, err := client.Container().
+		From(postgresImage).
+		WithServiceBinding(verifyDBHost, db).
+		WithEnvVariable("PGPASSWORD", verifyDBPassword).
+		WithMountedFile(verifyDumpPath, dump).
+		WithExec([]string{"bash", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(strings.Fields(output), " "), nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestNewestScheduledDump(t *testing.T) {
+	tests := []struct {
+		name    string
+		listing string
+		want    string
+	}{
+		{"empty", "", ""},
+		{"blank lines", "\n\n", ""},
+		{
+			"newest by timestamp",
+			"2024-01-02 03:00:05   1024 scheduled-20240102T030000Z.sql.gz\n" +
+				"2024-01-10 03:00:05   2048 scheduled-20240110T030000Z.sql.gz\n" +
+				"2024-01-05 03:00:05   1536 scheduled-20240105T030000Z.sql.gz\n",
+			"scheduled-20240110T030000Z.sql.gz",
+		},
+		{
+			"ignores other objects",
+			"2024-01-02 03:00:05   1024 scheduled-20240102T030000Z.sql.gz\n" +
+				"2024-02-01 10:00:00   4096 pre-deploy-20240201T100000Z.sql.gz\n" +
+				"2024-03-01 10:00:00    100 scheduled-20240301T100000Z.txt\n" +
+				"                           PRE archive/\n",
+			"scheduled-20240102T030000Z.sql.gz",
+		},
+		{"only other objects", "2024-02-01 10:00:00   4096 notes.txt\n", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := newestScheduledDump(test.listing); got != test.want {
+				t.Errorf("newestScheduledDump() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSpacesLocations(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.spacesRegion = "fra1"
+	config.spacesBucket = "n8n-backups"
+	config.composeProject = "n8n-staging"
+
+	if got, want := spacesEndpoint(config), "https://fra1.digitaloceanspaces.com"; got != want {
+		t.Errorf("spacesEndpoint() = %q, want %q", got, want)
+	}
+
+	if got, want := spacesBackupPrefix(config), "s3://n8n-backups/n8n-staging/"; got != want {
+		t.Errorf("spacesBackupPrefix() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/felipepimentel/n8n-digitalocean-cicd/ci/ssh"
+)
+
+const (
+	defaultBasicAuthPass      = "n8n-admin"
+	minBasicAuthPassLength    = 12
+	generatedBasicAuthPassLen = 24
+)
+
+var ErrWeakBasicAuthPass = errors.New("N8N_BASIC_AUTH_PASS is the default or too weak for a public instance")
+
+// weakBasicAuthPasswords are the default and the placeholders from our docs
+// and examples, compared case-insensitively.
+var weakBasicAuthPasswords = []string{
+	defaultBasicAuthPass,
+	"admin",
+	"password",
+	"changeme",
+	"change-me",
+	"your-secure-pass",
+	"strongpassword",
+	"123456789012",
+}
+
+// ensureBasicAuthPassword generates a password in place of the default when
+// GENERATE_BASIC_AUTH_PASS is set, otherwise checks the configured one.
+// A generated password is only surfaced once the deploy has checked the
+// droplet for one, see reuseBasicAuthPassword.
+func ensureBasicAuthPassword(config *Config) error {
+	if basicAuthPassWillBeGenerated(config) {
+		password := make([]byte, generatedBasicAuthPassLen)
+		if _, err := rand.Read(password); err != nil {
+			return fmt.Errorf("failed to generate basic auth password: %w", err)
+		}
+
+		config.basicAuthPass = base64.RawURLEncoding.EncodeToString(password)
+		config.basicAuthPassGenerated = true
+
+		return nil
+	}
+
+	return checkBasicAuthPassword(config)
+}
+
+// basicAuthPassWillBeGenerated reports whether GENERATE_BASIC_AUTH_PASS
+// replaces the default password.
+func basicAuthPassWillBeGenerated(config *Config) bool {
+	return config.basicAuthPass == defaultBasicAuthPass && config.generateBasicAuthPass
+}
+
+// checkBasicAuthPassword warns about a default or weak password, since n8n is
+// published on N8N_DOMAIN, and refuses to deploy it unless
+// ALLOW_DEFAULT_PASSWORD is set.
+func checkBasicAuthPassword(config *Config) error {
+	reason := weakBasicAuthPassReason(config.basicAuthUser, config.basicAuthPass)
+	if reason == "" {
+		return nil
+	}
+
+	fmt.Println("********************************************************************")
+	fmt.Printf("Warning: the basic auth password %s.\n", reason)
+	fmt.Printf("Anyone who finds %s can log in with well-known credentials.\n", n8nBaseURL(config))
+	fmt.Println("Set N8N_BASIC_AUTH_PASS, or GENERATE_BASIC_AUTH_PASS=true to create one.")
+	fmt.Println("********************************************************************")
+
+	if config.allowDefaultPassword {
+		fmt.Println("Deploying it anyway because ALLOW_DEFAULT_PASSWORD is set")
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s (set ALLOW_DEFAULT_PASSWORD=true to deploy it anyway)", ErrWeakBasicAuthPass, reason)
+}
+
+// reuseBasicAuthPassword keeps the password a previous run generated for the
+// droplet, the way the database password is carried over, so every deploy
+// with GENERATE_BASIC_AUTH_PASS doesn't change the login. Only a password
+// the droplet doesn't have yet is surfaced.
+func reuseBasicAuthPassword(sshClient *ssh.Client, config *Config) error {
+	if !config.basicAuthPassGenerated {
+		return nil
+	}
+
+	output, err := sshClient.ExecuteCommand(
+		`sed -n 's/^N8N_BASIC_AUTH_PASSWORD=//p' /opt/n8n/.env 2>/dev/null | head -n 1`)
+	if err != nil {
+		return fmt.Errorf("failed to read the existing basic auth password: %w\nOutput: %s", err, output)
+	}
+
+	if adoptStoredBasicAuthPassword(config, output) {
+		fmt.Println("Reusing the basic auth password already on the droplet")
+
+		return nil
+	}
+
+	return surfaceGeneratedSecret("N8N_BASIC_AUTH_PASS", config.basicAuthPass)
+}
+
+// adoptStoredBasicAuthPassword replaces the generated password with the one
+// stored in the droplet's .env, reporting whether there was one to reuse.
+func adoptStoredBasicAuthPassword(config *Config, stored string) bool {
+	config.basicAuthPassGenerated = false
+
+	existing := strings.TrimSpace(stored)
+	if existing == "" || existing == defaultBasicAuthPass {
+		return false
+	}
+
+	config.basicAuthPass = existing
+
+	return true
+}
+
+// weakBasicAuthPassReason explains why password is weak, or returns "".
+func weakBasicAuthPassReason(user, password string) string {
+	switch {
+	case password == defaultBasicAuthPass:
+		return "is the default " + defaultBasicAuthPass
+	case len(password) < minBasicAuthPassLength:
+		return fmt.Sprintf("is shorter than %d characters", minBasicAuthPassLength)
+	case strings.EqualFold(password, user):
+		return "is the username"
+	case strings.Count(password, password[:1]) == len(password):
+		return "repeats a single character"
+	}
+
+	for _, weak := range weakBasicAuthPasswords {
+		if strings.EqualFold(password, weak) {
+			return "is a documented placeholder"
+		}
+	}
+
+	return ""
+}
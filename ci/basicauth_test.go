@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEnsureBasicAuthPasswordRefusesUnlessDefaultIsAllowed(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.basicAuthPass = defaultBasicAuthPass
+
+	if err := ensureBasicAuthPassword(config); !errors.Is(err, ErrWeakBasicAuthPass) {
+		t.Fatalf("err = %v, want ErrWeakBasicAuthPass for the default password", err)
+	}
+
+	config.allowDefaultPassword = true
+
+	if err := ensureBasicAuthPassword(config); err != nil {
+		t.Fatalf("ALLOW_DEFAULT_PASSWORD should only warn: %v", err)
+	}
+}
+
+func TestEnsureBasicAuthPasswordAcceptsAStrongOne(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.basicAuthPass = "a-long-test-password"
+
+	if err := ensureBasicAuthPassword(config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnsureBasicAuthPasswordDefersSurfacingAGeneratedOne(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.basicAuthPass = defaultBasicAuthPass
+	config.generateBasicAuthPass = true
+
+	if err := ensureBasicAuthPassword(config); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.basicAuthPass == defaultBasicAuthPass || !config.basicAuthPassGenerated {
+		t.Fatal("no password was generated in place of the default")
+	}
+
+	if reason := weakBasicAuthPassReason(config.basicAuthUser, config.basicAuthPass); reason != "" {
+		t.Errorf("the generated password %s", reason)
+	}
+}
+
+func TestReusedBasicAuthPasswordIsWrittenToTheEnvFile(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.basicAuthPass = defaultBasicAuthPass
+	config.generateBasicAuthPass = true
+
+	if err := ensureBasicAuthPassword(config); err != nil {
+		t.Fatal(err)
+	}
+
+	generated := config.basicAuthPass
+
+	if !adoptStoredBasicAuthPassword(config, "stored-password-1234\n") {
+		t.Fatal("the stored password was not reused")
+	}
+
+	script := generateDeploymentScript(config)
+	if !strings.Contains(script, "N8N_BASIC_AUTH_PASSWORD=stored-password-1234\n") {
+		t.Error("the .env does not carry the stored password")
+	}
+
+	if strings.Contains(script, generated) {
+		t.Error("the .env still carries the newly generated password")
+	}
+}
+
+func TestStoredDefaultBasicAuthPasswordIsNotReused(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.basicAuthPass = "generated-password-1234"
+	config.basicAuthPassGenerated = true
+
+	if adoptStoredBasicAuthPassword(config, defaultBasicAuthPass+"\n") {
+		t.Error("the default password was reused")
+	}
+
+	if config.basicAuthPass != "generated-password-1234" || config.basicAuthPassGenerated {
+		t.Error("the generated password was not kept")
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultBatchConcurrency = 2
+
+	// batchPushLockVar names the file the environments of a batch lock while
+	// they push to and prune the shared n8n repository.
+	batchPushLockVar = "BATCH_PUSH_LOCK"
+)
+
+var ErrBatchConfig = errors.New("invalid batch")
+
+// batchEnvironment is one environment of a batch run and its outcome.
+type batchEnvironment struct {
+	name     string
+	env      []string
+	prefix   string
+	err      error
+	duration time.Duration
+}
+
+// runBatch deploys several environments, each described by an env file of
+// KEY=VALUE lines layered over the current environment. Each one runs as a
+// separate process of this binary, since the configuration is read from the
+// process environment, with at most --concurrency running at once so the
+// DigitalOcean API is not flooded. Their image pushes take turns, see
+// lockImagePush. Environments start in the order given, their output is
+// prefixed with their name, and a summary follows.
+func runBatch(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("batch", flag.ExitOnError)
+	concurrency := flags.Int("concurrency", defaultBatchConcurrency, "environments deployed at the same time")
+	command := flags.String("command", commandRun, "command, with its flags, run for each environment")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *concurrency < 1 {
+		return fmt.Errorf("%w: --concurrency must be at least 1, got %d", ErrBatchConfig, *concurrency)
+	}
+
+	environments, err := loadBatchEnvironments(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the executable: %w", err)
+	}
+
+	pushLock, err := os.CreateTemp("", "n8n-batch-push-*.lock")
+	if err != nil {
+		return fmt.Errorf("failed to create the push lock: %w", err)
+	}
+
+	pushLock.Close()
+	defer os.Remove(pushLock.Name())
+
+	for _, environment := range environments {
+		environment.env = append(environment.env, batchPushLockVar+"="+pushLock.Name())
+	}
+
+	var (
+		group  errgroup.Group
+		output sync.Mutex
+	)
+
+	group.SetLimit(*concurrency)
+
+	for _, environment := range environments {
+		group.Go(func() error {
+			started := time.Now()
+			environment.err = runBatchEnvironment(ctx, self, strings.Fields(*command), environment, &output)
+			environment.duration = time.Since(started).Round(time.Second)
+
+			// Failures are reported in the summary, not by cancelling the others
+			return nil
+		})
+	}
+
+	_ = group.Wait()
+
+	return printBatchSummary(environments)
+}
+
+// loadBatchEnvironments reads the env files and refuses two environments with
+// the same DEPLOY_PREFIX, which would deploy over each other.
+func loadBatchEnvironments(paths []string) ([]*batchEnvironment, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%w: pass one env file per environment", ErrBatchConfig)
+	}
+
+	environments := make([]*batchEnvironment, 0, len(paths))
+	owners := map[string]string{}
+
+	for _, path := range paths {
+		entries, err := readEnvFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		environment := &batchEnvironment{
+			name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+			env:  append(os.Environ(), entries...),
+		}
+		environment.prefix = batchDeployPrefix(environment.env)
+
+		// Environments generating secrets at once must not overwrite each
+		// other's, so each gets its own file unless its env file names one
+		if lookupEnv(entries, "CREDENTIALS_OUTPUT_FILE") == "" {
+			environment.env = append(environment.env,
+				"CREDENTIALS_OUTPUT_FILE="+batchCredentialsFile(environment.prefix))
+		}
+
+		if owner, taken := owners[environment.prefix]; taken {
+			return nil, fmt.Errorf("%w: %s and %s both deploy DEPLOY_PREFIX %q", ErrBatchConfig, owner, path,
+				environment.prefix)
+		}
+
+		owners[environment.prefix] = path
+		environments = append(environments, environment)
+	}
+
+	return environments, nil
+}
+
+// batchDeployPrefix resolves DEPLOY_PREFIX the way loadConfig does, from the
+// environment the child process will see. Later entries win, as in exec.
+func batchDeployPrefix(env []string) string {
+	for _, key := range []string{"DEPLOY_PREFIX", "DROPLET_NAME"} {
+		if value := lookupEnv(env, key); value != "" {
+			return value
+		}
+	}
+
+	return defaultDeployPrefix
+}
+
+// batchCredentialsFile puts the environment's DEPLOY_PREFIX in front of the
+// name of the credentials file the batch itself would write.
+func batchCredentialsFile(prefix string) string {
+	path := credentialsOutputFile()
+
+	return filepath.Join(filepath.Dir(path), prefix+"-"+filepath.Base(path))
+}
+
+// lookupEnv returns the last value env gives key, as exec would use it.
+func lookupEnv(env []string, key string) string {
+	value := ""
+
+	for _, entry := range env {
+		if name, v, found := strings.Cut(entry, "="); found && name == key {
+			value = v
+		}
+	}
+
+	return value
+}
+
+func runBatchEnvironment(ctx context.Context, self string, command []string, environment *batchEnvironment,
+	output *sync.Mutex,
+) error {
+	output.Lock()
+	fmt.Printf("[%s] starting %s\n", environment.name, strings.Join(command, " "))
+	output.Unlock()
+
+	// #nosec G204 -- runs this same binary with the operator's own arguments
+	cmd := exec.CommandContext(ctx, self, command...)
+	cmd.Env = environment.env
+
+	pipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	cmd.Stderr = cmd.Stdout
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	prefixLines(pipe, "["+environment.name+"] ", output)
+
+	return cmd.Wait()
+}
+
+// lockImagePush takes the batch's push lock, when running as part of a
+// batch, and returns the function that releases it.
+func lockImagePush(progress *buildProgress) (func(), error) {
+	path := os.Getenv(batchPushLockVar)
+	if path == "" {
+		return func() {}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, sshFilePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the push lock: %w", err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		progress.start("waiting for another environment's push")
+
+		if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+			file.Close()
+
+			return nil, fmt.Errorf("failed to take the push lock: %w", err)
+		}
+	}
+
+	return func() {
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+// prefixLines copies reader to stdout a line at a time, so lines of
+// environments deploying concurrently do not interleave. After a line too
+// long to buffer the rest is discarded, but still read so the command does
+// not block on a full pipe.
+func prefixLines(reader io.Reader, prefix string, output *sync.Mutex) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, bufio.MaxScanTokenSize*16)
+
+	for scanner.Scan() {
+		output.Lock()
+		fmt.Println(prefix + scanner.Text())
+		output.Unlock()
+	}
+
+	if err := scanner.Err(); err != nil {
+		output.Lock()
+		fmt.Printf("%sWarning: output dropped from here on: %v\n", prefix, err)
+		output.Unlock()
+
+		_, _ = io.Copy(io.Discard, reader)
+	}
+}
+
+func printBatchSummary(environments []*batchEnvironment) error {
+	fmt.Println("\nBatch summary:")
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, tabwriterWidth, ' ', 0)
+	fmt.Fprintln(writer, "ENVIRONMENT\tDEPLOY_PREFIX\tRESULT\tDURATION")
+
+	failed := 0
+
+	for _, environment := range environments {
+		result := "succeeded"
+		if environment.err != nil {
+			result = "failed: " + environment.err.Error()
+			failed++
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", environment.name, environment.prefix, result, environment.duration)
+	}
+
+	writer.Flush()
+
+	if failed > 0 {
+		fmt.Printf("%d of %d environments failed\n", failed, len(environments))
+
+		return &exitCodeError{code: 1}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockImagePushMakesPushesTakeTurns(t *testing.T) {
+	t.Setenv(batchPushLockVar, filepath.Join(t.TempDir(), "push.lock"))
+
+	var progress buildProgress
+
+	release, err := lockImagePush(&progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan func())
+
+	go func() {
+		var waiting buildProgress
+
+		second, err := lockImagePush(&waiting)
+		if err != nil {
+			t.Error(err)
+		}
+
+		acquired <- second
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("a second push took the lock while the first held it")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release()
+
+	select {
+	case second := <-acquired:
+		second()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second push never got the lock")
+	}
+}
+
+func TestLockImagePushOutsideABatch(t *testing.T) {
+	t.Setenv(batchPushLockVar, "")
+
+	release, err := lockImagePush(&buildProgress{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release()
+}
+
+func TestBatchEnvironmentsWriteTheirOwnCredentials(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CREDENTIALS_OUTPUT_FILE", filepath.Join(dir, "credentials.env"))
+
+	files := map[string]string{
+		"staging.env":    "DEPLOY_PREFIX=staging\n",
+		"production.env": "DEPLOY_PREFIX=production\n",
+		"eu.env":         "DEPLOY_PREFIX=eu\nCREDENTIALS_OUTPUT_FILE=/secure/eu.env\n",
+	}
+
+	var paths []string
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		paths = append(paths, path)
+	}
+
+	environments, err := loadBatchEnvironments(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"staging":    filepath.Join(dir, "staging-credentials.env"),
+		"production": filepath.Join(dir, "production-credentials.env"),
+		"eu":         "/secure/eu.env",
+	}
+
+	for _, environment := range environments {
+		if got := lookupEnv(environment.env, "CREDENTIALS_OUTPUT_FILE"); got != want[environment.prefix] {
+			t.Errorf("%s writes credentials to %q, want %q", environment.name, got, want[environment.prefix])
+		}
+	}
+}
+
+func TestPrefixLinesDrainsAfterAnOverlongLine(t *testing.T) {
+	reader, writer := io.Pipe()
+
+	written := make(chan error, 1)
+
+	go func() {
+		_, err := io.WriteString(writer, strings.Repeat("x", bufio.MaxScanTokenSize*32)+"\nmore output\n")
+		writer.Close()
+		written <- err
+	}()
+
+	done := make(chan struct{})
+
+	go func() {
+		prefixLines(reader, "[test] ", &sync.Mutex{})
+		close(done)
+	}()
+
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the writer blocked after the overlong line")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("prefixLines did not return")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+// N8N_BIND values: where the n8n port is published on the droplet.
+const (
+	n8nBindLoopback = "loopback"
+	n8nBindPrivate  = "private"
+	n8nBindAll      = "all"
+
+	n8nPort = 5678
+
+	// privateIPCommand reads the droplet's VPC address from the metadata
+	// service. It runs on the droplet, when the .env file is written.
+	privateIPCommand = "$(curl -sf http://169.254.169.254/metadata/v1/interfaces/private/0/ipv4/address)"
+)
+
+// n8nBindAddress is the host address n8n's port is published on, as written
+// to the .env file.
+func n8nBindAddress(config *Config) string {
+	switch config.n8nBind {
+	case n8nBindPrivate:
+		return privateIPCommand
+	case n8nBindAll:
+		return "0.0.0.0"
+	default:
+		return "127.0.0.1"
+	}
+}
+
+// n8nPortBinding publishes n8n's port on N8N_BIND_ADDRESS. Swarm's ingress
+// network cannot bind a host address, so there it is published on every
+// address and only the firewall guards it.
+func n8nPortBinding(config *Config) string {
+	if config.deployMode == deployModeSwarm {
+		return fmt.Sprintf("%d:%d", n8nPort, n8nPort)
+	}
+
+	return fmt.Sprintf("${N8N_BIND_ADDRESS}:%d:%d", n8nPort, n8nPort)
+}
+
+// n8nLocalURL reaches n8n from a shell on the droplet. With N8N_BIND=private
+// it is not listening on loopback.
+func n8nLocalURL(config *Config) string {
+	host := "127.0.0.1"
+	if config.n8nBind == n8nBindPrivate {
+		host = privateIPCommand
+	}
+
+	return fmt.Sprintf("http://%s:%d", host, n8nPort)
+}
+
+// validateN8NBind refuses to publish n8n on every interface while the managed
+// firewall would let the internet reach it. A FIREWALL_ID firewall is checked
+// when the deploy looks it up.
+func validateN8NBind(config *Config) error {
+	switch config.n8nBind {
+	case n8nBindLoopback, n8nBindPrivate, n8nBindAll:
+	default:
+		return fmt.Errorf("%w: N8N_BIND must be loopback, private or all, got %q", ErrInvalidConfig, config.n8nBind)
+	}
+
+	exposedBy := n8nPublishedEverywhere(config)
+	if exposedBy == "" || config.firewallID != "" {
+		return nil
+	}
+
+	rules, err := inboundRules(config.extraInbound)
+	if err != nil {
+		return err
+	}
+
+	if n8nPortPublic(rules) {
+		return fmt.Errorf("%w: %s with EXTRA_INBOUND_PORTS opening port %d to the internet "+
+			"would bypass Caddy", ErrInvalidConfig, exposedBy, n8nPort)
+	}
+
+	return nil
+}
+
+// n8nPublishedEverywhere names the setting that publishes n8n's port on every
+// interface, leaving only the firewall in front of it, or returns "". Swarm
+// always does, since its ingress network ignores N8N_BIND.
+func n8nPublishedEverywhere(config *Config) string {
+	switch {
+	case config.deployMode == deployModeSwarm:
+		return "DEPLOY_MODE=swarm"
+	case config.n8nBind == n8nBindAll:
+		return "N8N_BIND=all"
+	default:
+		return ""
+	}
+}
+
+// n8nPortPublic reports whether any inbound rule lets the whole internet
+// reach n8n's port.
+func n8nPortPublic(rules []godo.InboundRule) bool {
+	for _, rule := range rules {
+		if rule.Protocol != "tcp" || !portRangeCovers(rule.PortRange, n8nPort) || rule.Sources == nil {
+			continue
+		}
+
+		for _, address := range rule.Sources.Addresses {
+			if address == "0.0.0.0/0" || address == "::/0" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// portRangeCovers reports whether a firewall port range ("all", "80" or
+// "8000-9000") includes port.
+func portRangeCovers(ports string, port int) bool {
+	if ports == "all" || ports == "0" {
+		return true
+	}
+
+	low, high, isRange := strings.Cut(ports, "-")
+	if !isRange {
+		high = low
+	}
+
+	first, err := strconv.Atoi(low)
+	if err != nil {
+		return false
+	}
+
+	last, err := strconv.Atoi(high)
+	if err != nil {
+		return false
+	}
+
+	return first <= port && port <= last
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestSwarmIsRefusedWhenTheFirewallOpensN8N(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.deployMode = deployModeSwarm
+	config.extraInbound = []string{"tcp:5678:0.0.0.0/0"}
+
+	if err := validateN8NBind(config); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("err = %v, want ErrInvalidConfig for swarm with a public port 5678", err)
+	}
+
+	config.extraInbound = nil
+
+	if err := validateN8NBind(config); err != nil {
+		t.Errorf("swarm behind the default firewall: %v", err)
+	}
+}
+
+func TestPrivateBindAddressFailsClosed(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.n8nBind = n8nBindPrivate
+
+	script := generateEnvFile(config)
+	guard := strings.Index(script, `if [ -z "$N8N_BIND_ADDRESS" ]`)
+	write := strings.Index(script, "cat > /opt/n8n/.env")
+
+	if guard < 0 || write < guard {
+		t.Fatalf("the address is not checked before .env is written:\n%s", script)
+	}
+
+	if !strings.Contains(script, "N8N_BIND_ADDRESS="+privateIPCommand+"\n") {
+		t.Error("the private address is not resolved into N8N_BIND_ADDRESS")
+	}
+}
+
+func TestValidateN8NBind(t *testing.T) {
+	tests := []struct {
+		name         string
+		bind         string
+		extraInbound []string
+		firewallID   string
+		wantErr      bool
+	}{
+		{name: "loopback", bind: n8nBindLoopback},
+		{name: "loopback with a public 5678", bind: n8nBindLoopback, extraInbound: []string{"tcp:5678:0.0.0.0/0"}},
+		{name: "private with a public 5678", bind: n8nBindPrivate, extraInbound: []string{"tcp:5678:::/0"}},
+		{name: "all behind the default firewall", bind: n8nBindAll},
+		{name: "all with 5678 open to the VPC", bind: n8nBindAll, extraInbound: []string{"tcp:5678:10.0.0.0/8"}},
+		{name: "all with a public 5678", bind: n8nBindAll, extraInbound: []string{"tcp:5678:0.0.0.0/0"}, wantErr: true},
+		{name: "all with a public IPv6 5678", bind: n8nBindAll, extraInbound: []string{"tcp:5678:::/0"}, wantErr: true},
+		{name: "all with a public range", bind: n8nBindAll, extraInbound: []string{"tcp:5000-6000:0.0.0.0/0"}, wantErr: true},
+		{name: "all with a public udp 5678", bind: n8nBindAll, extraInbound: []string{"udp:5678:0.0.0.0/0"}},
+		{name: "all with FIREWALL_ID", bind: n8nBindAll, extraInbound: []string{"tcp:5678:0.0.0.0/0"}, firewallID: "fw"},
+		{name: "unknown value", bind: "public", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := defaultTestConfig(t)
+			config.n8nBind = test.bind
+			config.extraInbound = test.extraInbound
+			config.firewallID = test.firewallID
+
+			if err := validateN8NBind(config); (err != nil) != test.wantErr {
+				t.Errorf("validateN8NBind() = %v, want error %t", err, test.wantErr)
+			} else if err != nil && !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("err = %v, want ErrInvalidConfig", err)
+			}
+		})
+	}
+}
+
+func TestN8NPortPublic(t *testing.T) {
+	rule := func(protocol, ports string, sources ...string) godo.InboundRule {
+		return godo.InboundRule{Protocol: protocol, PortRange: ports, Sources: &godo.Sources{Addresses: sources}}
+	}
+
+	tests := []struct {
+		name  string
+		rules []godo.InboundRule
+		want  bool
+	}{
+		{"no rules", nil, false},
+		{"web ports only", []godo.InboundRule{rule("tcp", "443", "0.0.0.0/0", "::/0")}, false},
+		{"public 5678", []godo.InboundRule{rule("tcp", "5678", "0.0.0.0/0")}, true},
+		{"public IPv6 5678", []godo.InboundRule{rule("tcp", "5678", "::/0")}, true},
+		{"5678 from the VPC", []godo.InboundRule{rule("tcp", "5678", "10.10.0.0/16")}, false},
+		{"public range", []godo.InboundRule{rule("tcp", "5000-6000", "0.0.0.0/0")}, true},
+		{"public range below", []godo.InboundRule{rule("tcp", "5000-5677", "0.0.0.0/0")}, false},
+		{"all ports", []godo.InboundRule{rule("tcp", "all", "0.0.0.0/0")}, true},
+		{"port 0 means all", []godo.InboundRule{rule("tcp", "0", "::/0")}, true},
+		{"udp", []godo.InboundRule{rule("udp", "5678", "0.0.0.0/0")}, false},
+		{"no sources", []godo.InboundRule{{Protocol: "tcp", PortRange: "5678"}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := n8nPortPublic(test.rules); got != test.want {
+				t.Errorf("n8nPortPublic() = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPortRangeCovers(t *testing.T) {
+	tests := []struct {
+		ports string
+		want  bool
+	}{
+		{"5678", true},
+		{"5679", false},
+		{"5678-5678", true},
+		{"5600-5700", true},
+		{"5679-5700", false},
+		{"1-65535", true},
+		{"all", true},
+		{"0", true},
+		{"", false},
+		{"http", false},
+		{"5600-", false},
+	}
+
+	for _, test := range tests {
+		if got := portRangeCovers(test.ports, n8nPort); got != test.want {
+			t.Errorf("portRangeCovers(%q, %d) = %t, want %t", test.ports, n8nPort, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/godo"
+	"github.com/felipepimentel/n8n-digitalocean-cicd/ci/ssh"
+)
+
+// bootstrapStubs stand in for the commands the bootstrap script changes the
+// droplet with, recording state under $ROOT and each call in $ROOT/calls.
+var bootstrapStubs = map[string]string{
+	"id": `if [ "$1" = -nG ]; then cat "$ROOT/groups" 2>/dev/null; exit 0; fi
+[ -f "$ROOT/user" ]`,
+	"useradd": `echo useradd >> "$ROOT/calls"; touch "$ROOT/user"`,
+	"usermod": `echo "usermod $2" >> "$ROOT/calls"; echo "$2" >> "$ROOT/groups"`,
+	"docker": `if [ "$2" = inspect ]; then [ -f "$ROOT/volume-$3" ]; exit; fi
+echo "docker $*" >> "$ROOT/calls"; touch "$ROOT/volume-$3"`,
+	"chown": `exit 0`,
+}
+
+// runBootstrap runs the bootstrap script against a droplet stand-in rooted at
+// root and returns its output and the commands it ran.
+func runBootstrap(t *testing.T, root string) (string, []string) {
+	t.Helper()
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not available")
+	}
+
+	bin := filepath.Join(root, "bin")
+	for _, dir := range []string{bin, filepath.Join(root, "etc/sudoers.d"), filepath.Join(root, "root/.ssh")} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "root/.ssh/authorized_keys"), []byte("ssh-ed25519 AAAA\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, body := range bootstrapStubs {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+body+"\n"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	script := generateBootstrapScript()
+	for _, path := range []string{"/opt/n8n", "/home/n8n", "/etc/sudoers.d", "/root/.ssh"} {
+		script = strings.ReplaceAll(script, path, root+path)
+	}
+
+	os.Remove(filepath.Join(root, "calls"))
+
+	cmd := exec.Command(bash, "-c", script)
+	cmd.Env = append(os.Environ(), "ROOT="+root, "PATH="+bin+":"+os.Getenv("PATH"))
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("bootstrap failed: %v\n%s", err, output)
+	}
+
+	calls, _ := os.ReadFile(filepath.Join(root, "calls"))
+
+	return string(output), strings.Fields(strings.ReplaceAll(string(calls), " ", "_"))
+}
+
+func TestBootstrapRunsOnce(t *testing.T) {
+	root := t.TempDir()
+
+	output, calls := runBootstrap(t, root)
+	if strings.Contains(output, bootstrapSkipMessage) {
+		t.Fatal("a fresh droplet was reported as bootstrapped")
+	}
+
+	want := []string{"useradd", "usermod_sudo", "usermod_docker", "docker_volume_create_caddy_data",
+		"docker_volume_create_n8n_data"}
+	if strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Errorf("first run = %v, want %v", calls, want)
+	}
+
+	if _, err := os.Stat(root + bootstrapSentinel); err != nil {
+		t.Errorf("the sentinel was not written: %v", err)
+	}
+
+	output, calls = runBootstrap(t, root)
+	if !strings.Contains(output, bootstrapSkipMessage) || len(calls) > 0 {
+		t.Errorf("second run changed %v (output %q), want it skipped", calls, output)
+	}
+}
+
+func TestBootstrapIsIdempotentWithoutTheSentinel(t *testing.T) {
+	root := t.TempDir()
+
+	runBootstrap(t, root)
+
+	if err := os.Remove(root + bootstrapSentinel); err != nil {
+		t.Fatal(err)
+	}
+
+	output, calls := runBootstrap(t, root)
+	if strings.Contains(output, bootstrapSkipMessage) {
+		t.Fatal("the run without a sentinel was skipped")
+	}
+
+	if len(calls) > 0 {
+		t.Errorf("re-running the setup changed %v, want nothing", calls)
+	}
+
+	if _, err := os.Stat(root + bootstrapSentinel); err != nil {
+		t.Errorf("the sentinel was not rewritten: %v", err)
+	}
+}
+
+func TestBootstrapRepairsALostUser(t *testing.T) {
+	root := t.TempDir()
+
+	runBootstrap(t, root)
+
+	if err := os.Remove(filepath.Join(root, "user")); err != nil {
+		t.Fatal(err)
+	}
+
+	output, calls := runBootstrap(t, root)
+	if strings.Contains(output, bootstrapSkipMessage) {
+		t.Fatal("the sentinel skipped a droplet without its user")
+	}
+
+	if len(calls) == 0 || calls[0] != "useradd" {
+		t.Errorf("re-running the setup ran %v, want the user recreated", calls)
+	}
+}
+
+// preparedDroplet runs prepareDroplet without an SSH agent, so the first
+// connection it makes fails at once and names the step that made it.
+func preparedDroplet(t *testing.T, hostPublicKey string) (*Config, error) {
+	t.Helper()
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	config := defaultTestConfig(t)
+	droplet := &godo.Droplet{Networks: &godo.Networks{V4: []godo.NetworkV4{{IPAddress: "203.0.113.10", Type: "public"}}}}
+
+	return config, prepareDroplet(context.Background(), config, droplet, hostPublicKey)
+}
+
+func TestPrepareDropletBootstrapsAnExistingDroplet(t *testing.T) {
+	config, err := preparedDroplet(t, "")
+
+	if err == nil || !strings.Contains(err.Error(), "failed to setup non-root user") {
+		t.Fatalf("err = %v, want the bootstrap to have been attempted", err)
+	}
+
+	if _, statErr := os.Stat(config.knownHostsPath); statErr == nil {
+		t.Error("an existing droplet's host key was trusted without being seeded")
+	}
+}
+
+func TestPrepareDropletTrustsAndWaitsForANewDroplet(t *testing.T) {
+	_, hostPublicKey, err := ssh.GenerateHostKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := preparedDroplet(t, hostPublicKey)
+
+	// cloud-init is waited for before the bootstrap
+	if !errors.Is(err, ErrSSHClient) || strings.Contains(err.Error(), "non-root user") {
+		t.Fatalf("err = %v, want the cloud-init wait to have been attempted first", err)
+	}
+
+	knownHosts, readErr := os.ReadFile(config.knownHostsPath)
+	if readErr != nil || !strings.Contains(string(knownHosts), "203.0.113.10") {
+		t.Errorf("the seeded host key was not trusted: %v\n%s", readErr, knownHosts)
+	}
+}
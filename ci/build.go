@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const buildOutputPerm = 0o644
+
+// builtImage is what the build subcommand hands to a later deploy job.
+type builtImage struct {
+	Ref        string `json:"ref"`
+	Digest     string `json:"digest"`
+	N8NVersion string `json:"n8nVersion"`
+	Signature  string `json:"signature,omitempty"`
+	ContentKey string `json:"contentKey,omitempty"`
+}
+
+// runBuild builds and pushes the n8n image without touching the droplet, then
+// reports the pushed reference and digest as JSON on stdout (or --output) and
+// as GitHub Actions step outputs when GITHUB_OUTPUT is set.
+func runBuild(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	output := flags.String("output", "", "write the image JSON to this file instead of stdout")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config := loadConfig()
+
+	if err := refuseDryRun(&config, "build"); err != nil {
+		return err
+	}
+
+	// The key is only injected at deploy time, so the image never needs it
+	if err := usePlaceholderEncryptionKey(&config); err != nil {
+		return err
+	}
+
+	if err := validateConfig(&config); err != nil {
+		return err
+	}
+
+	var image *publishedImage
+
+	err := runWithDeadline(ctx, config.deployTimeout, func(ctx context.Context, steps *stepTracker) error {
+		steps.start("connecting to Dagger")
+
+		client, err := connectDagger(ctx, &config)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		steps.start("building and pushing image")
+
+		image, err = buildAndPushImage(ctx, client, &config)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return reportBuiltImage(image, *output)
+}
+
+func reportBuiltImage(image *publishedImage, output string) error {
+	built := builtImage{
+		Ref:        image.ref,
+		Digest:     image.digest,
+		N8NVersion: image.version,
+		Signature:  image.signature,
+		ContentKey: image.contentKey,
+	}
+
+	data, err := json.MarshalIndent(built, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	if output == "" {
+		fmt.Println(string(data))
+	} else {
+		if err := os.WriteFile(output, append(data, '\n'), buildOutputPerm); err != nil {
+			return fmt.Errorf("failed to write %s: %w", output, err)
+		}
+
+		fmt.Printf("Wrote image %s to %s\n", built.Ref, output)
+	}
+
+	githubOutput := os.Getenv("GITHUB_OUTPUT")
+	if githubOutput == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(githubOutput, os.O_APPEND|os.O_CREATE|os.O_WRONLY, buildOutputPerm)
+	if err != nil {
+		return fmt.Errorf("failed to open GITHUB_OUTPUT: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintf(file, "image-ref=%s\nimage-digest=%s\nn8n-version=%s\n",
+		built.Ref, built.Digest, built.N8NVersion); err != nil {
+		return fmt.Errorf("failed to write GITHUB_OUTPUT: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const buildProgressPrecision = 100 * time.Millisecond
+
+// buildProgress reports the phases of the image build with their duration.
+// Dagger's own output can be silenced with BUILD_QUIET, these lines cannot.
+type buildProgress struct {
+	phase   string
+	started time.Time
+}
+
+// start finishes the running phase, if any, and begins the next one.
+func (p *buildProgress) start(phase string) {
+	p.finish()
+
+	p.phase = phase
+	p.started = time.Now()
+
+	fmt.Printf("  -> %s\n", phase)
+}
+
+// finish reports the running phase as done.
+func (p *buildProgress) finish() {
+	if p.phase == "" {
+		return
+	}
+
+	fmt.Printf("  -> %s done in %s\n", p.phase, time.Since(p.started).Round(buildProgressPrecision))
+
+	p.phase = ""
+}
+
+// abort reports the running phase, if any, as failed. Deferred, it covers
+// every early return; a build that completes calls finish first.
+func (p *buildProgress) abort() {
+	if p.phase == "" {
+		return
+	}
+
+	fmt.Printf("  -> %s failed after %s\n", p.phase, time.Since(p.started).Round(buildProgressPrecision))
+
+	p.phase = ""
+}
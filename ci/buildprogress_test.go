@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what run prints.
+func captureStdout(t *testing.T, run func()) string {
+	t.Helper()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = writer
+
+	defer func() { os.Stdout = stdout }()
+
+	run()
+	writer.Close()
+
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(output)
+}
+
+func TestBuildProgressReportsFailure(t *testing.T) {
+	output := captureStdout(t, func() {
+		var progress buildProgress
+		defer progress.abort()
+
+		progress.start("pulling base image")
+		progress.start("pushing latest")
+	})
+
+	if !strings.Contains(output, "pulling base image done") {
+		t.Errorf("a phase that was followed by another should be done:\n%s", output)
+	}
+
+	if !strings.Contains(output, "pushing latest failed after") || strings.Contains(output, "pushing latest done") {
+		t.Errorf("the phase running at the failure should be reported as failed:\n%s", output)
+	}
+}
+
+func TestBuildProgressReportsSuccess(t *testing.T) {
+	output := captureStdout(t, func() {
+		var progress buildProgress
+		defer progress.abort()
+
+		progress.start("pushing latest")
+		progress.finish()
+	})
+
+	if !strings.Contains(output, "pushing latest done") || strings.Contains(output, "failed") {
+		t.Errorf("a completed build should only report success:\n%s", output)
+	}
+}
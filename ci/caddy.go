@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/felipepimentel/n8n-digitalocean-cicd/ci/ssh"
+)
+
+const (
+	caddyfilePath = "/opt/n8n/caddy_config/Caddyfile"
+	caddyImage    = "caddy:2"
+	n8nUpstream   = "n8n:5678"
+
+	// Upstream health checks.
+	caddyHealthURI             = "/healthz"
+	defaultCaddyHealthInterval = 10 * time.Second
+	defaultCaddyFailDuration   = 30 * time.Second
+	defaultCaddyTryDuration    = 5 * time.Second
+
+	// defaultCaddyFlushInterval flushes every write, which server-sent events
+	// from the n8n editor rely on.
+	defaultCaddyFlushInterval = "-1"
+)
+
+// generateCaddyfile renders the Caddy site config that terminates TLS for the
+// configured domain and proxies to n8n.
+func generateCaddyfile(config *Config) string {
+	proxy := fmt.Sprintf(`reverse_proxy %s {
+%s    }`, n8nUpstream, caddyProxyDirectives(config))
+
+	return fmt.Sprintf(`%s%s {
+%s
+}
+`, caddyGlobalOptions(config), config.domain, caddySiteRoutes(proxy, config))
+}
+
+// caddyProxyDirectives renders the body of the reverse_proxy block.
+func caddyProxyDirectives(config *Config) string {
+	return fmt.Sprintf("        flush_interval %s\n", config.caddyFlushInterval) +
+		caddyHealthDirectives(config) + caddyTransportDirectives(config)
+}
+
+// caddyTransportDirectives sets the upstream timeouts that are configured,
+// leaving the others at Caddy's defaults.
+func caddyTransportDirectives(config *Config) string {
+	var timeouts strings.Builder
+
+	for _, timeout := range caddyTransportTimeouts(config) {
+		if timeout.value > 0 {
+			fmt.Fprintf(&timeouts, "            %s %s\n", timeout.directive, timeout.value)
+		}
+	}
+
+	if timeouts.Len() == 0 {
+		return ""
+	}
+
+	return "        transport http {\n" + timeouts.String() + "        }\n"
+}
+
+type caddyTimeout struct {
+	directive string
+	env       string
+	value     time.Duration
+}
+
+func caddyTransportTimeouts(config *Config) []caddyTimeout {
+	return []caddyTimeout{
+		{"dial_timeout", "CADDY_DIAL_TIMEOUT", config.caddyDialTimeout},
+		{"read_timeout", "CADDY_READ_TIMEOUT", config.caddyReadTimeout},
+		{"write_timeout", "CADDY_WRITE_TIMEOUT", config.caddyWriteTimeout},
+		{"response_header_timeout", "CADDY_RESPONSE_HEADER_TIMEOUT", config.caddyResponseHeaderTimeout},
+	}
+}
+
+// caddyHealthDirectives marks n8n down while /healthz fails (actively) or
+// requests to it fail (passively). Requests arriving while it is down are held
+// for lb_try_duration before Caddy answers 502, so a restart shorter than that
+// is invisible to clients.
+func caddyHealthDirectives(config *Config) string {
+	if !config.caddyHealthChecks {
+		return ""
+	}
+
+	return fmt.Sprintf(`        health_uri %s
+        health_interval %s
+        fail_duration %s
+        lb_try_duration %s
+`, caddyHealthURI, config.caddyHealthInterval, config.caddyFailDuration, config.caddyTryDuration)
+}
+
+// caddyfileMatches reports whether an existing Caddyfile serves the configured
+// domain and proxies to the n8n upstream with the configured proxy settings.
+func caddyfileMatches(caddyfile string, config *Config) bool {
+	servesDomain := false
+	proxiesN8N := false
+
+	for _, line := range strings.Split(caddyfile, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[len(fields)-1] == "{" && fields[0] == config.domain {
+			servesDomain = true
+		}
+
+		if len(fields) >= 2 && fields[0] == "reverse_proxy" && fields[1] == n8nUpstream {
+			proxiesN8N = true
+		}
+	}
+
+	return servesDomain && proxiesN8N && hasProxyDirectives(caddyfile, config)
+}
+
+func hasProxyDirectives(caddyfile string, config *Config) bool {
+	present := map[string]bool{}
+	for _, line := range strings.Split(caddyfile, "\n") {
+		present[strings.Join(strings.Fields(line), " ")] = true
+	}
+
+	directives := caddyGlobalOptions(config) + caddyProxyDirectives(config)
+
+	for _, directive := range strings.Split(strings.TrimSpace(directives), "\n") {
+		if directive != "" && !present[strings.TrimSpace(directive)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validateCaddyConfig(config *Config) error {
+	if config.caddyFlushInterval != defaultCaddyFlushInterval {
+		if interval, err := time.ParseDuration(config.caddyFlushInterval); err != nil || interval < 0 {
+			return fmt.Errorf("%w: CADDY_FLUSH_INTERVAL must be -1 or a non-negative duration, got %q",
+				ErrInvalidConfig, config.caddyFlushInterval)
+		}
+	}
+
+	for _, timeout := range caddyTransportTimeouts(config) {
+		if timeout.value < 0 {
+			return fmt.Errorf("%w: %s must not be negative, got %s", ErrInvalidConfig, timeout.env, timeout.value)
+		}
+	}
+
+	if !config.caddyHealthChecks {
+		return nil
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"CADDY_HEALTH_INTERVAL", config.caddyHealthInterval},
+		{"CADDY_FAIL_DURATION", config.caddyFailDuration},
+		{"CADDY_TRY_DURATION", config.caddyTryDuration},
+	}
+
+	for _, duration := range durations {
+		if duration.value <= 0 {
+			return fmt.Errorf("%w: %s must be positive, got %s", ErrInvalidConfig, duration.name, duration.value)
+		}
+	}
+
+	return nil
+}
+
+// verifyCaddyfile checks the Caddyfile on the droplet and rewrites it when it
+// doesn't match the configuration. Caddy reloads the new config without
+// dropping connections, and is only restarted when the reload fails. It
+// reports whether a fix was applied.
+func verifyCaddyfile(sshClient *ssh.Client, config *Config) (bool, error) {
+	current, err := sshClient.ExecuteCommand(fmt.Sprintf("cat %s 2>/dev/null || true", caddyfilePath))
+	if err != nil {
+		return false, fmt.Errorf("failed to read Caddyfile: %w", err)
+	}
+
+	if caddyfileMatches(current, config) {
+		return false, nil
+	}
+
+	output, err := sshClient.ExecuteCommand(generateCaddyReloadScript(config))
+	if err != nil {
+		return false, fmt.Errorf("failed to rewrite Caddyfile: %w\nOutput: %s", err, output)
+	}
+
+	fmt.Print(output)
+
+	return true, nil
+}
+
+// generateCaddyReloadScript validates the new Caddyfile in a throwaway
+// container before it replaces the live one, then reloads Caddy in place.
+// The file is overwritten rather than moved: Compose bind-mounts the file
+// itself, and a new inode would not be seen inside the container.
+func generateCaddyReloadScript(config *Config) string {
+	return fmt.Sprintf(`set -e
+cat > %[1]s.new << 'EOF'
+%[2]sEOF
+if ! docker run --rm -v %[1]s.new:/etc/caddy/Caddyfile:ro %[3]s caddy validate --config /etc/caddy/Caddyfile --adapter caddyfile; then
+	rm -f %[1]s.new
+	echo "New Caddyfile failed validation, keeping the current one" >&2
+	exit 1
+fi
+cat %[1]s.new > %[1]s
+rm -f %[1]s.new
+CADDY=$(docker ps -q %[4]s | head -n 1)
+if [ -n "$CADDY" ] && docker exec "$CADDY" caddy reload --config /etc/caddy/Caddyfile --adapter caddyfile; then
+	echo "Caddy reloaded the new Caddyfile"
+else
+	echo "Caddy could not reload, restarting it"
+	cd /opt/n8n
+	%[5]s
+fi`, caddyfilePath, generateCaddyfile(config), caddyImage, serviceContainerFilter(config, "caddy"),
+		restartServiceCommand(config, "caddy"))
+}
+
+// restartServiceCommand returns the shell command that restarts a single
+// service for the configured deploy mode.
+func restartServiceCommand(config *Config, service string) string {
+	if config.deployMode == deployModeSwarm {
+		return fmt.Sprintf("docker service update --force %s_%s", config.composeProject, service)
+	}
+
+	return fmt.Sprintf("%s\n%s restart %s", composeDetectScript(config), composeCmd, service)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// cloudflareIPsURL publishes the ranges Cloudflare connects to origins from.
+const cloudflareIPsURL = "https://api.cloudflare.com/client/v4/ips"
+
+var ErrCloudflareRanges = errors.New("failed to fetch Cloudflare IP ranges")
+
+type cloudflareIPsResponse struct {
+	Success bool `json:"success"`
+	Result  struct {
+		IPv4CIDRs []string `json:"ipv4_cidrs"`
+		IPv6CIDRs []string `json:"ipv6_cidrs"`
+	} `json:"result"`
+}
+
+// fetchCloudflareRanges downloads the current Cloudflare ranges. They are
+// fetched on every run so the firewall and Caddy follow Cloudflare's changes.
+func fetchCloudflareRanges(ctx context.Context) ([]string, error) {
+	var ranges []string
+
+	err := retryWithBackoff(ctx, apiAttempts, apiRetryDelay, func() error {
+		var fetchErr error
+		ranges, fetchErr = requestCloudflareRanges(ctx)
+
+		return fetchErr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Fetched %d Cloudflare IP ranges\n", len(ranges))
+
+	return ranges, nil
+}
+
+func requestCloudflareRanges(ctx context.Context) ([]string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, cloudflareIPsURL, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCloudflareRanges, err)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCloudflareRanges, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		err := fmt.Errorf("%w: %s returned %s", ErrCloudflareRanges, cloudflareIPsURL, response.Status)
+		if isRetryableStatus(response.StatusCode) {
+			return nil, retryable(err)
+		}
+
+		return nil, err
+	}
+
+	var body cloudflareIPsResponse
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCloudflareRanges, err)
+	}
+
+	ranges := append(body.Result.IPv4CIDRs, body.Result.IPv6CIDRs...)
+
+	// Never open the firewall to an empty or garbled source list
+	if !body.Success || len(body.Result.IPv4CIDRs) == 0 {
+		return nil, fmt.Errorf("%w: response lists no IPv4 ranges", ErrCloudflareRanges)
+	}
+
+	for _, cidr := range ranges {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("%w: invalid range %q", ErrCloudflareRanges, cidr)
+		}
+	}
+
+	return ranges, nil
+}
+
+// caddyGlobalOptions trusts Cloudflare as a proxy and takes the client IP
+// from CF-Connecting-IP, so logs and the X-Forwarded-For header passed to
+// n8n carry the visitor's address rather than Cloudflare's.
+func caddyGlobalOptions(config *Config) string {
+	if !config.cloudflare {
+		return ""
+	}
+
+	return fmt.Sprintf(`{
+    servers {
+        trusted_proxies static %s
+        client_ip_headers CF-Connecting-IP X-Forwarded-For
+    }
+}
+
+`, strings.Join(config.cloudflareRanges, " "))
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const (
+	cloudflareAPIURL = "https://api.cloudflare.com/client/v4"
+
+	// minCloudflareTTL is the lowest TTL Cloudflare accepts other than 1
+	// (automatic).
+	minCloudflareTTL = 60
+	// cloudflareAutoTTL is the only TTL proxied records have.
+	cloudflareAutoTTL = 1
+)
+
+var (
+	ErrCloudflareAPI  = errors.New("cloudflare API request failed")
+	ErrCloudflareZone = errors.New("cloudflare zone not found")
+)
+
+// cloudflareDNS manages records in a Cloudflare zone with an API token that
+// has Zone:Read and DNS:Edit on it. With proxied set (CLOUDFLARE=true) the
+// records go through Cloudflare's proxy, as the firewall then only admits
+// Cloudflare's ranges on 80/443.
+type cloudflareDNS struct {
+	token   string
+	proxied bool
+	zoneID  string
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+	Proxied bool   `json:"proxied"`
+}
+
+// EnsureZone looks the zone up; zones are created in the Cloudflare dashboard
+// along with the nameserver change, never by the deploy.
+func (c *cloudflareDNS) EnsureZone(ctx context.Context, zone string) error {
+	var zones []struct {
+		ID string `json:"id"`
+	}
+
+	if err := c.request(ctx, http.MethodGet, "/zones?name="+url.QueryEscape(zone), nil, &zones); err != nil {
+		return err
+	}
+
+	if len(zones) == 0 {
+		return fmt.Errorf("%w: %s is not a zone the CLOUDFLARE_API_TOKEN can read", ErrCloudflareZone, zone)
+	}
+
+	c.zoneID = zones[0].ID
+
+	return nil
+}
+
+// UpsertRecord proxies the record when proxied is set. Otherwise an existing
+// record keeps its proxy setting, which is managed in the Cloudflare
+// dashboard. The reported change is in what resolvers return: the target, or
+// switching to the proxy's addresses.
+func (c *cloudflareDNS) UpsertRecord(ctx context.Context, zone, name, recordType, value string, ttl int) (bool, error) {
+	if c.zoneID == "" {
+		if err := c.EnsureZone(ctx, zone); err != nil {
+			return false, err
+		}
+	}
+
+	if c.proxied {
+		ttl = cloudflareAutoTTL
+	}
+
+	fqdn := recordFQDN(zone, name)
+	record := cloudflareRecord{Type: recordType, Name: fqdn, Content: value, TTL: ttl, Proxied: c.proxied}
+	path := "/zones/" + c.zoneID + "/dns_records"
+
+	var records []cloudflareRecord
+
+	query := "?type=" + url.QueryEscape(recordType) + "&name=" + url.QueryEscape(fqdn)
+	if err := c.request(ctx, http.MethodGet, path+query, nil, &records); err != nil {
+		return false, err
+	}
+
+	if len(records) == 0 {
+		return true, c.request(ctx, http.MethodPost, path, record, nil)
+	}
+
+	if !c.proxied {
+		record.Proxied = records[0].Proxied
+	}
+
+	changed := records[0].Content != value || records[0].Proxied != record.Proxied
+	if !changed && records[0].TTL == ttl {
+		return false, nil
+	}
+
+	switch {
+	case records[0].Content != value:
+		fmt.Printf("DNS record %s pointed at %s, updating it to %s\n", fqdn, records[0].Content, value)
+	case changed:
+		fmt.Printf("DNS record %s was not proxied, proxying it through Cloudflare\n", fqdn)
+	}
+
+	return changed, c.request(ctx, http.MethodPut, path+"/"+records[0].ID, record, nil)
+}
+
+func (c *cloudflareDNS) LookupRecord(ctx context.Context, zone, name, recordType string) (string, error) {
+	if c.zoneID == "" {
+		if err := c.EnsureZone(ctx, zone); err != nil {
+			return "", err
+		}
+	}
+
+	var records []cloudflareRecord
+
+	query := "?type=" + url.QueryEscape(recordType) + "&name=" + url.QueryEscape(recordFQDN(zone, name))
+	if err := c.request(ctx, http.MethodGet, "/zones/"+c.zoneID+"/dns_records"+query, nil, &records); err != nil {
+		return "", err
+	}
+
+	if len(records) == 0 {
+		return "", nil
+	}
+
+	return records[0].Content, nil
+}
+
+// request calls the Cloudflare API and decodes the envelope's result into
+// result when it is not nil.
+func (c *cloudflareDNS) request(ctx context.Context, method, path string, body, result any) error {
+	var payload io.Reader = http.NoBody
+
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrCloudflareAPI, err)
+		}
+
+		payload = bytes.NewReader(encoded)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, cloudflareAPIURL+path, payload)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCloudflareAPI, err)
+	}
+
+	request.Header.Set("Authorization", "Bearer "+c.token)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCloudflareAPI, err)
+	}
+	defer response.Body.Close()
+
+	var envelope struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result json.RawMessage `json:"result"`
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&envelope); err != nil || !envelope.Success {
+		err = fmt.Errorf("%w: %s %s returned %s %v", ErrCloudflareAPI, method, path, response.Status, envelope.Errors)
+		if isRetryableStatus(response.StatusCode) {
+			return retryable(err)
+		}
+
+		return err
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(envelope.Result, result); err != nil {
+		return fmt.Errorf("%w: %w", ErrCloudflareAPI, err)
+	}
+
+	return nil
+}
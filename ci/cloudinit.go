@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCloudInitTimeout = 15 * time.Minute
+
+	// Exit codes of cloud-init status --wait under timeout(1).
+	cloudInitRecoverable = 2
+	cloudInitTimedOut    = 124
+)
+
+var ErrCloudInit = errors.New("droplet bootstrap did not complete")
+
+// hostKeyCloudConfig installs a pre-generated ed25519 host key before sshd
+// first starts, replacing the keys cloud-init would otherwise generate. That
+// lets the pipeline pin the key before it ever connects.
+func hostKeyCloudConfig(privateKey, publicKey string) string {
+	indented := "    " + strings.ReplaceAll(strings.TrimSpace(privateKey), "\n", "\n    ")
+
+	return fmt.Sprintf(`#cloud-config
+ssh_deletekeys: true
+ssh_genkeytypes: []
+ssh_keys:
+  ed25519_private: |
+%s
+  ed25519_public: %s
+`, indented, publicKey)
+}
+
+// cloudInitMultipart combines a cloud-config document and a shell script into
+// a single MIME multipart user-data payload.
+func cloudInitMultipart(cloudConfig, script string) (string, error) {
+	var body bytes.Buffer
+
+	writer := multipart.NewWriter(&body)
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/cloud-config", cloudConfig},
+		{"text/x-shellscript", script},
+	}
+
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType+`; charset="utf-8"`)
+
+		partWriter, err := writer.CreatePart(header)
+		if err != nil {
+			return "", fmt.Errorf("failed to create user-data part: %w", err)
+		}
+
+		if _, err := partWriter.Write([]byte(part.content)); err != nil {
+			return "", fmt.Errorf("failed to write user-data part: %w", err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to finish user-data: %w", err)
+	}
+
+	return fmt.Sprintf("Content-Type: multipart/mixed; boundary=%q\nMIME-Version: 1.0\n\n%s",
+		writer.Boundary(), body.String()), nil
+}
+
+// waitForCloudInit blocks until cloud-init has finished the first-boot
+// user-data, so nothing races its apt and Docker setup for the dpkg lock.
+// connectSSH keeps retrying while sshd is still coming up.
+func waitForCloudInit(ctx context.Context, host string, config *Config) error {
+	sshClient, err := connectSSH(ctx, host, config.deploySSHUser, config)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSSHClient, err)
+	}
+	defer sshClient.Close()
+
+	fmt.Printf("Waiting up to %s for cloud-init to finish on %s\n", config.cloudInitTimeout, host)
+
+	var output bytes.Buffer
+
+	code, err := sshClient.Run(fmt.Sprintf("timeout %d cloud-init status --wait --long",
+		int(config.cloudInitTimeout.Seconds())), nil, &output, &output)
+	if err != nil {
+		return fmt.Errorf("failed to check cloud-init status: %w", err)
+	}
+
+	switch code {
+	case 0:
+		fmt.Println("cloud-init finished")
+
+		return nil
+	case cloudInitRecoverable:
+		fmt.Printf("Warning: cloud-init finished with recoverable errors:\n%s", output.String())
+
+		return nil
+	case cloudInitTimedOut:
+		return fmt.Errorf("%w: cloud-init still running after %s", ErrCloudInit, config.cloudInitTimeout)
+	default:
+		return fmt.Errorf("%w: cloud-init failed, see /var/log/cloud-init-output.log on the droplet:\n%s",
+			ErrCloudInit, output.String())
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/felipepimentel/n8n-digitalocean-cicd/ci/ssh"
+)
+
+// sshdHardeningPath sorts first so its settings win over later drop-ins such
+// as cloud-init's, since sshd keeps the first value it reads.
+const sshdHardeningPath = "/etc/ssh/sshd_config.d/00-n8n-hardening.conf"
+
+// complianceControl is one baseline check. check exits 0 when the control
+// passes; fix remediates it.
+type complianceControl struct {
+	name  string
+	check string
+	fix   string
+}
+
+func complianceControls() []complianceControl {
+	return []complianceControl{
+		{
+			name:  "UFW firewall active",
+			check: `ufw status | grep -q "Status: active"`,
+			fix: `ufw default deny incoming
+ufw default allow outgoing
+ufw allow ssh
+ufw allow http
+ufw allow https
+yes | ufw enable`,
+		},
+		{
+			name:  "fail2ban running",
+			check: "systemctl is-active --quiet fail2ban",
+			fix:   "apt-get install -y fail2ban && systemctl enable --now fail2ban",
+		},
+		{
+			name:  "SSH password authentication disabled",
+			check: "sshd -T | grep -qix 'passwordauthentication no'",
+			fix:   generateSSHHardeningScript(),
+		},
+		{
+			// The deploy itself connects as root with a key, so root is restricted
+			// to key authentication rather than locked out
+			name:  "SSH root login restricted to keys",
+			check: "sshd -T | grep -Eqix 'permitrootlogin (no|prohibit-password|without-password)'",
+			fix:   generateSSHHardeningScript(),
+		},
+		{
+			name:  "Non-root n8n user exists",
+			check: "id n8n >/dev/null 2>&1",
+			fix:   generateNonRootUserScript(),
+		},
+		{
+			name: "Unattended upgrades enabled",
+			check: `dpkg -s unattended-upgrades >/dev/null 2>&1 &&
+grep -q 'APT::Periodic::Unattended-Upgrade "1"' /etc/apt/apt.conf.d/20auto-upgrades`,
+			fix: `apt-get install -y unattended-upgrades
+cat > /etc/apt/apt.conf.d/20auto-upgrades << 'EOF'
+APT::Periodic::Update-Package-Lists "1";
+APT::Periodic::Unattended-Upgrade "1";
+EOF`,
+		},
+	}
+}
+
+// generateSSHHardeningScript disables password logins and limits root to key
+// authentication. It only reloads sshd when the drop-in changes and the new
+// configuration validates, so re-running it is harmless.
+func generateSSHHardeningScript() string {
+	return fmt.Sprintf(`set -e
+mkdir -p "$(dirname %[1]s)"
+cat > %[1]s.new << 'EOF'
+PasswordAuthentication no
+PermitRootLogin prohibit-password
+EOF
+if cmp -s %[1]s.new %[1]s; then
+	rm %[1]s.new
+	exit 0
+fi
+mv %[1]s.new %[1]s
+if ! sshd -t; then
+	rm %[1]s
+	exit 1
+fi
+systemctl reload ssh
+echo "SSH password authentication disabled"`, sshdHardeningPath)
+}
+
+// runComplianceCheck reports whether the droplet meets the hardening baseline
+// applied by user-data, and exits non-zero if any control fails.
+func runComplianceCheck(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("compliance-check", flag.ExitOnError)
+	fix := flags.Bool("fix", false, "remediate failing controls and check them again")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config := loadConfig()
+
+	stopAgent, err := prepareSSHKey(&config)
+	if err != nil {
+		return err
+	}
+	defer stopAgent()
+
+	sshClient, err := connectDroplet(ctx, newDOClient(&config), config.resourceName(resourceDroplet), &config)
+	if err != nil {
+		return err
+	}
+	defer sshClient.Close()
+
+	failed := 0
+
+	for _, control := range complianceControls() {
+		passed, err := checkControl(sshClient, control, *fix)
+		if err != nil {
+			return err
+		}
+
+		status := "PASS"
+		if !passed {
+			status = "FAIL"
+			failed++
+		}
+
+		fmt.Printf("[%s] %s\n", status, control.name)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d controls failed\n", failed, len(complianceControls()))
+
+		return &exitCodeError{code: 1}
+	}
+
+	return nil
+}
+
+// checkControl runs the check, and when it fails and fix is set, the
+// remediation followed by the check again.
+func checkControl(sshClient *ssh.Client, control complianceControl, fix bool) (bool, error) {
+	code, err := sshClient.Run(control.check, nil, io.Discard, io.Discard)
+	if err != nil {
+		return false, fmt.Errorf("failed to check %q: %w", control.name, err)
+	}
+
+	if code == 0 || !fix {
+		return code == 0, nil
+	}
+
+	fmt.Printf("Fixing %s...\n", control.name)
+
+	if output, err := sshClient.ExecuteCommand(control.fix); err != nil {
+		fmt.Printf("Fix for %s failed: %v\nOutput: %s\n", control.name, err, output)
+
+		return false, nil
+	}
+
+	code, err = sshClient.Run(control.check, nil, io.Discard, io.Discard)
+	if err != nil {
+		return false, fmt.Errorf("failed to check %q: %w", control.name, err)
+	}
+
+	return code == 0, nil
+}
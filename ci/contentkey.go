@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// imageContentKey fingerprints what goes into the image rather than the
+// image itself: its digest changes on every build through the created and
+// build-url labels and non-reproducible layers, so comparing digests would
+// never find a deploy up to date. The key covers the resolved base image
+// (or the Dockerfile's build context), the copied sources, the community
+// nodes and the settings baked into the image.
+func imageContentKey(ctx context.Context, from *dagger.Container, config *Config, buster string) (string, error) {
+	sum := sha256.New()
+
+	if config.dockerfile != "" {
+		contextDir := config.buildContext
+		if contextDir == "" {
+			contextDir = filepath.Dir(config.dockerfile)
+		}
+
+		if err := hashDirectory(sum, "context", contextDir); err != nil {
+			return "", err
+		}
+	} else {
+		baseRef, err := from.ImageRef(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve base image digest: %w", err)
+		}
+
+		fmt.Fprintf(sum, "base %s\n", baseRef)
+	}
+
+	if err := hashDirectory(sum, "source", "."); err != nil {
+		return "", err
+	}
+
+	fmt.Fprintf(sum, "nodes %s\n", strings.Join(config.communityNodes, ","))
+	fmt.Fprintf(sum, "version %s\nuser-folder %s\nenforce-permissions %t\n",
+		config.n8nVersion, config.n8nUserFolder, config.enforceSettingsPermissions)
+
+	labels := make([]string, 0, len(config.imageLabels))
+	for name, value := range config.imageLabels {
+		labels = append(labels, name+"="+value)
+	}
+
+	sort.Strings(labels)
+	fmt.Fprintf(sum, "labels %s\n", strings.Join(labels, ","))
+
+	// BUILD_NO_CACHE asks for a fresh build, which is always a change
+	fmt.Fprintf(sum, "cache-buster %s\n", buster)
+
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// hashDirectory adds every regular file under root to sum, by path, mode and
+// content, in lexical order. The .git directory and the credentials file,
+// which the build excludes, are skipped.
+func hashDirectory(sum io.Writer, name, root string) error {
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() && entry.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		if !entry.Type().IsRegular() || entry.Name() == credentialsOutputName {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(sum, "%s %s %s\n", name, filepath.ToSlash(rel), info.Mode())
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(sum, file)
+
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to hash %s %s: %w", name, root, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+const (
+	// daggerEngineFilter matches the engine container the Dagger CLI starts on
+	// the local Docker daemon.
+	daggerEngineFilter = "name=dagger-engine"
+
+	// cacheBusterVar carries a per-run value into the build when
+	// BUILD_NO_CACHE is set.
+	cacheBusterVar = "CACHEBUSTER"
+)
+
+var ErrDaggerEngineNotFound = errors.New("no running Dagger engine container found")
+
+// connectDagger opens a Dagger session and applies the configured build
+// resource constraints to the engine.
+func connectDagger(ctx context.Context, config *Config) (*dagger.Client, error) {
+	var logOutput io.Writer = os.Stdout
+	if config.buildQuiet {
+		logOutput = io.Discard
+	}
+
+	limitCPU := config.buildCPULimit != "" && localDaggerEngine()
+	if config.buildCPULimit != "" && !limitCPU {
+		fmt.Println("Warning: BUILD_CPU_LIMIT is not applied to a Dagger engine this run does not start")
+	}
+
+	// Engines already running may serve other builds, so only the one the
+	// session starts is limited
+	var runningEngines []string
+
+	if limitCPU {
+		var err error
+		if runningEngines, err = daggerEngineContainers(); err != nil {
+			return nil, err
+		}
+	}
+
+	client, err := dagger.Connect(ctx, dagger.WithLogOutput(logOutput))
+	if err != nil {
+		return nil, err
+	}
+
+	if limitCPU {
+		if err := limitDaggerEngineCPU(config.buildCPULimit, runningEngines); err != nil {
+			client.Close()
+
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// cacheBuster returns a value unique to this run, or an empty string when
+// caching is allowed.
+func cacheBuster(config *Config) string {
+	if !config.buildNoCache {
+		return ""
+	}
+
+	return strconv.FormatInt(time.Now().UnixNano(), 10)
+}
+
+// withCacheBuster changes the environment of every step that follows, so
+// Dagger cannot serve them from its layer cache.
+func withCacheBuster(container *dagger.Container, buster string) *dagger.Container {
+	if buster == "" {
+		return container
+	}
+
+	return container.WithEnvVariable(cacheBusterVar, buster)
+}
+
+// localDaggerEngine reports whether the SDK starts the engine as a container
+// on the local Docker daemon. A session opened by 'dagger run' or a remote
+// runner uses an engine this run has no business limiting.
+func localDaggerEngine() bool {
+	if os.Getenv("DAGGER_SESSION_PORT") != "" {
+		return false
+	}
+
+	runner := os.Getenv("_EXPERIMENTAL_DAGGER_RUNNER_HOST")
+
+	return runner == "" || strings.HasPrefix(runner, "docker-image://")
+}
+
+func daggerEngineContainers() ([]string, error) {
+	output, err := exec.Command("docker", "ps", "-q", "--no-trunc", "--filter", daggerEngineFilter).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Dagger engine containers: %w", err)
+	}
+
+	return strings.Fields(string(output)), nil
+}
+
+// limitDaggerEngineCPU caps the CPU available to the Dagger engine container
+// the session started, leaving the engines in running alone. Dagger has no
+// per-container resource limits, so constraining the engine is the only way
+// to bound the build as a whole.
+func limitDaggerEngineCPU(limit string, running []string) error {
+	containerIDs, err := daggerEngineContainers()
+	if err != nil {
+		return err
+	}
+
+	if len(containerIDs) == 0 {
+		return ErrDaggerEngineNotFound
+	}
+
+	containerIDs = slices.DeleteFunc(containerIDs, func(id string) bool {
+		return slices.Contains(running, id)
+	})
+
+	if len(containerIDs) == 0 {
+		fmt.Println("Warning: BUILD_CPU_LIMIT is not applied: the Dagger engine was already running " +
+			"and may serve other builds")
+
+		return nil
+	}
+
+	args := append([]string{"update", "--cpus", limit}, containerIDs...)
+
+	updateOutput, err := exec.Command("docker", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to limit Dagger engine CPU: %w\nOutput: %s", err, updateOutput)
+	}
+
+	fmt.Printf("Limited Dagger engine to %s CPUs\n", limit)
+
+	return nil
+}
+
+func validateCPULimit(limit string) error {
+	if limit == "" {
+		return nil
+	}
+
+	cpus, err := strconv.ParseFloat(limit, 64)
+	if err != nil || cpus <= 0 {
+		return fmt.Errorf("%w: BUILD_CPU_LIMIT must be a positive number of CPUs, got %q", ErrInvalidConfig, limit)
+	}
+
+	return nil
+}
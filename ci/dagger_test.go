@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestLocalDaggerEngine(t *testing.T) {
+	tests := []struct {
+		name        string
+		runner      string
+		sessionPort string
+		want        bool
+	}{
+		{"started by the SDK", "", "", true},
+		{"pinned engine image", "docker-image://registry.dagger.io/engine:v0.9.3", "", true},
+		{"remote runner", "tcp://dagger.example.com:8080", "", false},
+		{"dagger run session", "", "41234", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("_EXPERIMENTAL_DAGGER_RUNNER_HOST", test.runner)
+			t.Setenv("DAGGER_SESSION_PORT", test.sessionPort)
+
+			if got := localDaggerEngine(); got != test.want {
+				t.Errorf("localDaggerEngine() = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
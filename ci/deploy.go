@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+var (
+	ErrDeployUsage   = errors.New("usage: deploy --image REF [--digest DIGEST] | --from FILE")
+	ErrImageNotFound = errors.New("image not found in the registry")
+)
+
+// runDeploy deploys an image pushed by an earlier build, pinned by digest,
+// without building anything: infrastructure is ensured, DNS reconciled and
+// the droplet's compose stack pointed at that exact image.
+func runDeploy(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("deploy", flag.ExitOnError)
+	ref := flags.String("image", "", "image reference to deploy, e.g. registry.digitalocean.com/REGISTRY/n8n:1.64.0")
+	digest := flags.String("digest", "", "expected image digest (sha256:...)")
+	from := flags.String("from", "", "read the image from the JSON written by build --output")
+	force := flags.Bool("force", false, "redeploy even if the image and configuration are unchanged")
+	forceKeyChange := flags.Bool("force-key-change", false,
+		"deploy even if N8N_ENCRYPTION_KEY differs from the running instance (stored credentials become unreadable)")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config := loadConfig()
+
+	image, err := deployImageFromFlags(config.registryURL, *ref, *digest, *from)
+	if err != nil {
+		return err
+	}
+	config.forceDeploy = *force
+	config.forceKeyChange = *forceKeyChange
+
+	// Checked before any secret is generated, which would be a side effect
+	if config.dryRun {
+		return runDryRun(ctx, &config, image)
+	}
+
+	if err := ensureEncryptionKey(&config); err != nil {
+		return err
+	}
+
+	if err := ensureBasicAuthPassword(&config); err != nil {
+		return err
+	}
+
+	if err := loadN8NEnvFile(&config); err != nil {
+		return err
+	}
+
+	if err := validateConfig(&config); err != nil {
+		return err
+	}
+
+	doClient := newDOClient(&config)
+
+	if err := verifyImageInRegistry(ctx, doClient, &config, image); err != nil {
+		return err
+	}
+
+	config.deployImage = image.pinnedRef()
+
+	ctx = withRetryBudget(ctx, config.retryBudget)
+
+	return runWithDeadline(ctx, config.deployTimeout, func(ctx context.Context, steps *stepTracker) error {
+		return runDeploySteps(ctx, doClient, &config, image, steps)
+	})
+}
+
+func runDeploySteps(ctx context.Context, client *godo.Client, config *Config, image *publishedImage, steps *stepTracker) error {
+	stopAgent, err := prepareDeploySteps(ctx, config, steps)
+	if err != nil {
+		return err
+	}
+	defer stopAgent()
+
+	steps.start("provisioning infrastructure")
+
+	dropletIP, err := setupInfrastructure(ctx, client, config)
+	if err != nil {
+		return err
+	}
+
+	return finishDeploySteps(ctx, client, config, dropletIP, image, steps)
+}
+
+// prepareDeploySteps are the first steps of run and deploy: the SSH key, and
+// with CLOUDFLARE the ranges the firewall admits. Callers must defer the
+// returned cleanup.
+func prepareDeploySteps(ctx context.Context, config *Config, steps *stepTracker) (func(), error) {
+	steps.start("setting up SSH key")
+
+	stopAgent, err := prepareSSHKey(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.cloudflare {
+		steps.start("fetching Cloudflare IP ranges")
+
+		if config.cloudflareRanges, err = fetchCloudflareRanges(ctx); err != nil {
+			stopAgent()
+
+			return nil, err
+		}
+	}
+
+	return stopAgent, nil
+}
+
+// finishDeploySteps are the last steps of run and deploy: image goes to the
+// provisioned droplet, then the deploy tags and the usage report follow,
+// which only warn when they fail.
+func finishDeploySteps(ctx context.Context, client *godo.Client, config *Config, dropletIP string,
+	image *publishedImage, steps *stepTracker,
+) error {
+	steps.start("deploying n8n")
+
+	if err := deployN8N(ctx, dropletIP, config, image); err != nil {
+		return err
+	}
+
+	updateDeployTags(ctx, client, config, image.version)
+	reportUsage(ctx, client, config)
+
+	fmt.Printf("Deployed %s\nAccess your instance at: %s\n", image.pinnedRef(), n8nBaseURL(config))
+
+	return nil
+}
+
+// deployImageFromFlags resolves the image from --from or --image/--digest.
+// The version is taken from the tag unless the build recorded it.
+func deployImageFromFlags(registryURL, ref, digest, from string) (*publishedImage, error) {
+	image := &publishedImage{ref: ref, digest: digest, prebuilt: true}
+
+	if from != "" {
+		if ref != "" || digest != "" {
+			return nil, fmt.Errorf("%w: --from cannot be combined with --image or --digest", ErrDeployUsage)
+		}
+
+		data, err := os.ReadFile(from)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", from, err)
+		}
+
+		var built builtImage
+		if err := json.Unmarshal(data, &built); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", from, err)
+		}
+
+		image = &publishedImage{
+			ref:        built.Ref,
+			digest:     built.Digest,
+			version:    built.N8NVersion,
+			signature:  built.Signature,
+			contentKey: built.ContentKey,
+			prebuilt:   true,
+		}
+	}
+
+	if image.ref == "" {
+		return nil, ErrDeployUsage
+	}
+
+	// A digest in the reference itself is accepted too
+	if refWithoutDigest, refDigest, found := strings.Cut(image.ref, "@"); found {
+		if image.digest != "" && image.digest != refDigest {
+			return nil, fmt.Errorf("%w: --digest %s does not match %s", ErrDeployUsage, image.digest, image.ref)
+		}
+
+		image.ref, image.digest = refWithoutDigest, refDigest
+	}
+
+	_, tag, err := splitImageRef(registryURL, image.ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if image.version == "" {
+		image.version = tag
+	}
+
+	return image, nil
+}
+
+// splitImageRef splits REGISTRY_URL/REGISTRY/REPOSITORY:TAG into the
+// repository path (REGISTRY/REPOSITORY) and the tag.
+func splitImageRef(registryURL, ref string) (string, string, error) {
+	path, found := strings.CutPrefix(ref, registryURL+"/")
+	if !found {
+		return "", "", fmt.Errorf("%w: %s is not in %s", ErrDeployUsage, ref, registryURL)
+	}
+
+	separator := strings.LastIndex(path, ":")
+	if separator < 0 || !strings.Contains(path[:separator], "/") {
+		return "", "", fmt.Errorf("%w: %s must be %s/REGISTRY/REPOSITORY:TAG", ErrDeployUsage, ref, registryURL)
+	}
+
+	return path[:separator], path[separator+1:], nil
+}
+
+// verifyImageInRegistry checks the image's manifest exists. Without a digest
+// the tag's current manifest is pinned, so every environment gets the same one.
+func verifyImageInRegistry(ctx context.Context, client *godo.Client, config *Config, image *publishedImage) error {
+	path, tag, err := splitImageRef(config.registryURL, image.ref)
+	if err != nil {
+		return err
+	}
+
+	registryName, repository, _ := strings.Cut(path, "/")
+
+	manifests, err := listRepositoryManifests(ctx, client, registryName, repository)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if image.digest == "" && slices.Contains(manifest.Tags, tag) {
+			image.digest = manifest.Digest
+		}
+
+		if manifest.Digest != image.digest {
+			continue
+		}
+
+		if !slices.Contains(manifest.Tags, tag) {
+			fmt.Printf("Note: %s has moved since the build; deploying %s by digest\n", image.ref, image.digest)
+		}
+
+		fmt.Printf("Found %s@%s in the registry\n", image.ref, image.digest)
+
+		return nil
+	}
+
+	if image.digest != "" {
+		return fmt.Errorf("%w: %s@%s", ErrImageNotFound, image.ref, image.digest)
+	}
+
+	return fmt.Errorf("%w: %s", ErrImageNotFound, image.ref)
+}
+
+// n8nServiceImage is the image the compose file runs: the image pushed by
+// the build, or the one given to deploy, pinned to its digest.
+func n8nServiceImage(config *Config) string {
+	return config.deployImage
+}
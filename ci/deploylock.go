@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/felipepimentel/n8n-digitalocean-cicd/ci/ssh"
+)
+
+const (
+	// deployLockPath sits next to the deploy state so every runner that can
+	// reach the droplet sees the same lock.
+	deployLockPath       = "/opt/n8n/deploy.lock"
+	defaultDeployLockTTL = 10 * time.Minute
+
+	// heartbeatsPerTTL is how often the holder refreshes the lock within one
+	// TTL, so a slow SSH round trip never makes a live run look stale.
+	heartbeatsPerTTL = 3
+
+	// deployLockAttempts bounds how often a lock that vanished or was taken
+	// over between two steps is retried before giving up.
+	deployLockAttempts   = 3
+	deployLockRetryDelay = time.Second
+)
+
+var ErrDeployLocked = errors.New("deploy locked")
+
+// deployLock records who holds the droplet's deploy lock. HeartbeatAt is
+// refreshed while the run is alive; once it is older than DEPLOY_LOCK_TTL the
+// holder is presumed dead.
+type deployLock struct {
+	Holder      string    `json:"holder"`
+	RunID       string    `json:"runId"`
+	AcquiredAt  time.Time `json:"acquiredAt"`
+	HeartbeatAt time.Time `json:"heartbeatAt"`
+}
+
+// stale reports whether the holder missed its heartbeats for longer than ttl.
+func (l *deployLock) stale(ttl time.Duration, now time.Time) bool {
+	return now.Sub(l.HeartbeatAt) > ttl
+}
+
+func (l *deployLock) describe() string {
+	return fmt.Sprintf("held by %s (run %s) since %s, last heartbeat %s",
+		l.Holder, l.RunID, l.AcquiredAt.Format(time.RFC3339), l.HeartbeatAt.Format(time.RFC3339))
+}
+
+// deployRunID identifies this run: the GitHub Actions run, or the local host
+// and process.
+func deployRunID() string {
+	if id := os.Getenv("GITHUB_RUN_ID"); id != "" {
+		return id + "/" + os.Getenv("GITHUB_RUN_ATTEMPT")
+	}
+
+	hostname, _ := os.Hostname()
+
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}
+
+// acquireDeployLock takes the droplet's deploy lock and keeps its heartbeat
+// fresh until the returned release is called. A lock whose heartbeat is older
+// than DEPLOY_LOCK_TTL, left behind by a crashed run, is taken over with a
+// warning; a live one fails the deploy.
+func acquireDeployLock(ctx context.Context, sshClient *ssh.Client, config *Config) (func(), error) {
+	now := time.Now().UTC()
+	lock := &deployLock{Holder: deployOperator(), RunID: deployRunID(), AcquiredAt: now, HeartbeatAt: now}
+
+	held, err := encodeDeployLock(lock)
+	if err != nil {
+		return nil, err
+	}
+
+	acquired, err := createDeployLock(sshClient, held)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; !acquired && attempt < deployLockAttempts; attempt++ {
+		existing, current, err := readDeployLock(sshClient)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case existing == nil:
+			// Released in between, or not written out by its creator yet, so
+			// the create is retried without removing anything
+			time.Sleep(deployLockRetryDelay)
+
+			acquired, err = createDeployLock(sshClient, held)
+		case !existing.stale(config.deployLockTTL, time.Now()):
+			return nil, fmt.Errorf("%w: %s; run force-unlock once it is stale (DEPLOY_LOCK_TTL=%s)",
+				ErrDeployLocked, existing.describe(), config.deployLockTTL)
+		default:
+			fmt.Printf("Warning: taking over stale deploy lock %s\n", existing.describe())
+
+			// Only replaced if no other run took it over since it was read
+			acquired, err = swapDeployLock(sshClient, current, held)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !acquired {
+		return nil, fmt.Errorf("%w: another run took the lock first", ErrDeployLocked)
+	}
+
+	heartbeatCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		heartbeatDeployLock(heartbeatCtx, sshClient, lock, &held, config.deployLockTTL/heartbeatsPerTTL)
+	}()
+
+	release := func() {
+		stop()
+		<-done
+
+		// Left alone if another run took it over in the meantime
+		if _, err := swapDeployLock(sshClient, held, ""); err != nil {
+			fmt.Printf("Warning: failed to release deploy lock: %v\n", err)
+		}
+	}
+
+	return release, nil
+}
+
+func encodeDeployLock(lock *deployLock) (string, error) {
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode deploy lock: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// createDeployLock writes content unless a lock exists. noclobber makes the
+// check and the write a single step on the droplet.
+func createDeployLock(sshClient *ssh.Client, content string) (bool, error) {
+	command := fmt.Sprintf("mkdir -p /opt/n8n && if (set -C; cat > %s) 2>/dev/null << 'EOF'\n%s\nEOF\n"+
+		"then echo created; else echo exists; fi", deployLockPath, content)
+
+	output, err := sshClient.ExecuteCommand(command)
+	if err != nil {
+		return false, fmt.Errorf("failed to create deploy lock: %w\nOutput: %s", err, output)
+	}
+
+	return strings.TrimSpace(output) == "created", nil
+}
+
+// readDeployLock returns the current lock and its content as read, or nil
+// when there is none.
+func readDeployLock(sshClient *ssh.Client) (*deployLock, string, error) {
+	output, err := sshClient.ExecuteCommand(fmt.Sprintf("cat %s 2>/dev/null || true", deployLockPath))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read deploy lock: %w", err)
+	}
+
+	content := strings.TrimSpace(output)
+	if content == "" {
+		return nil, "", nil
+	}
+
+	var lock deployLock
+	if err := json.Unmarshal([]byte(content), &lock); err != nil {
+		return nil, "", fmt.Errorf("failed to parse deploy lock %s: %w", deployLockPath, err)
+	}
+
+	return &lock, content, nil
+}
+
+// swapDeployLock replaces the lock with next, or removes it when next is
+// empty, but only while it still holds expected. Every run swaps under the
+// same flock on the droplet, so two runs can't both act on one lock, and the
+// new lock is moved into place so readers never see a truncated file.
+func swapDeployLock(sshClient *ssh.Client, expected, next string) (bool, error) {
+	swap := "rm -f " + deployLockPath
+	if next != "" {
+		swap = fmt.Sprintf("cat > %[1]s.tmp << 'EOF'\n%[2]s\nEOF\nmv %[1]s.tmp %[1]s", deployLockPath, next)
+	}
+
+	command := fmt.Sprintf(`mkdir -p /opt/n8n
+exec 9> %[1]s.guard
+flock 9
+expected=$(cat << 'EOF'
+%[2]s
+EOF
+)
+if [ "$(cat %[1]s 2>/dev/null)" = "$expected" ]; then
+%[3]s
+echo swapped
+fi`, deployLockPath, expected, swap)
+
+	output, err := sshClient.ExecuteCommand(command)
+	if err != nil {
+		return false, fmt.Errorf("failed to update deploy lock: %w\nOutput: %s", err, output)
+	}
+
+	return strings.TrimSpace(output) == "swapped", nil
+}
+
+// heartbeatDeployLock refreshes HeartbeatAt every interval until ctx is done,
+// keeping held at the content last written. The lock is only rewritten while
+// it still holds that content, so a run whose lock was forcibly taken over
+// does not clobber the new holder's.
+func heartbeatDeployLock(ctx context.Context, sshClient *ssh.Client, lock *deployLock, held *string,
+	interval time.Duration,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		lock.HeartbeatAt = time.Now().UTC()
+
+		next, err := encodeDeployLock(lock)
+		if err != nil {
+			return
+		}
+
+		swapped, err := swapDeployLock(sshClient, *held, next)
+		if err != nil {
+			fmt.Printf("Warning: failed to refresh deploy lock: %v\n", err)
+
+			continue
+		}
+
+		if !swapped {
+			fmt.Println("Warning: deploy lock is no longer held by this run")
+
+			return
+		}
+
+		*held = next
+	}
+}
+
+// runForceUnlock reports who holds the droplet's deploy lock and clears it,
+// but only once its heartbeat is older than DEPLOY_LOCK_TTL: a fresh
+// heartbeat means the run is still alive.
+func runForceUnlock(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("force-unlock", flag.ExitOnError)
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config := loadConfig()
+
+	if err := refuseDryRun(&config, "force-unlock"); err != nil {
+		return err
+	}
+
+	stopAgent, err := prepareSSHKey(&config)
+	if err != nil {
+		return err
+	}
+	defer stopAgent()
+
+	sshClient, err := connectDroplet(ctx, newDOClient(&config), config.resourceName(resourceDroplet), &config)
+	if err != nil {
+		return err
+	}
+	defer sshClient.Close()
+
+	lock, content, err := readDeployLock(sshClient)
+	if err != nil {
+		return err
+	}
+
+	if lock == nil {
+		fmt.Println("No deploy lock held")
+
+		return nil
+	}
+
+	fmt.Printf("Deploy lock %s\n", lock.describe())
+
+	if !lock.stale(config.deployLockTTL, time.Now()) {
+		return fmt.Errorf("%w: the holder's heartbeat is within DEPLOY_LOCK_TTL=%s, so a run is still in progress",
+			ErrDeployLocked, config.deployLockTTL)
+	}
+
+	removed, err := swapDeployLock(sshClient, content, "")
+	if err != nil {
+		return err
+	}
+
+	if !removed {
+		return fmt.Errorf("%w: the lock changed while it was being removed", ErrDeployLocked)
+	}
+
+	fmt.Println("Deploy lock removed")
+
+	return nil
+}
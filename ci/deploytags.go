@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// Deploy tags record the last successful deploy on the droplet, where the
+// DigitalOcean console shows them. DigitalOcean has no custom metrics API,
+// so tags are the closest place to surface this.
+const (
+	deployTagVersionPrefix = "n8n-version:"
+	deployTagTimePrefix    = "deployed:"
+	deployTagTimeFormat    = "20060102T1504Z"
+)
+
+// tagUnsafeChars are characters DigitalOcean does not allow in tag names.
+var tagUnsafeChars = regexp.MustCompile(`[^A-Za-z0-9_:-]`)
+
+// deployTags returns the tags describing a deploy of version at deployedAt.
+func deployTags(version string, deployedAt time.Time) []string {
+	return []string{
+		deployTagVersionPrefix + tagUnsafeChars.ReplaceAllString(version, "-"),
+		deployTagTimePrefix + deployedAt.UTC().Format(deployTagTimeFormat),
+	}
+}
+
+func isDeployTag(tag string) bool {
+	return strings.HasPrefix(tag, deployTagVersionPrefix) || strings.HasPrefix(tag, deployTagTimePrefix)
+}
+
+// updateDeployTags replaces the droplet's deploy tags with ones for version.
+// Tags left without resources are deleted so they do not pile up. Failures
+// are only reported, since the deploy itself already succeeded.
+func updateDeployTags(ctx context.Context, client *godo.Client, config *Config, version string) {
+	if !config.deployTags {
+		return
+	}
+
+	if err := replaceDeployTags(ctx, client, config, deployTags(version, time.Now())); err != nil {
+		fmt.Printf("Warning: failed to update deploy tags: %v\n", err)
+	}
+}
+
+func replaceDeployTags(ctx context.Context, client *godo.Client, config *Config, tags []string) error {
+	droplet, err := findDroplet(ctx, client, config.region, config.resourceName(resourceDroplet))
+	if err != nil {
+		return err
+	}
+
+	if droplet == nil {
+		return fmt.Errorf("%w: %s", ErrDropletNotFound, config.resourceName(resourceDroplet))
+	}
+
+	resource := []godo.Resource{{ID: fmt.Sprint(droplet.ID), Type: godo.DropletResourceType}}
+
+	for _, tag := range droplet.Tags {
+		if !isDeployTag(tag) || slices.Contains(tags, tag) {
+			continue
+		}
+
+		if _, err := client.Tags.UntagResources(ctx, tag, &godo.UntagResourcesRequest{Resources: resource}); err != nil {
+			return fmt.Errorf("failed to remove tag %s: %w", tag, err)
+		}
+
+		deleteUnusedTag(ctx, client, tag)
+	}
+
+	for _, tag := range tags {
+		if err := ensureTag(ctx, client, tag); err != nil {
+			return err
+		}
+
+		if _, err := client.Tags.TagResources(ctx, tag, &godo.TagResourcesRequest{Resources: resource}); err != nil {
+			return fmt.Errorf("failed to tag droplet with %s: %w", tag, err)
+		}
+	}
+
+	fmt.Printf("Tagged droplet %s with %s\n", droplet.Name, strings.Join(tags, ", "))
+
+	return nil
+}
+
+// ensureTag creates tag unless it exists already, for instance on another
+// deployment running the same version.
+func ensureTag(ctx context.Context, client *godo.Client, tag string) error {
+	if _, _, err := client.Tags.Get(ctx, tag); err == nil {
+		return nil
+	}
+
+	if _, _, err := client.Tags.Create(ctx, &godo.TagCreateRequest{Name: tag}); err != nil {
+		return fmt.Errorf("failed to create tag %s: %w", tag, err)
+	}
+
+	return nil
+}
+
+// deleteUnusedTag removes tag once nothing carries it. Other deployments may
+// share a version tag, so it is kept while they do.
+func deleteUnusedTag(ctx context.Context, client *godo.Client, tag string) {
+	existing, _, err := client.Tags.Get(ctx, tag)
+	if err != nil || existing.Resources == nil || existing.Resources.Count > 0 {
+		return
+	}
+
+	if _, err := client.Tags.Delete(ctx, tag); err != nil {
+		fmt.Printf("Warning: failed to delete unused tag %s: %v\n", tag, err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/felipepimentel/n8n-digitalocean-cicd/ci/ssh"
+)
+
+const rootUser = "root"
+
+var ErrDeployUser = errors.New("DEPLOY_SSH_USER cannot administer the droplet")
+
+// deployUserPattern is the portable subset of Linux user names.
+var deployUserPattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// generateDeployUserScript gives DEPLOY_SSH_USER root's authorized keys and
+// passwordless sudo on first boot, so a droplet this tool creates can be
+// managed without SSH as root from the start.
+func generateDeployUserScript(config *Config) string {
+	if config.deploySSHUser == rootUser {
+		return ""
+	}
+
+	return fmt.Sprintf(`
+# Deploy user
+id %[1]s >/dev/null 2>&1 || useradd -m -s /bin/bash %[1]s
+install -d -m 700 -o %[1]s -g %[1]s /home/%[1]s/.ssh
+[ -f /home/%[1]s/.ssh/authorized_keys ] || install -m 600 -o %[1]s -g %[1]s /root/.ssh/authorized_keys /home/%[1]s/.ssh/
+echo "%[1]s ALL=(ALL) NOPASSWD:ALL" > /etc/sudoers.d/90-deploy-%[1]s
+chmod 440 /etc/sudoers.d/90-deploy-%[1]s
+`, config.deploySSHUser)
+}
+
+// verifyDeployUser checks a non-root DEPLOY_SSH_USER can run commands as root
+// without a password, which every deploy step relies on. The connection
+// itself already proved the user exists and accepts the key.
+func verifyDeployUser(sshClient *ssh.Client, config *Config) error {
+	if config.deploySSHUser == rootUser {
+		return nil
+	}
+
+	output, err := sshClient.ExecuteCommand("test \"$(id -u)\" = 0")
+	if err != nil {
+		return fmt.Errorf("%w: %s needs passwordless sudo: %w\nOutput: %s", ErrDeployUser, config.deploySSHUser, err, output)
+	}
+
+	return nil
+}
+
+func validateDeployUser(user string) error {
+	if !deployUserPattern.MatchString(user) {
+		return fmt.Errorf("%w: DEPLOY_SSH_USER must be a Linux user name, got %q", ErrInvalidConfig, user)
+	}
+
+	return nil
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	defaultDNSResolvers       = "1.1.1.1,8.8.8.8,9.9.9.9,208.67.222.222"
+	defaultDNSQuorum          = 3
+	defaultDNSResolverTimeout = 5 * time.Second
+	dnsPort                   = "53"
+
+	recordTypeA    = "A"
+	recordTypeAAAA = "AAAA"
+
+	// DigitalOcean rejects record TTLs below 30 seconds.
+	defaultDNSTTL = 3600
+	minDNSTTL     = 30
+	maxDNSTTL     = 86400
+)
+
+// resolverResult is one resolver's answer for the domain.
+type resolverResult struct {
+	resolver string
+	ips      []string
+	err      error
+}
+
+// matches reports whether the resolver returned the expected IP.
+func (r resolverResult) matches(expectedIP string) bool {
+	return r.err == nil && slices.Contains(r.ips, expectedIP)
+}
+
+// queryResolvers looks domain up on every resolver concurrently, bounding
+// each lookup by timeout.
+func queryResolvers(ctx context.Context, domain string, resolvers []string, timeout time.Duration) []resolverResult {
+	results := make([]resolverResult, len(resolvers))
+
+	var wg sync.WaitGroup
+
+	for i, server := range resolvers {
+		wg.Add(1)
+
+		go func(i int, server string) {
+			defer wg.Done()
+
+			lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			ips, err := resolverFor(server).LookupHost(lookupCtx, domain)
+			results[i] = resolverResult{resolver: server, ips: ips, err: err}
+		}(i, server)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// resolverFor returns a resolver that bypasses the system configuration and
+// asks server directly.
+func resolverFor(server string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+
+			return dialer.DialContext(ctx, network, net.JoinHostPort(server, dnsPort))
+		},
+	}
+}
+
+// dnsTarget is an address the domain must resolve to, with the type of the
+// record that publishes it.
+type dnsTarget struct {
+	recordType string
+	ip         string
+}
+
+// dnsTargets are the droplet's IPv4 address (ipv4, which may be a reserved
+// IP) and, unless DNS_IPV6 is off or the droplet has none, its IPv6 address.
+func dnsTargets(config *Config, ipv4 string, droplet *godo.Droplet) []dnsTarget {
+	targets := []dnsTarget{{recordType: recordTypeA, ip: ipv4}}
+
+	if !config.dnsIPv6 || droplet == nil {
+		return targets
+	}
+
+	if ipv6, err := droplet.PublicIPv6(); err == nil && ipv6 != "" {
+		targets = append(targets, dnsTarget{recordType: recordTypeAAAA, ip: ipv6})
+	}
+
+	return targets
+}
+
+// cloudflareEdgeTargets are the addresses the domain resolves to through
+// Cloudflare's proxy, as Cloudflare's own nameservers for the zone answer.
+// Proxied records never return the droplet's address, so with CLOUDFLARE=true
+// resolvers are checked against these instead. AAAA is only included with
+// DNS_IPV6.
+func cloudflareEdgeTargets(ctx context.Context, config *Config) ([]dnsTarget, error) {
+	zone, _ := getDomainParts(config.domain)
+
+	var targets []dnsTarget
+
+	err := retryWithBackoff(ctx, apiAttempts, apiRetryDelay, func() error {
+		nameservers, err := net.DefaultResolver.LookupNS(ctx, zone)
+		if err != nil {
+			return retryable(fmt.Errorf("failed to look up the nameservers of %s: %w", zone, err))
+		}
+
+		if len(nameservers) == 0 {
+			return retryable(fmt.Errorf("%s has no nameservers", zone))
+		}
+
+		lookupCtx, cancel := context.WithTimeout(ctx, config.dnsResolverTimeout)
+		defer cancel()
+
+		nameserver := strings.TrimSuffix(nameservers[0].Host, ".")
+
+		ips, err := resolverFor(nameserver).LookupHost(lookupCtx, config.domain)
+		if err != nil {
+			return retryable(fmt.Errorf("failed to resolve %s on %s: %w", config.domain, nameserver, err))
+		}
+
+		targets = edgeTargets(ips, config.dnsIPv6)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%w: Cloudflare's nameservers return no address for %s", ErrDNSPropagation,
+			config.domain)
+	}
+
+	return targets, nil
+}
+
+// edgeTargets keeps the first address of each record type; a resolver that
+// caught up returns the same set, so one address per type identifies it.
+func edgeTargets(ips []string, ipv6 bool) []dnsTarget {
+	var targets []dnsTarget
+
+	for _, ip := range ips {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			continue
+		}
+
+		recordType := recordTypeAAAA
+		if parsed.To4() != nil {
+			recordType = recordTypeA
+		}
+
+		if recordType == recordTypeAAAA && !ipv6 {
+			continue
+		}
+
+		if !slices.ContainsFunc(targets, func(target dnsTarget) bool { return target.recordType == recordType }) {
+			targets = append(targets, dnsTarget{recordType: recordType, ip: ip})
+		}
+	}
+
+	return targets
+}
+
+func describeTargets(targets []dnsTarget) string {
+	described := make([]string, 0, len(targets))
+	for _, target := range targets {
+		described = append(described, target.recordType+" "+target.ip)
+	}
+
+	return strings.Join(described, ", ")
+}
+
+// checkDNSQuorum reports whether at least DNS_QUORUM of the results contain
+// expectedIP, along with the resolvers that do not yet.
+func checkDNSQuorum(results []resolverResult, config *Config, expectedIP string) (ok bool, lagging []string) {
+	agreeing := 0
+
+	for _, result := range results {
+		switch {
+		case result.matches(expectedIP):
+			agreeing++
+		case result.err != nil:
+			lagging = append(lagging, fmt.Sprintf("%s (%v)", result.resolver, result.err))
+		default:
+			lagging = append(lagging, fmt.Sprintf("%s (%s)", result.resolver, strings.Join(result.ips, ", ")))
+		}
+	}
+
+	return agreeing >= config.dnsQuorum, lagging
+}
+
+// laggingTargets checks every target against one round of lookups and
+// describes the record types that have not reached the quorum yet.
+func laggingTargets(results []resolverResult, config *Config, targets []dnsTarget) []string {
+	var lagging []string
+
+	for _, target := range targets {
+		if ok, resolvers := checkDNSQuorum(results, config, target.ip); !ok {
+			lagging = append(lagging, fmt.Sprintf("%s %s lagging on %s", target.recordType, target.ip,
+				strings.Join(resolvers, "; ")))
+		}
+	}
+
+	return lagging
+}
+
+// waitForDNSPropagation polls the resolvers until a quorum resolves the
+// domain to every target. IPv4 propagating alone is not enough: IPv6 clients
+// would still reach the old address. On timeout the error names each record
+// type that is still lagging.
+func waitForDNSPropagation(ctx context.Context, config *Config, targets []dnsTarget) error {
+	ticker := time.NewTicker(dnsCheckInterval)
+	defer ticker.Stop()
+
+	timeout := time.After(config.dnsTimeout)
+
+	for {
+		results := queryResolvers(ctx, config.domain, config.dnsResolvers, config.dnsResolverTimeout)
+
+		lagging := laggingTargets(results, config, targets)
+		if len(lagging) == 0 {
+			fmt.Printf("DNS for %s resolves to %s on a quorum of %d/%d resolvers\n",
+				config.domain, describeTargets(targets), config.dnsQuorum, len(config.dnsResolvers))
+
+			return nil
+		}
+
+		fmt.Printf("Waiting for DNS propagation of %s: %s\n", config.domain, strings.Join(lagging, " | "))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			return fmt.Errorf("%w: %s", ErrDNSPropagation, strings.Join(lagging, " | "))
+		case <-ticker.C:
+		}
+	}
+}
+
+// runDNSCheck checks once whether the domain has propagated to the droplet's
+// IPv4 and IPv6 addresses (or --ip and --ipv6), exiting non-zero when the
+// quorum is not met for either. With RESERVED_IP the A record is expected to
+// point at the reserved IP, as provisioning sets it.
+func runDNSCheck(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("dns-check", flag.ExitOnError)
+	expectedIP := flags.String("ip", "", "expected IPv4 address (defaults to RESERVED_IP or the droplet's public IP)")
+	expectedIPv6 := flags.String("ipv6", "", "expected IPv6 address (defaults to the droplet's, unless DNS_IPV6=false)")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config := loadConfig()
+
+	if err := validateDNSCheckConfig(&config); err != nil {
+		return err
+	}
+
+	targets := []dnsTarget{{recordType: recordTypeA, ip: *expectedIP}}
+	if *expectedIPv6 != "" {
+		targets = append(targets, dnsTarget{recordType: recordTypeAAAA, ip: *expectedIPv6})
+	}
+
+	if *expectedIP == "" {
+		droplet, err := findDroplet(ctx, newDOClient(&config), config.region, config.resourceName(resourceDroplet))
+		if err != nil {
+			return err
+		}
+
+		if droplet == nil {
+			return fmt.Errorf("%w: %s (pass --ip)", ErrDropletNotFound, config.resourceName(resourceDroplet))
+		}
+
+		ipv4 := config.reservedIP
+		if ipv4 == "" {
+			if ipv4, err = droplet.PublicIPv4(); err != nil {
+				return fmt.Errorf("failed to get droplet IP: %w", err)
+			}
+		}
+
+		targets = dnsTargets(&config, ipv4, droplet)
+
+		if config.cloudflare {
+			if targets, err = cloudflareEdgeTargets(ctx, &config); err != nil {
+				return err
+			}
+		}
+	}
+
+	results := queryResolvers(ctx, config.domain, config.dnsResolvers, config.dnsResolverTimeout)
+
+	for _, result := range results {
+		statuses := make([]string, 0, len(targets))
+
+		for _, target := range targets {
+			status := "ok"
+			if !result.matches(target.ip) {
+				status = "lagging"
+			}
+
+			statuses = append(statuses, target.recordType+" "+status)
+		}
+
+		if result.err != nil {
+			fmt.Printf("%-16s %-24s %v\n", result.resolver, strings.Join(statuses, ", "), result.err)
+		} else {
+			fmt.Printf("%-16s %-24s %s\n", result.resolver, strings.Join(statuses, ", "), strings.Join(result.ips, ", "))
+		}
+	}
+
+	failed := false
+
+	for _, target := range targets {
+		if ok, _ := checkDNSQuorum(results, &config, target.ip); !ok {
+			fmt.Printf("Quorum of %d not reached for %s %s -> %s\n", config.dnsQuorum, target.recordType, config.domain,
+				target.ip)
+
+			failed = true
+		}
+	}
+
+	if failed {
+		return &exitCodeError{code: 1}
+	}
+
+	return nil
+}
+
+func validateDNSCheckConfig(config *Config) error {
+	if len(config.dnsResolvers) == 0 {
+		return fmt.Errorf("%w: DNS_RESOLVERS must list at least one resolver", ErrInvalidConfig)
+	}
+
+	if config.dnsQuorum < 1 || config.dnsQuorum > len(config.dnsResolvers) {
+		return fmt.Errorf("%w: DNS_QUORUM must be between 1 and %d (the number of DNS_RESOLVERS), got %d",
+			ErrInvalidConfig, len(config.dnsResolvers), config.dnsQuorum)
+	}
+
+	if config.dnsResolverTimeout <= 0 {
+		return fmt.Errorf("%w: DNS_RESOLVER_TIMEOUT must be positive, got %s", ErrInvalidConfig, config.dnsResolverTimeout)
+	}
+
+	if config.dnsTimeout < dnsCheckInterval {
+		return fmt.Errorf("%w: DNS_WAIT_TIMEOUT must be at least %s, got %s",
+			ErrInvalidConfig, dnsCheckInterval, config.dnsTimeout)
+	}
+
+	if config.dnsTTL < minDNSTTL || config.dnsTTL > maxDNSTTL {
+		return fmt.Errorf("%w: DNS_TTL must be between %d and %d seconds, got %d",
+			ErrInvalidConfig, minDNSTTL, maxDNSTTL, config.dnsTTL)
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	dnsProviderDigitalOcean = "digitalocean"
+	dnsProviderCloudflare   = "cloudflare"
+)
+
+// DNSProvider manages the zone N8N_DOMAIN lives in, selected by DNS_PROVIDER.
+type DNSProvider interface {
+	// EnsureZone makes sure the zone exists, creating it where the provider
+	// supports that.
+	EnsureZone(ctx context.Context, zone string) error
+	// UpsertRecord points the record of recordType named name ("@" for the
+	// apex) at value, editing an existing record rather than adding a second
+	// one so repeated runs stay idempotent. It reports whether the record's
+	// target changed.
+	UpsertRecord(ctx context.Context, zone, name, recordType, value string, ttl int) (bool, error)
+	// LookupRecord returns the current target of the record, or "" when
+	// there is none, without changing anything.
+	LookupRecord(ctx context.Context, zone, name, recordType string) (string, error)
+}
+
+func newDNSProvider(client *godo.Client, config *Config) DNSProvider {
+	if config.dnsProvider == dnsProviderCloudflare {
+		return &cloudflareDNS{token: config.cloudflareAPIToken, proxied: config.cloudflare}
+	}
+
+	return &digitalOceanDNS{client: client}
+}
+
+// recordFQDN is the fully qualified name of a record in zone.
+func recordFQDN(zone, name string) string {
+	if name == "@" {
+		return zone
+	}
+
+	return name + "." + zone
+}
+
+func validateDNSProvider(config *Config) error {
+	switch config.dnsProvider {
+	case dnsProviderDigitalOcean:
+		return nil
+	case dnsProviderCloudflare:
+		if config.cloudflareAPIToken == "" {
+			return fmt.Errorf("%w: DNS_PROVIDER=cloudflare requires CLOUDFLARE_API_TOKEN", ErrInvalidConfig)
+		}
+
+		if config.dnsTTL < minCloudflareTTL {
+			return fmt.Errorf("%w: Cloudflare requires DNS_TTL of at least %d seconds, got %d",
+				ErrInvalidConfig, minCloudflareTTL, config.dnsTTL)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w: DNS_PROVIDER must be %q or %q, got %q",
+			ErrInvalidConfig, dnsProviderDigitalOcean, dnsProviderCloudflare, config.dnsProvider)
+	}
+}
+
+// digitalOceanDNS manages the zone as a DigitalOcean domain.
+type digitalOceanDNS struct {
+	client *godo.Client
+}
+
+func (d *digitalOceanDNS) EnsureZone(ctx context.Context, zone string) error {
+	// Check if domain exists
+	_, resp, err := d.client.Domains.Get(ctx, zone)
+	if err != nil {
+		if resp != nil && resp.StatusCode == 404 {
+			// Domain doesn't exist, create it
+			_, _, createErr := d.client.Domains.Create(ctx, &godo.DomainCreateRequest{
+				Name: zone,
+			})
+
+			if createErr != nil {
+				return fmt.Errorf("%w: %s", ErrDomainCreation, createErr)
+			}
+
+			return nil
+		}
+
+		return fmt.Errorf("failed to check domain: %w", err)
+	}
+
+	return nil
+}
+
+func (d *digitalOceanDNS) LookupRecord(ctx context.Context, zone, name, recordType string) (string, error) {
+	records, _, err := d.client.Domains.RecordsByTypeAndName(ctx, zone, recordType, recordFQDN(zone, name),
+		&godo.ListOptions{})
+	if err != nil || len(records) == 0 {
+		return "", err
+	}
+
+	return records[0].Data, nil
+}
+
+func (d *digitalOceanDNS) UpsertRecord(ctx context.Context, zone, name, recordType, value string, ttl int) (bool, error) {
+	request := &godo.DomainRecordEditRequest{
+		Type: recordType,
+		Name: name,
+		Data: value,
+		TTL:  ttl,
+	}
+
+	// Lookups by name take the fully qualified name
+	fqdn := recordFQDN(zone, name)
+
+	records, _, err := d.client.Domains.RecordsByTypeAndName(ctx, zone, recordType, fqdn, &godo.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	if len(records) == 0 {
+		_, _, err = d.client.Domains.CreateRecord(ctx, zone, request)
+
+		return true, err
+	}
+
+	changed := records[0].Data != value
+	if !changed && records[0].TTL == ttl {
+		return false, nil
+	}
+
+	if changed {
+		fmt.Printf("DNS record %s pointed at %s, updating it to %s\n", fqdn, records[0].Data, value)
+	}
+
+	_, _, err = d.client.Domains.EditRecord(ctx, zone, records[0].ID, request)
+
+	return changed, err
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestDigitalOceanDNSAppliesTheTTL(t *testing.T) {
+	const (
+		records = "GET /v2/domains/example.com/records"
+		create  = "POST /v2/domains/example.com/records"
+		edit    = "PUT /v2/domains/example.com/records/1"
+	)
+
+	tests := []struct {
+		name     string
+		existing string
+		ttl      int
+		want     string
+	}{
+		{"created with the TTL", ``, 300, create},
+		{"updated when only the TTL differs", `{"id":1,"type":"A","name":"n8n","data":"203.0.113.10","ttl":3600}`, 300, edit},
+		{"left alone when it matches", `{"id":1,"type":"A","name":"n8n","data":"203.0.113.10","ttl":300}`, 300, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := defaultTestConfig(t)
+
+			fake, client := newFakeDO(t, config, map[string]string{
+				records: `{"domain_records":[` + test.existing + `]}`,
+				create:  `{"domain_record":{"id":1}}`,
+				edit:    `{"domain_record":{"id":1}}`,
+			})
+
+			provider := newDNSProvider(client, config)
+			if _, err := provider.UpsertRecord(context.Background(), "example.com", "n8n", "A", "203.0.113.10",
+				test.ttl); err != nil {
+				t.Fatal(err)
+			}
+
+			mutations := fake.mutations()
+			if test.want == "" {
+				if len(mutations) > 0 {
+					t.Errorf("requests = %v, want none", mutations)
+				}
+
+				return
+			}
+
+			if !slices.Equal(mutations, []string{test.want}) {
+				t.Fatalf("requests = %v, want %s", mutations, test.want)
+			}
+
+			var sent struct {
+				TTL int `json:"ttl"`
+			}
+			if err := json.Unmarshal([]byte(fake.body(test.want)), &sent); err != nil {
+				t.Fatal(err)
+			}
+
+			if sent.TTL != test.ttl {
+				t.Errorf("sent TTL %d, want %d", sent.TTL, test.ttl)
+			}
+		})
+	}
+}
+
+func TestValidateDNSTTL(t *testing.T) {
+	for _, ttl := range []int{minDNSTTL, defaultDNSTTL, maxDNSTTL} {
+		config := defaultTestConfig(t)
+		config.dnsTTL = ttl
+
+		if err := validateDNSCheckConfig(config); err != nil {
+			t.Errorf("DNS_TTL=%d: %v", ttl, err)
+		}
+	}
+
+	for _, ttl := range []int{0, minDNSTTL - 1, maxDNSTTL + 1} {
+		config := defaultTestConfig(t)
+		config.dnsTTL = ttl
+
+		if err := validateDNSCheckConfig(config); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("DNS_TTL=%d: err = %v, want ErrInvalidConfig", ttl, err)
+		}
+	}
+
+	config := defaultTestConfig(t)
+	config.dnsProvider = dnsProviderCloudflare
+	config.cloudflareAPIToken = "token"
+	config.dnsTTL = minCloudflareTTL - 1
+
+	if err := validateDNSProvider(config); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Cloudflare with DNS_TTL=%d: err = %v, want ErrInvalidConfig", config.dnsTTL, err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	composeV1Command = "docker-compose"
+	composeV2Command = "docker compose"
+
+	// Compose CLI selection.
+	composeCLIAuto = "auto"
+	composeCLIV1   = "v1"
+	composeCLIV2   = "v2"
+
+	// composeCmd is how generated scripts invoke Compose once
+	// composeDetectScript has run.
+	composeCmd = "$COMPOSE"
+
+	composePluginDir = "/usr/local/lib/docker/cli-plugins"
+	composeAssetURL  = "https://github.com/docker/compose/releases/download/%s/docker-compose-linux-x86_64"
+)
+
+var (
+	dockerVersionPattern  = regexp.MustCompile(`^[0-9A-Za-z.:~+-]+$`)
+	composeVersionPattern = regexp.MustCompile(`^v2\.[0-9]+\.[0-9]+$`)
+	composeProjectPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+)
+
+// composeDetectScript exports $COMPOSE as the Compose CLI to use on the
+// droplet: the v2 plugin when present, the legacy v1 binary otherwise. Every
+// generated script that runs Compose must start with it.
+func composeDetectScript(config *Config) string {
+	cli := config.composeCLI
+	if cli == composeCLIAuto && config.composeVersion != "" {
+		// A pinned version is installed as the v2 plugin
+		cli = composeCLIV2
+	}
+
+	switch cli {
+	case composeCLIV2:
+		return fmt.Sprintf("export COMPOSE=%q", composeV2Command)
+	case composeCLIV1:
+		return fmt.Sprintf("export COMPOSE=%q", composeV1Command)
+	default:
+		return fmt.Sprintf(`if docker compose version >/dev/null 2>&1; then
+	export COMPOSE=%q
+else
+	export COMPOSE=%q
+fi`, composeV2Command, composeV1Command)
+	}
+}
+
+// generateDockerPinScript installs the configured Docker Engine and Compose
+// plugin versions so every droplet runs the same toolchain regardless of what
+// the marketplace image ships with.
+func generateDockerPinScript(config *Config) string {
+	script := ""
+
+	if config.dockerVersion != "" {
+		script += fmt.Sprintf(`
+# Pin Docker Engine
+apt-get install -y --allow-downgrades docker-ce=%[1]s docker-ce-cli=%[1]s containerd.io
+apt-mark hold docker-ce docker-ce-cli
+`, config.dockerVersion)
+	}
+
+	if config.composeVersion != "" {
+		script += fmt.Sprintf(`
+# Pin Docker Compose v2 plugin
+mkdir -p %[1]s
+curl -fsSL %[2]s -o %[1]s/docker-compose
+chmod +x %[1]s/docker-compose
+docker compose version
+`, composePluginDir, fmt.Sprintf(composeAssetURL, config.composeVersion))
+	}
+
+	return script
+}
+
+func validateDockerVersions(config *Config) error {
+	if config.dockerVersion != "" && !dockerVersionPattern.MatchString(config.dockerVersion) {
+		return fmt.Errorf("%w: DOCKER_VERSION %q is not a valid apt package version", ErrInvalidConfig, config.dockerVersion)
+	}
+
+	if config.composeVersion != "" && !composeVersionPattern.MatchString(config.composeVersion) {
+		return fmt.Errorf("%w: COMPOSE_VERSION must look like v2.24.5, got %q", ErrInvalidConfig, config.composeVersion)
+	}
+
+	switch config.composeCLI {
+	case composeCLIAuto, composeCLIV1, composeCLIV2:
+	default:
+		return fmt.Errorf("%w: COMPOSE_CLI must be %q, %q or %q, got %q",
+			ErrInvalidConfig, composeCLIAuto, composeCLIV1, composeCLIV2, config.composeCLI)
+	}
+
+	return nil
+}
+
+// serviceContainerFilter returns docker ps flags that select a service's
+// containers in the configured deploy mode.
+func serviceContainerFilter(config *Config, service string) string {
+	if config.deployMode == deployModeSwarm {
+		return fmt.Sprintf("--filter label=com.docker.swarm.service.name=%s_%s", config.composeProject, service)
+	}
+
+	return fmt.Sprintf("--filter label=com.docker.compose.project=%s --filter label=com.docker.compose.service=%s",
+		config.composeProject, service)
+}
+
+// validateComposeProject applies Compose's own naming rules, since container
+// and volume names on the droplet are derived from the project name.
+func validateComposeProject(project string) error {
+	if !composeProjectPattern.MatchString(project) {
+		return fmt.Errorf("%w: COMPOSE_PROJECT_NAME must be lowercase letters, digits, '-' or '_' "+
+			"and start with a letter or digit, got %q", ErrInvalidConfig, project)
+	}
+
+	return nil
+}
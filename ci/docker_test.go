@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestComposeProjectName(t *testing.T) {
+	if config := defaultTestConfig(t); config.composeProject != defaultComposeProject {
+		t.Errorf("composeProject = %q, want the default %q", config.composeProject, defaultComposeProject)
+	}
+
+	if config := testConfig(t, map[string]string{"DEPLOY_PREFIX": "Staging"}); config.composeProject != "staging" {
+		t.Errorf("composeProject = %q, want it derived from DEPLOY_PREFIX", config.composeProject)
+	}
+
+	config := testConfig(t, map[string]string{"DEPLOY_PREFIX": "Staging", "COMPOSE_PROJECT_NAME": "custom"})
+	if config.composeProject != "custom" {
+		t.Errorf("composeProject = %q, want COMPOSE_PROJECT_NAME to win", config.composeProject)
+	}
+}
+
+func TestDatabaseIsFoundByComposeLabels(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.composeProject = "staging"
+
+	check := generatePostgresCheck(config)
+	want := "docker ps -a -q --filter label=com.docker.compose.project=staging " +
+		"--filter label=com.docker.compose.service=db"
+
+	if !strings.Contains(check, want) {
+		t.Errorf("postgres check does not filter by labels:\n%s", check)
+	}
+
+	config.deployMode = deployModeSwarm
+
+	if filter := serviceContainerFilter(config, "db"); filter != "--filter label=com.docker.swarm.service.name=staging_db" {
+		t.Errorf("swarm filter = %q", filter)
+	}
+
+	if volume := n8nDataVolume(config); volume != "staging_n8n_data" {
+		t.Errorf("n8nDataVolume = %q, want it in the project", volume)
+	}
+}
+
+func TestValidateComposeProject(t *testing.T) {
+	for _, project := range []string{"n8n", "n8n-staging", "eu_1"} {
+		if err := validateComposeProject(project); err != nil {
+			t.Errorf("COMPOSE_PROJECT_NAME=%q: %v", project, err)
+		}
+	}
+
+	for _, project := range []string{"", "N8N", "-n8n", "n8n.prod", "n8n prod"} {
+		if err := validateComposeProject(project); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("COMPOSE_PROJECT_NAME=%q: err = %v, want ErrInvalidConfig", project, err)
+		}
+	}
+}
+
+func TestSwarmStackFileDropsComposeOnlyKeys(t *testing.T) {
+	config := defaultTestConfig(t)
+
+	compose := generateDockerComposeContent(config)
+	for _, want := range []string{"driver: bridge", "depends_on:", "condition: service_healthy",
+		`"${N8N_BIND_ADDRESS}:5678:5678"`} {
+		if !strings.Contains(compose, want) {
+			t.Errorf("compose file lacks %q", want)
+		}
+	}
+
+	config.deployMode = deployModeSwarm
+
+	stack := generateDockerComposeContent(config)
+	for _, want := range []string{"driver: overlay", "attachable: true", `"5678:5678"`} {
+		if !strings.Contains(stack, want) {
+			t.Errorf("stack file lacks %q", want)
+		}
+	}
+
+	for _, unwanted := range []string{"driver: bridge", "depends_on:", "service_healthy", "N8N_BIND_ADDRESS"} {
+		if strings.Contains(stack, unwanted) {
+			t.Errorf("stack file still has %q:\n%s", unwanted, stack)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// dropletOffTimeout is how long a new droplet may report "off" before it is
+// powered on, and again before giving up.
+const dropletOffTimeout = 2 * time.Minute
+
+// waitForDropletActive polls until the droplet is active. A droplet that
+// errors or stays powered off fails the wait instead of hanging the pipeline;
+// a powered-off one is powered on once first when powerOn is set.
+func waitForDropletActive(ctx context.Context, client *godo.Client, dropletID int, powerOn bool) (*godo.Droplet, error) {
+	var offSince time.Time
+
+	poweredOn := false
+
+	for {
+		d, _, err := client.Droplets.Get(ctx, dropletID)
+
+		switch {
+		case err != nil:
+			if !isRetryable(err) {
+				return nil, fmt.Errorf("failed to get droplet status: %w", err)
+			}
+
+			fmt.Printf("Transient error checking droplet status: %v\n", err)
+		case d.Status == "active":
+			return d, nil
+		case d.Status == "errored" || d.Status == "archive":
+			return nil, dropletStuckError(ctx, client, d)
+		case d.Status == "off":
+			if offSince.IsZero() {
+				offSince = time.Now()
+			}
+
+			if time.Since(offSince) < dropletOffTimeout {
+				break
+			}
+
+			if !powerOn || poweredOn {
+				return nil, dropletStuckError(ctx, client, d)
+			}
+
+			fmt.Printf("Droplet %s has been off for %s, powering it on\n", d.Name, dropletOffTimeout)
+
+			if _, _, err := client.DropletActions.PowerOn(ctx, dropletID); err != nil {
+				return nil, fmt.Errorf("failed to power on droplet %s: %w", d.Name, err)
+			}
+
+			poweredOn = true
+			offSince = time.Now()
+		default:
+			offSince = time.Time{}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(dropletStatusCheckDelay):
+		}
+	}
+}
+
+// dropletStuckError describes a droplet that will not become active, with
+// the failed actions DigitalOcean recorded for it.
+func dropletStuckError(ctx context.Context, client *godo.Client, droplet *godo.Droplet) error {
+	actions, _, err := client.Droplets.Actions(ctx, droplet.ID, &godo.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("%w: %s is %s (failed to list actions: %w)", ErrDropletStuck, droplet.Name, droplet.Status, err)
+	}
+
+	var failed []string
+
+	for _, action := range actions {
+		if action.Status != "errored" {
+			continue
+		}
+
+		started := ""
+		if action.StartedAt != nil {
+			started = " at " + action.StartedAt.Format(time.RFC3339)
+		}
+
+		failed = append(failed, action.Type+started)
+	}
+
+	if len(failed) == 0 {
+		return fmt.Errorf("%w: %s is %s", ErrDropletStuck, droplet.Name, droplet.Status)
+	}
+
+	return fmt.Errorf("%w: %s is %s; failed actions: %s", ErrDropletStuck, droplet.Name, droplet.Status,
+		strings.Join(failed, ", "))
+}
@@ -0,0 +1,624 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/digitalocean/godo"
+)
+
+// Plan actions.
+const (
+	planCreate   = "create"
+	planUpdate   = "update"
+	planNoChange = "no change"
+	planBuild    = "build"
+	planDeploy   = "deploy"
+)
+
+// Stand-ins for the addresses a droplet created by the run would get.
+const (
+	plannedIPv4 = "<new droplet IPv4>"
+	plannedIPv6 = "<new droplet IPv6>"
+)
+
+var ErrDryRunMutation = errors.New("DRY_RUN refused a mutating DigitalOcean API call")
+
+// readOnlyTransport lets only reads through, so a dry run cannot change the
+// account even if a code path it reaches would.
+type readOnlyTransport struct {
+	next http.RoundTripper
+}
+
+func (t *readOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return nil, fmt.Errorf("%w: %s %s", ErrDryRunMutation, req.Method, req.URL.Path)
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// planEntry is one line of the plan.
+type planEntry struct {
+	action   string
+	resource string
+	detail   string
+}
+
+type plan []planEntry
+
+// add records an entry once; provisioning reads some resources repeatedly.
+func (p *plan) add(action, resource, detail string, args ...any) {
+	entry := planEntry{action: action, resource: resource, detail: fmt.Sprintf(detail, args...)}
+	if !slices.Contains(*p, entry) {
+		*p = append(*p, entry)
+	}
+}
+
+// changes counts the entries that create or update something.
+func (p plan) changes() int {
+	count := 0
+
+	for _, entry := range p {
+		if entry.action == planCreate || entry.action == planUpdate {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (p plan) print() {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, tabwriterWidth, ' ', 0)
+	fmt.Fprintln(writer, "ACTION\tRESOURCE\tDETAIL")
+
+	for _, entry := range p {
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", entry.action, entry.resource, entry.detail)
+	}
+
+	writer.Flush()
+}
+
+// refuseDryRun stops a command without a dry run before it changes anything,
+// rather than letting DRY_RUN=true run it for real.
+func refuseDryRun(config *Config, command string) error {
+	if !config.dryRun {
+		return nil
+	}
+
+	return fmt.Errorf("%w: DRY_RUN is only supported by %s and deploy, not %s", ErrInvalidConfig, commandRun, command)
+}
+
+// runDryRun prints what run (image == nil) or deploy would create, update or
+// leave alone. It walks the real provisioning path with a planning client
+// that records the changes instead of making them, so the plan cannot drift
+// from what a run does. No secret is generated, no image is built and the
+// droplet is not contacted.
+func runDryRun(ctx context.Context, config *Config, image *publishedImage) error {
+	// A placeholder stands in for a key the run would generate; the plan
+	// never shows it
+	if err := usePlaceholderEncryptionKey(config); err != nil {
+		return err
+	}
+
+	// A run would refuse a weak password before changing anything, so the
+	// plan does too; one GENERATE_BASIC_AUTH_PASS would create is not made
+	if !basicAuthPassWillBeGenerated(config) {
+		if err := checkBasicAuthPassword(config); err != nil {
+			return err
+		}
+	}
+
+	if err := loadN8NEnvFile(config); err != nil {
+		return err
+	}
+
+	if err := validateConfig(config); err != nil {
+		return err
+	}
+
+	fmt.Println("DRY_RUN: walking the provisioning steps without changing anything; " +
+		"messages below describe what a run would do")
+
+	var steps plan
+
+	client := planningClient(newDOClient(config), config, &steps)
+
+	if image != nil {
+		if err := verifyImageInRegistry(ctx, client, config, image); err != nil {
+			return err
+		}
+	}
+
+	if config.cloudflare {
+		var err error
+		if config.cloudflareRanges, err = fetchCloudflareRanges(ctx); err != nil {
+			return err
+		}
+	}
+
+	dns := &planningDNS{DNSProvider: newDNSProvider(client, config), steps: &steps}
+
+	infra, err := provisionInfrastructure(ctx, client, dns, config)
+	if err != nil {
+		return err
+	}
+
+	target := infra.droplet.Name
+	if infra.hostPublicKey != "" {
+		target += " (new)"
+	}
+
+	if image == nil {
+		registryName := "n8n"
+		if registry, _, err := client.Registry.Get(ctx); err == nil && registry != nil {
+			registryName = registry.Name
+		}
+
+		steps.add(planBuild, "image", "%s/%s/n8n:%s and :latest", config.registryURL, registryName, config.n8nVersion)
+		steps.add(planDeploy, "n8n", "built image to %s (%s), skipped on the droplet if unchanged", target,
+			config.deployMode)
+	} else {
+		steps.add(planDeploy, "n8n", "%s to %s (%s), skipped on the droplet if unchanged", image.pinnedRef(),
+			target, config.deployMode)
+	}
+
+	fmt.Printf("DRY_RUN: nothing was changed. %d planned changes:\n", steps.changes())
+	steps.print()
+
+	return nil
+}
+
+// planningClient swaps client's services so the mutating calls on the
+// provisioning path are recorded in steps and answered with what the real
+// call would return. Reads go to the account, so the plan is against what
+// exists; under DRY_RUN the client's transport refuses any other mutation.
+func planningClient(client *godo.Client, config *Config, steps *plan) *godo.Client {
+	client.Keys = &planningKeys{KeysService: client.Keys, steps: steps}
+	client.VPCs = &planningVPCs{VPCsService: client.VPCs, steps: steps}
+	client.Firewalls = &planningFirewalls{FirewallsService: client.Firewalls, steps: steps}
+	client.Registry = &planningRegistry{RegistryService: client.Registry, steps: steps}
+	client.Storage = &planningStorage{StorageService: client.Storage, steps: steps}
+	client.Droplets = &planningDroplets{DropletsService: client.Droplets, steps: steps, size: config.dropletSize}
+	client.Monitoring = &planningMonitoring{MonitoringService: client.Monitoring, steps: steps}
+	client.Projects = &planningProjects{ProjectsService: client.Projects, steps: steps}
+	client.ReservedIPActions = &planningReservedIPActions{ReservedIPActionsService: client.ReservedIPActions, steps: steps}
+	client.Domains = &planningDomains{DomainsService: client.Domains, steps: steps}
+	client.Tags = &planningTags{TagsService: client.Tags, steps: steps}
+	client.DropletActions = &planningDropletActions{DropletActionsService: client.DropletActions, steps: steps}
+
+	return client
+}
+
+type planningKeys struct {
+	godo.KeysService
+	steps *plan
+}
+
+func (k *planningKeys) Create(_ context.Context, request *godo.KeyCreateRequest) (*godo.Key, *godo.Response, error) {
+	k.steps.add(planCreate, "ssh key "+request.Name, "from SSH_KEY_PATH")
+
+	return &godo.Key{Name: request.Name, PublicKey: request.PublicKey}, nil, nil
+}
+
+type planningVPCs struct {
+	godo.VPCsService
+	steps *plan
+}
+
+// Get is only called for the existing VPC provisioning settled on.
+func (v *planningVPCs) Get(ctx context.Context, id string) (*godo.VPC, *godo.Response, error) {
+	vpc, resp, err := v.VPCsService.Get(ctx, id)
+	if err == nil {
+		v.steps.add(planNoChange, "vpc "+vpc.Name, "exists in %s", vpc.RegionSlug)
+	}
+
+	return vpc, resp, err
+}
+
+func (v *planningVPCs) Create(_ context.Context, request *godo.VPCCreateRequest) (*godo.VPC, *godo.Response, error) {
+	v.steps.add(planCreate, "vpc "+request.Name, "%s in %s", request.IPRange, request.RegionSlug)
+
+	return &godo.VPC{Name: request.Name, RegionSlug: request.RegionSlug, IPRange: request.IPRange}, nil, nil
+}
+
+type planningFirewalls struct {
+	godo.FirewallsService
+	steps   *plan
+	created *godo.Firewall
+}
+
+// List includes a firewall the plan creates, so it is found again.
+func (f *planningFirewalls) List(ctx context.Context, opt *godo.ListOptions) ([]godo.Firewall, *godo.Response, error) {
+	firewalls, resp, err := f.FirewallsService.List(ctx, opt)
+	if err == nil && f.created != nil {
+		firewalls = append(firewalls, *f.created)
+	}
+
+	return firewalls, resp, err
+}
+
+func (f *planningFirewalls) Create(_ context.Context, request *godo.FirewallRequest,
+) (*godo.Firewall, *godo.Response, error) {
+	f.steps.add(planCreate, "firewall "+request.Name, "%d inbound, %d outbound rules",
+		len(request.InboundRules), len(request.OutboundRules))
+
+	f.created = &godo.Firewall{
+		Name:          request.Name,
+		InboundRules:  request.InboundRules,
+		OutboundRules: request.OutboundRules,
+		DropletIDs:    request.DropletIDs,
+		Tags:          request.Tags,
+	}
+
+	return f.created, nil, nil
+}
+
+// Update reports whether the rules would change; every run re-applies them.
+func (f *planningFirewalls) Update(ctx context.Context, id string, request *godo.FirewallRequest,
+) (*godo.Firewall, *godo.Response, error) {
+	firewall, resp, err := f.FirewallsService.Get(ctx, id)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	want := firewallRuleKeys(request.InboundRules, request.OutboundRules)
+	if have := firewallRuleKeys(firewall.InboundRules, firewall.OutboundRules); slices.Equal(have, want) {
+		f.steps.add(planNoChange, "firewall "+firewall.Name, "rules match")
+	} else {
+		f.steps.add(planUpdate, "firewall "+firewall.Name, "rules become %s", strings.Join(want, ", "))
+	}
+
+	firewall.InboundRules, firewall.OutboundRules = request.InboundRules, request.OutboundRules
+
+	return firewall, resp, nil
+}
+
+func (f *planningFirewalls) AddDroplets(_ context.Context, id string, dropletIDs ...int) (*godo.Response, error) {
+	name := id
+	if f.created != nil && f.created.ID == id {
+		name = f.created.Name
+	}
+
+	droplets := make([]string, 0, len(dropletIDs))
+	for _, id := range dropletIDs {
+		// Only a droplet the plan creates has no ID
+		if id == 0 {
+			droplets = append(droplets, "the new droplet")
+		} else {
+			droplets = append(droplets, strconv.Itoa(id))
+		}
+	}
+
+	f.steps.add(planUpdate, "firewall "+name, "add droplet %s", strings.Join(droplets, ", "))
+
+	return nil, nil
+}
+
+type planningRegistry struct {
+	godo.RegistryService
+	steps   *plan
+	created *godo.Registry
+}
+
+func (r *planningRegistry) Get(ctx context.Context) (*godo.Registry, *godo.Response, error) {
+	if r.created != nil {
+		return r.created, nil, nil
+	}
+
+	registry, resp, err := r.RegistryService.Get(ctx)
+	if err == nil && registry != nil {
+		r.steps.add(planNoChange, "registry "+registry.Name, "exists")
+	}
+
+	return registry, resp, err
+}
+
+func (r *planningRegistry) Create(_ context.Context, request *godo.RegistryCreateRequest,
+) (*godo.Registry, *godo.Response, error) {
+	r.steps.add(planCreate, "registry "+request.Name, "%s tier", request.SubscriptionTierSlug)
+	r.created = &godo.Registry{Name: request.Name}
+
+	return r.created, nil, nil
+}
+
+type planningStorage struct {
+	godo.StorageService
+	steps *plan
+}
+
+func (s *planningStorage) ListVolumes(ctx context.Context, params *godo.ListVolumeParams,
+) ([]godo.Volume, *godo.Response, error) {
+	volumes, resp, err := s.StorageService.ListVolumes(ctx, params)
+	if err == nil && len(volumes) > 0 {
+		s.steps.add(planNoChange, "volume "+volumes[0].Name, "exists")
+	}
+
+	return volumes, resp, err
+}
+
+func (s *planningStorage) CreateVolume(_ context.Context, request *godo.VolumeCreateRequest,
+) (*godo.Volume, *godo.Response, error) {
+	s.steps.add(planCreate, "volume "+request.Name, "%dGB in %s", request.SizeGigaBytes, request.Region)
+
+	return &godo.Volume{Name: request.Name, SizeGigaBytes: request.SizeGigaBytes}, nil, nil
+}
+
+type planningDroplets struct {
+	godo.DropletsService
+	steps   *plan
+	size    string
+	created *godo.Droplet
+}
+
+func (d *planningDroplets) ListByName(ctx context.Context, name string, opt *godo.ListOptions,
+) ([]godo.Droplet, *godo.Response, error) {
+	droplets, resp, err := d.DropletsService.ListByName(ctx, name, opt)
+	if err != nil || len(droplets) == 0 {
+		return droplets, resp, err
+	}
+
+	if droplets[0].Size != nil && droplets[0].Size.Slug != d.size {
+		d.steps.add(planNoChange, "droplet "+name, "exists as %s; DROPLET_SIZE %s is not applied to existing droplets",
+			droplets[0].Size.Slug, d.size)
+	} else {
+		d.steps.add(planNoChange, "droplet "+name, "exists")
+	}
+
+	return droplets, resp, err
+}
+
+// Create answers with an active droplet so provisioning carries on; its
+// addresses are placeholders until it exists.
+func (d *planningDroplets) Create(_ context.Context, request *godo.DropletCreateRequest,
+) (*godo.Droplet, *godo.Response, error) {
+	d.steps.add(planCreate, "droplet "+request.Name, "%s in %s from %s", request.Size, request.Region,
+		request.Image.Slug)
+
+	d.created = &godo.Droplet{
+		Name:    request.Name,
+		Status:  "active",
+		Region:  &godo.Region{Slug: request.Region},
+		Size:    &godo.Size{Slug: request.Size},
+		VPCUUID: request.VPCUUID,
+		Tags:    request.Tags,
+		Networks: &godo.Networks{
+			V4: []godo.NetworkV4{{IPAddress: plannedIPv4, Type: "public"}},
+		},
+	}
+
+	if request.IPv6 {
+		d.created.Networks.V6 = []godo.NetworkV6{{IPAddress: plannedIPv6, Type: "public"}}
+	}
+
+	return d.created, nil, nil
+}
+
+func (d *planningDroplets) Get(ctx context.Context, id int) (*godo.Droplet, *godo.Response, error) {
+	if d.created != nil && d.created.ID == id {
+		return d.created, nil, nil
+	}
+
+	return d.DropletsService.Get(ctx, id)
+}
+
+type planningDropletActions struct {
+	godo.DropletActionsService
+	steps *plan
+}
+
+func (a *planningDropletActions) Rename(_ context.Context, id int, name string,
+) (*godo.Action, *godo.Response, error) {
+	a.steps.add(planUpdate, "droplet "+strconv.Itoa(id), "rename to %s", name)
+
+	return &godo.Action{Status: actionStatusDone}, nil, nil
+}
+
+type planningMonitoring struct {
+	godo.MonitoringService
+	steps *plan
+}
+
+func (m *planningMonitoring) CreateAlertPolicy(_ context.Context, request *godo.AlertPolicyCreateRequest,
+) (*godo.AlertPolicy, *godo.Response, error) {
+	m.steps.add(planCreate, fmt.Sprintf("alert %q", request.Description), "above %g%% over %s",
+		request.Value, request.Window)
+
+	return &godo.AlertPolicy{Description: request.Description}, nil, nil
+}
+
+func (m *planningMonitoring) UpdateAlertPolicy(ctx context.Context, uuid string,
+	request *godo.AlertPolicyUpdateRequest,
+) (*godo.AlertPolicy, *godo.Response, error) {
+	policy, resp, err := m.GetAlertPolicy(ctx, uuid)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	resource := fmt.Sprintf("alert %q", request.Description)
+
+	if alertPolicyKey(policy.Value, policy.Window, policy.Tags, policy.Alerts, policy.Enabled) ==
+		alertPolicyKey(request.Value, request.Window, request.Tags, request.Alerts, *request.Enabled) {
+		m.steps.add(planNoChange, resource, "matches")
+	} else {
+		m.steps.add(planUpdate, resource, "above %g%% over %s", request.Value, request.Window)
+	}
+
+	return policy, resp, nil
+}
+
+// alertPolicyKey summarizes the settings ensureAlertPolicies manages.
+func alertPolicyKey(value float32, window string, tags []string, alerts godo.Alerts, enabled bool) string {
+	slack := make([]string, 0, len(alerts.Slack))
+	for _, destination := range alerts.Slack {
+		slack = append(slack, destination.Channel+" "+destination.URL)
+	}
+
+	return fmt.Sprintf("%g %s %v %v %v %t", value, window, tags, alerts.Email, slack, enabled)
+}
+
+type planningTags struct {
+	godo.TagsService
+	steps *plan
+}
+
+func (t *planningTags) Create(_ context.Context, request *godo.TagCreateRequest) (*godo.Tag, *godo.Response, error) {
+	t.steps.add(planCreate, "tag "+request.Name, "")
+
+	return &godo.Tag{Name: request.Name}, nil, nil
+}
+
+func (t *planningTags) TagResources(_ context.Context, tag string, request *godo.TagResourcesRequest,
+) (*godo.Response, error) {
+	ids := make([]string, 0, len(request.Resources))
+	for _, resource := range request.Resources {
+		ids = append(ids, resource.ID)
+	}
+
+	t.steps.add(planUpdate, "tag "+tag, "add droplet %s", strings.Join(ids, ", "))
+
+	return nil, nil
+}
+
+type planningProjects struct {
+	godo.ProjectsService
+	steps   *plan
+	created *godo.Project
+}
+
+func (p *planningProjects) Create(_ context.Context, request *godo.CreateProjectRequest,
+) (*godo.Project, *godo.Response, error) {
+	p.steps.add(planCreate, "project "+request.Name, "%s", request.Description)
+	p.created = &godo.Project{Name: request.Name}
+
+	return p.created, nil, nil
+}
+
+// AssignResources reports the resources not in the project yet.
+func (p *planningProjects) AssignResources(ctx context.Context, projectID string, resources ...interface{},
+) ([]godo.ProjectResource, *godo.Response, error) {
+	var assigned []godo.ProjectResource
+
+	name := projectID
+
+	if p.created != nil && p.created.ID == projectID {
+		name = p.created.Name
+	} else {
+		var err error
+
+		if assigned, _, err = p.ListResources(ctx, projectID, &godo.ListOptions{PerPage: projectsPerPage}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var missing []string
+
+	for _, resource := range resources {
+		urn := fmt.Sprint(resource)
+		if !slices.ContainsFunc(assigned, func(r godo.ProjectResource) bool { return r.URN == urn }) {
+			missing = append(missing, urn)
+		}
+	}
+
+	if len(missing) > 0 {
+		p.steps.add(planUpdate, "project "+name, "assign %s", strings.Join(missing, ", "))
+	}
+
+	return nil, nil, nil
+}
+
+type planningReservedIPActions struct {
+	godo.ReservedIPActionsService
+	steps *plan
+}
+
+func (r *planningReservedIPActions) Assign(_ context.Context, ip string, dropletID int,
+) (*godo.Action, *godo.Response, error) {
+	r.steps.add(planUpdate, "reserved ip "+ip, "assign to droplet %d", dropletID)
+
+	return &godo.Action{Status: actionStatusDone}, nil, nil
+}
+
+type planningDomains struct {
+	godo.DomainsService
+	steps   *plan
+	created []string
+}
+
+func (d *planningDomains) Create(_ context.Context, request *godo.DomainCreateRequest,
+) (*godo.Domain, *godo.Response, error) {
+	d.steps.add(planCreate, "domain "+request.Name, "DigitalOcean DNS zone")
+	d.created = append(d.created, request.Name)
+
+	return &godo.Domain{Name: request.Name}, nil, nil
+}
+
+// RecordsByTypeAndName finds no records in a zone the plan creates.
+func (d *planningDomains) RecordsByTypeAndName(ctx context.Context, zone, recordType, name string,
+	opt *godo.ListOptions,
+) ([]godo.DomainRecord, *godo.Response, error) {
+	if slices.Contains(d.created, zone) {
+		return nil, nil, nil
+	}
+
+	return d.DomainsService.RecordsByTypeAndName(ctx, zone, recordType, name, opt)
+}
+
+// planningDNS records record changes instead of making them, for either
+// provider. The zone lookup is left to the provider: Cloudflare's only reads
+// and DigitalOcean's goes through planningDomains.
+type planningDNS struct {
+	DNSProvider
+	steps *plan
+}
+
+func (p *planningDNS) UpsertRecord(ctx context.Context, zone, name, recordType, value string, _ int) (bool, error) {
+	current, err := p.LookupRecord(ctx, zone, name, recordType)
+	if err != nil {
+		return false, err
+	}
+
+	resource := fmt.Sprintf("dns %s %s", recordType, recordFQDN(zone, name))
+
+	switch current {
+	case "":
+		p.steps.add(planCreate, resource, "%s", value)
+	case value:
+		p.steps.add(planNoChange, resource, "%s", value)
+	default:
+		p.steps.add(planUpdate, resource, "%s -> %s", current, value)
+	}
+
+	return current != value, nil
+}
+
+// firewallRuleKeys flattens rules to sorted "in tcp:22 0.0.0.0/0" strings so
+// two rule sets can be compared regardless of order.
+func firewallRuleKeys(inbound []godo.InboundRule, outbound []godo.OutboundRule) []string {
+	var keys []string
+
+	for _, rule := range inbound {
+		if rule.Sources != nil {
+			keys = append(keys, fmt.Sprintf("in %s:%s %s", rule.Protocol, rule.PortRange,
+				strings.Join(rule.Sources.Addresses, "/")))
+		}
+	}
+
+	for _, rule := range outbound {
+		if rule.Destinations != nil {
+			keys = append(keys, fmt.Sprintf("out %s:%s %s", rule.Protocol, rule.PortRange,
+				strings.Join(rule.Destinations.Addresses, "/")))
+		}
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/digitalocean/godo"
+)
+
+// accountResponses are the reads every provisioning run makes.
+func accountResponses(config *Config) map[string]string {
+	return map[string]string{
+		"GET /v2/regions": `{"regions":[{"slug":"` + config.region + `","available":true}]}`,
+		"GET /v2/sizes": `{"sizes":[{"slug":"` + config.dropletSize + `","available":true,"regions":["` +
+			config.region + `"]}]}`,
+		"GET /v2/account/keys":                `{"ssh_keys":[]}`,
+		"GET /v2/vpcs":                        `{"vpcs":[]}`,
+		"GET /v2/firewalls":                   `{"firewalls":[]}`,
+		"GET /v2/droplets":                    `{"droplets":[]}`,
+		"GET /v2/volumes":                     `{"volumes":[]}`,
+		"GET /v2/monitoring/alerts":           `{"policies":[]}`,
+		"GET /v2/domains/example.com":         `{"domain":{"name":"example.com"}}`,
+		"GET /v2/domains/example.com/records": `{"domain_records":[]}`,
+	}
+}
+
+func dryRunConfig(t *testing.T) *Config {
+	t.Helper()
+
+	config := defaultTestConfig(t)
+	config.dryRun = true
+	config.sshKeyPath = filepath.Join(t.TempDir(), "id_ed25519.pub")
+
+	if err := os.WriteFile(config.sshKeyPath, []byte("ssh-ed25519 AAAA test"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return config
+}
+
+// dryRun provisions through the planning client and provider, failing the
+// test if anything but a read reached the API.
+func dryRun(t *testing.T, config *Config, responses map[string]string) (plan, *provisioned) {
+	t.Helper()
+
+	fake, client := newFakeDO(t, config, responses)
+
+	var steps plan
+
+	client = planningClient(client, config, &steps)
+	dns := &planningDNS{DNSProvider: newDNSProvider(client, config), steps: &steps}
+
+	infra, err := provisionInfrastructure(context.Background(), client, dns, config)
+	if err != nil {
+		t.Fatalf("provisionInfrastructure: %v", err)
+	}
+
+	if mutations := fake.mutations(); len(mutations) > 0 {
+		t.Errorf("dry run sent mutating requests: %v", mutations)
+	}
+
+	return steps, infra
+}
+
+func hasEntry(steps plan, action, resource string) bool {
+	return slices.ContainsFunc(steps, func(entry planEntry) bool {
+		return entry.action == action && entry.resource == resource
+	})
+}
+
+func TestDryRunPlansCreatesWithoutCallingCreate(t *testing.T) {
+	config := dryRunConfig(t)
+	config.alertEmail = "ops@example.com"
+	config.volumeSizeGB = 10
+
+	responses := accountResponses(config)
+	delete(responses, "GET /v2/domains/example.com")
+
+	steps, infra := dryRun(t, config, responses)
+
+	for _, resource := range []string{
+		"ssh key " + config.resourceName(resourceSSHKey),
+		"vpc " + config.resourceName(resourceVPC),
+		"firewall " + config.resourceName(resourceFirewall),
+		"registry n8n",
+		"volume " + dataVolumeName(config),
+		"droplet " + config.resourceName(resourceDroplet),
+		"domain example.com",
+		"dns A " + testDomain,
+		"dns AAAA " + testDomain,
+		`alert "` + config.resourceName(resourceTag) + ` CPU utilization"`,
+	} {
+		if !hasEntry(steps, planCreate, resource) {
+			t.Errorf("plan has no create for %s:\n%v", resource, steps)
+		}
+	}
+
+	if infra.hostPublicKey == "" {
+		t.Error("a planned droplet should be reported as new")
+	}
+
+	if ip, _ := infra.droplet.PublicIPv4(); ip != plannedIPv4 {
+		t.Errorf("planned droplet address = %q, want the placeholder", ip)
+	}
+}
+
+func TestDryRunReportsNoChangeForMatchingResources(t *testing.T) {
+	config := dryRunConfig(t)
+
+	request, err := firewallRequest(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firewall, err := json.Marshal(godo.Firewall{
+		ID:            "fw-1",
+		Name:          request.Name,
+		InboundRules:  request.InboundRules,
+		OutboundRules: request.OutboundRules,
+		DropletIDs:    []int{42},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vpcName := config.resourceName(resourceVPC)
+	vpc := `{"id":"vpc-1","name":"` + vpcName + `","region":"` + config.region + `"}`
+
+	responses := accountResponses(config)
+	responses["GET /v2/account/keys"] = `{"ssh_keys":[{"id":7,"name":"deploy","fingerprint":"` +
+		config.sshFingerprint + `"}]}`
+	responses["GET /v2/vpcs"] = `{"vpcs":[` + vpc + `]}`
+	responses["GET /v2/vpcs/vpc-1"] = `{"vpc":` + vpc + `}`
+	responses["GET /v2/firewalls"] = `{"firewalls":[` + string(firewall) + `]}`
+	responses["GET /v2/firewalls/fw-1"] = `{"firewall":` + string(firewall) + `}`
+	responses["GET /v2/registry"] = `{"registry":{"name":"n8n"}}`
+	responses["GET /v2/droplets"] = `{"droplets":[{"id":42,"name":"` + config.resourceName(resourceDroplet) +
+		`","status":"active","region":{"slug":"` + config.region + `"},"size":{"slug":"` + config.dropletSize +
+		`"},"vpc_uuid":"vpc-1","networks":{"v4":[{"ip_address":"203.0.113.10","type":"public"}]}}]}`
+	responses["GET /v2/domains/example.com/records"] = `{"domain_records":[{"id":1,"type":"A","name":"n8n",` +
+		`"data":"203.0.113.10"}]}`
+
+	steps, infra := dryRun(t, config, responses)
+
+	if changes := steps.changes(); changes != 0 {
+		t.Errorf("plan has %d changes against a matching account:\n%v", changes, steps)
+	}
+
+	for _, resource := range []string{
+		"vpc " + vpcName,
+		"firewall " + request.Name,
+		"registry n8n",
+		"droplet " + config.resourceName(resourceDroplet),
+		"dns A " + testDomain,
+	} {
+		if !hasEntry(steps, planNoChange, resource) {
+			t.Errorf("plan has no no-change entry for %s:\n%v", resource, steps)
+		}
+	}
+
+	if infra.hostPublicKey != "" {
+		t.Error("an existing droplet should not be reported as new")
+	}
+}
+
+func TestReadOnlyTransportRefusesMutations(t *testing.T) {
+	config := dryRunConfig(t)
+	fake, client := newFakeDO(t, config, accountResponses(config))
+
+	_, _, err := client.VPCs.Create(context.Background(), &godo.VPCCreateRequest{Name: "x", RegionSlug: "nyc1"})
+	if err == nil {
+		t.Fatal("a VPC create went through under DRY_RUN")
+	}
+
+	if mutations := fake.mutations(); len(mutations) > 0 {
+		t.Errorf("mutating requests reached the API: %v", mutations)
+	}
+}
+
+func TestDryRunRefusesAWeakPassword(t *testing.T) {
+	config := dryRunConfig(t)
+	config.basicAuthPass = defaultBasicAuthPass
+
+	if err := runDryRun(context.Background(), config, nil); !errors.Is(err, ErrWeakBasicAuthPass) {
+		t.Fatalf("err = %v, want ErrWeakBasicAuthPass", err)
+	}
+}
+
+func TestCommandsWithoutADryRunRefuseIt(t *testing.T) {
+	config := defaultTestConfig(t)
+
+	if err := refuseDryRun(config, "import"); err != nil {
+		t.Fatalf("refused without DRY_RUN: %v", err)
+	}
+
+	config.dryRun = true
+
+	if err := refuseDryRun(config, "import"); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("err = %v, want ErrInvalidConfig", err)
+	}
+
+	testConfig(t, map[string]string{"DRY_RUN": "true"})
+
+	// Refused before the droplet is looked up
+	if err := runImport(context.Background(), []string{"--droplet", "1"}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("import: err = %v, want ErrInvalidConfig", err)
+	}
+}
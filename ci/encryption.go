@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/felipepimentel/n8n-digitalocean-cicd/ci/ssh"
+)
+
+const (
+	minEncryptionKeyLength   = 16
+	generatedEncryptionBytes = 32
+
+	// imagePullFailed is what verifyImageEncryptionKey's script prints when
+	// it could not pull the image to inspect.
+	imagePullFailed = "pull-failed"
+)
+
+var (
+	ErrEncryptionKeyMismatch = errors.New("N8N_ENCRYPTION_KEY does not match the key used by the running instance")
+	ErrWeakEncryptionKey     = errors.New("N8N_ENCRYPTION_KEY is too weak")
+	ErrImageEncryptionKey    = errors.New("the image carries a different N8N_ENCRYPTION_KEY than the deploy")
+)
+
+// weakEncryptionKeys are placeholders from docs and examples that must never
+// reach production, in lower case.
+var weakEncryptionKeys = []string{
+	"changeme",
+	"change-me",
+	"generate-32-char-key",
+	"n8n",
+	"password",
+	"secret",
+	"encryption-key",
+	"32_char_secure_encryption_key",
+	"c8d6d2d5f6a9b7c8d6d2d5f6a9b7c8d6",
+}
+
+// ensureEncryptionKey generates a key when none is configured and
+// GENERATE_ENCRYPTION_KEY is set. A generated key is only surfaced once the
+// deploy has checked the droplet for one, see reuseEncryptionKey.
+func ensureEncryptionKey(config *Config) error {
+	if config.encryptionKey != "" {
+		return nil
+	}
+
+	if !config.generateEncryptionKey {
+		return fmt.Errorf("%w: N8N_ENCRYPTION_KEY (or set GENERATE_ENCRYPTION_KEY=true)", ErrEnvVarNotSet)
+	}
+
+	keyBytes := make([]byte, generatedEncryptionBytes)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+
+	config.encryptionKey = hex.EncodeToString(keyBytes)
+	config.encryptionKeyGenerated = true
+
+	return nil
+}
+
+// reuseEncryptionKey keeps the key the running instance encrypted its
+// credentials with, the way the basic auth password is carried over, so
+// every deploy with GENERATE_ENCRYPTION_KEY doesn't fail verifyEncryptionKey.
+// Only a key the droplet doesn't have yet is surfaced.
+func reuseEncryptionKey(sshClient *ssh.Client, config *Config) error {
+	if !config.encryptionKeyGenerated {
+		return nil
+	}
+
+	existingKey, err := readExistingEncryptionKey(sshClient, config)
+	if err != nil {
+		return err
+	}
+
+	if adoptStoredEncryptionKey(config, existingKey) {
+		fmt.Println("Reusing the encryption key already on the droplet")
+
+		return nil
+	}
+
+	return surfaceGeneratedSecret("N8N_ENCRYPTION_KEY", config.encryptionKey)
+}
+
+// adoptStoredEncryptionKey replaces the generated key with the one stored on
+// the n8n data volume, reporting whether there was one to reuse.
+func adoptStoredEncryptionKey(config *Config, stored string) bool {
+	config.encryptionKeyGenerated = false
+
+	if stored == "" {
+		return false
+	}
+
+	config.encryptionKey = stored
+
+	return true
+}
+
+func validateEncryptionKey(key string) error {
+	if len(key) < minEncryptionKeyLength {
+		return fmt.Errorf("%w: must be at least %d characters, generate one with 'openssl rand -hex 16'",
+			ErrWeakEncryptionKey, minEncryptionKeyLength)
+	}
+
+	// Only whole placeholders are refused; a random key may well contain "n8n"
+	if slices.Contains(weakEncryptionKeys, strings.ToLower(key)) {
+		return fmt.Errorf("%w: %q is a placeholder", ErrWeakEncryptionKey, key)
+	}
+
+	if strings.Count(key, key[:1]) == len(key) {
+		return fmt.Errorf("%w: key repeats a single character", ErrWeakEncryptionKey)
+	}
+
+	return nil
+}
+
+// n8nDataVolume is the name Compose gives the n8n_data volume within the
+// configured project.
+func n8nDataVolume(config *Config) string {
+	return config.composeProject + "_n8n_data"
+}
+
+// n8nInstanceConfig mirrors the config file n8n writes to its user folder.
+type n8nInstanceConfig struct {
+	EncryptionKey string `json:"encryptionKey"`
+}
+
+// readExistingEncryptionKey returns the encryption key stored on the n8n data
+// volume, or an empty string when the volume or config file does not exist yet.
+func readExistingEncryptionKey(sshClient *ssh.Client, config *Config) (string, error) {
+	// The config lives in the user folder root or in a nested .n8n directory
+	// depending on how N8N_USER_FOLDER was set when the instance first started.
+	readScript := fmt.Sprintf(`
+if docker volume inspect %[1]s >/dev/null 2>&1; then
+	docker run --rm -v %[1]s:/data:ro alpine sh -c 'cat /data/config 2>/dev/null || cat /data/.n8n/config 2>/dev/null || true'
+fi`, n8nDataVolume(config))
+
+	output, err := sshClient.ExecuteCommand(readScript)
+	if err != nil {
+		return "", fmt.Errorf("failed to read n8n config from volume: %w\nOutput: %s", err, output)
+	}
+
+	output = strings.TrimSpace(output)
+	if output == "" {
+		return "", nil
+	}
+
+	var instanceConfig n8nInstanceConfig
+	if err := json.Unmarshal([]byte(output), &instanceConfig); err != nil {
+		return "", fmt.Errorf("failed to parse n8n config from volume: %w", err)
+	}
+
+	return instanceConfig.EncryptionKey, nil
+}
+
+// verifyEncryptionKey refuses to deploy a key that differs from the one the
+// existing instance encrypted its credentials with, unless explicitly forced.
+func verifyEncryptionKey(sshClient *ssh.Client, config *Config) error {
+	existingKey, err := readExistingEncryptionKey(sshClient, config)
+	if err != nil {
+		return err
+	}
+
+	if existingKey == "" || existingKey == config.encryptionKey {
+		return nil
+	}
+
+	if !config.forceKeyChange {
+		return fmt.Errorf("%w: stored credentials would become unreadable; "+
+			"redeploy with the original key or pass --force-key-change", ErrEncryptionKeyMismatch)
+	}
+
+	fmt.Println("WARNING: --force-key-change is set and N8N_ENCRYPTION_KEY differs from the running instance.")
+	fmt.Println("WARNING: All stored credentials will become undecryptable and must be re-entered.")
+
+	return nil
+}
+
+// verifyImageEncryptionKey checks images built before the key was only
+// injected at deploy time: their baked-in key must match the deploy's, and
+// they should be rebuilt so the published image stops carrying the secret.
+// The compose environment sets the key, so this is the only other source.
+// Only images the deploy command got by reference can be that old; images
+// built by this run are skipped rather than pulled twice. The image is
+// inspected by the digest that was pushed, not by tag.
+func verifyImageEncryptionKey(sshClient *ssh.Client, config *Config, image *publishedImage) error {
+	if !image.prebuilt {
+		return nil
+	}
+
+	// Log in first: the deploy script only does so after this check
+	inspectScript := fmt.Sprintf(`if ! { %[2]s; } >/dev/null 2>&1 ||
+	! docker pull %[1]s >/dev/null 2>&1; then
+	echo %[3]s
+	exit 0
+fi
+docker image inspect --format '{{range .Config.Env}}{{println .}}{{end}}' %[1]s`,
+		shellQuote(image.pinnedRef()), registryLoginCommand(config), imagePullFailed)
+
+	output, err := sshClient.ExecuteCommand(inspectScript)
+	if err != nil {
+		return fmt.Errorf("failed to inspect image environment: %w\nOutput: %s", err, output)
+	}
+
+	if strings.TrimSpace(output) == imagePullFailed {
+		fmt.Printf("Warning: could not pull %s on the droplet; skipped checking it for a baked-in N8N_ENCRYPTION_KEY\n",
+			image.pinnedRef())
+
+		return nil
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		imageKey, found := strings.CutPrefix(strings.TrimSpace(line), "N8N_ENCRYPTION_KEY=")
+		if !found {
+			continue
+		}
+
+		if imageKey != config.encryptionKey && !config.forceKeyChange {
+			return fmt.Errorf("%w: rebuild the image or pass --force-key-change", ErrImageEncryptionKey)
+		}
+
+		fmt.Printf("Warning: %s has N8N_ENCRYPTION_KEY baked in; rebuild it so the registry no longer holds the key\n",
+			image.pinnedRef())
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateEncryptionKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "random hex", key: "3f9a1c7e5b2d8f4a6c0e9b1d7a3f5c2e"},
+		{name: "random key containing n8n", key: "a1n8nb2c3d4e5f6a7b8c9d0e"},
+		{name: "random key containing secret", key: "x7Secretq9ZpL2mVw4Rt"},
+		{name: "too short", key: "0123456789abcde", wantErr: true},
+		{name: "example placeholder", key: "generate-32-char-key", wantErr: true},
+		{name: "placeholder in another case", key: "Generate-32-Char-Key", wantErr: true},
+		{name: "documented sample key", key: "c8d6d2d5f6a9b7c8d6d2d5f6a9b7c8d6", wantErr: true},
+		{name: "single repeated character", key: "aaaaaaaaaaaaaaaa", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateEncryptionKey(test.key)
+			if test.wantErr && !errors.Is(err, ErrWeakEncryptionKey) {
+				t.Errorf("validateEncryptionKey(%q) = %v, want ErrWeakEncryptionKey", test.key, err)
+			}
+
+			if !test.wantErr && err != nil {
+				t.Errorf("validateEncryptionKey(%q) = %v, want nil", test.key, err)
+			}
+		})
+	}
+}
+
+func TestGeneratedEncryptionKeyDefersToTheStoredOne(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.encryptionKey = ""
+	config.generateEncryptionKey = true
+
+	if err := ensureEncryptionKey(config); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.encryptionKey == "" || !config.encryptionKeyGenerated {
+		t.Fatal("no key was generated")
+	}
+
+	if !adoptStoredEncryptionKey(config, "3f9a1c7e5b2d8f4a6c0e9b1d7a3f5c2e") {
+		t.Fatal("the stored key was not reused")
+	}
+
+	if config.encryptionKey != "3f9a1c7e5b2d8f4a6c0e9b1d7a3f5c2e" || config.encryptionKeyGenerated {
+		t.Errorf("encryptionKey = %q, want the stored key", config.encryptionKey)
+	}
+}
+
+func TestGeneratedEncryptionKeyIsKeptForANewInstance(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.encryptionKey = ""
+	config.generateEncryptionKey = true
+
+	if err := ensureEncryptionKey(config); err != nil {
+		t.Fatal(err)
+	}
+
+	generated := config.encryptionKey
+
+	if adoptStoredEncryptionKey(config, "") {
+		t.Error("reused a key from an instance without one")
+	}
+
+	if config.encryptionKey != generated {
+		t.Error("the generated key was replaced")
+	}
+}
+
+func TestImageEncryptionKeyIsOnlyCheckedForPrebuiltImages(t *testing.T) {
+	config := defaultTestConfig(t)
+
+	image, err := deployImageFromFlags(config.registryURL, config.registryURL+"/n8n/n8n:1.70.0", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !image.prebuilt {
+		t.Error("an image deployed by reference is not checked for a baked-in key")
+	}
+
+	// Without an SSH client, anything but skipping would panic
+	if err := verifyImageEncryptionKey(nil, config, &publishedImage{ref: image.ref}); err != nil {
+		t.Errorf("an image built by this run was checked: %v", err)
+	}
+}
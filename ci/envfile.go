@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// n8nEnvFilePath is where N8N_ENV_FILE lands on the droplet. Compose gives
+// the service's environment entries precedence over env_file, so the
+// deploy's own settings always win.
+const n8nEnvFilePath = "/opt/n8n/n8n.env"
+
+var ErrInvalidEnvFile = errors.New("invalid N8N_ENV_FILE")
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// loadN8NEnvFile reads N8N_ENV_FILE into config.n8nEnv as KEY=VALUE lines.
+// Comments and blank lines are dropped; Docker's bare KEY form is rejected
+// since it would read the droplet's environment.
+func loadN8NEnvFile(config *Config) error {
+	if config.n8nEnvFile == "" {
+		return nil
+	}
+
+	entries, err := readEnvFile(config.n8nEnvFile)
+	if err != nil {
+		return fmt.Errorf("N8N_ENV_FILE: %w", err)
+	}
+
+	config.n8nEnv = entries
+
+	fmt.Printf("Loaded %d n8n settings from %s\n", len(entries), config.n8nEnvFile)
+
+	return nil
+}
+
+// readEnvFile parses KEY=VALUE lines, skipping blanks and # comments.
+func readEnvFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+
+	var entries []string
+
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found || !envKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("%w: line %d is not KEY=VALUE", ErrInvalidEnvFile, i+1)
+		}
+
+		entries = append(entries, key+"="+value)
+	}
+
+	return entries, nil
+}
+
+// managedN8NEnv lists the variables the generated n8n service sets itself,
+// read from its environment section so the list cannot drift.
+func managedN8NEnv(config *Config) []string {
+	var keys []string
+
+	for _, line := range strings.Split(generateN8NServiceConfig(config), "\n") {
+		entry, found := strings.CutPrefix(strings.TrimSpace(line), "- ")
+		if !found {
+			continue
+		}
+
+		if key, _, found := strings.Cut(entry, "="); found {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// validateN8NEnv rejects files that set a managed variable. Such entries
+// would be silently ignored, and an empty one usually means a required
+// setting was deleted by accident.
+func validateN8NEnv(config *Config) error {
+	managed := managedN8NEnv(config)
+
+	var conflicts []string
+
+	for _, entry := range config.n8nEnv {
+		key, _, _ := strings.Cut(entry, "=")
+		if slices.Contains(managed, key) && !slices.Contains(conflicts, key) {
+			conflicts = append(conflicts, key)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%w: remove the variables the deploy manages, which cannot be overridden or cleared: %s",
+			ErrInvalidEnvFile, strings.Join(conflicts, ", "))
+	}
+
+	return nil
+}
+
+// generateN8NEnvFile writes the n8n env file, or removes a stale one. The
+// quoted delimiter stops the shell expanding values, and no validated
+// KEY=VALUE line can equal it.
+func generateN8NEnvFile(config *Config) string {
+	if len(config.n8nEnv) == 0 {
+		return fmt.Sprintf("\n# No N8N_ENV_FILE configured\nrm -f %s", n8nEnvFilePath)
+	}
+
+	return fmt.Sprintf(`
+# Create n8n env file, restricted before any secret is written to it
+touch %[1]s
+chmod 600 %[1]s
+cat > %[1]s << 'N8N_ENV'
+%[2]s
+N8N_ENV`, n8nEnvFilePath, strings.Join(config.n8nEnv, "\n"))
+}
+
+// n8nEnvFileDirective adds the env file to the n8n service when one is set.
+func n8nEnvFileDirective(config *Config) string {
+	if len(config.n8nEnv) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(`
+    env_file:
+      - %s`, n8nEnvFilePath)
+}
+
+// redactN8NEnv masks every value, since the file is free-form and any of
+// them may be a credential.
+func redactN8NEnv(entries []string) string {
+	lines := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		key, _, _ := strings.Cut(entry, "=")
+		lines = append(lines, key+"="+redactedValue)
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	ephemeralSuffix = "-validate"
+
+	// ephemeralCleanupTimeout bounds the teardown, which runs on its own
+	// context so it still happens after a timeout or cancellation.
+	ephemeralCleanupTimeout = 2 * time.Minute
+)
+
+// ephemeralConfig derives the throwaway deployment: its own droplet name and
+// tag, no DNS, and none of the production-only extras.
+func ephemeralConfig(config *Config) Config {
+	ephemeral := *config
+	ephemeral.deployPrefix = config.deployPrefix + ephemeralSuffix
+	// Named after the prefix so it never shares DROPLET_HOSTNAME's droplet
+	ephemeral.dropletName = ""
+	ephemeral.manageDNS = false
+	ephemeral.volumeSizeGB = 0
+	ephemeral.doProject = ""
+	ephemeral.backupBeforeDeploy = false
+	ephemeral.backupSnapshot = false
+	ephemeral.backupCron = ""
+	ephemeral.spacesBucket = ""
+	ephemeral.reservedIP = ""
+	// The throwaway droplet neither has nor needs stored secrets
+	ephemeral.basicAuthPassGenerated = false
+	ephemeral.encryptionKeyGenerated = false
+
+	return ephemeral
+}
+
+// validateOnEphemeral deploys image to a temporary droplet and waits for n8n
+// to become ready there. The droplet is always destroyed afterwards.
+func validateOnEphemeral(ctx context.Context, client *godo.Client, config *Config, image *publishedImage) (err error) {
+	ephemeral := ephemeralConfig(config)
+	name := ephemeral.resourceName(resourceDroplet)
+
+	fmt.Printf("Validating the deploy on ephemeral droplet %s\n", name)
+
+	defer func() {
+		if cleanupErr := destroyEphemeralDroplet(client, config.region, name); cleanupErr != nil && err == nil {
+			err = cleanupErr
+		}
+	}()
+
+	sshKeyID, err := ensureSSHKey(ctx, client, config)
+	if err != nil {
+		return fmt.Errorf("failed to ensure SSH key: %w", err)
+	}
+
+	vpc, err := createVPC(ctx, client, config)
+	if err != nil {
+		return err
+	}
+
+	droplet, hostPublicKey, err := createOrGetDroplet(ctx, client, &ephemeral, vpc.ID, sshKeyID)
+	if err != nil {
+		return fmt.Errorf("ephemeral validation failed: %w", err)
+	}
+
+	if err := prepareDroplet(ctx, &ephemeral, droplet, hostPublicKey); err != nil {
+		return fmt.Errorf("ephemeral validation failed: %w", err)
+	}
+
+	dropletIP, err := droplet.PublicIPv4()
+	if err != nil {
+		return fmt.Errorf("ephemeral validation failed: failed to get droplet IP: %w", err)
+	}
+
+	if err := deployN8N(ctx, dropletIP, &ephemeral, image); err != nil {
+		return fmt.Errorf("ephemeral validation failed: %w", err)
+	}
+
+	fmt.Println("Ephemeral validation passed")
+
+	return nil
+}
+
+// destroyEphemeralDroplet looks the droplet up by name, so a droplet whose
+// creation was interrupted is removed too.
+func destroyEphemeralDroplet(client *godo.Client, region, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ephemeralCleanupTimeout)
+	defer cancel()
+
+	droplet, err := findDroplet(ctx, client, region, name)
+	if err != nil {
+		return fmt.Errorf("failed to find ephemeral droplet %s for cleanup: %w", name, err)
+	}
+
+	if droplet == nil {
+		return nil
+	}
+
+	if _, err := client.Droplets.Delete(ctx, droplet.ID); err != nil {
+		return fmt.Errorf("failed to delete ephemeral droplet %s (%d), delete it manually: %w", name, droplet.ID, err)
+	}
+
+	fmt.Printf("Deleted ephemeral droplet %s\n", name)
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var ErrExecUsage = errors.New("usage: exec [--service NAME] [--file PATH] [COMMAND...]")
+
+// runExec runs an ad-hoc command or script on the droplet, streaming its
+// output and exiting with the remote exit code.
+func runExec(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("exec", flag.ExitOnError)
+	service := flags.String("service", "", "run inside this service's container instead of on the host")
+	scriptFile := flags.String("file", "", "read a script from this file instead of a command argument ('-' for stdin)")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	command := strings.Join(flags.Args(), " ")
+	if command != "" && *scriptFile != "" {
+		return fmt.Errorf("%w: pass either a command or --file, not both", ErrExecUsage)
+	}
+
+	// Without a command the script is read from --file, or from stdin
+	var stdin io.Reader
+
+	if command == "" {
+		script, err := openScript(*scriptFile)
+		if err != nil {
+			return err
+		}
+		defer script.Close()
+
+		stdin = script
+	}
+
+	config := loadConfig()
+
+	if err := refuseDryRun(&config, "exec"); err != nil {
+		return err
+	}
+
+	stopAgent, err := prepareSSHKey(&config)
+	if err != nil {
+		return err
+	}
+	defer stopAgent()
+
+	droplet, err := findDroplet(ctx, newDOClient(&config), config.region, config.resourceName(resourceDroplet))
+	if err != nil {
+		return err
+	}
+
+	if droplet == nil {
+		return fmt.Errorf("%w: %s", ErrDropletNotFound, config.resourceName(resourceDroplet))
+	}
+
+	dropletIP, err := droplet.PublicIPv4()
+	if err != nil {
+		return fmt.Errorf("failed to get droplet IP: %w", err)
+	}
+
+	sshClient, err := connectSSH(ctx, dropletIP, config.deploySSHUser, &config)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSSHClient, err)
+	}
+	defer sshClient.Close()
+
+	code, err := sshClient.Run(execRemoteCommand(&config, *service, command), stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	if code != 0 {
+		return &exitCodeError{code: code}
+	}
+
+	return nil
+}
+
+// openScript opens the script to pipe to the remote shell.
+func openScript(path string) (io.ReadCloser, error) {
+	if path == "" || path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open script %s: %w", path, err)
+	}
+
+	return file, nil
+}
+
+// execRemoteCommand builds the remote command line. An empty command means the
+// script arrives on stdin.
+func execRemoteCommand(config *Config, service, command string) string {
+	shell := "sh -s"
+	if command != "" {
+		shell = "sh -c " + shellQuote(command)
+	}
+
+	if service == "" {
+		if command == "" {
+			return "bash -s"
+		}
+
+		return command
+	}
+
+	if config.deployMode == deployModeSwarm {
+		// Swarm tasks are not compose containers, so pick a running task by label
+		filter := shellQuote(fmt.Sprintf("label=com.docker.swarm.service.name=%s_%s", config.composeProject, service))
+
+		return fmt.Sprintf(`docker exec -i "$(docker ps -q -f %s | head -n 1)" %s`, filter, shell)
+	}
+
+	return fmt.Sprintf("cd /opt/n8n && %s\n%s exec -T %s %s",
+		composeDetectScript(config), composeCmd, shellQuote(service), shell)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	// Presets accepted as OUTBOUND_ALLOWED.
+	outboundPresetAll        = "all"
+	outboundPresetRestricted = "restricted"
+
+	maxPort = 65535
+)
+
+var ErrFirewallNotFound = errors.New("firewall not found")
+
+// anywhere is every IPv4 and IPv6 address, so dual-stack droplets are
+// reachable over their AAAA record and can reach IPv6 hosts.
+var anywhere = []string{"0.0.0.0/0", "::/0"}
+
+// restrictedOutbound allows only what n8n and the droplet need: DNS, NTP,
+// HTTP(S) for package mirrors, the container registry and webhooks, and SMTP
+// submission for n8n mail.
+var restrictedOutbound = []string{
+	"udp:53:0.0.0.0/0", "udp:53:::/0",
+	"tcp:53:0.0.0.0/0", "tcp:53:::/0",
+	"udp:123:0.0.0.0/0", "udp:123:::/0",
+	"tcp:80:0.0.0.0/0", "tcp:80:::/0",
+	"tcp:443:0.0.0.0/0", "tcp:443:::/0",
+	"tcp:465:0.0.0.0/0", "tcp:465:::/0",
+	"tcp:587:0.0.0.0/0", "tcp:587:::/0",
+}
+
+// firewallInboundPorts are SSH, HTTP and HTTPS. With CLOUDFLARE=true the
+// web ports only accept Cloudflare's ranges. EXTRA_INBOUND_PORTS adds to them.
+var firewallInboundPorts = []string{"22", "80", "443"}
+
+// firewallRequest builds the rules shared by the create and update paths.
+func firewallRequest(config *Config) (*godo.FirewallRequest, error) {
+	outbound, err := outboundRules(config.outboundAllowed)
+	if err != nil {
+		return nil, err
+	}
+
+	extraInbound, err := inboundRules(config.extraInbound)
+	if err != nil {
+		return nil, err
+	}
+
+	inbound := make([]godo.InboundRule, 0, len(firewallInboundPorts)+len(extraInbound))
+	for _, port := range firewallInboundPorts {
+		sources := anywhere
+		if config.cloudflare && port != strconv.Itoa(sshPort) {
+			sources = config.cloudflareRanges
+		}
+
+		inbound = append(inbound, godo.InboundRule{
+			Protocol:  "tcp",
+			PortRange: port,
+			Sources: &godo.Sources{
+				Addresses: sources,
+			},
+		})
+	}
+
+	inbound = append(inbound, extraInbound...)
+
+	return &godo.FirewallRequest{
+		Name:          config.resourceName(resourceFirewall),
+		InboundRules:  inbound,
+		OutboundRules: outbound,
+	}, nil
+}
+
+// verifyExistingFirewall checks FIREWALL_ID exists. Its rules belong to
+// whoever manages it centrally, so they are left as they are.
+func verifyExistingFirewall(ctx context.Context, client *godo.Client, config *Config) error {
+	firewall, resp, err := client.Firewalls.Get(ctx, config.firewallID)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%w: FIREWALL_ID %s", ErrFirewallNotFound, config.firewallID)
+		}
+
+		return fmt.Errorf("failed to get firewall %s: %w", config.firewallID, err)
+	}
+
+	fmt.Printf("Using existing firewall %s (%s) without changing its rules\n", firewall.Name, firewall.ID)
+
+	if exposedBy := n8nPublishedEverywhere(config); exposedBy != "" && n8nPortPublic(firewall.InboundRules) {
+		return fmt.Errorf("%w: %s but FIREWALL_ID %s allows port %d from the internet",
+			ErrInvalidConfig, exposedBy, firewall.Name, n8nPort)
+	}
+
+	if len(config.extraInbound) > 0 || config.cloudflare ||
+		!(len(config.outboundAllowed) == 1 && config.outboundAllowed[0] == outboundPresetAll) {
+		fmt.Println("Warning: EXTRA_INBOUND_PORTS, OUTBOUND_ALLOWED and CLOUDFLARE rules are not applied " +
+			"to the FIREWALL_ID firewall")
+	}
+
+	return nil
+}
+
+// reconcileFirewall makes sure droplet is protected by its firewall, the
+// FIREWALL_ID one or the managed one. Associations are by droplet ID, so a
+// recreated droplet silently drops out of them; this re-adds it and reports
+// the repair. Being covered through one of its tags counts as associated.
+func reconcileFirewall(ctx context.Context, client *godo.Client, config *Config, droplet *godo.Droplet) error {
+	firewall, err := dropletFirewall(ctx, client, config)
+	if err != nil {
+		return err
+	}
+
+	if firewallCovers(firewall, droplet) {
+		fmt.Printf("Droplet %s is protected by firewall %s\n", droplet.Name, firewall.Name)
+
+		return nil
+	}
+
+	if _, err := client.Firewalls.AddDroplets(ctx, firewall.ID, droplet.ID); err != nil {
+		return fmt.Errorf("failed to add droplet %s to firewall %s: %w", droplet.Name, firewall.Name, err)
+	}
+
+	fmt.Printf("Repaired firewall association: added droplet %s (%d) to firewall %s\n",
+		droplet.Name, droplet.ID, firewall.Name)
+
+	return nil
+}
+
+// dropletFirewall fetches FIREWALL_ID, or the managed firewall by name.
+func dropletFirewall(ctx context.Context, client *godo.Client, config *Config) (*godo.Firewall, error) {
+	if config.firewallID != "" {
+		firewall, _, err := client.Firewalls.Get(ctx, config.firewallID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get firewall %s: %w", config.firewallID, err)
+		}
+
+		return firewall, nil
+	}
+
+	name := config.resourceName(resourceFirewall)
+
+	firewalls, _, err := client.Firewalls.List(ctx, &godo.ListOptions{PerPage: listPerPage})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list firewalls: %w", err)
+	}
+
+	for i := range firewalls {
+		if firewalls[i].Name == name {
+			return &firewalls[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrFirewallNotFound, name)
+}
+
+// firewallCovers reports whether firewall applies to droplet, directly or
+// through one of its tags.
+func firewallCovers(firewall *godo.Firewall, droplet *godo.Droplet) bool {
+	return slices.Contains(firewall.DropletIDs, droplet.ID) ||
+		slices.ContainsFunc(droplet.Tags, func(tag string) bool { return slices.Contains(firewall.Tags, tag) })
+}
+
+// outboundRules expands the presets and parses protocol:port:cidr entries.
+// The port is omitted for icmp ("icmp::0.0.0.0/0").
+func outboundRules(entries []string) ([]godo.OutboundRule, error) {
+	if len(entries) == 1 && entries[0] == outboundPresetAll {
+		allPorts := "1-" + strconv.Itoa(maxPort)
+
+		return []godo.OutboundRule{
+			{Protocol: "tcp", PortRange: allPorts, Destinations: &godo.Destinations{Addresses: anywhere}},
+			{Protocol: "udp", PortRange: allPorts, Destinations: &godo.Destinations{Addresses: anywhere}},
+			{Protocol: "icmp", Destinations: &godo.Destinations{Addresses: anywhere}},
+		}, nil
+	}
+
+	if len(entries) == 1 && entries[0] == outboundPresetRestricted {
+		entries = restrictedOutbound
+	}
+
+	rules := make([]godo.OutboundRule, 0, len(entries))
+
+	for _, entry := range entries {
+		rule, err := parseOutboundRule(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func parseOutboundRule(entry string) (godo.OutboundRule, error) {
+	protocol, ports, destination, err := parseFirewallEntry("OUTBOUND_ALLOWED", entry)
+	if err != nil {
+		return godo.OutboundRule{}, err
+	}
+
+	return godo.OutboundRule{
+		Protocol:  protocol,
+		PortRange: ports,
+		Destinations: &godo.Destinations{
+			Addresses: []string{destination},
+		},
+	}, nil
+}
+
+// inboundRules parses the EXTRA_INBOUND_PORTS entries.
+func inboundRules(entries []string) ([]godo.InboundRule, error) {
+	rules := make([]godo.InboundRule, 0, len(entries))
+
+	for _, entry := range entries {
+		protocol, ports, source, err := parseFirewallEntry("EXTRA_INBOUND_PORTS", entry)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, godo.InboundRule{
+			Protocol:  protocol,
+			PortRange: ports,
+			Sources: &godo.Sources{
+				Addresses: []string{source},
+			},
+		})
+	}
+
+	return rules, nil
+}
+
+// parseFirewallEntry splits a protocol:port:cidr entry of setting and
+// validates each part.
+func parseFirewallEntry(setting, entry string) (string, string, string, error) {
+	// The CIDR comes last so IPv6 addresses may contain colons
+	parts := strings.SplitN(entry, ":", 3)
+	if len(parts) != 3 {
+		return "", "", "", fmt.Errorf("%w: %s entry %q must be protocol:port:cidr", ErrInvalidConfig, setting, entry)
+	}
+
+	protocol, ports, address := parts[0], parts[1], parts[2]
+
+	switch protocol {
+	case "tcp", "udp":
+		if err := validatePortRange(ports); err != nil {
+			return "", "", "", fmt.Errorf("%w: %s entry %q: %v", ErrInvalidConfig, setting, entry, err)
+		}
+	case "icmp":
+		if ports != "" {
+			return "", "", "", fmt.Errorf("%w: %s entry %q: icmp takes no port", ErrInvalidConfig, setting, entry)
+		}
+	default:
+		return "", "", "", fmt.Errorf("%w: %s entry %q: protocol must be tcp, udp or icmp",
+			ErrInvalidConfig, setting, entry)
+	}
+
+	if _, _, err := net.ParseCIDR(address); err != nil && net.ParseIP(address) == nil {
+		return "", "", "", fmt.Errorf("%w: %s entry %q: %q is not an IP or CIDR", ErrInvalidConfig, setting, entry, address)
+	}
+
+	return protocol, ports, address, nil
+}
+
+// validatePortRange accepts a single port or a low-high range.
+func validatePortRange(ports string) error {
+	low, high, isRange := strings.Cut(ports, "-")
+	if !isRange {
+		high = low
+	}
+
+	first, err := strconv.Atoi(low)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", ports)
+	}
+
+	last, err := strconv.Atoi(high)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", ports)
+	}
+
+	if first < 1 || last > maxPort || first > last {
+		return fmt.Errorf("port range %q must be within 1-%d", ports, maxPort)
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestReconcileFirewall(t *testing.T) {
+	const addDroplets = "POST /v2/firewalls/fw-1/droplets"
+
+	tests := []struct {
+		name     string
+		firewall godo.Firewall
+		droplet  godo.Droplet
+		wantAdd  bool
+	}{
+		{
+			name:     "added when missing",
+			firewall: godo.Firewall{ID: "fw-1", DropletIDs: []int{}},
+			droplet:  godo.Droplet{ID: 42},
+			wantAdd:  true,
+		},
+		{
+			name:     "re-added after the droplet was recreated",
+			firewall: godo.Firewall{ID: "fw-1", DropletIDs: []int{41}},
+			droplet:  godo.Droplet{ID: 42},
+			wantAdd:  true,
+		},
+		{
+			name:     "unchanged when associated",
+			firewall: godo.Firewall{ID: "fw-1", DropletIDs: []int{42}},
+			droplet:  godo.Droplet{ID: 42},
+		},
+		{
+			name:     "unchanged when covered by a tag",
+			firewall: godo.Firewall{ID: "fw-1", Tags: []string{"n8n"}},
+			droplet:  godo.Droplet{ID: 42, Tags: []string{"n8n", "production"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := defaultTestConfig(t)
+			test.firewall.Name = config.resourceName(resourceFirewall)
+			test.droplet.Name = config.resourceName(resourceDroplet)
+
+			firewall, err := json.Marshal(test.firewall)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fake, client := newFakeDO(t, config, map[string]string{
+				"GET /v2/firewalls": `{"firewalls":[` + string(firewall) + `]}`,
+				addDroplets:         ``,
+			})
+
+			if err := reconcileFirewall(context.Background(), client, config, &test.droplet); err != nil {
+				t.Fatal(err)
+			}
+
+			if added := slices.Contains(fake.mutations(), addDroplets); added != test.wantAdd {
+				t.Errorf("added the droplet = %t, want %t (requests %v)", added, test.wantAdd, fake.mutations())
+			}
+		})
+	}
+}
+
+func TestReconcileFirewallUsesFirewallID(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.firewallID = "fw-custom"
+
+	fake, client := newFakeDO(t, config, map[string]string{
+		"GET /v2/firewalls/fw-custom":           `{"firewall":{"id":"fw-custom","name":"custom","droplet_ids":[]}}`,
+		"POST /v2/firewalls/fw-custom/droplets": ``,
+	})
+
+	if err := reconcileFirewall(context.Background(), client, config, &godo.Droplet{ID: 42}); err != nil {
+		t.Fatal(err)
+	}
+
+	if mutations := fake.mutations(); !slices.Equal(mutations, []string{"POST /v2/firewalls/fw-custom/droplets"}) {
+		t.Errorf("requests = %v, want only the add to FIREWALL_ID", mutations)
+	}
+}
+
+func TestExtraInboundPortsAreAddedToTheDefaults(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.extraInbound = []string{"tcp:5679:0.0.0.0/0", "udp:51820-51821:2001:db8::/32", "icmp::10.0.0.0/8"}
+
+	request, err := firewallRequest(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, rule := range request.InboundRules {
+		got = append(got, rule.Protocol+":"+rule.PortRange+":"+strings.Join(rule.Sources.Addresses, ","))
+	}
+
+	want := []string{
+		"tcp:22:0.0.0.0/0,::/0",
+		"tcp:80:0.0.0.0/0,::/0",
+		"tcp:443:0.0.0.0/0,::/0",
+		"tcp:5679:0.0.0.0/0",
+		"udp:51820-51821:2001:db8::/32",
+		"icmp::10.0.0.0/8",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("inbound rules = %v, want %v", got, want)
+	}
+}
+
+func TestFirewallRulesCoverIPv4AndIPv6(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.outboundAllowed = []string{outboundPresetAll}
+
+	request, err := firewallRequest(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"in tcp:22 0.0.0.0/0/::/0",
+		"in tcp:443 0.0.0.0/0/::/0",
+		"in tcp:80 0.0.0.0/0/::/0",
+		"out icmp: 0.0.0.0/0/::/0",
+		"out tcp:1-65535 0.0.0.0/0/::/0",
+		"out udp:1-65535 0.0.0.0/0/::/0",
+	}
+	if got := firewallRuleKeys(request.InboundRules, request.OutboundRules); !slices.Equal(got, want) {
+		t.Errorf("rules = %v, want %v", got, want)
+	}
+
+	config.cloudflare = true
+	config.cloudflareRanges = []string{"173.245.48.0/20", "2400:cb00::/32"}
+
+	if request, err = firewallRequest(config); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rule := range request.InboundRules {
+		want := anywhere
+		if rule.PortRange != "22" {
+			want = config.cloudflareRanges
+		}
+
+		if !slices.Equal(rule.Sources.Addresses, want) {
+			t.Errorf("port %s sources = %v, want %v", rule.PortRange, rule.Sources.Addresses, want)
+		}
+	}
+}
+
+func TestExtraInboundPortsRejectsMalformedEntries(t *testing.T) {
+	for _, entry := range []string{
+		"5679",
+		"tcp:5679",
+		"sctp:5679:0.0.0.0/0",
+		"tcp:0:0.0.0.0/0",
+		"tcp:70000:0.0.0.0/0",
+		"tcp:90-80:0.0.0.0/0",
+		"icmp:8:0.0.0.0/0",
+		"tcp:5679:example.com",
+	} {
+		if _, err := inboundRules([]string{entry}); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("EXTRA_INBOUND_PORTS=%q: err = %v, want ErrInvalidConfig", entry, err)
+		}
+	}
+}
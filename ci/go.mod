@@ -8,6 +8,7 @@ require (
 	dagger.io/dagger v0.9.3
 	github.com/digitalocean/godo v1.132.0
 	golang.org/x/crypto v0.32.0
+	golang.org/x/sync v0.4.0
 )
 
 require (
@@ -21,7 +22,6 @@ require (
 	github.com/vektah/gqlparser/v2 v2.5.6 // indirect
 	golang.org/x/exp v0.0.0-20231006140011-7918f672742d // indirect
 	golang.org/x/oauth2 v0.23.0 // indirect
-	golang.org/x/sync v0.4.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/time v0.6.0 // indirect
 )
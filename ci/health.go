@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/felipepimentel/n8n-digitalocean-cicd/ci/ssh"
+)
+
+const (
+	healthProbeHealthz = "healthz"
+	healthProbeMetrics = "metrics"
+
+	// Readiness polling. A first start pulls the image and runs every
+	// database migration, so it gets twice the attempts of a redeploy.
+	defaultHealthCheckTimeout   = 10 * time.Second
+	defaultHealthCheckInterval  = healthCheckDelay
+	defaultReadinessAttempts    = 30
+	firstStartReadinessAttempts = 2 * defaultReadinessAttempts
+)
+
+var ErrN8NNotReady = errors.New("n8n did not become ready")
+
+// requiredMetrics must all be exported before the metrics probe considers n8n
+// ready. n8n prefixes them (n8n_ by default), so they are matched by suffix.
+var requiredMetrics = []string{
+	"process_cpu_user_seconds_total",
+	"nodejs_eventloop_lag_seconds",
+}
+
+// waitForN8NReady polls n8n from the droplet with the configured probe until
+// it reports ready or the attempts run out. firstStart allows for the slower
+// start of a fresh instance unless HEALTH_CHECK_RETRIES is set.
+func waitForN8NReady(ctx context.Context, sshClient *ssh.Client, config *Config, firstStart bool) error {
+	attempts := readinessAttempts(config, firstStart)
+
+	var lastErr error
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if lastErr = probeN8N(sshClient, config); lastErr == nil {
+			fmt.Printf("n8n is ready (%s probe)\n", config.healthProbe)
+
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(config.healthCheckInterval):
+		}
+	}
+
+	return fmt.Errorf("%w after %d attempts: %w", ErrN8NNotReady, attempts, lastErr)
+}
+
+func readinessAttempts(config *Config, firstStart bool) int {
+	switch {
+	case config.healthCheckRetries > 0:
+		return config.healthCheckRetries
+	case firstStart:
+		return firstStartReadinessAttempts
+	default:
+		return defaultReadinessAttempts
+	}
+}
+
+func probeN8N(sshClient *ssh.Client, config *Config) error {
+	if config.healthProbe == healthProbeMetrics {
+		return probeMetrics(sshClient, config)
+	}
+
+	output, err := sshClient.ExecuteCommand(fmt.Sprintf("curl -sf --max-time %s %s/healthz",
+		curlSeconds(config.healthCheckTimeout), n8nLocalURL(config)))
+	if err != nil {
+		return fmt.Errorf("healthz probe failed: %w\nOutput: %s", err, output)
+	}
+
+	return nil
+}
+
+// probeMetrics requires the Prometheus endpoint to answer and to expose the
+// process and event loop metrics, which only appear once n8n is fully up.
+// The credentials reach curl as a config file on stdin, so they never show
+// up in the droplet's process list.
+func probeMetrics(sshClient *ssh.Client, config *Config) error {
+	command := fmt.Sprintf("curl -sf --max-time %s -K - %s/metrics",
+		curlSeconds(config.healthCheckTimeout), n8nLocalURL(config))
+	credentials := fmt.Sprintf("user = %s\n", curlConfigQuote(config.basicAuthUser+":"+config.basicAuthPass))
+
+	var output bytes.Buffer
+
+	code, err := sshClient.Run(command, strings.NewReader(credentials), &output, io.Discard)
+	if err != nil {
+		return fmt.Errorf("metrics probe failed: %w", err)
+	}
+
+	if code != 0 {
+		return fmt.Errorf("metrics probe failed: curl exited with %d", code)
+	}
+
+	for _, metric := range requiredMetrics {
+		if !hasMetric(output.String(), metric) {
+			return fmt.Errorf("metrics probe: %s not exported yet", metric)
+		}
+	}
+
+	return nil
+}
+
+// curlConfigQuote quotes value for a curl config file, escaping the
+// backslashes and double quotes it contains.
+func curlConfigQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// curlSeconds formats a duration for curl's --max-time.
+func curlSeconds(timeout time.Duration) string {
+	return strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64)
+}
+
+// validateHealthCheckConfig requires a positive probe timeout and interval,
+// and a health wait of at least a second.
+// HEALTH_CHECK_RETRIES may be 0 to keep the first-start aware default.
+func validateHealthCheckConfig(config *Config) error {
+	if config.healthProbe != healthProbeHealthz && config.healthProbe != healthProbeMetrics {
+		return fmt.Errorf("%w: HEALTH_CHECK_PROBE must be %q or %q, got %q",
+			ErrInvalidConfig, healthProbeHealthz, healthProbeMetrics, config.healthProbe)
+	}
+
+	if config.healthCheckTimeout <= 0 {
+		return fmt.Errorf("%w: HEALTH_CHECK_TIMEOUT must be positive, got %s", ErrInvalidConfig, config.healthCheckTimeout)
+	}
+
+	if config.healthCheckInterval <= 0 {
+		return fmt.Errorf("%w: HEALTH_CHECK_INTERVAL must be positive, got %s", ErrInvalidConfig, config.healthCheckInterval)
+	}
+
+	if config.healthWaitTimeout < time.Second {
+		return fmt.Errorf("%w: HEALTH_WAIT_TIMEOUT must be at least 1s, got %s", ErrInvalidConfig, config.healthWaitTimeout)
+	}
+
+	if config.healthCheckRetries < 0 {
+		return fmt.Errorf("%w: HEALTH_CHECK_RETRIES must not be negative, got %d", ErrInvalidConfig, config.healthCheckRetries)
+	}
+
+	return nil
+}
+
+func hasMetric(exposition, metric string) bool {
+	for _, line := range strings.Split(exposition, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, _, _ := strings.Cut(line, " ")
+		name, _, _ = strings.Cut(name, "{")
+
+		if strings.HasSuffix(name, metric) {
+			return true
+		}
+	}
+
+	return false
+}
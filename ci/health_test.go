@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestCurlConfigQuote(t *testing.T) {
+	tests := map[string]string{
+		"admin:secret":       `"admin:secret"`,
+		`admin:pa"ss`:        `"admin:pa\"ss"`,
+		`admin:back\slash`:   `"admin:back\\slash"`,
+		"admin:with space #": `"admin:with space #"`,
+	}
+
+	for value, want := range tests {
+		if got := curlConfigQuote(value); got != want {
+			t.Errorf("curlConfigQuote(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/felipepimentel/n8n-digitalocean-cicd/ci/ssh"
+)
+
+// deployHistoryPath holds one JSON entry per line next to the deploy state,
+// so it travels with /opt/n8n when the instance is migrated.
+const deployHistoryPath = "/opt/n8n/deploy-history.jsonl"
+
+// maxDeployHistoryEntries caps the history file, keeping it well below the
+// SSH output limit when it is read back in full.
+const maxDeployHistoryEntries = 1000
+
+// Outcomes recorded in the history.
+const (
+	outcomeSucceeded = "succeeded"
+	outcomeUnchanged = "unchanged"
+	outcomeFailed    = "failed"
+)
+
+// historyEntry is one deploy attempt.
+type historyEntry struct {
+	Time        time.Time `json:"time"`
+	Operator    string    `json:"operator"`
+	GitSHA      string    `json:"gitSha,omitempty"`
+	N8NVersion  string    `json:"n8nVersion"`
+	ImageDigest string    `json:"imageDigest,omitempty"`
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// deployOperator names who started the deploy: DEPLOYED_BY, the GitHub
+// Actions actor, or the local user.
+func deployOperator() string {
+	for _, key := range []string{"DEPLOYED_BY", "GITHUB_ACTOR", "USER"} {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+	}
+
+	return "unknown"
+}
+
+// newHistoryEntry describes a deploy of image that ended with err.
+func newHistoryEntry(image *publishedImage, outcome string, err error) historyEntry {
+	entry := historyEntry{
+		Time:        time.Now().UTC(),
+		Operator:    deployOperator(),
+		GitSHA:      os.Getenv("GITHUB_SHA"),
+		N8NVersion:  image.version,
+		ImageDigest: image.digest,
+		Outcome:     outcome,
+	}
+
+	if err != nil {
+		// Errors may carry command output; the first line names the failure
+		entry.Outcome = outcomeFailed
+		entry.Error, _, _ = strings.Cut(err.Error(), "\n")
+	}
+
+	return entry
+}
+
+// appendDeployHistory records entry on the droplet, dropping the oldest
+// entries beyond maxDeployHistoryEntries. It only warns on failure so a
+// history problem never masks the deploy's own result.
+func appendDeployHistory(sshClient *ssh.Client, entry historyEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Printf("Warning: failed to encode deploy history entry: %v\n", err)
+
+		return
+	}
+
+	// JSON escapes newlines, so the entry is a single line
+	command := fmt.Sprintf(`mkdir -p /opt/n8n && echo %[1]s >> %[2]s
+tail -n %[3]d %[2]s > %[2]s.tmp && mv %[2]s.tmp %[2]s`,
+		shellQuote(string(data)), deployHistoryPath, maxDeployHistoryEntries)
+	if output, err := sshClient.ExecuteCommand(command); err != nil {
+		fmt.Printf("Warning: failed to record deploy history: %v\nOutput: %s\n", err, output)
+	}
+}
+
+// runHistory prints the droplet's deploy history, newest last.
+func runHistory(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the entries as JSON")
+	limit := flags.Int("n", maxDeployHistoryEntries, "only show the last N entries")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config := loadConfig()
+
+	stopAgent, err := prepareSSHKey(&config)
+	if err != nil {
+		return err
+	}
+	defer stopAgent()
+
+	sshClient, err := connectDroplet(ctx, newDOClient(&config), config.resourceName(resourceDroplet), &config)
+	if err != nil {
+		return err
+	}
+	defer sshClient.Close()
+
+	entries, err := readDeployHistory(sshClient, *limit)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(entries)
+	}
+
+	printDeployHistory(entries)
+
+	return nil
+}
+
+// readDeployHistory returns the last limit entries, taken on the droplet so
+// the output stays within the SSH output limit however long the file grew.
+func readDeployHistory(sshClient *ssh.Client, limit int) ([]historyEntry, error) {
+	if limit <= 0 || limit > maxDeployHistoryEntries {
+		limit = maxDeployHistoryEntries
+	}
+
+	output, err := sshClient.ExecuteCommand(fmt.Sprintf("tail -n %d %s 2>/dev/null || true", limit, deployHistoryPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deploy history: %w", err)
+	}
+
+	entries := []historyEntry{}
+
+	for i, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var entry historyEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse deploy history line %d: %w", i+1, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func printDeployHistory(entries []historyEntry) {
+	if len(entries) == 0 {
+		fmt.Println("No deploys recorded")
+
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, tabwriterWidth, ' ', 0)
+	defer writer.Flush()
+
+	fmt.Fprintln(writer, "TIME\tOPERATOR\tGIT SHA\tN8N\tDIGEST\tOUTCOME")
+
+	for _, entry := range entries {
+		outcome := entry.Outcome
+		if entry.Error != "" {
+			outcome += ": " + entry.Error
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.Time.Format(time.RFC3339), entry.Operator, orDash(shortDigest(entry.GitSHA)),
+			orDash(entry.N8NVersion), orDash(shortDigest(entry.ImageDigest)), outcome)
+	}
+}
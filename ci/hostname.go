@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+const maxHostnameLength = 253
+
+// hostnameLabelPattern is an RFC 1123 label: alphanumerics and inner hyphens.
+var hostnameLabelPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+// generateHostnameScript makes the droplet identify as its FQDN instead of
+// the droplet name, and stops cloud-init from resetting it on reboot.
+func generateHostnameScript(config *Config) string {
+	shortName := strings.SplitN(config.dropletHostname, ".", 2)[0]
+
+	return fmt.Sprintf(`
+# Set hostname
+hostnamectl set-hostname %[1]s
+echo %[1]s > /etc/hostname
+sed -i '/^127\.0\.1\.1/d' /etc/hosts
+echo "127.0.1.1 %[1]s %[2]s" >> /etc/hosts
+echo "preserve_hostname: true" > /etc/cloud/cloud.cfg.d/99-hostname.cfg
+`, config.dropletHostname, shortName)
+}
+
+// hostnameCloudConfig has cloud-init give the droplet its FQDN from first
+// boot. DigitalOcean's metadata would otherwise set the droplet name.
+func hostnameCloudConfig(config *Config) string {
+	shortName := strings.SplitN(config.dropletHostname, ".", 2)[0]
+
+	return fmt.Sprintf(`hostname: %s
+fqdn: %s
+prefer_fqdn_over_hostname: true
+`, shortName, config.dropletHostname)
+}
+
+// renameLegacyDroplet renames the droplet still named after DEPLOY_PREFIX to
+// DROPLET_HOSTNAME, so setting it later does not create a second droplet.
+func renameLegacyDroplet(ctx context.Context, client *godo.Client, config *Config) (*godo.Droplet, error) {
+	droplet, err := findDroplet(ctx, client, config.region, config.deployPrefix)
+	if err != nil || droplet == nil {
+		return nil, err
+	}
+
+	if _, _, err := client.DropletActions.Rename(ctx, droplet.ID, config.dropletName); err != nil {
+		return nil, fmt.Errorf("failed to rename droplet %s to %s: %w", droplet.Name, config.dropletName, err)
+	}
+
+	fmt.Printf("Renamed droplet %s to %s\n", droplet.Name, config.dropletName)
+
+	droplet.Name = config.dropletName
+
+	return droplet, nil
+}
+
+// validateHostname checks that hostname, read from setting, is an RFC 1123
+// hostname of at least minLabels labels.
+func validateHostname(setting, hostname string, minLabels int) error {
+	if len(hostname) > maxHostnameLength {
+		return fmt.Errorf("%w: %s must be at most %d characters, got %d",
+			ErrInvalidConfig, setting, maxHostnameLength, len(hostname))
+	}
+
+	labels := strings.Split(hostname, ".")
+	if len(labels) < minLabels {
+		return fmt.Errorf("%w: %s must be a hostname of at least %d dot-separated labels, got %q",
+			ErrInvalidConfig, setting, minLabels, hostname)
+	}
+
+	for _, label := range labels {
+		if !hostnameLabelPattern.MatchString(label) {
+			return fmt.Errorf("%w: %s %q is not a valid hostname (bad label %q)",
+				ErrInvalidConfig, setting, hostname, label)
+		}
+	}
+
+	return nil
+}
+
+// normalizeDomain turns what is commonly pasted into N8N_DOMAIN, such as
+// "HTTPS://N8N.Example.com./", into the bare lowercase hostname used for DNS
+// records and the Caddyfile.
+func normalizeDomain(domain string) string {
+	domain = strings.TrimSpace(domain)
+
+	if _, rest, found := strings.Cut(domain, "://"); found {
+		domain = rest
+	}
+
+	// Drop any path, query or fragment
+	if end := strings.IndexAny(domain, "/?#"); end >= 0 {
+		domain = domain[:end]
+	}
+
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+func validateDomain(domain string) error {
+	return validateHostname("N8N_DOMAIN", domain, minDomainParts)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeAndValidateDomain(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		valid bool
+	}{
+		{"n8n.example.com", "n8n.example.com", true},
+		{"HTTPS://N8N.Example.com./", "n8n.example.com", true},
+		{"  https://n8n.example.com/path?query#fragment ", "n8n.example.com", true},
+		{"n8n.example.com.", "n8n.example.com", true},
+		{"http://n8n.example.com:5678", "n8n.example.com:5678", false},
+		{"localhost", "localhost", false},
+		{"n8n..example.com", "n8n..example.com", false},
+		{"-n8n.example.com", "-n8n.example.com", false},
+		{"n8n_test.example.com", "n8n_test.example.com", false},
+		{strings.Repeat("a", 64) + ".example.com", strings.Repeat("a", 64) + ".example.com", false},
+		{"", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			domain := normalizeDomain(test.input)
+			if domain != test.want {
+				t.Errorf("normalizeDomain(%q) = %q, want %q", test.input, domain, test.want)
+			}
+
+			err := validateDomain(domain)
+			if test.valid && err != nil {
+				t.Errorf("validateDomain(%q) = %v, want nil", domain, err)
+			}
+
+			if !test.valid && !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("validateDomain(%q) = %v, want ErrInvalidConfig", domain, err)
+			}
+		})
+	}
+}
+
+func TestValidateHostnameLength(t *testing.T) {
+	label := strings.Repeat("a", 63)
+	long := strings.Join([]string{label, label, label, label}, ".")
+
+	if err := validateHostname("DROPLET_HOSTNAME", long, 1); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("a %d character hostname: err = %v, want ErrInvalidConfig", len(long), err)
+	}
+
+	if err := validateHostname("DROPLET_HOSTNAME", "n8n", 1); err != nil {
+		t.Errorf("a single-label hostname: %v", err)
+	}
+}
+
+func TestHostnameCloudConfig(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.dropletHostname = "n8n-01.example.com"
+
+	cloudConfig := hostnameCloudConfig(config)
+
+	for _, want := range []string{"hostname: n8n-01\n", "fqdn: n8n-01.example.com\n", "prefer_fqdn_over_hostname: true\n"} {
+		if !strings.Contains(cloudConfig, want) {
+			t.Errorf("cloud-config is missing %q:\n%s", want, cloudConfig)
+		}
+	}
+}
+
+func TestDropletHostnameNamesTheDroplet(t *testing.T) {
+	if config := defaultTestConfig(t); config.resourceName(resourceDroplet) != config.deployPrefix {
+		t.Errorf("droplet name = %q, want DEPLOY_PREFIX %q without DROPLET_HOSTNAME",
+			config.resourceName(resourceDroplet), config.deployPrefix)
+	}
+
+	config := testConfig(t, map[string]string{"DROPLET_HOSTNAME": "n8n-01.example.com"})
+
+	if name := config.resourceName(resourceDroplet); name != "n8n-01.example.com" {
+		t.Errorf("droplet name = %q, want DROPLET_HOSTNAME", name)
+	}
+
+	if tag := config.resourceName(resourceTag); tag != config.deployPrefix {
+		t.Errorf("droplet tag = %q, want DEPLOY_PREFIX %q", tag, config.deployPrefix)
+	}
+
+	ephemeral := ephemeralConfig(config)
+	if name := ephemeral.resourceName(resourceDroplet); name != config.deployPrefix+ephemeralSuffix {
+		t.Errorf("ephemeral droplet name = %q, want %q", name, config.deployPrefix+ephemeralSuffix)
+	}
+}
+
+func TestRenameLegacyDroplet(t *testing.T) {
+	const rename = "POST /v2/droplets/42/actions"
+
+	config := defaultTestConfig(t)
+	config.dropletName = "n8n-01.example.com"
+
+	fake, client := newFakeDO(t, config, map[string]string{
+		"GET /v2/droplets": `{"droplets":[{"id":42,"name":"` + config.deployPrefix + `"}]}`,
+		rename:             `{"action":{"id":1,"status":"completed"}}`,
+	})
+
+	droplet, err := renameLegacyDroplet(context.Background(), client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if droplet == nil || droplet.Name != config.dropletName {
+		t.Fatalf("droplet = %+v, want it renamed to %s", droplet, config.dropletName)
+	}
+
+	if body := fake.body(rename); !strings.Contains(body, `"name":"n8n-01.example.com"`) {
+		t.Errorf("rename request = %s", body)
+	}
+}
+
+func TestRenameLegacyDropletWithoutOne(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.dropletName = "n8n-01.example.com"
+
+	fake, client := newFakeDO(t, config, map[string]string{"GET /v2/droplets": `{"droplets":[]}`})
+
+	droplet, err := renameLegacyDroplet(context.Background(), client, config)
+	if err != nil || droplet != nil {
+		t.Fatalf("renameLegacyDroplet = %v, %v, want nothing to rename", droplet, err)
+	}
+
+	if mutations := fake.mutations(); len(mutations) != 0 {
+		t.Errorf("requests = %v, want none", mutations)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// imageSecretEnv are variables that only the droplet's .env may set. Anyone
+// able to pull from the registry can read an image's environment.
+var imageSecretEnv = []string{
+	"N8N_ENCRYPTION_KEY",
+	"N8N_BASIC_AUTH_USER",
+	"N8N_BASIC_AUTH_PASSWORD",
+	"DB_PASSWORD",
+	"DB_POSTGRESDB_PASSWORD",
+}
+
+var ErrSecretInImage = errors.New("image environment contains secrets")
+
+// verifyNoSecretEnv refuses to publish an image whose environment carries a
+// secret, including one inherited from N8N_BASE_IMAGE.
+func verifyNoSecretEnv(ctx context.Context, image *dagger.Container) error {
+	variables, err := image.EnvVariables(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read image environment: %w", err)
+	}
+
+	names := make([]string, 0, len(variables))
+
+	for _, variable := range variables {
+		name, err := variable.Name(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read image environment: %w", err)
+		}
+
+		names = append(names, name)
+	}
+
+	if found := secretEnvNames(names); len(found) > 0 {
+		return fmt.Errorf("%w: %s", ErrSecretInImage, strings.Join(found, ", "))
+	}
+
+	return nil
+}
+
+// secretEnvNames returns the names that are in imageSecretEnv.
+func secretEnvNames(names []string) []string {
+	var found []string
+
+	for _, name := range names {
+		if slices.Contains(imageSecretEnv, name) {
+			found = append(found, name)
+		}
+	}
+
+	return found
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSecretEnvNames(t *testing.T) {
+	names := []string{"NODE_ENV", "N8N_BASIC_AUTH_PASSWORD", "N8N_PORT", "N8N_ENCRYPTION_KEY", "N8N_BASIC_AUTH_ACTIVE"}
+
+	found := secretEnvNames(names)
+	if !slices.Equal(found, []string{"N8N_BASIC_AUTH_PASSWORD", "N8N_ENCRYPTION_KEY"}) {
+		t.Errorf("secretEnvNames = %v, want the password and encryption key", found)
+	}
+
+	if found := secretEnvNames([]string{"NODE_ENV", "N8N_BASIC_AUTH_ACTIVE"}); len(found) != 0 {
+		t.Errorf("secretEnvNames = %v, want none", found)
+	}
+}
+
+func TestBasicAuthCredentialsOnlyInTheDropletEnv(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.basicAuthUser = "operator"
+	config.basicAuthPass = "a-long-test-password"
+
+	compose := generateDockerComposeContent(config)
+
+	for _, secret := range []string{config.basicAuthPass, config.encryptionKey} {
+		if strings.Contains(compose, secret) {
+			t.Errorf("the compose file carries a secret value %q", secret)
+		}
+	}
+
+	if !strings.Contains(compose, "N8N_BASIC_AUTH_PASSWORD=${N8N_BASIC_AUTH_PASSWORD}") {
+		t.Error("the n8n service does not read the password from the .env")
+	}
+
+	env := generateEnvFile(config)
+	if !strings.Contains(env, "N8N_BASIC_AUTH_USER=operator\n") ||
+		!strings.Contains(env, "N8N_BASIC_AUTH_PASSWORD=a-long-test-password\n") {
+		t.Errorf("the .env does not set the credentials:\n%s", env)
+	}
+}
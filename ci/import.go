@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+
+	"github.com/felipepimentel/n8n-digitalocean-cicd/ci/ssh"
+)
+
+var (
+	ErrImportUsage        = errors.New("usage: import --droplet ID|IP [--adopt-vpc]")
+	ErrImportIncompatible = errors.New("instance cannot be imported")
+)
+
+// outcomeImported marks the history entry written when an instance is adopted.
+const outcomeImported = "imported"
+
+// importProbe is what import finds on the droplet, one key=value per line.
+type importProbe map[string]string
+
+// runImport adopts an n8n instance that was set up by hand so later deploys
+// manage it in place. The running setup is checked for the layout a deploy
+// expects, the deploy state is recorded, and only then is the droplet renamed
+// and tagged like one this tool created, so a failed check changes nothing in
+// the account. Nothing is recreated; anything a deploy would replace or
+// cannot manage is reported.
+func runImport(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dropletRef := flags.String("droplet", "", "ID or public IP of the existing droplet")
+	adoptVPC := flags.Bool("adopt-vpc", false, "rename the droplet's VPC to the deployment's VPC name")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *dropletRef == "" {
+		return ErrImportUsage
+	}
+
+	config := loadConfig()
+
+	if err := refuseDryRun(&config, "import"); err != nil {
+		return err
+	}
+
+	if err := validateConfig(&config); err != nil {
+		return err
+	}
+
+	client := newDOClient(&config)
+
+	droplet, err := findImportDroplet(ctx, client, *dropletRef)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Importing droplet %s (%d) as %s\n", droplet.Name, droplet.ID, config.resourceName(resourceDroplet))
+
+	vpc, err := checkImportDroplet(ctx, client, &config, droplet, *adoptVPC)
+	if err != nil {
+		return err
+	}
+
+	stopAgent, err := prepareSSHKey(&config)
+	if err != nil {
+		return err
+	}
+	defer stopAgent()
+
+	ip, err := droplet.PublicIPv4()
+	if err != nil {
+		return fmt.Errorf("failed to read droplet IP: %w", err)
+	}
+
+	sshClient, err := connectSSH(ctx, ip, config.deploySSHUser, &config)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSSHClient, err)
+	}
+	defer sshClient.Close()
+
+	if err := verifyDeployUser(sshClient, &config); err != nil {
+		return err
+	}
+
+	probe, err := probeImportedInstance(sshClient, &config)
+	if err != nil {
+		return err
+	}
+
+	if err := checkImportCompatibility(probe, &config); err != nil {
+		return err
+	}
+
+	// A key mismatch would make every stored credential unreadable on the
+	// first deploy, so it fails the import rather than waiting for it
+	if err := verifyEncryptionKey(sshClient, &config); err != nil {
+		return err
+	}
+
+	version, err := readRunningN8NVersion(sshClient, &config)
+	if err != nil {
+		return err
+	}
+
+	if err := recordImportedDBPassword(sshClient, probe["dbPassword"]); err != nil {
+		return err
+	}
+
+	// No config hash, so the first deploy always applies the generated setup
+	state := &deployState{N8NVersion: version, ImageRef: probe["image"], DeployedAt: time.Now().UTC()}
+	if err := writeDeployState(sshClient, state); err != nil {
+		return err
+	}
+
+	if err := adoptImportDroplet(ctx, client, &config, droplet, vpc); err != nil {
+		return err
+	}
+
+	appendDeployHistory(sshClient, newHistoryEntry(&publishedImage{version: version}, outcomeImported, nil))
+
+	if config.manageDNS {
+		warnImportDNS(&config, ip)
+	}
+
+	fmt.Printf("Imported n8n %s on %s; the next deploy manages it in place\n", version, config.resourceName(resourceDroplet))
+
+	return nil
+}
+
+// findImportDroplet looks the droplet up by ID, or by public IPv4 address.
+func findImportDroplet(ctx context.Context, client *godo.Client, ref string) (*godo.Droplet, error) {
+	if id, err := strconv.Atoi(ref); err == nil {
+		droplet, _, err := client.Droplets.Get(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get droplet %d: %w", id, err)
+		}
+
+		return droplet, nil
+	}
+
+	if net.ParseIP(ref) == nil {
+		return nil, fmt.Errorf("%w: %q is neither a droplet ID nor an IP address", ErrImportUsage, ref)
+	}
+
+	droplets, _, err := client.Droplets.List(ctx, &godo.ListOptions{PerPage: listPerPage})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list droplets: %w", err)
+	}
+
+	for i := range droplets {
+		if ip, err := droplets[i].PublicIPv4(); err == nil && ip == ref {
+			return &droplets[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: no droplet has the public IP %s", ErrDropletNotFound, ref)
+}
+
+// checkImportDroplet makes sure droplet can take the name and VPC name a
+// deploy looks for without changing anything. Its region cannot change in
+// place, so a mismatch fails the import. It returns the droplet's VPC when
+// adoptImportDroplet has to rename it.
+func checkImportDroplet(ctx context.Context, client *godo.Client, config *Config, droplet *godo.Droplet,
+	adoptVPC bool,
+) (*godo.VPC, error) {
+	if droplet.Region != nil && droplet.Region.Slug != config.region {
+		return nil, fmt.Errorf("%w: droplet is in %s, DO_REGION is %s",
+			ErrImportIncompatible, droplet.Region.Slug, config.region)
+	}
+
+	name := config.resourceName(resourceDroplet)
+	if droplet.Name != name {
+		existing, err := findDroplet(ctx, client, config.region, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if existing != nil {
+			return nil, fmt.Errorf("%w: another droplet is already named %s: %s",
+				ErrImportIncompatible, name, describeDroplet(existing))
+		}
+	}
+
+	return checkImportVPC(ctx, client, config, droplet, adoptVPC)
+}
+
+// checkImportVPC looks up the droplet's VPC. VPCs cannot be swapped without
+// recreating the droplet, so the existing one is renamed to the deployment's
+// VPC name, but only when asked: it may be shared. It returns the VPC when it
+// needs that rename.
+func checkImportVPC(ctx context.Context, client *godo.Client, config *Config, droplet *godo.Droplet,
+	adoptVPC bool,
+) (*godo.VPC, error) {
+	vpc, _, err := client.VPCs.Get(ctx, droplet.VPCUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VPC %s: %w", droplet.VPCUUID, err)
+	}
+
+	name := config.resourceName(resourceVPC)
+	if vpc.Name == name {
+		return nil, nil
+	}
+
+	if !adoptVPC {
+		return nil, fmt.Errorf("%w: the droplet is in VPC %s, deploys expect %s; pass --adopt-vpc to rename it "+
+			"or move the instance with migrate", ErrImportIncompatible, vpc.Name, name)
+	}
+
+	return vpc, nil
+}
+
+// adoptImportDroplet gives droplet the name, tags and VPC name a deploy looks
+// for, once checkImportDroplet has cleared them. vpc is renamed unless nil.
+func adoptImportDroplet(ctx context.Context, client *godo.Client, config *Config, droplet *godo.Droplet,
+	vpc *godo.VPC,
+) error {
+	if vpc != nil {
+		name := config.resourceName(resourceVPC)
+
+		if vpc.Default {
+			fmt.Printf("Warning: %s is the region's default VPC; other droplets in it are not affected\n", vpc.Name)
+		}
+
+		if _, _, err := client.VPCs.Set(ctx, vpc.ID, godo.VPCSetName(name)); err != nil {
+			return fmt.Errorf("failed to rename VPC %s to %s: %w", vpc.Name, name, err)
+		}
+
+		fmt.Printf("Renamed VPC %s to %s\n", vpc.Name, name)
+	}
+
+	name := config.resourceName(resourceDroplet)
+	if droplet.Name != name {
+		if _, _, err := client.DropletActions.Rename(ctx, droplet.ID, name); err != nil {
+			return fmt.Errorf("failed to rename droplet: %w", err)
+		}
+
+		fmt.Printf("Renamed droplet %s to %s\n", droplet.Name, name)
+	}
+
+	resource := []godo.Resource{{ID: strconv.Itoa(droplet.ID), Type: godo.DropletResourceType}}
+
+	for _, tag := range []string{managedTag, environmentTag, config.resourceName(resourceTag)} {
+		if err := ensureTag(ctx, client, tag); err != nil {
+			return err
+		}
+
+		if _, err := client.Tags.TagResources(ctx, tag, &godo.TagResourcesRequest{Resources: resource}); err != nil {
+			return fmt.Errorf("failed to tag droplet with %s: %w", tag, err)
+		}
+	}
+
+	if len(droplet.VolumeIDs) > 0 && config.volumeSizeGB > 0 {
+		fmt.Println("Warning: the droplet has volumes attached; VOLUME_SIZE_GB manages its own " +
+			dataVolumeName(config) + " volume and does not adopt them")
+	}
+
+	return nil
+}
+
+// probeImportedInstance collects what a deploy depends on: the compose files,
+// the named volumes, the containers of each service and the password n8n
+// uses for Postgres.
+func probeImportedInstance(sshClient *ssh.Client, config *Config) (importProbe, error) {
+	script := fmt.Sprintf(`[ -f /opt/n8n/docker-compose.yml ] && echo compose=yes
+[ -f /opt/n8n/.env ] && echo env=yes
+docker volume inspect %[1]s_n8n_data >/dev/null 2>&1 && echo n8nVolume=yes
+docker volume inspect %[1]s_db_data >/dev/null 2>&1 && echo dbVolume=yes
+N8N=$(docker ps -q %[2]s | head -n 1)
+if [ -n "$N8N" ]; then
+	echo "image=$(docker inspect --format '{{.Config.Image}}' "$N8N")"
+	N8N_ENV=$(docker inspect --format '{{range .Config.Env}}{{println .}}{{end}}' "$N8N")
+	printf '%%s\n' "$N8N_ENV" | grep -q '^DB_TYPE=postgresdb$' && echo postgres=yes
+	printf '%%s\n' "$N8N_ENV" | sed -n 's/^DB_POSTGRESDB_PASSWORD=/dbPassword=/p' | head -n 1
+fi
+[ -n "$(docker ps -q %[3]s)" ] && echo db=yes
+[ -n "$(docker ps -q %[4]s)" ] && echo caddy=yes
+echo "others=$(docker ps --format '{{.Names}} {{.Label "com.docker.compose.project"}}' | awk '$2 != "%[1]s" {print $1}' | paste -sd, -)"
+true`, config.composeProject, serviceContainerFilter(config, "n8n"), serviceContainerFilter(config, "db"),
+		serviceContainerFilter(config, "caddy"))
+
+	output, err := sshClient.ExecuteCommand(script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe the existing instance: %w\nOutput: %s", err, output)
+	}
+
+	probe := importProbe{}
+
+	for _, line := range strings.Split(output, "\n") {
+		if key, value, found := strings.Cut(strings.TrimSpace(line), "="); found {
+			probe[key] = value
+		}
+	}
+
+	return probe, nil
+}
+
+// recordImportedDBPassword stores the password n8n already uses as
+// DB_PASSWORD in /opt/n8n/.env, which the next deploy carries over instead
+// of generating one the existing database would reject.
+func recordImportedDBPassword(sshClient *ssh.Client, password string) error {
+	script := fmt.Sprintf(`mkdir -p /opt/n8n && touch /opt/n8n/.env
+sed -i '/^DB_PASSWORD=/d' /opt/n8n/.env
+printf 'DB_PASSWORD=%%s\n' %s >> /opt/n8n/.env`, shellQuote(password))
+
+	if output, err := sshClient.ExecuteCommand(script); err != nil {
+		return fmt.Errorf("failed to record the database password: %w\nOutput: %s", err, output)
+	}
+
+	return nil
+}
+
+// checkImportCompatibility fails on a layout a deploy would break, namely
+// data it would not find, and warns about what it would replace or ignore.
+func checkImportCompatibility(probe importProbe, config *Config) error {
+	var problems []string
+
+	if probe["image"] == "" {
+		problems = append(problems, fmt.Sprintf("no running n8n container in compose project %s "+
+			"(set COMPOSE_PROJECT_NAME to the instance's project)", config.composeProject))
+	}
+
+	if probe["n8nVolume"] == "" {
+		problems = append(problems, fmt.Sprintf("volume %s not found", n8nDataVolume(config)))
+	}
+
+	if probe["postgres"] == "" || probe["dbVolume"] == "" || probe["db"] == "" {
+		problems = append(problems, fmt.Sprintf("n8n does not use a Postgres db service with volume %s_db_data",
+			config.composeProject))
+	} else if probe["dbPassword"] == "" {
+		problems = append(problems, "n8n's DB_POSTGRESDB_PASSWORD could not be read "+
+			"(a password from DB_POSTGRESDB_PASSWORD_FILE is not supported)")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrImportIncompatible, strings.Join(problems, "; "))
+	}
+
+	if probe["compose"] == "" || probe["env"] == "" {
+		fmt.Println("Warning: /opt/n8n/docker-compose.yml or .env is missing; the next deploy writes both")
+	} else {
+		fmt.Println("Warning: the next deploy replaces /opt/n8n/docker-compose.yml and .env with generated ones")
+	}
+
+	if probe["caddy"] == "" {
+		fmt.Println("Warning: no caddy service is running; the next deploy starts one on ports 80 and 443, " +
+			"so stop any other reverse proxy first")
+	}
+
+	if probe["others"] != "" {
+		fmt.Printf("Warning: containers outside project %s are not managed: %s\n", config.composeProject, probe["others"])
+	}
+
+	return nil
+}
+
+// warnImportDNS reports when the domain does not point at the droplet yet.
+// The first deploy with MANAGE_DNS=true rewrites the record.
+func warnImportDNS(config *Config, ip string) {
+	addrs, err := net.LookupHost(config.domain)
+	if err != nil || !slices.Contains(addrs, ip) {
+		fmt.Printf("Warning: %s does not resolve to %s yet; the next deploy updates its DNS records\n", config.domain, ip)
+	}
+}
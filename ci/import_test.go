@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestCheckImportDropletChangesNothing(t *testing.T) {
+	config := defaultTestConfig(t)
+	droplet := &godo.Droplet{ID: 1, Name: "hand-made", Region: &godo.Region{Slug: config.region}, VPCUUID: "vpc-1"}
+	responses := map[string]string{
+		"GET /v2/droplets":   `{"droplets":[]}`,
+		"GET /v2/vpcs/vpc-1": `{"vpc":{"id":"vpc-1","name":"hand-made-vpc"}}`,
+	}
+
+	fake, client := newFakeDO(t, config, responses)
+
+	_, err := checkImportDroplet(context.Background(), client, config, droplet, false)
+	if !errors.Is(err, ErrImportIncompatible) {
+		t.Fatalf("err = %v, want ErrImportIncompatible without --adopt-vpc", err)
+	}
+
+	vpc, err := checkImportDroplet(context.Background(), client, config, droplet, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vpc == nil || vpc.ID != "vpc-1" {
+		t.Errorf("vpc = %v, want vpc-1 to be renamed", vpc)
+	}
+
+	if mutations := fake.mutations(); len(mutations) > 0 {
+		t.Errorf("checking the droplet changed %v", mutations)
+	}
+}
+
+func TestCheckImportDropletRefusesATakenName(t *testing.T) {
+	config := defaultTestConfig(t)
+	droplet := &godo.Droplet{ID: 1, Name: "hand-made", Region: &godo.Region{Slug: config.region}, VPCUUID: "vpc-1"}
+	responses := map[string]string{
+		"GET /v2/droplets": `{"droplets":[{"id":2,"name":"` + config.resourceName(resourceDroplet) + `"}]}`,
+	}
+
+	fake, client := newFakeDO(t, config, responses)
+
+	_, err := checkImportDroplet(context.Background(), client, config, droplet, true)
+	if !errors.Is(err, ErrImportIncompatible) {
+		t.Fatalf("err = %v, want ErrImportIncompatible", err)
+	}
+
+	if mutations := fake.mutations(); len(mutations) > 0 {
+		t.Errorf("checking the droplet changed %v", mutations)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+var ErrEnvVarParseLabels = errors.New("failed to parse environment variable as KEY=VALUE pairs")
+
+// requireEnvLabels parses comma-separated KEY=VALUE pairs.
+func requireEnvLabels(key string) map[string]string {
+	labels := map[string]string{}
+
+	for _, pair := range splitList(os.Getenv(key)) {
+		name, value, found := strings.Cut(pair, "=")
+		if !found || strings.TrimSpace(name) == "" {
+			panic(fmt.Sprintf("%v: %s entry %q", ErrEnvVarParseLabels, key, pair))
+		}
+
+		labels[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	return labels
+}
+
+// imageCreatedTime is SOURCE_DATE_EPOCH when set, so rebuilding unchanged
+// sources yields the same image digest, and the current time otherwise.
+func imageCreatedTime() string {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("%v: SOURCE_DATE_EPOCH=%q", ErrEnvVarParseInt, epoch))
+		}
+
+		return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+	}
+
+	return time.Now().Format(time.RFC3339)
+}
+
+// ciImageLabels derives the standard OCI source labels from the GitHub
+// Actions environment when it is available.
+func ciImageLabels() map[string]string {
+	labels := map[string]string{}
+
+	if sha := os.Getenv("GITHUB_SHA"); sha != "" {
+		labels["org.opencontainers.image.revision"] = sha
+	}
+
+	server, repository := os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY")
+	if server != "" && repository != "" {
+		repoURL := fmt.Sprintf("%s/%s", server, repository)
+		labels["org.opencontainers.image.source"] = repoURL
+		labels["org.opencontainers.image.url"] = repoURL
+
+		if runID := os.Getenv("GITHUB_RUN_ID"); runID != "" {
+			labels["org.opencontainers.image.build-url"] = fmt.Sprintf("%s/actions/runs/%s", repoURL, runID)
+		}
+	}
+
+	return labels
+}
+
+// imageLabels merges the CI-derived labels and IMAGE_LABELS, so configured
+// labels win.
+func imageLabels(config *Config) map[string]string {
+	labels := ciImageLabels()
+	for name, value := range config.imageLabels {
+		labels[name] = value
+	}
+
+	return labels
+}
+
+// withImageLabels applies imageLabels in a stable order to keep builds
+// reproducible.
+func withImageLabels(container *dagger.Container, config *Config) *dagger.Container {
+	labels := imageLabels(config)
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		container = container.WithLabel(name, labels[name])
+	}
+
+	return container
+}
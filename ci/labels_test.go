@@ -0,0 +1,67 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestRequireEnvLabels(t *testing.T) {
+	t.Setenv("IMAGE_LABELS", " team = platform ,org.example.tier=prod,empty=")
+
+	want := map[string]string{"team": "platform", "org.example.tier": "prod", "empty": ""}
+	if got := requireEnvLabels("IMAGE_LABELS"); !maps.Equal(got, want) {
+		t.Errorf("requireEnvLabels = %v, want %v", got, want)
+	}
+
+	for _, value := range []string{"team", "=platform"} {
+		t.Run(value, func(t *testing.T) {
+			t.Setenv("IMAGE_LABELS", value)
+
+			defer func() {
+				if recover() == nil {
+					t.Errorf("IMAGE_LABELS=%q was accepted", value)
+				}
+			}()
+
+			requireEnvLabels("IMAGE_LABELS")
+		})
+	}
+}
+
+func TestImageLabelsFromCI(t *testing.T) {
+	t.Setenv("GITHUB_SHA", "0123abcd")
+	t.Setenv("GITHUB_SERVER_URL", "https://github.com")
+	t.Setenv("GITHUB_REPOSITORY", "example/n8n")
+	t.Setenv("GITHUB_RUN_ID", "42")
+
+	config := defaultTestConfig(t)
+	config.imageLabels = map[string]string{"org.opencontainers.image.source": "https://example.com/n8n"}
+
+	want := map[string]string{
+		"org.opencontainers.image.revision":  "0123abcd",
+		"org.opencontainers.image.source":    "https://example.com/n8n",
+		"org.opencontainers.image.url":       "https://github.com/example/n8n",
+		"org.opencontainers.image.build-url": "https://github.com/example/n8n/actions/runs/42",
+	}
+	if got := imageLabels(config); !maps.Equal(got, want) {
+		t.Errorf("imageLabels = %v, want %v", got, want)
+	}
+}
+
+func TestImageLabelsOutsideCI(t *testing.T) {
+	for _, key := range []string{"GITHUB_SHA", "GITHUB_SERVER_URL", "GITHUB_REPOSITORY", "GITHUB_RUN_ID"} {
+		t.Setenv(key, "")
+	}
+
+	if labels := ciImageLabels(); len(labels) != 0 {
+		t.Errorf("ciImageLabels = %v, want none", labels)
+	}
+}
+
+func TestImageCreatedTimeFromSourceDateEpoch(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+
+	if created := imageCreatedTime(); created != "2023-11-14T22:13:20Z" {
+		t.Errorf("imageCreatedTime = %q", created)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	listPerPage    = 200
+	tabwriterWidth = 2
+)
+
+// deployment groups the resources sharing one DEPLOY_PREFIX.
+type deployment struct {
+	Prefix    string            `json:"prefix"`
+	Droplets  []deployedDroplet `json:"droplets"`
+	VPCs      []string          `json:"vpcs"`
+	Firewalls []string          `json:"firewalls"`
+	DNSNames  []string          `json:"dnsNames"`
+}
+
+type deployedDroplet struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	IP         string `json:"ip"`
+	Region     string `json:"region"`
+	N8NVersion string `json:"n8nVersion,omitempty"`
+}
+
+// runList prints every deployment this tool manages in the account, grouped
+// by DEPLOY_PREFIX.
+func runList(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the inventory as JSON")
+	versions := flags.Bool("versions", false, "connect to each droplet to read the deployed n8n version")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config := loadConfig()
+	client := newDOClient(&config)
+
+	deployments, err := listDeployments(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	if *versions {
+		stopAgent, err := prepareSSHKey(&config)
+		if err != nil {
+			return err
+		}
+		defer stopAgent()
+
+		readDeployedVersions(ctx, deployments, &config)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(deployments)
+	}
+
+	printDeployments(deployments)
+
+	return nil
+}
+
+// listDeployments finds droplets carrying the managed tag and attaches the
+// VPCs, firewalls and A records that belong to their prefix.
+func listDeployments(ctx context.Context, client *godo.Client) ([]*deployment, error) {
+	droplets, _, err := client.Droplets.ListByTag(ctx, managedTag, &godo.ListOptions{PerPage: listPerPage})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list droplets: %w", err)
+	}
+
+	byPrefix := map[string]*deployment{}
+	ipOwners := map[string]*deployment{}
+
+	for i := range droplets {
+		prefix := dropletPrefix(&droplets[i])
+
+		group, ok := byPrefix[prefix]
+		if !ok {
+			group = &deployment{Prefix: prefix}
+			byPrefix[prefix] = group
+		}
+
+		ip, _ := droplets[i].PublicIPv4()
+		if ip != "" {
+			ipOwners[ip] = group
+		}
+
+		group.Droplets = append(group.Droplets, deployedDroplet{
+			ID:     droplets[i].ID,
+			Name:   droplets[i].Name,
+			IP:     ip,
+			Region: droplets[i].Region.Slug,
+		})
+	}
+
+	if err := attachNetworking(ctx, client, byPrefix); err != nil {
+		return nil, err
+	}
+
+	if err := attachDNSNames(ctx, client, ipOwners); err != nil {
+		return nil, err
+	}
+
+	deployments := make([]*deployment, 0, len(byPrefix))
+	for _, group := range byPrefix {
+		deployments = append(deployments, group)
+	}
+
+	sort.Slice(deployments, func(i, j int) bool { return deployments[i].Prefix < deployments[j].Prefix })
+
+	return deployments, nil
+}
+
+// dropletPrefix recovers DEPLOY_PREFIX from the droplet's resource tag,
+// falling back to its name for droplets created before the tag existed.
+func dropletPrefix(droplet *godo.Droplet) string {
+	for _, tag := range droplet.Tags {
+		if tag != managedTag && tag != environmentTag && !isDeployTag(tag) {
+			return tag
+		}
+	}
+
+	return droplet.Name
+}
+
+func attachNetworking(ctx context.Context, client *godo.Client, byPrefix map[string]*deployment) error {
+	vpcs, _, err := client.VPCs.List(ctx, &godo.ListOptions{PerPage: listPerPage})
+	if err != nil {
+		return fmt.Errorf("failed to list VPCs: %w", err)
+	}
+
+	firewalls, _, err := client.Firewalls.List(ctx, &godo.ListOptions{PerPage: listPerPage})
+	if err != nil {
+		return fmt.Errorf("failed to list firewalls: %w", err)
+	}
+
+	for prefix, group := range byPrefix {
+		names := Config{deployPrefix: prefix}
+
+		for i := range vpcs {
+			if vpcs[i].Name == names.resourceName(resourceVPC) {
+				group.VPCs = append(group.VPCs, vpcs[i].Name)
+			}
+		}
+
+		for i := range firewalls {
+			if firewalls[i].Name == names.resourceName(resourceFirewall) {
+				group.Firewalls = append(group.Firewalls, firewalls[i].Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// attachDNSNames matches A records in every account domain against the
+// droplet IPs.
+func attachDNSNames(ctx context.Context, client *godo.Client, ipOwners map[string]*deployment) error {
+	domains, _, err := client.Domains.List(ctx, &godo.ListOptions{PerPage: listPerPage})
+	if err != nil {
+		return fmt.Errorf("failed to list domains: %w", err)
+	}
+
+	for _, domain := range domains {
+		records, _, err := client.Domains.Records(ctx, domain.Name, &godo.ListOptions{PerPage: listPerPage})
+		if err != nil {
+			return fmt.Errorf("failed to list records of %s: %w", domain.Name, err)
+		}
+
+		for _, record := range records {
+			group, ok := ipOwners[record.Data]
+			if record.Type != "A" || !ok {
+				continue
+			}
+
+			name := domain.Name
+			if record.Name != "@" {
+				name = record.Name + "." + domain.Name
+			}
+
+			if !slices.Contains(group.DNSNames, name) {
+				group.DNSNames = append(group.DNSNames, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// readDeployedVersions fills in the n8n version recorded by the last deploy.
+// Unreachable droplets are reported and left without a version.
+func readDeployedVersions(ctx context.Context, deployments []*deployment, config *Config) {
+	for _, group := range deployments {
+		for i := range group.Droplets {
+			droplet := &group.Droplets[i]
+
+			sshClient, err := connectSSH(ctx, droplet.IP, config.deploySSHUser, config)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping version of %s: %v\n", droplet.Name, err)
+
+				continue
+			}
+
+			state, err := readDeployState(sshClient)
+			sshClient.Close()
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping version of %s: %v\n", droplet.Name, err)
+
+				continue
+			}
+
+			if state != nil {
+				droplet.N8NVersion = state.N8NVersion
+			}
+		}
+	}
+}
+
+func printDeployments(deployments []*deployment) {
+	if len(deployments) == 0 {
+		fmt.Println("No managed deployments found")
+
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, tabwriterWidth, ' ', 0)
+	defer writer.Flush()
+
+	fmt.Fprintln(writer, "PREFIX\tDROPLET\tIP\tREGION\tN8N\tDNS\tVPC\tFIREWALL")
+
+	for _, group := range deployments {
+		for _, droplet := range group.Droplets {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				group.Prefix, droplet.Name, droplet.IP, droplet.Region, orDash(droplet.N8NVersion),
+				orDash(strings.Join(group.DNSNames, ",")), orDash(strings.Join(group.VPCs, ",")),
+				orDash(strings.Join(group.Firewalls, ",")))
+		}
+	}
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
@@ -3,11 +3,14 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,16 +25,14 @@ const (
 	defaultRegion           = "nyc1"
 	backupRetention         = 7 // days.
 	sshPort                 = 22
-	dnsRecordTTL            = 3600
 	healthCheckDelay        = 10 * time.Second
 	dropletStatusCheckDelay = 5 * time.Second
 	maxRetries              = 3
 	registryRetryDelay      = 5 * time.Second
 
 	// DNS configuration.
-	dnsCheckInterval    = 10 * time.Second
-	dnsTimeout          = 5 * time.Minute
-	dnsHealthCheckDelay = 30 * time.Second
+	dnsCheckInterval  = 10 * time.Second
+	defaultDNSTimeout = 5 * time.Minute
 
 	// Resource limits.
 	cpuLimit          = "2"
@@ -39,9 +40,21 @@ const (
 	cpuReservation    = "1"
 	memoryReservation = "1G"
 
+	// Graceful shutdown.
+	defaultDrainTimeout = 30 * time.Second
+	drainKillMargin     = 10 * time.Second
+
+	// defaultStartPeriod is how long failing healthchecks are not counted
+	// after n8n or Postgres starts.
+	defaultStartPeriod = 30 * time.Second
+
+	// Execution data pruning.
+	defaultExecutionsMaxAge   = 336 // hours (14 days).
+	defaultExecutionsMaxCount = 10000
+
 	// Magic numbers.
 	minDomainParts = 2
-	sshReadyDelay  = 30 * time.Second
+	sshKeysPerPage = 200
 
 	// File permissions.
 	sshDirPerm  = 0o700
@@ -49,7 +62,28 @@ const (
 
 	defaultGithubHome = "/home/runner"
 	sshKeyName        = "id_rsa"
+	knownHostsName    = "known_hosts"
 	sshDirName        = ".ssh"
+
+	// Host key checking modes.
+	hostKeyCheckingTOFU   = "tofu"
+	hostKeyCheckingStrict = "strict"
+	hostKeyCheckingOff    = "off"
+
+	// Deploy modes.
+	deployModeCompose     = "compose"
+	deployModeSwarm       = "swarm"
+	defaultComposeProject = "n8n"
+
+	dockerHubRegistry  = "docker.io"
+	defaultRegistryURL = "registry.digitalocean.com"
+
+	// Droplet bootstrap.
+	bootstrapSentinel    = "/opt/n8n/.bootstrapped"
+	bootstrapSkipMessage = "Already bootstrapped"
+
+	// commandRun is the default subcommand.
+	commandRun = "run"
 )
 
 var (
@@ -61,19 +95,33 @@ var (
 	ErrDomainNotFound      = errors.New("domain not found")
 	ErrDomainCreation      = errors.New("failed to create domain")
 	ErrSSHKeyNotFound      = errors.New("SSH key not found")
+	ErrAmbiguousSSHKey     = errors.New("SSH key name is ambiguous")
+	ErrSSHKeyMismatch      = errors.New("DO_SSH_KEY_NAME and DO_SSH_KEY_FINGERPRINT refer to different keys")
 	ErrDNSPropagation      = errors.New("timeout waiting for DNS propagation")
 	ErrRegistryEmpty       = errors.New("registry creation failed: no registry name returned")
 	ErrEmptyCredentials    = errors.New("empty registry credentials received")
 	ErrRegistryNotReady    = errors.New("registry not ready after maximum retries")
 	ErrInvalidSSHKeyFormat = errors.New("invalid SSH key format: key must begin with '-----BEGIN'")
 	ErrParseSSHAgentOutput = errors.New("failed to parse ssh-agent output")
+	ErrEnvVarParseBool     = errors.New("failed to parse environment variable as boolean")
+	ErrEnvVarParseDuration = errors.New("failed to parse environment variable as duration")
+	ErrInvalidConfig       = errors.New("invalid configuration")
+	ErrSwarmNotActive      = errors.New("docker swarm is not initialized")
+	ErrDropletNotFound     = errors.New("droplet not found")
+	ErrAmbiguousDroplet    = errors.New("several droplets share the name")
+	ErrDropletPlacement    = errors.New("existing droplet is outside the expected region or VPC")
+	ErrDropletStuck        = errors.New("droplet did not become active")
+	ErrVPCRegionMismatch   = errors.New("existing VPC is in another region")
 )
 
 type Config struct {
 	doToken        string
 	registryURL    string
-	dropletName    string
+	deployPrefix   string
 	sshFingerprint string
+	region         string
+	dropletSize    string
+	sshKeyName     string
 	domain         string
 	n8nVersion     string
 	slackWebhook   string
@@ -82,51 +130,379 @@ type Config struct {
 	basicAuthUser  string
 	basicAuthPass  string
 	sshKeyPath     string
+
+	n8nPath string
+
+	// n8nBind is where n8n's port is published: loopback, private or all
+	n8nBind string
+
+	deploySSHUser  string
+	sshBastionHost string
+	sshBastionUser string
+	knownHostsPath string
+	sshMaxOutput   int
+
+	// hostKeyChecking is tofu, strict or off
+	hostKeyChecking string
+	sshHardening    bool
+	dropletPowerOn  bool
+
+	cloudInitTimeout time.Duration
+
+	dropletHostname string
+	// dropletName is DROPLET_HOSTNAME when set explicitly; it then names
+	// the droplet instead of DEPLOY_PREFIX
+	dropletName string
+
+	volumeSizeGB int
+
+	outboundAllowed []string
+	firewallID      string
+	extraInbound    []string
+
+	cloudflare bool
+	// cloudflareRanges are fetched at runtime when cloudflare is set
+	cloudflareRanges []string
+
+	doProject   string
+	deployTags  bool
+	usageReport bool
+
+	backupBeforeDeploy  bool
+	backupSnapshot      bool
+	backupRetentionDays int
+	backupCron          string
+	spacesBucket        string
+	spacesRegion        string
+	spacesAccessKey     string
+	spacesSecretKey     string
+
+	postgresDefaultTuning bool
+	postgresSettings      []string
+
+	deployTimeout time.Duration
+
+	// deployLockTTL is how long a deploy lock outlives its last heartbeat
+	deployLockTTL time.Duration
+
+	// retryBudget caps the retries of a whole run, across all its steps
+	retryBudget         int
+	apiFailureThreshold int
+
+	// apiBreaker is shared by every DigitalOcean client of the run
+	apiBreaker *apiBreaker
+
+	// dryRun prints the planned changes instead of making them
+	dryRun bool
+
+	alertCPUThreshold    int
+	alertMemoryThreshold int
+	alertDiskThreshold   int
+	alertWindow          string
+	alertSlackChannel    string
+
+	dnsResolvers       []string
+	dnsQuorum          int
+	dnsResolverTimeout time.Duration
+	dnsTTL             int
+	dnsTimeout         time.Duration
+
+	dnsProvider        string
+	cloudflareAPIToken string
+	dnsIPv6            bool
+
+	generateEncryptionKey bool
+	generateBasicAuthPass bool
+	allowDefaultPassword  bool
+
+	// basicAuthPassGenerated and encryptionKeyGenerated are set while a
+	// generated secret has not been checked against the droplet yet
+	basicAuthPassGenerated bool
+	encryptionKeyGenerated bool
+
+	n8nUserFolder              string
+	enforceSettingsPermissions bool
+	n8nEnvFile                 string
+	// n8nEnv holds the KEY=VALUE entries read from n8nEnvFile
+	n8nEnv []string
+
+	executionsPrune    bool
+	executionsMaxAge   int
+	executionsMaxCount int
+
+	forceKeyChange bool
+	forceDeploy    bool
+	// deployImage pins the compose file to the pushed image; set once the
+	// build has pushed it, or by the deploy command
+	deployImage string
+
+	validateEphemeral bool
+	skipDNSWait       bool
+
+	buildCPULimit string
+	buildQuiet    bool
+	buildNoCache  bool
+
+	deployMode  string
+	manageDNS   bool
+	reservedIP  string
+	healthProbe string
+
+	registryCredentialAttempts int
+	registryCredentialTimeout  time.Duration
+
+	healthCheckTimeout  time.Duration
+	healthCheckInterval time.Duration
+	healthCheckRetries  int
+	healthWaitTimeout   time.Duration
+
+	drainTimeout time.Duration
+	startPeriod  time.Duration
+
+	n8nHealthcheckCommand  string
+	n8nHealthcheckInterval time.Duration
+	n8nHealthcheckTimeout  time.Duration
+	n8nHealthcheckRetries  int
+
+	caddyHealthChecks   bool
+	caddyHealthInterval time.Duration
+	caddyFailDuration   time.Duration
+	caddyTryDuration    time.Duration
+
+	caddyFlushInterval         string
+	caddyDialTimeout           time.Duration
+	caddyReadTimeout           time.Duration
+	caddyWriteTimeout          time.Duration
+	caddyResponseHeaderTimeout time.Duration
+
+	dockerVersion  string
+	composeVersion string
+	composeCLI     string
+	composeProject string
+
+	baseImage      string
+	dockerfile     string
+	buildContext   string
+	communityNodes []string
+	autoPruneTags  int
+
+	dockerHubUser   string
+	dockerHubToken  string
+	dockerHubMirror string
+
+	imageLabels map[string]string
+
+	generateSBOM bool
+	sbomDir      string
+
+	scanImage     bool
+	scanFailOn    string
+	scanAllowlist string
+
+	signImage        bool
+	verifySignature  bool
+	cosignKey        string
+	cosignPublicKey  string
+	cosignIdentity   string
+	cosignOIDCIssuer string
+}
+
+// commands maps subcommand names to their entry points. Running without a
+// subcommand executes the full provision, build and deploy pipeline.
+var commands = map[string]func(ctx context.Context, args []string) error{
+	commandRun:         runPipeline,
+	"exec":             runExec,
+	"dns-check":        runDNSCheck,
+	"restore-snapshot": runRestoreSnapshot,
+	"render":           runRender,
+	"list":             runList,
+	"migrate":          runMigrate,
+	"compliance-check": runComplianceCheck,
+	"restart":          runRestart,
+	"deploy":           runDeploy,
+	"history":          runHistory,
+	"build":            runBuild,
+	"usage":            runUsage,
+	"verify-backup":    runVerifyBackup,
+	"batch":            runBatch,
+	"export-terraform": runExportTerraform,
+	"force-unlock":     runForceUnlock,
+	"import":           runImport,
+}
+
+// exitCodeError makes the process exit with code instead of panicking,
+// printing err first when there is one.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
 }
 
 func main() {
 	ctx := context.Background()
 
+	name, args := commandRun, applyGlobalFlags(os.Args[1:])
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	command, ok := commands[name]
+	if !ok {
+		panic(fmt.Sprintf("unknown command %q", name))
+	}
+
+	if err := command(ctx, args); err != nil {
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			if exitErr.err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+
+			os.Exit(exitErr.code)
+		}
+
+		panic(err)
+	}
+}
+
+// runPipeline provisions the infrastructure, builds the image and deploys n8n.
+func runPipeline(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet(commandRun, flag.ExitOnError)
+	forceKeyChange := flags.Bool("force-key-change", false,
+		"deploy even if N8N_ENCRYPTION_KEY differs from the running instance (stored credentials become unreadable)")
+	force := flags.Bool("force", false, "redeploy even if the image and configuration are unchanged")
+	validateEphemeral := flags.Bool("validate-on-ephemeral", false,
+		"deploy to a temporary droplet first and only continue to production if it becomes healthy")
+	noWaitDNS := flags.Bool("no-wait-dns", false, "do not wait for the A record to propagate to DNS_RESOLVERS")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
 	// Load configuration
 	config := loadConfig()
+	config.forceKeyChange = *forceKeyChange
+	config.forceDeploy = *force
+	config.validateEphemeral = *validateEphemeral
+	config.skipDNSWait = *noWaitDNS
 
-	// Initialize DO client
-	doClient := godo.NewFromToken(config.doToken)
+	// Checked before any secret is generated, which would be a side effect
+	if config.dryRun {
+		return runDryRun(ctx, &config, nil)
+	}
 
-	// Create SSH directory and key file with proper permissions
-	sshPrivateKey := os.Getenv("DO_SSH_PRIVATE_KEY")
-	if sshPrivateKey == "" {
-		panic("DO_SSH_PRIVATE_KEY environment variable is required")
+	if err := ensureEncryptionKey(&config); err != nil {
+		return err
+	}
+
+	if err := ensureBasicAuthPassword(&config); err != nil {
+		return err
+	}
+
+	if err := loadN8NEnvFile(&config); err != nil {
+		return err
 	}
 
-	if err := setupSSHKey(config.sshKeyPath, sshPrivateKey); err != nil {
-		panic(fmt.Sprintf("failed to setup SSH key: %v", err))
+	if err := validateConfig(&config); err != nil {
+		return err
+	}
+
+	ctx = withRetryBudget(ctx, config.retryBudget)
+
+	return runWithDeadline(ctx, config.deployTimeout, func(ctx context.Context, steps *stepTracker) error {
+		return runPipelineSteps(ctx, &config, steps)
+	})
+}
+
+// runPipelineSteps provisions, builds and deploys, reporting each step to
+// steps so a timeout can name where it fired.
+func runPipelineSteps(ctx context.Context, config *Config, steps *stepTracker) error {
+	// Initialize DO client
+	doClient := newDOClient(config)
+
+	stopAgent, err := prepareDeploySteps(ctx, config, steps)
+	if err != nil {
+		return err
 	}
+	defer stopAgent()
 
 	// Initialize Dagger client
-	client, err := dagger.Connect(ctx, dagger.WithLogOutput(os.Stdout))
+	steps.start("connecting to Dagger")
+
+	client, err := connectDagger(ctx, config)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer client.Close()
 
+	var image *publishedImage
+
+	// The image is built up front so the ephemeral droplet and production
+	// run exactly the same one
+	if config.validateEphemeral {
+		steps.start("building and pushing image")
+
+		if image, err = buildAndPushImage(ctx, client, config); err != nil {
+			return err
+		}
+
+		config.deployImage = image.pinnedRef()
+
+		steps.start("validating on ephemeral droplet")
+
+		if err := validateOnEphemeral(ctx, doClient, config, image); err != nil {
+			return err
+		}
+	}
+
 	// Setup infrastructure
-	dropletIP, err := setupInfrastructure(ctx, doClient, &config)
+	steps.start("provisioning infrastructure")
+
+	dropletIP, err := setupInfrastructure(ctx, doClient, config)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// Build and push N8N image
-	if err := buildAndPushImage(ctx, client, &config); err != nil {
-		panic(err)
+	if image == nil {
+		steps.start("building and pushing image")
+
+		if image, err = buildAndPushImage(ctx, client, config); err != nil {
+			return err
+		}
+
+		config.deployImage = image.pinnedRef()
 	}
 
-	// Configure and deploy N8N
-	if err := deployN8N(dropletIP, &config); err != nil {
-		panic(err)
+	return finishDeploySteps(ctx, doClient, config, dropletIP, image, steps)
+}
+
+// prepareSSHKey installs DO_SSH_PRIVATE_KEY at the configured key path and
+// loads it into an ssh-agent. Callers must defer the returned cleanup.
+func prepareSSHKey(config *Config) (func(), error) {
+	// Create SSH directory and key file with proper permissions
+	sshPrivateKey := os.Getenv("DO_SSH_PRIVATE_KEY")
+	if sshPrivateKey == "" {
+		return nil, fmt.Errorf("%w: DO_SSH_PRIVATE_KEY", ErrEnvVarNotSet)
 	}
 
-	fmt.Printf("N8N deployment completed successfully!\nAccess your instance at: https://%s\n", config.domain)
+	cleanup, err := setupSSHKey(config.sshKeyPath, sshPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup SSH key: %w", err)
+	}
+
+	return cleanup, nil
 }
 
 func loadConfig() Config {
@@ -138,645 +514,1580 @@ func loadConfig() Config {
 
 	defaultSSHPath := filepath.Join(homeDir, sshDirName, sshKeyName)
 
-	return Config{
+	config := Config{
 		doToken:        requireEnv("DIGITALOCEAN_ACCESS_TOKEN"),
-		registryURL:    "registry.digitalocean.com",
-		dropletName:    requireEnvOrDefault("DROPLET_NAME", "n8n-production"),
-		sshFingerprint: requireEnv("DO_SSH_KEY_FINGERPRINT"),
-		domain:         requireEnv("N8N_DOMAIN"),
+		registryURL:    strings.TrimSuffix(requireEnvOrDefault("REGISTRY_URL", defaultRegistryURL), "/"),
+		deployPrefix:   requireEnvOrDefault("DEPLOY_PREFIX", requireEnvOrDefault("DROPLET_NAME", defaultDeployPrefix)),
+		sshFingerprint: os.Getenv("DO_SSH_KEY_FINGERPRINT"),
+		region:         requireEnvOrDefault("DO_REGION", requireEnvOrDefault("DROPLET_REGION", defaultRegion)),
+		dropletSize:    requireEnvOrDefault("DROPLET_SIZE", defaultDropletSize),
+		sshKeyName:     os.Getenv("DO_SSH_KEY_NAME"),
+		domain:         normalizeDomain(requireEnv("N8N_DOMAIN")),
+		n8nPath:        normalizeN8NPath(os.Getenv("N8N_PATH")),
+		n8nBind:        requireEnvOrDefault("N8N_BIND", n8nBindLoopback),
 		n8nVersion:     requireEnvOrDefault("N8N_VERSION", "latest"),
 		slackWebhook:   os.Getenv("SLACK_WEBHOOK_URL"),
 		alertEmail:     os.Getenv("ALERT_EMAIL"),
-		encryptionKey:  requireEnv("N8N_ENCRYPTION_KEY"),
+		encryptionKey:  os.Getenv("N8N_ENCRYPTION_KEY"),
 		basicAuthUser:  requireEnvOrDefault("N8N_BASIC_AUTH_USER", "admin"),
-		basicAuthPass:  requireEnvOrDefault("N8N_BASIC_AUTH_PASS", "n8n-admin"),
+		basicAuthPass:  requireEnvOrDefault("N8N_BASIC_AUTH_PASS", defaultBasicAuthPass),
 		sshKeyPath:     requireEnvOrDefault("SSH_KEY_PATH", defaultSSHPath),
-	}
-}
 
-func setupInfrastructure(ctx context.Context, client *godo.Client, config *Config) (string, error) {
-	// Ensure SSH key exists
-	sshKeyID, err := ensureSSHKey(ctx, client, config)
-	if err != nil {
-		return "", fmt.Errorf("failed to ensure SSH key: %w", err)
-	}
+		deploySSHUser:  requireEnvOrDefault("DEPLOY_SSH_USER", rootUser),
+		sshBastionHost: os.Getenv("SSH_BASTION_HOST"),
+		sshBastionUser: os.Getenv("SSH_BASTION_USER"),
+		knownHostsPath: requireEnvOrDefault("SSH_KNOWN_HOSTS", filepath.Join(homeDir, sshDirName, knownHostsName)),
+		sshMaxOutput:   requireEnvIntOrDefault("SSH_MAX_OUTPUT_BYTES", ssh.DefaultMaxOutput),
+
+		hostKeyChecking: requireEnvOrDefault("SSH_HOST_KEY_CHECKING", hostKeyCheckingTOFU),
+		sshHardening:    requireEnvBoolOrDefault("SSH_HARDENING", true),
+		dropletPowerOn:  requireEnvBoolOrDefault("DROPLET_AUTO_POWER_ON", true),
+
+		cloudInitTimeout: requireEnvDurationOrDefault("CLOUD_INIT_TIMEOUT", defaultCloudInitTimeout),
+
+		alertCPUThreshold:    requireEnvIntOrDefault("ALERT_CPU_THRESHOLD", defaultAlertCPUThreshold),
+		alertMemoryThreshold: requireEnvIntOrDefault("ALERT_MEMORY_THRESHOLD", defaultAlertMemoryThreshold),
+		alertDiskThreshold:   requireEnvIntOrDefault("ALERT_DISK_THRESHOLD", defaultAlertDiskThreshold),
+		alertWindow:          requireEnvOrDefault("ALERT_WINDOW", defaultAlertWindow),
+		alertSlackChannel:    requireEnvOrDefault("ALERT_SLACK_CHANNEL", defaultAlertSlackChannel),
+
+		dnsResolvers:       splitList(requireEnvOrDefault("DNS_RESOLVERS", defaultDNSResolvers)),
+		dnsQuorum:          requireEnvIntOrDefault("DNS_QUORUM", defaultDNSQuorum),
+		dnsResolverTimeout: requireEnvDurationOrDefault("DNS_RESOLVER_TIMEOUT", defaultDNSResolverTimeout),
+		dnsTTL:             requireEnvIntOrDefault("DNS_TTL", defaultDNSTTL),
+		dnsTimeout:         requireEnvDurationOrDefault("DNS_WAIT_TIMEOUT", defaultDNSTimeout),
+
+		dnsProvider:        requireEnvOrDefault("DNS_PROVIDER", dnsProviderDigitalOcean),
+		cloudflareAPIToken: os.Getenv("CLOUDFLARE_API_TOKEN"),
+		dnsIPv6:            requireEnvBoolOrDefault("DNS_IPV6", true),
+
+		backupBeforeDeploy:  requireEnvBoolOrDefault("BACKUP_BEFORE_DEPLOY", true),
+		backupSnapshot:      requireEnvBoolOrDefault("BACKUP_SNAPSHOT", false),
+		backupRetentionDays: requireEnvIntOrDefault("BACKUP_RETENTION_DAYS", backupRetention),
+		backupCron:          strings.TrimSpace(os.Getenv("BACKUP_CRON")),
+		spacesBucket:        os.Getenv("SPACES_BUCKET"),
+		spacesRegion:        requireEnvOrDefault("SPACES_REGION", defaultSpacesRegion),
+		spacesAccessKey:     os.Getenv("SPACES_ACCESS_KEY_ID"),
+		spacesSecretKey:     os.Getenv("SPACES_SECRET_ACCESS_KEY"),
+
+		postgresDefaultTuning: requireEnvBoolOrDefault("POSTGRES_DEFAULT_TUNING", true),
+		postgresSettings:      splitList(os.Getenv("POSTGRES_SETTINGS")),
+
+		deployTimeout: requireEnvDurationOrDefault("DEPLOY_TIMEOUT", defaultDeployTimeout),
+		deployLockTTL: requireEnvDurationOrDefault("DEPLOY_LOCK_TTL", defaultDeployLockTTL),
+
+		retryBudget:         requireEnvIntOrDefault("RETRY_BUDGET", defaultRetryBudget),
+		apiFailureThreshold: requireEnvIntOrDefault("DO_API_FAILURE_THRESHOLD", defaultAPIFailureThreshold),
+
+		dryRun: requireEnvBoolOrDefault("DRY_RUN", false),
 
-	// Create VPC if not exists
-	vpc, err := createVPC(ctx, client, config)
-	if err != nil {
-		return "", err
-	}
+		volumeSizeGB: requireEnvIntOrDefault("VOLUME_SIZE_GB", 0),
 
-	// Create firewall
-	err = createFirewall(ctx, client, config)
-	if err != nil {
-		return "", err
-	}
+		outboundAllowed: splitList(requireEnvOrDefault("OUTBOUND_ALLOWED", outboundPresetAll)),
+		firewallID:      os.Getenv("FIREWALL_ID"),
+		extraInbound:    splitList(os.Getenv("EXTRA_INBOUND_PORTS")),
+		cloudflare:      requireEnvBoolOrDefault("CLOUDFLARE", false),
 
-	// Create registry if not exists
-	err = createRegistry(ctx, client)
-	if err != nil {
-		return "", err
+		doProject:   os.Getenv("DO_PROJECT"),
+		deployTags:  requireEnvBoolOrDefault("DEPLOY_TAGS", false),
+		usageReport: requireEnvBoolOrDefault("USAGE_REPORT", true),
+
+		generateEncryptionKey: requireEnvBoolOrDefault("GENERATE_ENCRYPTION_KEY", false),
+		generateBasicAuthPass: requireEnvBoolOrDefault("GENERATE_BASIC_AUTH_PASS", false),
+		allowDefaultPassword:  requireEnvBoolOrDefault("ALLOW_DEFAULT_PASSWORD", false),
+
+		n8nUserFolder:              requireEnvOrDefault("N8N_USER_FOLDER", defaultN8NUserFolder),
+		enforceSettingsPermissions: requireEnvBoolOrDefault("N8N_ENFORCE_SETTINGS_FILE_PERMISSIONS", true),
+		n8nEnvFile:                 os.Getenv("N8N_ENV_FILE"),
+
+		executionsPrune:    requireEnvBoolOrDefault("EXECUTIONS_DATA_PRUNE", true),
+		executionsMaxAge:   requireEnvIntOrDefault("EXECUTIONS_DATA_MAX_AGE", defaultExecutionsMaxAge),
+		executionsMaxCount: requireEnvIntOrDefault("EXECUTIONS_DATA_MAX_COUNT", defaultExecutionsMaxCount),
+
+		buildCPULimit: os.Getenv("BUILD_CPU_LIMIT"),
+		buildQuiet:    requireEnvBoolOrDefault("BUILD_QUIET", false),
+		buildNoCache:  requireEnvBoolOrDefault("BUILD_NO_CACHE", false),
+
+		deployMode: requireEnvOrDefault("DEPLOY_MODE", deployModeCompose),
+		manageDNS:  requireEnvBoolOrDefault("MANAGE_DNS", true),
+		reservedIP: os.Getenv("RESERVED_IP"),
+
+		registryCredentialAttempts: requireEnvIntOrDefault("REGISTRY_CREDENTIALS_ATTEMPTS",
+			defaultRegistryCredentialAttempts),
+		registryCredentialTimeout: requireEnvDurationOrDefault("REGISTRY_CREDENTIALS_TIMEOUT",
+			defaultRegistryCredentialTimeout),
+
+		healthProbe:         requireEnvOrDefault("HEALTH_CHECK_PROBE", healthProbeHealthz),
+		healthCheckTimeout:  requireEnvDurationOrDefault("HEALTH_CHECK_TIMEOUT", defaultHealthCheckTimeout),
+		healthCheckInterval: requireEnvDurationOrDefault("HEALTH_CHECK_INTERVAL", defaultHealthCheckInterval),
+		healthCheckRetries:  requireEnvIntOrDefault("HEALTH_CHECK_RETRIES", 0),
+		healthWaitTimeout:   requireEnvDurationOrDefault("HEALTH_WAIT_TIMEOUT", defaultHealthWaitTimeout),
+
+		drainTimeout: requireEnvDurationOrDefault("DRAIN_TIMEOUT", defaultDrainTimeout),
+		startPeriod:  requireEnvDurationOrDefault("STARTUP_GRACE_PERIOD", defaultStartPeriod),
+
+		n8nHealthcheckCommand: requireEnvOrDefault("N8N_HEALTHCHECK_COMMAND", defaultN8NHealthcheckCommand),
+		n8nHealthcheckInterval: requireEnvDurationOrDefault("N8N_HEALTHCHECK_INTERVAL",
+			defaultN8NHealthcheckInterval),
+		n8nHealthcheckTimeout: requireEnvDurationOrDefault("N8N_HEALTHCHECK_TIMEOUT", defaultN8NHealthcheckTimeout),
+		n8nHealthcheckRetries: requireEnvIntOrDefault("N8N_HEALTHCHECK_RETRIES", defaultN8NHealthcheckRetries),
+
+		caddyHealthChecks:   requireEnvBoolOrDefault("CADDY_HEALTH_CHECKS", true),
+		caddyHealthInterval: requireEnvDurationOrDefault("CADDY_HEALTH_INTERVAL", defaultCaddyHealthInterval),
+		caddyFailDuration:   requireEnvDurationOrDefault("CADDY_FAIL_DURATION", defaultCaddyFailDuration),
+		caddyTryDuration:    requireEnvDurationOrDefault("CADDY_TRY_DURATION", defaultCaddyTryDuration),
+
+		caddyFlushInterval:         requireEnvOrDefault("CADDY_FLUSH_INTERVAL", defaultCaddyFlushInterval),
+		caddyDialTimeout:           requireEnvDurationOrDefault("CADDY_DIAL_TIMEOUT", 0),
+		caddyReadTimeout:           requireEnvDurationOrDefault("CADDY_READ_TIMEOUT", 0),
+		caddyWriteTimeout:          requireEnvDurationOrDefault("CADDY_WRITE_TIMEOUT", 0),
+		caddyResponseHeaderTimeout: requireEnvDurationOrDefault("CADDY_RESPONSE_HEADER_TIMEOUT", 0),
+
+		dockerVersion:  os.Getenv("DOCKER_VERSION"),
+		composeVersion: os.Getenv("COMPOSE_VERSION"),
+		composeCLI:     requireEnvOrDefault("COMPOSE_CLI", composeCLIAuto),
+		composeProject: requireEnvOrDefault("COMPOSE_PROJECT_NAME", defaultComposeProject),
+
+		baseImage:    os.Getenv("N8N_BASE_IMAGE"),
+		dockerfile:   os.Getenv("N8N_DOCKERFILE"),
+		buildContext: os.Getenv("N8N_BUILD_CONTEXT"),
+
+		communityNodes: splitList(os.Getenv("N8N_COMMUNITY_NODES")),
+		autoPruneTags:  requireEnvIntOrDefault("AUTO_PRUNE_TAGS", 0),
+
+		dockerHubUser:   os.Getenv("DOCKERHUB_USER"),
+		dockerHubToken:  os.Getenv("DOCKERHUB_TOKEN"),
+		dockerHubMirror: os.Getenv("DOCKERHUB_MIRROR"),
+
+		imageLabels: requireEnvLabels("IMAGE_LABELS"),
+
+		generateSBOM: requireEnvBoolOrDefault("GENERATE_SBOM", false),
+		sbomDir:      requireEnvOrDefault("SBOM_DIR", defaultSBOMDir),
+
+		scanImage:     requireEnvBoolOrDefault("SCAN_IMAGE", false),
+		scanFailOn:    strings.ToUpper(requireEnvOrDefault("SCAN_FAIL_ON", defaultScanFailOn)),
+		scanAllowlist: os.Getenv("SCAN_ALLOWLIST"),
+
+		signImage:        requireEnvBoolOrDefault("SIGN_IMAGE", false),
+		verifySignature:  requireEnvBoolOrDefault("VERIFY_SIGNATURE", false),
+		cosignKey:        os.Getenv("COSIGN_KEY"),
+		cosignPublicKey:  os.Getenv("COSIGN_PUBLIC_KEY"),
+		cosignIdentity:   os.Getenv("COSIGN_IDENTITY"),
+		cosignOIDCIssuer: os.Getenv("COSIGN_OIDC_ISSUER"),
 	}
 
-	// Ensure domain exists
-	err = ensureDomain(ctx, client, config)
-	if err != nil {
-		return "", fmt.Errorf("failed to ensure domain: %w", err)
+	// The fingerprint is only optional when the key is selected by name
+	if config.sshKeyName == "" {
+		config.sshFingerprint = requireEnv("DO_SSH_KEY_FINGERPRINT")
 	}
 
-	// Create or get droplet
-	droplet, err := createOrGetDroplet(ctx, client, config, vpc.ID, sshKeyID)
-	if err != nil {
-		return "", err
+	config.dropletHostname = requireEnvOrDefault("DROPLET_HOSTNAME", config.domain)
+	if os.Getenv("DROPLET_HOSTNAME") != "" {
+		config.dropletName = config.dropletHostname
 	}
+	config.apiBreaker = &apiBreaker{threshold: config.apiFailureThreshold}
 
-	// Configure DNS with health check
-	err = configureAndVerifyDNS(ctx, client, config, droplet)
-	if err != nil {
-		return "", err
+	// Only derive the project from an explicit prefix; existing installs keep
+	// the "n8n" project so their volumes are not orphaned.
+	if os.Getenv("DEPLOY_PREFIX") != "" && os.Getenv("COMPOSE_PROJECT_NAME") == "" {
+		config.composeProject = config.resourceName(resourceProject)
 	}
 
-	return droplet.Networks.V4[0].IPAddress, nil
+	return config
 }
 
-func ensureSSHKey(ctx context.Context, client *godo.Client, config *Config) (int, error) {
-	// First try to find existing key by fingerprint
-	keys, _, err := client.Keys.List(ctx, &godo.ListOptions{})
-	if err != nil {
-		return 0, fmt.Errorf("failed to list SSH keys: %w", err)
+func validateConfig(config *Config) error {
+	if err := validateEncryptionKey(config.encryptionKey); err != nil {
+		return err
 	}
 
-	for _, key := range keys {
-		if key.Fingerprint == config.sshFingerprint {
-			return key.ID, nil
-		}
+	if config.executionsMaxAge <= 0 {
+		return fmt.Errorf("%w: EXECUTIONS_DATA_MAX_AGE must be a positive number of hours, got %d",
+			ErrInvalidConfig, config.executionsMaxAge)
 	}
 
-	// If key not found, try to read from file and create it
-	keyBytes, err := os.ReadFile(os.ExpandEnv(config.sshKeyPath))
-	if err != nil {
-		return 0, fmt.Errorf("failed to read SSH key file: %w", err)
+	// A max count of 0 disables count-based pruning in n8n.
+	if config.executionsMaxCount < 0 {
+		return fmt.Errorf("%w: EXECUTIONS_DATA_MAX_COUNT must not be negative, got %d",
+			ErrInvalidConfig, config.executionsMaxCount)
 	}
 
-	createRequest := &godo.KeyCreateRequest{
-		Name:      fmt.Sprintf("%s-key", config.dropletName),
-		PublicKey: string(keyBytes),
+	if err := validateCPULimit(config.buildCPULimit); err != nil {
+		return err
 	}
 
-	key, _, err := client.Keys.Create(ctx, createRequest)
-	if err != nil {
-		return 0, fmt.Errorf("failed to create SSH key: %w", err)
+	if config.deployMode != deployModeCompose && config.deployMode != deployModeSwarm {
+		return fmt.Errorf("%w: DEPLOY_MODE must be %q or %q, got %q",
+			ErrInvalidConfig, deployModeCompose, deployModeSwarm, config.deployMode)
 	}
 
-	return key.ID, nil
-}
-
-func getDomainParts(domain string) (rootDomain string, parts []string) {
-	parts = strings.Split(domain, ".")
-	rootDomain = domain
+	if err := validateDeployTimeout(config.deployTimeout); err != nil {
+		return err
+	}
 
-	if len(parts) > minDomainParts {
-		rootDomain = strings.Join(parts[len(parts)-minDomainParts:], ".")
+	if err := validateBackupConfig(config); err != nil {
+		return err
 	}
 
-	return rootDomain, parts
-}
+	if err := validateDomain(config.domain); err != nil {
+		return err
+	}
 
-func ensureDomain(ctx context.Context, client *godo.Client, config *Config) error {
-	rootDomain, _ := getDomainParts(config.domain)
+	if err := validateN8NPath(config.n8nPath); err != nil {
+		return err
+	}
 
-	// Check if domain exists
-	_, resp, err := client.Domains.Get(ctx, rootDomain)
-	if err != nil {
-		if resp != nil && resp.StatusCode == 404 {
-			// Domain doesn't exist, create it
-			_, _, createErr := client.Domains.Create(ctx, &godo.DomainCreateRequest{
-				Name: rootDomain,
-			})
-
-			if createErr != nil {
-				return fmt.Errorf("%w: %s", ErrDomainCreation, createErr)
-			}
+	if err := validateN8NBind(config); err != nil {
+		return err
+	}
 
-			return nil
-		}
+	if err := validateDeployUser(config.deploySSHUser); err != nil {
+		return err
+	}
 
-		return fmt.Errorf("failed to check domain: %w", err)
+	if !slices.Contains([]string{hostKeyCheckingTOFU, hostKeyCheckingStrict, hostKeyCheckingOff},
+		config.hostKeyChecking) {
+		return fmt.Errorf("%w: SSH_HOST_KEY_CHECKING must be tofu, strict or off, got %q",
+			ErrInvalidConfig, config.hostKeyChecking)
 	}
 
-	return nil
-}
+	if config.sshMaxOutput < 1 {
+		return fmt.Errorf("%w: SSH_MAX_OUTPUT_BYTES must be at least 1, got %d", ErrInvalidConfig, config.sshMaxOutput)
+	}
 
-func sanitizeRecordName(name string) string {
-	// If name is empty or root domain, return @
-	if name == "" || name == "@" {
-		return "@"
+	if err := validateHostname("DROPLET_HOSTNAME", config.dropletHostname, 1); err != nil {
+		return err
 	}
 
-	// Replace invalid characters with -
-	invalidChars := regexp.MustCompile(`[^a-zA-Z0-9._-]`)
-	sanitized := invalidChars.ReplaceAllString(name, "-")
+	if err := validateVolumeConfig(config); err != nil {
+		return err
+	}
 
-	return sanitized
-}
+	if _, err := outboundRules(config.outboundAllowed); err != nil {
+		return err
+	}
 
-func configureAndVerifyDNS(ctx context.Context, client *godo.Client, config *Config, droplet *godo.Droplet) error {
-	recordName := "@"
-	rootDomain := config.domain
-	parts := strings.Split(config.domain, ".")
+	if _, err := inboundRules(config.extraInbound); err != nil {
+		return err
+	}
 
-	if len(parts) > minDomainParts {
-		recordName = sanitizeRecordName(parts[0])
-		rootDomain = strings.Join(parts[len(parts)-minDomainParts:], ".")
+	if err := validateReservedIP(config); err != nil {
+		return err
 	}
 
-	// Create or update A record
-	createRequest := &godo.DomainRecordEditRequest{
-		Type: "A",
-		Name: recordName,
-		Data: droplet.Networks.V4[0].IPAddress,
-		TTL:  dnsRecordTTL,
+	if err := validateRegion(config); err != nil {
+		return err
 	}
 
-	_, _, err := client.Domains.CreateRecord(ctx, rootDomain, createRequest)
-	if err != nil {
-		return fmt.Errorf("failed to create DNS record: %w", err)
+	if _, err := postgresSettings(config); err != nil {
+		return err
 	}
 
-	// Wait for DNS propagation
-	return waitForDNSPropagation(ctx)
-}
+	if config.retryBudget < 0 {
+		return fmt.Errorf("%w: RETRY_BUDGET must not be negative, got %d", ErrInvalidConfig, config.retryBudget)
+	}
 
-func waitForDNSPropagation(ctx context.Context) error {
-	ticker := time.NewTicker(dnsCheckInterval)
-	defer ticker.Stop()
+	if config.apiFailureThreshold < 1 {
+		return fmt.Errorf("%w: DO_API_FAILURE_THRESHOLD must be at least 1, got %d", ErrInvalidConfig,
+			config.apiFailureThreshold)
+	}
 
-	timeout := time.After(dnsTimeout)
+	if config.deployLockTTL < time.Minute {
+		return fmt.Errorf("%w: DEPLOY_LOCK_TTL must be at least 1m, got %s", ErrInvalidConfig, config.deployLockTTL)
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-timeout:
-			return ErrDNSPropagation
-		case <-ticker.C:
-			// Implement DNS lookup here to verify propagation
-			// For now we'll just wait a reasonable time
-			time.Sleep(dnsHealthCheckDelay)
-			return nil
-		}
+	if config.cloudInitTimeout < time.Second {
+		return fmt.Errorf("%w: CLOUD_INIT_TIMEOUT must be at least 1s, got %s", ErrInvalidConfig, config.cloudInitTimeout)
 	}
-}
 
-func createVPC(ctx context.Context, client *godo.Client, config *Config) (*godo.VPC, error) {
-	vpcs, _, err := client.VPCs.List(ctx, &godo.ListOptions{})
-	if err != nil {
-		return nil, err
+	if err := validateRegistryCredentialConfig(config); err != nil {
+		return err
 	}
 
-	vpcName := fmt.Sprintf("%s-vpc", config.dropletName)
+	if err := validateRegistryURL(config.registryURL); err != nil {
+		return err
+	}
 
-	for i := range vpcs {
-		if vpcs[i].Name == vpcName {
-			existingVPC, _, getErr := client.VPCs.Get(ctx, vpcs[i].ID)
-			if getErr != nil {
-				return nil, getErr
-			}
+	if err := validateScanConfig(config); err != nil {
+		return err
+	}
 
-			return existingVPC, nil
-		}
+	if err := validateSigningConfig(config); err != nil {
+		return err
 	}
 
-	createRequest := &godo.VPCCreateRequest{
-		Name:        vpcName,
-		RegionSlug:  defaultRegion,
-		IPRange:     "192.168.32.0/24",
-		Description: "VPC for n8n deployment",
+	if err := validateDNSCheckConfig(config); err != nil {
+		return err
 	}
 
-	vpc, _, err := client.VPCs.Create(ctx, createRequest)
-	if err != nil {
-		return nil, err
+	if err := validateDNSProvider(config); err != nil {
+		return err
 	}
 
-	return vpc, nil
-}
+	if err := validateAlertConfig(config); err != nil {
+		return err
+	}
 
-func createFirewall(ctx context.Context, client *godo.Client, config *Config) error {
-	firewallName := fmt.Sprintf("%s-firewall", config.dropletName)
+	if err := validateDeployPrefix(config.deployPrefix); err != nil {
+		return err
+	}
 
-	// Check if firewall already exists
-	firewalls, _, err := client.Firewalls.List(ctx, &godo.ListOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to list firewalls: %w", err)
+	if err := validateComposeProject(config.composeProject); err != nil {
+		return err
 	}
 
-	for i := range firewalls {
-		if firewalls[i].Name == firewallName {
-			// Firewall exists, update it
-			updateRequest := &godo.FirewallRequest{
-				Name: firewallName,
-				InboundRules: []godo.InboundRule{
-					{
-						Protocol:  "tcp",
-						PortRange: "22",
-						Sources: &godo.Sources{
-							Addresses: []string{"0.0.0.0/0"},
-						},
-					},
-					{
-						Protocol:  "tcp",
-						PortRange: "80",
-						Sources: &godo.Sources{
-							Addresses: []string{"0.0.0.0/0"},
-						},
-					},
-					{
-						Protocol:  "tcp",
-						PortRange: "443",
-						Sources: &godo.Sources{
-							Addresses: []string{"0.0.0.0/0"},
-						},
-					},
-				},
-				OutboundRules: []godo.OutboundRule{
-					{
-						Protocol:  "tcp",
-						PortRange: "1-65535",
-						Destinations: &godo.Destinations{
-							Addresses: []string{"0.0.0.0/0"},
-						},
-					},
-				},
-			}
+	if err := validateImageSource(config); err != nil {
+		return err
+	}
 
-			_, _, err = client.Firewalls.Update(ctx, firewalls[i].ID, updateRequest)
+	if err := validateCommunityNodes(config.communityNodes); err != nil {
+		return err
+	}
+
+	if err := validateUserFolder(config.n8nUserFolder); err != nil {
+		return err
+	}
+
+	if err := validateN8NEnv(config); err != nil {
+		return err
+	}
+
+	if err := validateHealthCheckConfig(config); err != nil {
+		return err
+	}
+
+	if config.drainTimeout < time.Second {
+		return fmt.Errorf("%w: DRAIN_TIMEOUT must be at least 1s, got %s", ErrInvalidConfig, config.drainTimeout)
+	}
+
+	if config.startPeriod < 0 {
+		return fmt.Errorf("%w: STARTUP_GRACE_PERIOD must not be negative, got %s", ErrInvalidConfig, config.startPeriod)
+	}
+
+	if err := validateN8NHealthcheck(config); err != nil {
+		return err
+	}
+
+	if err := validateCaddyConfig(config); err != nil {
+		return err
+	}
+
+	if err := validateDockerVersions(config); err != nil {
+		return err
+	}
+
+	if config.autoPruneTags < 0 {
+		return fmt.Errorf("%w: AUTO_PRUNE_TAGS must not be negative, got %d", ErrInvalidConfig, config.autoPruneTags)
+	}
+
+	return nil
+}
+
+// provisioned is what provisionInfrastructure settled on.
+type provisioned struct {
+	droplet *godo.Droplet
+	// hostPublicKey is the host key seeded into a droplet this run created,
+	// "" for an existing one.
+	hostPublicKey string
+	// dnsTargets are the addresses N8N_DOMAIN should resolve to, and
+	// dnsChanged whether a record had to be changed to get there.
+	dnsTargets []dnsTarget
+	dnsChanged bool
+}
+
+func setupInfrastructure(ctx context.Context, client *godo.Client, config *Config) (string, error) {
+	infra, err := provisionInfrastructure(ctx, client, newDNSProvider(client, config), config)
+	if err != nil {
+		return "", err
+	}
+
+	dropletIP, err := infra.droplet.PublicIPv4()
+	if err != nil {
+		return "", fmt.Errorf("failed to get droplet IP: %w", err)
+	}
+
+	if err := prepareDroplet(ctx, config, infra.droplet, infra.hostPublicKey); err != nil {
+		return "", err
+	}
+
+	if config.sshHardening {
+		if err := hardenSSH(ctx, dropletIP, config); err != nil {
+			return "", err
+		}
+	}
+
+	if !config.manageDNS {
+		fmt.Printf("DNS management disabled (MANAGE_DNS=false): point the records of %s at %s\n",
+			config.domain, describeTargets(infra.dnsTargets))
+
+		return dropletIP, nil
+	}
+
+	if err := waitForDNSChange(ctx, config, infra.dnsTargets, infra.dnsChanged); err != nil {
+		return "", err
+	}
+
+	return dropletIP, nil
+}
+
+// provisionInfrastructure ensures everything DigitalOcean and the DNS
+// provider hold for the deployment, without connecting to the droplet. A
+// dry run passes it a planning client and provider that record the changes
+// instead of making them.
+func provisionInfrastructure(ctx context.Context, client *godo.Client, dns DNSProvider, config *Config,
+) (*provisioned, error) {
+	if err := verifyRegionAndSize(ctx, client, config); err != nil {
+		return nil, err
+	}
+
+	// Ensure SSH key exists
+	sshKeyID, err := ensureSSHKey(ctx, client, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure SSH key: %w", err)
+	}
+
+	// Create VPC if not exists
+	vpc, err := createVPC(ctx, client, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create firewall
+	err = createFirewall(ctx, client, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create registry if not exists
+	err = createRegistry(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure domain exists
+	if config.manageDNS {
+		rootDomain, _ := getDomainParts(config.domain)
+
+		err = dns.EnsureZone(ctx, rootDomain)
+		if err != nil {
+			return nil, fmt.Errorf("failed to ensure domain: %w", err)
+		}
+	}
+
+	// Create or get droplet
+	droplet, hostPublicKey, err := createOrGetDroplet(ctx, client, config, vpc.ID, sshKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := reconcileFirewall(ctx, client, config, droplet); err != nil {
+		return nil, err
+	}
+
+	if err := ensureAlertPolicies(ctx, client, config, droplet); err != nil {
+		return nil, err
+	}
+
+	if err := assignToProject(ctx, client, config, droplet); err != nil {
+		return nil, err
+	}
+
+	infra := &provisioned{droplet: droplet, hostPublicKey: hostPublicKey}
+
+	if !config.manageDNS {
+		publicIP, err := ensureReservedIP(ctx, client, config, droplet)
+		if err != nil {
+			return nil, err
+		}
+
+		infra.dnsTargets = dnsTargets(config, publicIP, droplet)
+
+		return infra, nil
+	}
+
+	if infra.dnsTargets, infra.dnsChanged, err = configureDNS(ctx, client, dns, config, droplet); err != nil {
+		return nil, err
+	}
+
+	return infra, nil
+}
+
+func ensureSSHKey(ctx context.Context, client *godo.Client, config *Config) (int, error) {
+	// First try to find existing key by name or fingerprint
+	keys, _, err := client.Keys.List(ctx, &godo.ListOptions{PerPage: sshKeysPerPage})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list SSH keys: %w", err)
+	}
+
+	existing, err := selectSSHKey(keys, config.sshKeyName, config.sshFingerprint)
+	if err != nil {
+		return 0, err
+	}
+
+	if existing != nil {
+		return existing.ID, nil
+	}
+
+	// If key not found, try to read from file and create it
+	keyBytes, err := os.ReadFile(os.ExpandEnv(config.sshKeyPath))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read SSH key file: %w", err)
+	}
+
+	createRequest := &godo.KeyCreateRequest{
+		Name:      config.resourceName(resourceSSHKey),
+		PublicKey: string(keyBytes),
+	}
+
+	key, _, err := client.Keys.Create(ctx, createRequest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create SSH key: %w", err)
+	}
+
+	return key.ID, nil
+}
+
+// selectSSHKey picks the account key named by DO_SSH_KEY_NAME, which must then
+// also match DO_SSH_KEY_FINGERPRINT when both are set, or else the key with
+// the fingerprint. It returns nil when only a fingerprint is set and no key
+// has it.
+func selectSSHKey(keys []godo.Key, name, fingerprint string) (*godo.Key, error) {
+	if name == "" {
+		for i := range keys {
+			if keys[i].Fingerprint == fingerprint {
+				return &keys[i], nil
+			}
+		}
+
+		return nil, nil
+	}
+
+	var named []*godo.Key
+
+	for i := range keys {
+		if keys[i].Name == name {
+			named = append(named, &keys[i])
+		}
+	}
+
+	switch {
+	case len(named) == 0:
+		return nil, fmt.Errorf("%w: no key named %q", ErrSSHKeyNotFound, name)
+	case len(named) > 1:
+		return nil, fmt.Errorf("%w: %d keys are named %q, use DO_SSH_KEY_FINGERPRINT instead",
+			ErrAmbiguousSSHKey, len(named), name)
+	case fingerprint != "" && named[0].Fingerprint != fingerprint:
+		return nil, fmt.Errorf("%w: key %q has fingerprint %s, DO_SSH_KEY_FINGERPRINT is %s",
+			ErrSSHKeyMismatch, name, named[0].Fingerprint, fingerprint)
+	}
+
+	return named[0], nil
+}
+
+func getDomainParts(domain string) (rootDomain string, parts []string) {
+	parts = strings.Split(domain, ".")
+	rootDomain = domain
+
+	if len(parts) > minDomainParts {
+		rootDomain = strings.Join(parts[len(parts)-minDomainParts:], ".")
+	}
+
+	return rootDomain, parts
+}
+
+func sanitizeRecordName(name string) string {
+	// If name is empty or root domain, return @
+	if name == "" || name == "@" {
+		return "@"
+	}
+
+	// Replace invalid characters with -
+	invalidChars := regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+	sanitized := invalidChars.ReplaceAllString(name, "-")
+
+	return sanitized
+}
+
+func configureAndVerifyDNS(ctx context.Context, client *godo.Client, config *Config, droplet *godo.Droplet) error {
+	targets, changed, err := configureDNS(ctx, client, newDNSProvider(client, config), config, droplet)
+	if err != nil {
+		return err
+	}
+
+	return waitForDNSChange(ctx, config, targets, changed)
+}
+
+// configureDNS points the A and AAAA records at droplet, or at the reserved
+// IP, returning the targets and whether any record changed.
+func configureDNS(ctx context.Context, client *godo.Client, provider DNSProvider, config *Config,
+	droplet *godo.Droplet,
+) ([]dnsTarget, bool, error) {
+	recordName := "@"
+	rootDomain := config.domain
+	parts := strings.Split(config.domain, ".")
+
+	if len(parts) > minDomainParts {
+		recordName = sanitizeRecordName(parts[0])
+		rootDomain = strings.Join(parts[len(parts)-minDomainParts:], ".")
+	}
+
+	// With a reserved IP this also repairs a record changed by hand
+	ip, err := ensureReservedIP(ctx, client, config, droplet)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Create or update the A and AAAA records
+	targets := dnsTargets(config, ip, droplet)
+	changed := false
+
+	for _, target := range targets {
+		var targetChanged bool
+
+		err = retryWithBackoff(ctx, apiAttempts, apiRetryDelay, func() error {
+			var upsertErr error
+			targetChanged, upsertErr = provider.UpsertRecord(ctx, rootDomain, recordName, target.recordType, target.ip,
+				config.dnsTTL)
+
+			return upsertErr
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to create %s record: %w", target.recordType, err)
+		}
+
+		changed = changed || targetChanged
+	}
+
+	return targets, changed, nil
+}
+
+// waitForDNSChange waits for changed records to propagate.
+func waitForDNSChange(ctx context.Context, config *Config, targets []dnsTarget, changed bool) error {
+	switch {
+	case config.skipDNSWait:
+		fmt.Printf("Not waiting for DNS propagation of %s (--no-wait-dns)\n", config.domain)
+
+		return nil
+	case !changed:
+		fmt.Printf("Not waiting for DNS propagation: %s already pointed at %s\n", config.domain,
+			describeTargets(targets))
+
+		return nil
+	}
+
+	// Proxied records resolve to Cloudflare's edge, never to the droplet
+	if config.cloudflare {
+		var err error
+		if targets, err = cloudflareEdgeTargets(ctx, config); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Waiting up to %s for %s to propagate to %s\n", config.dnsTimeout, config.domain,
+		describeTargets(targets))
+
+	return waitForDNSPropagation(ctx, config, targets)
+}
+
+func createVPC(ctx context.Context, client *godo.Client, config *Config) (*godo.VPC, error) {
+	vpcs, _, err := client.VPCs.List(ctx, &godo.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	vpcName := config.resourceName(resourceVPC)
+
+	for i := range vpcs {
+		if vpcs[i].Name == vpcName {
+			// VPCs are regional and their names account-wide, so one left in
+			// another region can neither hold the droplet nor be recreated here
+			if vpcs[i].RegionSlug != config.region {
+				return nil, fmt.Errorf("%w: %s (%s) is in %s, DO_REGION is %s; use another DEPLOY_PREFIX "+
+					"or delete the VPC once it is empty", ErrVPCRegionMismatch, vpcName, vpcs[i].ID,
+					vpcs[i].RegionSlug, config.region)
+			}
+
+			existingVPC, _, getErr := client.VPCs.Get(ctx, vpcs[i].ID)
+			if getErr != nil {
+				return nil, getErr
+			}
+
+			return existingVPC, nil
+		}
+	}
+
+	createRequest := &godo.VPCCreateRequest{
+		Name:        vpcName,
+		RegionSlug:  config.region,
+		IPRange:     vpcIPRange,
+		Description: vpcDescription,
+	}
+
+	vpc, _, err := client.VPCs.Create(ctx, createRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return vpc, nil
+}
+
+func createFirewall(ctx context.Context, client *godo.Client, config *Config) error {
+	if config.firewallID != "" {
+		return verifyExistingFirewall(ctx, client, config)
+	}
+
+	request, err := firewallRequest(config)
+	if err != nil {
+		return err
+	}
+
+	// Check if firewall already exists
+	firewalls, _, err := client.Firewalls.List(ctx, &godo.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list firewalls: %w", err)
+	}
+
+	for i := range firewalls {
+		if firewalls[i].Name == request.Name {
+			// Firewall exists, update it. The update replaces the whole
+			// firewall, so keep the droplets and tags it already applies to
+			request.DropletIDs = firewalls[i].DropletIDs
+			request.Tags = firewalls[i].Tags
+
+			_, _, err = client.Firewalls.Update(ctx, firewalls[i].ID, request)
 			if err != nil {
 				return fmt.Errorf("failed to update firewall: %w", err)
 			}
 
-			return nil
+			return nil
+		}
+	}
+
+	// Create new firewall if it doesn't exist
+	_, _, err = client.Firewalls.Create(ctx, request)
+	if err != nil {
+		return fmt.Errorf("failed to create firewall: %w", err)
+	}
+
+	return nil
+}
+
+func createRegistry(ctx context.Context, client *godo.Client) error {
+	// Check if registry already exists
+	registry, resp, err := client.Registry.Get(ctx)
+	if err != nil {
+		if resp == nil || resp.StatusCode != 404 {
+			return fmt.Errorf("failed to check registry: %w", registryCreateError(err))
+		}
+
+		// Registry doesn't exist, create it
+		registry, _, err = client.Registry.Create(ctx, &godo.RegistryCreateRequest{
+			Name:                 "n8n",
+			SubscriptionTierSlug: "starter",
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create registry: %w", registryCreateError(err))
+		}
+	}
+
+	// Ensure we have a registry name
+	if registry == nil || registry.Name == "" {
+		return ErrRegistryEmpty
+	}
+
+	// Ensure registry is ready
+	err = retryWithBackoff(ctx, maxRetries, registryRetryDelay, func() error {
+		registry, _, err = client.Registry.Get(ctx)
+		if err != nil {
+			return err
+		}
+
+		if registry == nil || registry.Name == "" {
+			return retryable(ErrRegistryNotReady)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to wait for registry: %w", err)
+	}
+
+	return nil
+}
+
+// findDroplet returns the droplet with the given name, or nil when none exists.
+// DigitalOcean allows duplicate names, so several matches are narrowed to the
+// one this tool manages in region, and an error lists them otherwise.
+func findDroplet(ctx context.Context, client *godo.Client, region, name string) (*godo.Droplet, error) {
+	droplets, _, err := client.Droplets.ListByName(ctx, name, &godo.ListOptions{PerPage: listPerPage})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list droplets: %w", err)
+	}
+
+	switch len(droplets) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &droplets[0], nil
+	}
+
+	var candidates []*godo.Droplet
+
+	// Use index to avoid copying large structs
+	for i := range droplets {
+		if droplets[i].Region != nil && droplets[i].Region.Slug == region &&
+			slices.Contains(droplets[i].Tags, managedTag) && slices.Contains(droplets[i].Tags, name) {
+			candidates = append(candidates, &droplets[i])
+		}
+	}
+
+	if len(candidates) == 1 {
+		fmt.Printf("Found %d droplets named %s, using %d, the managed one in %s\n",
+			len(droplets), name, candidates[0].ID, region)
+
+		return candidates[0], nil
+	}
+
+	matches := make([]string, 0, len(droplets))
+	for i := range droplets {
+		matches = append(matches, describeDroplet(&droplets[i]))
+	}
+
+	return nil, fmt.Errorf("%w %s: %s; rename or delete the extra droplets",
+		ErrAmbiguousDroplet, name, strings.Join(matches, ", "))
+}
+
+// describeDroplet identifies a droplet in messages about duplicate names.
+func describeDroplet(droplet *godo.Droplet) string {
+	region := "unknown region"
+	if droplet.Region != nil {
+		region = droplet.Region.Slug
+	}
+
+	return fmt.Sprintf("%d (%s, tags %s)", droplet.ID, region, strings.Join(droplet.Tags, "/"))
+}
+
+// verifyDropletPlacement refuses to deploy to a droplet that has the right
+// name but lives in another region or VPC, which means it is not the one
+// this configuration created.
+func verifyDropletPlacement(droplet *godo.Droplet, region, vpcID string) error {
+	if droplet.Region != nil && droplet.Region.Slug != region {
+		return fmt.Errorf("%w: %s is in %s, expected %s",
+			ErrDropletPlacement, describeDroplet(droplet), droplet.Region.Slug, region)
+	}
+
+	if droplet.VPCUUID != vpcID {
+		return fmt.Errorf("%w: %s is in VPC %s, expected %s",
+			ErrDropletPlacement, describeDroplet(droplet), droplet.VPCUUID, vpcID)
+	}
+
+	return nil
+}
+
+// createOrGetDroplet returns the droplet, creating it when it does not exist
+// yet along with the host key it is seeded with. The host key is "" for an
+// existing droplet. prepareDroplet finishes a new droplet's setup over SSH.
+func createOrGetDroplet(ctx context.Context, client *godo.Client, config *Config, vpcID string, sshKeyID int,
+) (*godo.Droplet, string, error) {
+	// Check if droplet already exists
+	existing, err := findDroplet(ctx, client, config.region, config.resourceName(resourceDroplet))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var volume *godo.Volume
+
+	if config.volumeSizeGB > 0 {
+		if volume, err = ensureDataVolume(ctx, client, config); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if existing == nil && config.dropletName != "" {
+		if existing, err = renameLegacyDroplet(ctx, client, config); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if existing != nil {
+		if err := verifyDropletPlacement(existing, config.region, vpcID); err != nil {
+			return nil, "", err
+		}
+
+		if volume != nil {
+			warnUnattachedVolume(existing, volume)
+		}
+
+		return existing, "", nil
+	}
+
+	// Create new droplet using Docker marketplace image
+	createRequest := dropletCreateRequest(config, config.resourceName(resourceDroplet), godo.DropletCreateImage{
+		Slug: dropletImageSlug, // Docker marketplace image
+	}, vpcID, sshKeyID)
+
+	if volume != nil {
+		createRequest.Volumes = []godo.DropletCreateVolume{{ID: volume.ID}}
+	}
+
+	// DigitalOcean does not expose host keys, so seed one we already know
+	hostPrivateKey, hostPublicKey, err := ssh.GenerateHostKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Script to run on first boot
+	createRequest.UserData, err = cloudInitMultipart(
+		hostKeyCloudConfig(hostPrivateKey, hostPublicKey)+hostnameCloudConfig(config), generateUserData(config))
+	if err != nil {
+		return nil, "", err
+	}
+
+	droplet, _, err := client.Droplets.Create(ctx, createRequest)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create droplet: %w", err)
+	}
+
+	d, err := waitForDropletActive(ctx, client, droplet.ID, config.dropletPowerOn)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return d, hostPublicKey, nil
+}
+
+// prepareDroplet trusts the host key a new droplet was seeded with and waits
+// for its first boot to finish, then runs the bootstrap on every droplet. Its
+// sentinel makes that a no-op on a set-up droplet, while an existing droplet
+// that was never set up, or lost its user, gets repaired: the sentinel only
+// counts while the n8n user exists.
+func prepareDroplet(ctx context.Context, config *Config, droplet *godo.Droplet, hostPublicKey string) error {
+	dropletIP, err := droplet.PublicIPv4()
+	if err != nil {
+		return fmt.Errorf("failed to get droplet IP: %w", err)
+	}
+
+	if hostPublicKey != "" {
+		if err := ssh.AddKnownHost(config.knownHostsPath, dropletIP, hostPublicKey); err != nil {
+			return err
+		}
+
+		if err := waitForCloudInit(ctx, dropletIP, config); err != nil {
+			return err
 		}
 	}
 
-	// Create new firewall if it doesn't exist
-	createRequest := &godo.FirewallRequest{
-		Name: firewallName,
-		InboundRules: []godo.InboundRule{
-			{
-				Protocol:  "tcp",
-				PortRange: "22",
-				Sources: &godo.Sources{
-					Addresses: []string{"0.0.0.0/0"},
-				},
-			},
-			{
-				Protocol:  "tcp",
-				PortRange: "80",
-				Sources: &godo.Sources{
-					Addresses: []string{"0.0.0.0/0"},
-				},
-			},
-			{
-				Protocol:  "tcp",
-				PortRange: "443",
-				Sources: &godo.Sources{
-					Addresses: []string{"0.0.0.0/0"},
-				},
-			},
-		},
-		OutboundRules: []godo.OutboundRule{
+	// Configure non-root user
+	if err := setupNonRootUser(ctx, dropletIP, config); err != nil {
+		return fmt.Errorf("failed to setup non-root user: %w", err)
+	}
+
+	return nil
+}
+
+func dropletCreateRequest(config *Config, name string, image godo.DropletCreateImage,
+	vpcID string, sshKeyID int,
+) *godo.DropletCreateRequest {
+	return &godo.DropletCreateRequest{
+		Name:   name,
+		Region: config.region,
+		Size:   config.dropletSize,
+		Image:  image,
+		SSHKeys: []godo.DropletCreateSSHKey{
 			{
-				Protocol:  "tcp",
-				PortRange: "1-65535",
-				Destinations: &godo.Destinations{
-					Addresses: []string{"0.0.0.0/0"},
-				},
+				ID: sshKeyID,
 			},
 		},
+		Monitoring: true,
+		VPCUUID:    vpcID,
+		Tags:       []string{managedTag, environmentTag, config.resourceName(resourceTag)},
+		IPv6:       true,
+		Backups:    true,
 	}
+}
 
-	_, _, err = client.Firewalls.Create(ctx, createRequest)
+func setupNonRootUser(ctx context.Context, dropletIP string, config *Config) error {
+	sshClient, err := connectSSH(ctx, dropletIP, config.deploySSHUser, config)
 	if err != nil {
-		return fmt.Errorf("failed to create firewall: %w", err)
+		return fmt.Errorf("failed to create SSH client: %w", err)
+	}
+	defer sshClient.Close()
+
+	output, err := sshClient.ExecuteCommand(generateBootstrapScript())
+	if err != nil {
+		return fmt.Errorf("failed to execute setup script: %w", err)
+	}
+
+	if strings.Contains(output, bootstrapSkipMessage) {
+		fmt.Println("Droplet already bootstrapped, skipping non-root user setup")
 	}
 
 	return nil
 }
 
-func createRegistry(ctx context.Context, client *godo.Client) error {
-	// Check if registry already exists
-	registry, resp, err := client.Registry.Get(ctx)
+// generateBootstrapScript runs the non-root user setup once per droplet,
+// recording completion in bootstrapSentinel. The setup itself stays
+// idempotent, so a droplet that lost the sentinel, or the n8n user, is simply
+// set up again.
+func generateBootstrapScript() string {
+	return fmt.Sprintf(`#!/bin/bash
+set -e
+
+if [ -f %[1]s ] && id n8n >/dev/null 2>&1; then
+	echo "%[2]s"
+	exit 0
+fi
+%[3]s
+touch %[1]s`, bootstrapSentinel, bootstrapSkipMessage, generateNonRootUserScript())
+}
+
+// hardenSSH disables SSH password logins. It runs over a key-authenticated
+// connection, which proves key access works before passwords are turned off.
+func hardenSSH(ctx context.Context, dropletIP string, config *Config) error {
+	sshClient, err := connectSSH(ctx, dropletIP, config.deploySSHUser, config)
 	if err != nil {
-		if resp == nil || resp.StatusCode != 404 {
-			return fmt.Errorf("failed to check registry: %w", err)
-		}
+		return fmt.Errorf("%w: %v", ErrSSHClient, err)
+	}
+	defer sshClient.Close()
 
-		// Registry doesn't exist, create it
-		registry, _, err = client.Registry.Create(ctx, &godo.RegistryCreateRequest{
-			Name:                 "n8n",
-			SubscriptionTierSlug: "starter",
-		})
-		if err != nil {
-			return fmt.Errorf("failed to create registry: %w", err)
-		}
+	if output, err := sshClient.ExecuteCommand(generateSSHHardeningScript()); err != nil {
+		return fmt.Errorf("failed to harden sshd: %w\nOutput: %s", err, output)
 	}
 
-	// Ensure we have a registry name
-	if registry == nil || registry.Name == "" {
-		return ErrRegistryEmpty
+	return nil
+}
+
+// generateNonRootUserScript creates the n8n user and host directories. Every
+// step is guarded so the script can be re-run against a provisioned droplet.
+func generateNonRootUserScript() string {
+	return `
+#!/bin/bash
+set -e
+
+# Create n8n user
+if ! id n8n >/dev/null 2>&1; then
+	useradd -m -s /bin/bash n8n
+fi
+
+# Add to sudo and docker groups
+id -nG n8n | grep -qw sudo || usermod -aG sudo n8n
+id -nG n8n | grep -qw docker || usermod -aG docker n8n
+
+# Set up SSH directory
+mkdir -p /home/n8n/.ssh
+chmod 700 /home/n8n/.ssh
+
+# Copy SSH key
+cp /root/.ssh/authorized_keys /home/n8n/.ssh/
+chown -R n8n:n8n /home/n8n/.ssh
+chmod 600 /home/n8n/.ssh/authorized_keys
+
+# Set up sudoers
+echo "n8n ALL=(ALL) NOPASSWD:ALL" > /etc/sudoers.d/n8n
+chmod 440 /etc/sudoers.d/n8n
+
+# Create necessary directories
+mkdir -p /opt/n8n/{caddy_config,local_files}
+
+# Create docker volumes
+docker volume inspect caddy_data >/dev/null 2>&1 || docker volume create caddy_data
+docker volume inspect n8n_data >/dev/null 2>&1 || docker volume create n8n_data
+
+# Set proper permissions
+chown -R n8n:n8n /opt/n8n
+`
+}
+
+// connectSSH dials the droplet, through SSH_BASTION_HOST when set, retrying
+// while sshd is not accepting connections yet.
+func connectSSH(ctx context.Context, host, user string, config *Config) (*ssh.Client, error) {
+	var sshClient *ssh.Client
+
+	opts := []ssh.Option{ssh.WithKnownHosts(config.knownHostsPath), ssh.WithMaxOutput(config.sshMaxOutput)}
+
+	switch config.hostKeyChecking {
+	case hostKeyCheckingStrict:
+		opts = append(opts, ssh.WithStrictHostKeys())
+	case hostKeyCheckingOff:
+		opts = append(opts, ssh.WithInsecureHostKey())
+	}
+	if user != rootUser {
+		opts = append(opts, ssh.WithSudo())
 	}
 
-	// Ensure registry is ready
-	for i := 0; i < maxRetries; i++ {
-		registry, _, err = client.Registry.Get(ctx)
-		if err == nil && registry != nil && registry.Name != "" {
-			return nil
+	if config.sshBastionHost != "" {
+		opts = append(opts, ssh.WithBastion(config.sshBastionHost, config.sshBastionUser))
+	}
+
+	err := retryWithBackoff(ctx, sshConnectAttempts, sshConnectDelay, func() error {
+		client, err := ssh.NewClient(host, sshPort, user, config.sshKeyPath, opts...)
+		if err != nil {
+			return err
 		}
 
-		time.Sleep(registryRetryDelay)
+		sshClient = client
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return ErrRegistryNotReady
+	return sshClient, nil
 }
 
-func createOrGetDroplet(ctx context.Context, client *godo.Client, config *Config, vpcID string, sshKeyID int) (*godo.Droplet, error) {
-	// Check if droplet already exists
-	droplets, _, err := client.Droplets.List(ctx, &godo.ListOptions{})
+func generateUserData(config *Config) string {
+	return fmt.Sprintf(`#!/bin/bash
+set -e
+%s%s%s
+# System updates
+apt-get update
+apt-get upgrade -y
+
+# Install required packages
+apt-get install -y \
+    apt-transport-https \
+    ca-certificates \
+    curl \
+    software-properties-common \
+    fail2ban \
+    ufw \
+    git \
+    jq
+%s
+# Configure UFW
+ufw default deny incoming
+ufw default allow outgoing
+ufw allow ssh
+ufw allow http
+ufw allow https
+yes | ufw enable
+
+# Configure fail2ban
+cat > /etc/fail2ban/jail.local << EOF
+[sshd]
+enabled = true
+bantime = 3600
+findtime = 600
+maxretry = 3
+EOF
+
+systemctl enable fail2ban
+systemctl start fail2ban
+
+# Create app directories
+mkdir -p /opt/n8n/{caddy_config,local_files}
+
+# Clone n8n-docker-caddy repository
+cd /opt/n8n
+git clone https://github.com/n8n-io/n8n-docker-caddy.git
+mv n8n-docker-caddy/* .
+rm -rf n8n-docker-caddy
+
+# Create Caddyfile
+cat > %s << 'EOF'
+%sEOF
+`, generateHostnameScript(config), generateVolumeMountScript(config), generateDeployUserScript(config),
+		generateDockerPinScript(config), caddyfilePath, generateCaddyfile(config))
+}
+
+func buildAndPushImage(ctx context.Context, client *dagger.Client, config *Config) (*publishedImage, error) {
+	var progress buildProgress
+	defer progress.abort()
+
+	progress.start("preparing registry access")
+
+	// First ensure registry exists
+	doClient := newDOClient(config)
+	err := createRegistry(ctx, doClient)
+
 	if err != nil {
-		return nil, fmt.Errorf("failed to list droplets: %w", err)
+		return nil, fmt.Errorf("failed to ensure registry exists: %w", err)
 	}
 
-	// Use index to avoid copying large structs
-	for i := range droplets {
-		if droplets[i].Name == config.dropletName {
-			return &droplets[i], nil
+	// Get registry credentials with read/write access
+	credentials, registryName, err := registryAccess(ctx, doClient, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create Docker config.json content with the registry credentials
+	dockerConfig, err := dockerConfigForRegistry(credentials.DockerConfigJSON, config.registryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	dockerConfigSecret := client.SetSecret("docker_config", dockerConfig)
+
+	// Build base image URL
+	baseRef := fmt.Sprintf("%s/%s", config.registryURL, registryName)
+
+	// Create source directory, without any generated secrets left in it
+	src := client.Host().Directory(".", dagger.HostDirectoryOpts{Exclude: []string{credentialsOutputName}})
+
+	// Build the image
+	buster := cacheBuster(config)
+	from := baseContainer(client, config, buster)
+	base := withCacheBuster(from, buster)
+
+	// Dagger evaluates lazily, so each phase is synced to time it on its own
+	if config.dockerfile != "" {
+		progress.start("building " + config.dockerfile)
+	} else {
+		progress.start("pulling base image")
+	}
+
+	if base, err = base.Sync(ctx); err != nil {
+		return nil, fmt.Errorf("failed to prepare base image: %w", err)
+	}
+
+	if len(config.communityNodes) > 0 {
+		progress.start(fmt.Sprintf("installing %d community nodes", len(config.communityNodes)))
+
+		if base, err = withCommunityNodes(base, config.communityNodes).Sync(ctx); err != nil {
+			return nil, fmt.Errorf("failed to install community nodes: %w", err)
 		}
 	}
 
-	// Create new droplet using Docker marketplace image
-	createRequest := &godo.DropletCreateRequest{
-		Name:   config.dropletName,
-		Region: defaultRegion,
-		Size:   defaultDropletSize,
-		Image: godo.DropletCreateImage{
-			Slug: "docker-20-04", // Docker marketplace image
-		},
-		SSHKeys: []godo.DropletCreateSSHKey{
-			{
-				ID: sshKeyID,
-			},
-		},
-		Monitoring: true,
-		VPCUUID:    vpcID,
-		Tags:       []string{"n8n", "production"},
-		IPv6:       true,
-		Backups:    true,
-		UserData:   generateUserData(config), // Script to run on first boot
+	progress.start("configuring and labelling image")
+
+	n8nImage := base.
+		WithEnvVariable("NODE_ENV", "production").
+		WithEnvVariable("N8N_PORT", "5678").
+		WithEnvVariable("N8N_PROTOCOL", "https").
+		WithEnvVariable("N8N_METRICS", "true").
+		WithEnvVariable("N8N_USER_FOLDER", config.n8nUserFolder).
+		WithEnvVariable("N8N_BASIC_AUTH_ACTIVE", "true").
+		WithEnvVariable("TINI_SUBREAPER", "true").
+		WithEnvVariable("N8N_ENFORCE_SETTINGS_FILE_PERMISSIONS", strconv.FormatBool(config.enforceSettingsPermissions)).
+		WithMountedSecret("/root/.docker/config.json", dockerConfigSecret).
+		WithLabel("org.opencontainers.image.created", imageCreatedTime()).
+		WithLabel("org.opencontainers.image.version", config.n8nVersion).
+		WithDirectory("/app", src).
+		// A fresh volume copies this directory's ownership, so n8n can write to it
+		WithDirectory(config.n8nUserFolder, client.Directory(), dagger.ContainerWithDirectoryOpts{Owner: n8nImageUser})
+
+	// Configured and CI-derived labels go last so they can override the defaults
+	n8nImage = withImageLabels(n8nImage, config)
+
+	if buster != "" {
+		n8nImage = n8nImage.WithoutEnvVariable(cacheBusterVar)
 	}
 
-	droplet, _, err := client.Droplets.Create(ctx, createRequest)
+	if n8nImage, err = n8nImage.Sync(ctx); err != nil {
+		return nil, fmt.Errorf("failed to configure image: %w", err)
+	}
+
+	if err := verifyNoSecretEnv(ctx, n8nImage); err != nil {
+		return nil, err
+	}
+
+	contentKey, err := imageContentKey(ctx, from, config, buster)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create droplet: %w", err)
+		return nil, err
 	}
 
-	// Wait for droplet to be ready
-	for {
-		d, _, err := client.Droplets.Get(ctx, droplet.ID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get droplet status: %w", err)
+	if config.scanImage {
+		progress.start("scanning image")
+
+		if err := scanImage(ctx, client, n8nImage, config); err != nil {
+			return nil, err
 		}
+	}
 
-		if d.Status == "active" {
-			// Wait a bit more to ensure SSH is ready
-			time.Sleep(sshReadyDelay)
+	if config.generateSBOM {
+		progress.start("generating SBOM")
 
-			// Configure non-root user
-			if err := setupNonRootUser(d.Networks.V4[0].IPAddress, config); err != nil {
-				return nil, fmt.Errorf("failed to setup non-root user: %w", err)
-			}
+		if n8nImage, err = withSBOM(ctx, client, n8nImage, config); err != nil {
+			return nil, err
+		}
+	}
+
+	// Environments of a batch push the same tags to the same repository and
+	// prune it, so they take turns from the first push to the prune
+	releasePushLock, err := lockImagePush(&progress)
+	if err != nil {
+		return nil, err
+	}
+	defer releasePushLock()
+
+	// Push latest tag
+	latestRef := fmt.Sprintf("%s/n8n:latest", baseRef)
+
+	progress.start("pushing " + latestRef)
+
+	err = retryWithBackoff(ctx, publishAttempts, publishRetryDelay, func() error {
+		_, publishErr := n8nImage.Publish(ctx, latestRef)
+
+		return publishErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish latest image: %w", err)
+	}
+
+	// Push versioned tag
+	versionedRef := fmt.Sprintf("%s/n8n:%s", baseRef, config.n8nVersion)
+
+	progress.start("pushing " + versionedRef)
+
+	var publishedRef string
+
+	err = retryWithBackoff(ctx, publishAttempts, publishRetryDelay, func() error {
+		ref, publishErr := n8nImage.Publish(ctx, versionedRef)
+		publishedRef = ref
+
+		return publishErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish versioned image: %w", err)
+	}
+
+	image := &publishedImage{
+		ref:        versionedRef,
+		digest:     imageDigest(publishedRef),
+		version:    config.n8nVersion,
+		contentKey: contentKey,
+	}
+
+	if config.signImage {
+		progress.start("signing image")
 
-			return d, nil
+		if err := signImage(ctx, client, dockerConfigSecret, config, image); err != nil {
+			return nil, err
 		}
-
-		time.Sleep(dropletStatusCheckDelay)
 	}
-}
 
-func setupNonRootUser(dropletIP string, config *Config) error {
-	// Create SSH client as root
-	sshClient, err := ssh.NewClient(dropletIP, sshPort, "root", config.sshKeyPath)
-	if err != nil {
-		return fmt.Errorf("failed to create SSH client: %w", err)
+	if config.autoPruneTags > 0 {
+		progress.start("pruning old image tags")
+
+		if err := pruneImageTags(ctx, doClient, registryName, config.autoPruneTags, "latest", config.n8nVersion); err != nil {
+			return nil, fmt.Errorf("failed to prune old image tags: %w", err)
+		}
 	}
 
-	// Create n8n user and setup
-	setupScript := `
-#!/bin/bash
-set -e
+	progress.finish()
 
-# Create n8n user
-useradd -m -s /bin/bash n8n
+	return image, nil
+}
 
-# Add to sudo group
-usermod -aG sudo n8n
-usermod -aG docker n8n
+// baseContainer returns the container the n8n image is built from: a
+// Dockerfile build when N8N_DOCKERFILE is set, otherwise N8N_BASE_IMAGE or the
+// official image for the configured version.
+func baseContainer(client *dagger.Client, config *Config, buster string) *dagger.Container {
+	if config.dockerfile != "" {
+		contextDir := config.buildContext
+		if contextDir == "" {
+			contextDir = filepath.Dir(config.dockerfile)
+		}
 
-# Set up SSH directory
-mkdir -p /home/n8n/.ssh
-chmod 700 /home/n8n/.ssh
+		// Dagger resolves the Dockerfile relative to the build context
+		dockerfile, err := filepath.Rel(contextDir, config.dockerfile)
+		if err != nil {
+			dockerfile = config.dockerfile
+		}
 
-# Copy SSH key
-cp /root/.ssh/authorized_keys /home/n8n/.ssh/
-chown -R n8n:n8n /home/n8n/.ssh
-chmod 600 /home/n8n/.ssh/authorized_keys
+		opts := dagger.DirectoryDockerBuildOpts{
+			Dockerfile: dockerfile,
+		}
 
-# Set up sudoers
-echo "n8n ALL=(ALL) NOPASSWD:ALL" > /etc/sudoers.d/n8n
-chmod 440 /etc/sudoers.d/n8n
+		// Dockerfiles opt into cache busting by declaring ARG CACHEBUSTER
+		if buster != "" {
+			opts.BuildArgs = []dagger.BuildArg{{Name: cacheBusterVar, Value: buster}}
+		}
 
-# Create necessary directories
-mkdir -p /opt/n8n/{caddy_config,local_files}
-chown -R n8n:n8n /opt/n8n
+		return client.Host().Directory(contextDir).DockerBuild(opts)
+	}
 
-# Create docker volumes
-docker volume create caddy_data
-docker volume create n8n_data
+	baseImage := config.baseImage
+	if baseImage == "" {
+		baseImage = fmt.Sprintf("n8nio/n8n:%s", config.n8nVersion)
 
-# Set proper permissions
-chown -R n8n:n8n /opt/n8n
-`
+		if config.dockerHubMirror != "" {
+			baseImage = fmt.Sprintf("%s/%s", strings.TrimSuffix(config.dockerHubMirror, "/"), baseImage)
+		}
+	}
 
-	if _, err := sshClient.ExecuteCommand(setupScript); err != nil {
-		return fmt.Errorf("failed to execute setup script: %w", err)
+	container := client.Container()
+
+	// Authenticated pulls get a far higher Docker Hub rate limit than anonymous ones
+	if config.dockerHubUser != "" {
+		token := client.SetSecret("dockerhub_token", config.dockerHubToken)
+		container = container.WithRegistryAuth(dockerHubRegistry, config.dockerHubUser, token)
 	}
 
-	return nil
+	return container.From(baseImage)
 }
 
-func generateUserData(_ *Config) string {
-	return `#!/bin/bash
-set -e
+func validateImageSource(config *Config) error {
+	if config.baseImage != "" && config.dockerfile != "" {
+		return fmt.Errorf("%w: N8N_BASE_IMAGE and N8N_DOCKERFILE are mutually exclusive", ErrInvalidConfig)
+	}
 
-# System updates
-apt-get update
-apt-get upgrade -y
+	if config.buildContext != "" && config.dockerfile == "" {
+		return fmt.Errorf("%w: N8N_BUILD_CONTEXT requires N8N_DOCKERFILE", ErrInvalidConfig)
+	}
 
-# Install required packages
-apt-get install -y \
-    apt-transport-https \
-    ca-certificates \
-    curl \
-    software-properties-common \
-    fail2ban \
-    ufw \
-    git \
-    jq
+	if (config.dockerHubUser == "") != (config.dockerHubToken == "") {
+		return fmt.Errorf("%w: DOCKERHUB_USER and DOCKERHUB_TOKEN must be set together", ErrInvalidConfig)
+	}
 
-# Configure UFW
-ufw default deny incoming
-ufw default allow outgoing
-ufw allow ssh
-ufw allow http
-ufw allow https
-yes | ufw enable
+	if config.dockerfile != "" {
+		if _, err := os.Stat(config.dockerfile); err != nil {
+			return fmt.Errorf("%w: N8N_DOCKERFILE: %w", ErrInvalidConfig, err)
+		}
+	}
 
-# Configure fail2ban
-cat > /etc/fail2ban/jail.local << EOF
-[sshd]
-enabled = true
-bantime = 3600
-findtime = 600
-maxretry = 3
-EOF
+	return nil
+}
 
-systemctl enable fail2ban
-systemctl start fail2ban
+func deployN8N(ctx context.Context, dropletIP string, config *Config, image *publishedImage) (err error) {
+	// Create SSH client
+	sshClient, err := connectSSH(ctx, dropletIP, config.deploySSHUser, config)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSSHClient, err)
+	}
+	defer sshClient.Close()
 
-# Create app directories
-mkdir -p /opt/n8n/{caddy_config,local_files}
+	if err := verifyDeployUser(sshClient, config); err != nil {
+		return err
+	}
 
-# Clone n8n-docker-caddy repository
-cd /opt/n8n
-git clone https://github.com/n8n-io/n8n-docker-caddy.git
-mv n8n-docker-caddy/* .
-rm -rf n8n-docker-caddy
+	if err := reuseBasicAuthPassword(sshClient, config); err != nil {
+		return err
+	}
 
-# Create Caddyfile
-cat > /opt/n8n/caddy_config/Caddyfile << EOF
-${config.domain} {
-    reverse_proxy n8n:5678 {
-        flush_interval -1
-    }
-}
-EOF
-`
-}
+	if err := reuseEncryptionKey(sshClient, config); err != nil {
+		return err
+	}
 
-func buildAndPushImage(ctx context.Context, client *dagger.Client, config *Config) error {
-	// First ensure registry exists
-	doClient := godo.NewFromToken(config.doToken)
-	err := createRegistry(ctx, doClient)
+	// Generate deployment script once the droplet's stored secrets are in config
+	deployScript := generateDeploymentScript(config)
 
+	releaseLock, err := acquireDeployLock(ctx, sshClient, config)
 	if err != nil {
-		return fmt.Errorf("failed to ensure registry exists: %w", err)
+		return err
 	}
+	defer releaseLock()
 
-	// Get registry credentials with read/write access
-	credentials, _, err := doClient.Registry.DockerCredentials(ctx, &godo.RegistryDockerCredentialsRequest{
-		ReadWrite: true,
-	})
+	outcome := outcomeSucceeded
 
-	if err != nil {
-		return fmt.Errorf("failed to get registry credentials: %w", err)
+	defer func() {
+		appendDeployHistory(sshClient, newHistoryEntry(image, outcome, err))
+	}()
+
+	// Refuse to deploy a key the existing instance can't decrypt with
+	if err := verifyEncryptionKey(sshClient, config); err != nil {
+		return err
 	}
 
-	if credentials == nil || len(credentials.DockerConfigJSON) == 0 {
-		return ErrEmptyCredentials
+	if err := verifyImageEncryptionKey(sshClient, config, image); err != nil {
+		return err
 	}
 
-	// Create Docker config.json content with the registry credentials
-	dockerConfigSecret := client.SetSecret("docker_config", string(credentials.DockerConfigJSON))
+	if config.deployMode == deployModeSwarm {
+		if err := verifySwarmActive(sshClient); err != nil {
+			return err
+		}
+	}
 
-	// Get registry name
-	registry, _, err := doClient.Registry.Get(ctx)
+	previousState, err := readDeployState(sshClient)
+	if err != nil {
+		return err
+	}
 
+	runningVersion, err := readRunningN8NVersion(sshClient, config)
 	if err != nil {
-		return fmt.Errorf("failed to get registry info: %w", err)
+		return err
 	}
 
-	if registry == nil || registry.Name == "" {
-		return ErrRegistryEmpty
+	logVersionChange(runningVersion, image.version)
+
+	// Instances deployed before state was recorded still report their version
+	if previousState == nil && runningVersion != "" {
+		previousState = &deployState{N8NVersion: runningVersion}
 	}
 
-	// Build base image URL
-	baseRef := fmt.Sprintf("%s/%s", config.registryURL, registry.Name)
+	configHash := deployConfigHash(config)
 
-	// Create source directory
-	src := client.Host().Directory(".")
+	if !config.forceDeploy && deployUpToDate(previousState, image, configHash) {
+		probeErr := probeN8N(sshClient, config)
+		if probeErr == nil {
+			fmt.Println("No changes, deployment up to date (pass --force to redeploy)")
 
-	// Build the image
-	n8nImage := client.Container().
-		From(fmt.Sprintf("n8nio/n8n:%s", config.n8nVersion)).
-		WithEnvVariable("NODE_ENV", "production").
-		WithEnvVariable("N8N_PORT", "5678").
-		WithEnvVariable("N8N_PROTOCOL", "https").
-		WithEnvVariable("N8N_METRICS", "true").
-		WithEnvVariable("N8N_USER_FOLDER", "/home/node/.n8n").
-		WithEnvVariable("N8N_ENCRYPTION_KEY", config.encryptionKey).
-		WithEnvVariable("N8N_BASIC_AUTH_ACTIVE", "true").
-		WithEnvVariable("N8N_BASIC_AUTH_USER", config.basicAuthUser).
-		WithEnvVariable("N8N_BASIC_AUTH_PASSWORD", config.basicAuthPass).
-		WithEnvVariable("TINI_SUBREAPER", "true").
-		WithEnvVariable("N8N_ENFORCE_SETTINGS_FILE_PERMISSIONS", "true").
-		WithMountedSecret("/root/.docker/config.json", dockerConfigSecret).
-		WithLabel("org.opencontainers.image.created", time.Now().Format(time.RFC3339)).
-		WithLabel("org.opencontainers.image.version", config.n8nVersion).
-		WithDirectory("/app", src)
+			outcome = outcomeUnchanged
 
-	// Push latest tag
-	latestRef := fmt.Sprintf("%s/n8n:latest", baseRef)
-	_, err = n8nImage.Publish(ctx, latestRef)
+			return nil
+		}
 
+		fmt.Printf("Deployment is unchanged but n8n is unhealthy (%v), redeploying\n", probeErr)
+	}
+
+	backup, err := backupBeforeDeploy(ctx, sshClient, config, previousState)
 	if err != nil {
-		return fmt.Errorf("failed to publish latest image: %w", err)
+		return err
 	}
 
-	// Push versioned tag
-	versionedRef := fmt.Sprintf("%s/n8n:%s", baseRef, config.n8nVersion)
-	_, err = n8nImage.Publish(ctx, versionedRef)
+	// Captured before the script pulls, so a failed deploy can return to it
+	previousImage, err := captureRunningImage(sshClient, config)
+	if err != nil {
+		return err
+	}
 
+	// Execute deployment script via SSH
+	output, err := sshClient.ExecuteCommand(deployScript)
 	if err != nil {
-		return fmt.Errorf("failed to publish versioned image: %w", err)
+		err = fmt.Errorf("%w: %v\nOutput: %s", ErrDeployment, err, output)
+	} else {
+		err = waitForN8NReady(ctx, sshClient, config, previousState == nil)
 	}
 
-	return nil
-}
+	if err != nil {
+		if previousImage == "" {
+			return err
+		}
 
-func deployN8N(dropletIP string, config *Config) error {
-	// Generate deployment script
-	deployScript := generateDeploymentScript(config)
+		return rollbackDeployment(ctx, sshClient, config, previousImage, err)
+	}
 
-	// Create SSH client
-	sshClient, err := ssh.NewClient(dropletIP, sshPort, "root", config.sshKeyPath)
+	// Self-heal a Caddyfile left behind for a previous domain
+	fixed, err := verifyCaddyfile(sshClient, config)
 	if err != nil {
-		return fmt.Errorf("%w: %v", ErrSSHClient, err)
+		return err
 	}
 
-	// Execute deployment script via SSH
-	output, err := sshClient.ExecuteCommand(deployScript)
-	if err != nil {
-		return fmt.Errorf("%w: %v\nOutput: %s", ErrDeployment, err, output)
+	if fixed {
+		fmt.Printf("Caddyfile did not match %s and was rewritten\n", config.domain)
+	} else {
+		fmt.Println("Caddyfile matches the configured domain")
 	}
 
-	return nil
+	logDeployChangelog(previousState, image)
+
+	state := &deployState{
+		N8NVersion:  image.version,
+		ImageRef:    image.ref,
+		ImageDigest: image.digest,
+		ContentKey:  image.contentKey,
+		Signature:   image.signature,
+		ConfigHash:  configHash,
+		DeployedAt:  time.Now().UTC(),
+	}
+
+	if backup != nil {
+		state.BackupPath = backup.dumpPath
+		state.BackupSnapshot = backup.snapshotName
+	}
+
+	return writeDeployState(sshClient, state)
 }
 
 func generateDeploymentScript(config *Config) string {
-	return fmt.Sprintf("%s\n%s\n%s",
+	return fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s",
 		generateDockerCompose(config),
 		generateEnvFile(config),
+		generateN8NEnvFile(config),
+		generateBackupSchedule(config),
+		generatePostgresCheck(config),
 		generateSetupCommands(config))
 }
 
@@ -791,18 +2102,20 @@ EOF`, generateDockerComposeContent(config))
 }
 
 func generateDockerComposeContent(config *Config) string {
-	return generatePostgresCheck() + "\n" + generateServicesConfig(config)
+	return generateServicesConfig(config)
 }
 
-func generatePostgresCheck() string {
-	return `
+// generatePostgresCheck looks the database container up by its compose labels
+// rather than its generated name, which varies between Compose versions.
+func generatePostgresCheck(config *Config) string {
+	return fmt.Sprintf(`
 # Check if PostgreSQL container exists and is running
-if docker ps -a --format '{{.Names}}' | grep -q "^n8n-db-1$"; then
+if [ -n "$(docker ps -a -q %s)" ]; then
 	echo "PostgreSQL container already exists, skipping creation..."
 	POSTGRES_EXISTS=true
 else
 	POSTGRES_EXISTS=false
-fi`
+fi`, serviceContainerFilter(config, "db"))
 }
 
 func generateServicesConfig(config *Config) string {
@@ -820,23 +2133,51 @@ volumes:
   caddy_config:
 
 networks:
-  n8n_network:
-    driver: bridge`,
+  n8n_network:%s`,
 		generateN8NServiceConfig(config),
-		generateDBServiceConfig(),
-		generateCaddyServiceConfig())
+		generateDBServiceConfig(config),
+		generateCaddyServiceConfig(config),
+		generateNetworkDriver(config))
+}
+
+// generateNetworkDriver picks the driver of the shared network. Swarm
+// services can only share an overlay network, which is made attachable so
+// standalone containers can still join it as they can the compose bridge.
+func generateNetworkDriver(config *Config) string {
+	if config.deployMode == deployModeSwarm {
+		return `
+    driver: overlay
+    attachable: true`
+	}
+
+	return `
+    driver: bridge`
+}
+
+// generateDependsOn renders a service's depends_on, which only Compose
+// supports: docker stack deploy rejects the service_healthy condition and
+// starts services in any order, restarting those that fail until the ones
+// they need are up.
+func generateDependsOn(config *Config, dependencies string) string {
+	if config.deployMode == deployModeSwarm {
+		return ""
+	}
+
+	return `
+    depends_on:` + dependencies
 }
 
 func generateN8NServiceConfig(config *Config) string {
 	return fmt.Sprintf(`
-    image: %s/n8n-app:latest
-    restart: unless-stopped
+    image: %s
+    restart: unless-stopped%s
     ports:
-      - "127.0.0.1:5678:5678"
+      - "%s"
     environment:
       - N8N_HOST=${N8N_HOST}
       - N8N_PORT=5678
       - N8N_PROTOCOL=https
+      - N8N_PATH=${N8N_PATH}
       - NODE_ENV=production
       - N8N_ENCRYPTION_KEY=${N8N_ENCRYPTION_KEY}
       - DB_TYPE=postgresdb
@@ -857,19 +2198,18 @@ func generateN8NServiceConfig(config *Config) string {
       - N8N_HIRING_BANNER_ENABLED=false
       - N8N_DIAGNOSTICS_ENABLED=false
       - N8N_METRICS=true
+      - EXECUTIONS_DATA_PRUNE=%t
+      - EXECUTIONS_DATA_MAX_AGE=%d
+      - EXECUTIONS_DATA_PRUNE_MAX_COUNT=%d
+      - N8N_GRACEFUL_SHUTDOWN_TIMEOUT=%d
+      - N8N_USER_FOLDER=%s
+      - N8N_ENFORCE_SETTINGS_FILE_PERMISSIONS=%t
+    stop_grace_period: %ds
     volumes:
-      - n8n_data:/home/node/.n8n
-      - /opt/n8n/local_files:/files
-    depends_on:
-      - db
+      - n8n_data:%s
+      - /opt/n8n/local_files:/files%s
     networks:
-      - n8n_network
-    healthcheck:
-      test: ["CMD", "curl", "-f", "http://localhost:5678/healthz"]
-      interval: 30s
-      timeout: 10s
-      retries: 3
-      start_period: 30s
+      - n8n_network%s
     deploy:
       resources:
         limits:
@@ -877,13 +2217,19 @@ func generateN8NServiceConfig(config *Config) string {
           memory: %s
         reservations:
           cpus: '%s'
-          memory: %s'`, config.registryURL, cpuLimit, memoryLimit, cpuReservation, memoryReservation)
+          memory: %s`, n8nServiceImage(config), n8nEnvFileDirective(config), n8nPortBinding(config),
+		config.executionsPrune, config.executionsMaxAge, config.executionsMaxCount,
+		int(config.drainTimeout.Seconds()), config.n8nUserFolder, config.enforceSettingsPermissions,
+		stopTimeoutSeconds(config), config.n8nUserFolder, generateDependsOn(config, `
+      db:
+        condition: service_healthy`), n8nHealthcheck(config),
+		cpuLimit, memoryLimit, cpuReservation, memoryReservation)
 }
 
-func generateDBServiceConfig() string {
-	return `
-    image: postgres:13
-    restart: unless-stopped
+func generateDBServiceConfig(config *Config) string {
+	return fmt.Sprintf(`
+    image: %s
+    restart: unless-stopped%s
     environment:
       - POSTGRES_DB=n8n
       - POSTGRES_USER=n8n
@@ -897,13 +2243,12 @@ func generateDBServiceConfig() string {
       interval: 10s
       timeout: 5s
       retries: 5
-    profiles:
-      - new-install`
+      start_period: %s`, postgresImage, postgresCommand(config), config.startPeriod)
 }
 
-func generateCaddyServiceConfig() string {
+func generateCaddyServiceConfig(config *Config) string {
 	return `
-    image: caddy:2
+    image: ` + caddyImage + `
     restart: unless-stopped
     ports:
       - "80:80"
@@ -913,11 +2258,13 @@ func generateCaddyServiceConfig() string {
       - caddy_data:/data
       - caddy_config:/config
     networks:
-      - n8n_network
-    depends_on:
-      - n8n`
+      - n8n_network` + generateDependsOn(config, `
+      - n8n`)
 }
 
+// generateEnvFile writes the compose .env. The database password is only
+// generated once: Postgres keeps the password it was initialized with, so an
+// existing one, including one recorded by import, is carried over.
 func generateEnvFile(config *Config) string {
 	// Optional email settings
 	emailMode := os.Getenv("N8N_EMAIL_MODE")
@@ -926,16 +2273,35 @@ func generateEnvFile(config *Config) string {
 	}
 
 	return fmt.Sprintf(`
+DB_PASSWORD=$(sed -n 's/^DB_PASSWORD=//p' /opt/n8n/.env 2>/dev/null | head -n 1)
+[ -n "$DB_PASSWORD" ] || DB_PASSWORD=$(openssl rand -hex 24)
+
+# An empty address would publish n8n on every interface
+N8N_BIND_ADDRESS=%s
+if [ -z "$N8N_BIND_ADDRESS" ]; then
+	echo "Failed to resolve the address to publish n8n on (N8N_BIND=%s)" >&2
+	exit 1
+fi
+
 # Create .env file for docker-compose
 cat > /opt/n8n/.env << EOF
+COMPOSE_PROJECT_NAME=%s
 N8N_HOST=%s
+N8N_PATH=%s
+WEBHOOK_URL=%s
+N8N_BIND_ADDRESS=${N8N_BIND_ADDRESS}
 N8N_ENCRYPTION_KEY=%s
-DB_PASSWORD=$(openssl rand -hex 24)
+DB_PASSWORD=${DB_PASSWORD}
 N8N_BASIC_AUTH_USER=%s
 N8N_BASIC_AUTH_PASSWORD=%s
 N8N_EMAIL_MODE=%s
 EOF`,
+		n8nBindAddress(config),
+		config.n8nBind,
+		config.composeProject,
 		config.domain,
+		config.n8nPath,
+		n8nBaseURL(config),
 		config.encryptionKey,
 		config.basicAuthUser,
 		config.basicAuthPass,
@@ -943,31 +2309,110 @@ EOF`,
 }
 
 func generateSetupCommands(config *Config) string {
-	return fmt.Sprintf(`
+	setup := fmt.Sprintf(`
 # Set proper permissions
 chown -R n8n:n8n /opt/n8n
 chmod 600 /opt/n8n/.env
 
 # Login to registry
-docker login registry.digitalocean.com -u %s -p %s
+%s
 
 # Pull and start services
 cd /opt/n8n
+%s
+`,
+		registryLoginCommand(config),
+		composeDetectScript(config))
 
-# Start services based on PostgreSQL existence
+	if config.deployMode == deployModeSwarm {
+		return setup + generateSwarmDeployCommands(config)
+	}
+
+	return setup + generateComposeDeployCommands(config)
+}
+
+func generateComposeDeployCommands(config *Config) string {
+	return fmt.Sprintf(`
+# Pull images based on PostgreSQL existence
 if [ "$POSTGRES_EXISTS" = true ]; then
-	docker-compose pull n8n caddy
-	docker-compose up -d n8n caddy
+	%[2]s pull n8n caddy
 else
-	docker-compose pull
-	docker-compose --profile new-install up -d
+	%[2]s pull
+fi
+
+# Let n8n finish in-flight executions before its container is replaced
+if [ -n "$(%[2]s ps -q n8n)" ]; then
+	echo "Draining n8n executions (up to %[1]ds)..."
+	%[2]s stop -t %[1]d n8n
 fi
 
+# n8n waits for the database to report healthy before it starts
+%[2]s up -d
+
 # Wait for services to be healthy
 echo "Waiting for services to be ready..."
-timeout 300 bash -c 'until docker-compose ps | grep -q "(healthy)"; do sleep 5; done'`,
-		config.doToken,
-		config.doToken)
+timeout %[3]d bash -c 'until %[2]s ps | grep -q "(healthy)"; do sleep 5; done'`, stopTimeoutSeconds(config), composeCmd,
+		int(config.healthWaitTimeout.Seconds()))
+}
+
+// stopTimeoutSeconds gives n8n its graceful shutdown window plus a margin
+// before Docker kills the container.
+func stopTimeoutSeconds(config *Config) int {
+	return int((config.drainTimeout + drainKillMargin).Seconds())
+}
+
+func generateSwarmDeployCommands(config *Config) string {
+	return fmt.Sprintf(`
+# Resolve .env interpolation into a standalone stack file, since docker
+# stack deploy does not read it
+%[2]s config > /opt/n8n/stack.yml
+
+docker stack deploy --with-registry-auth --prune -c /opt/n8n/stack.yml %[1]s
+
+# Wait for every service to reach its desired replica count
+echo "Waiting for stack services to converge..."
+deadline=$((SECONDS + %[3]d))
+until [ -z "$(docker stack services %[1]s --format '{{.Replicas}}' | awk -F/ '$1 != $2')" ]; do
+	if [ "$SECONDS" -ge "$deadline" ]; then
+		echo "Timed out waiting for stack services to converge"
+		docker stack services %[1]s
+		exit 1
+	fi
+	sleep 5
+done`, config.composeProject, composeCmd, int(config.healthWaitTimeout.Seconds()))
+}
+
+// verifySwarmActive fails when the droplet's Docker daemon is not part of an
+// initialized swarm, since docker stack deploy would otherwise error mid-script.
+func verifySwarmActive(sshClient *ssh.Client) error {
+	output, err := sshClient.ExecuteCommand("docker info --format '{{.Swarm.LocalNodeState}}'")
+	if err != nil {
+		return fmt.Errorf("failed to query swarm state: %w\nOutput: %s", err, output)
+	}
+
+	if state := strings.TrimSpace(output); state != "active" {
+		return fmt.Errorf("%w: node state is %q, run 'docker swarm init' on the droplet", ErrSwarmNotActive, state)
+	}
+
+	return nil
+}
+
+// splitList splits a comma-separated value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// shellQuote quotes a value for safe interpolation into a remote shell command.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
 }
 
 func requireEnv(key string) string {
@@ -992,6 +2437,48 @@ func requireEnvOrDefault(key, defaultValue string) string {
 	return value
 }
 
+func requireEnvIntOrDefault(key string, defaultValue int) int {
+	value := requireEnvOrDefault(key, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		panic(fmt.Sprintf("%v: %s=%q", ErrEnvVarParseInt, key, value))
+	}
+
+	return parsed
+}
+
+func requireEnvBoolOrDefault(key string, defaultValue bool) bool {
+	value := requireEnvOrDefault(key, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		panic(fmt.Sprintf("%v: %s=%q", ErrEnvVarParseBool, key, value))
+	}
+
+	return parsed
+}
+
+func requireEnvDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+	value := requireEnvOrDefault(key, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		panic(fmt.Sprintf("%v: %s=%q", ErrEnvVarParseDuration, key, value))
+	}
+
+	return parsed
+}
+
 func validateSSHKey(privateKey string) error {
 	trimmedKey := strings.TrimSpace(privateKey)
 	if !strings.HasPrefix(trimmedKey, "-----BEGIN") {
@@ -1045,7 +2532,16 @@ func convertKey(tmpKeyPath string) error {
 	return nil
 }
 
-func setupSSHAgent() ([]string, error) {
+// setupSSHAgent starts an ssh-agent unless SSH_AUTH_SOCK already points at a
+// running one, and exports its socket into this process so the SSH client can
+// reach it. The returned function stops an agent started here.
+func setupSSHAgent() (func(), error) {
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if _, err := os.Stat(socket); err == nil {
+			return func() {}, nil
+		}
+	}
+
 	startAgentCmd := exec.Command("ssh-agent", "-s")
 	output, err := startAgentCmd.Output()
 
@@ -1061,19 +2557,30 @@ func setupSSHAgent() ([]string, error) {
 		return nil, fmt.Errorf("%w: %s", ErrParseSSHAgentOutput, agentOutput)
 	}
 
-	env := append(os.Environ(),
-		fmt.Sprintf("SSH_AUTH_SOCK=%s", authSockMatch[1]),
-		fmt.Sprintf("SSH_AGENT_PID=%s", agentPIDMatch[1]),
-		"SSH_ASKPASS=/bin/false",
-		"DISPLAY=",
-	)
+	if err := os.Setenv("SSH_AUTH_SOCK", authSockMatch[1]); err != nil {
+		return nil, fmt.Errorf("failed to export SSH_AUTH_SOCK: %w", err)
+	}
+
+	if err := os.Setenv("SSH_AGENT_PID", agentPIDMatch[1]); err != nil {
+		return nil, fmt.Errorf("failed to export SSH_AGENT_PID: %w", err)
+	}
+
+	stop := func() {
+		// ssh-agent -k finds the agent through SSH_AGENT_PID
+		if output, err := exec.Command("ssh-agent", "-k").CombinedOutput(); err != nil {
+			fmt.Printf("failed to stop ssh-agent: %v\nOutput: %s\n", err, output)
+		}
+
+		os.Unsetenv("SSH_AUTH_SOCK")
+		os.Unsetenv("SSH_AGENT_PID")
+	}
 
-	return env, nil
+	return stop, nil
 }
 
-func addKeyToAgent(keyPath string, env []string) error {
+func addKeyToAgent(keyPath string) error {
 	addKeyCmd := exec.Command("ssh-add", keyPath)
-	addKeyCmd.Env = env
+	addKeyCmd.Env = append(os.Environ(), "SSH_ASKPASS=/bin/false", "DISPLAY=")
 
 	output, err := addKeyCmd.CombinedOutput()
 	if err != nil {
@@ -1083,11 +2590,13 @@ func addKeyToAgent(keyPath string, env []string) error {
 	return nil
 }
 
-func setupSSHKey(keyPath, privateKey string) error {
+// setupSSHKey installs the key and loads it into an ssh-agent. The returned
+// function stops the agent if setupSSHKey started it.
+func setupSSHKey(keyPath, privateKey string) (func(), error) {
 	fmt.Printf("Setting up SSH key at path: %s\n", keyPath)
 
 	if err := validateSSHKey(privateKey); err != nil {
-		return err
+		return nil, err
 	}
 
 	absPath := getAbsolutePath(keyPath)
@@ -1095,49 +2604,51 @@ func setupSSHKey(keyPath, privateKey string) error {
 
 	sshDir := filepath.Dir(absPath)
 	if err := os.MkdirAll(sshDir, sshDirPerm); err != nil {
-		return fmt.Errorf("failed to create SSH directory %s: %w", sshDir, err)
+		return nil, fmt.Errorf("failed to create SSH directory %s: %w", sshDir, err)
 	}
 
 	fmt.Printf("Created SSH directory: %s\n", sshDir)
 
 	tmpKeyPath := absPath + ".tmp"
 	if err := writeKeyFile(tmpKeyPath, privateKey, sshFilePerm); err != nil {
-		return fmt.Errorf("failed to write temporary key file %s: %w", tmpKeyPath, err)
+		return nil, fmt.Errorf("failed to write temporary key file %s: %w", tmpKeyPath, err)
 	}
 	defer os.Remove(tmpKeyPath)
 
 	fmt.Printf("Wrote temporary key file: %s\n", tmpKeyPath)
 
 	if err := convertKey(tmpKeyPath); err != nil {
-		return err
+		return nil, err
 	}
 
 	fmt.Printf("Successfully converted key\n")
 
 	keyBytes, err := os.ReadFile(tmpKeyPath)
 	if err != nil {
-		return fmt.Errorf("failed to read key file %s: %w", tmpKeyPath, err)
+		return nil, fmt.Errorf("failed to read key file %s: %w", tmpKeyPath, err)
 	}
 
 	keyStr := string(keyBytes)
 	if writeErr := writeKeyFile(absPath, keyStr, sshFilePerm); writeErr != nil {
-		return fmt.Errorf("failed to write SSH key file %s: %w", absPath, writeErr)
+		return nil, fmt.Errorf("failed to write SSH key file %s: %w", absPath, writeErr)
 	}
 
 	fmt.Printf("Wrote final key file: %s\n", absPath)
 
-	env, agentErr := setupSSHAgent()
+	stopAgent, agentErr := setupSSHAgent()
 	if agentErr != nil {
-		return agentErr
+		return nil, agentErr
 	}
 
-	fmt.Printf("Started ssh-agent\n")
+	fmt.Printf("Using ssh-agent at %s\n", os.Getenv("SSH_AUTH_SOCK"))
 
-	if addErr := addKeyToAgent(absPath, env); addErr != nil {
-		return addErr
+	if addErr := addKeyToAgent(absPath); addErr != nil {
+		stopAgent()
+
+		return nil, addErr
 	}
 
 	fmt.Printf("Successfully added key to ssh-agent\n")
 
-	return nil
+	return stopAgent, nil
 }
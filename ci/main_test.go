@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/digitalocean/godo"
+)
+
+const testDomain = "n8n.example.com"
+
+// testEnv is the minimal environment loadConfig needs.
+var testEnv = map[string]string{
+	"DIGITALOCEAN_ACCESS_TOKEN": "test-token",
+	"N8N_DOMAIN":                testDomain,
+	"DO_SSH_KEY_FINGERPRINT":    "aa:bb:cc",
+	"N8N_ENCRYPTION_KEY":        "9f86d081884c7d659a2feaa0c55ad015",
+}
+
+// testConfig loads the configuration the way a run does, from testEnv plus
+// env. loadConfig is slow, so tests that only need the defaults use
+// defaultTestConfig instead.
+func testConfig(t *testing.T, env map[string]string) *Config {
+	t.Helper()
+
+	t.Setenv("HOME", t.TempDir())
+
+	for key, value := range testEnv {
+		t.Setenv(key, value)
+	}
+
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+
+	config := loadConfig()
+
+	return &config
+}
+
+var (
+	defaultConfigOnce sync.Once
+	defaultConfig     Config
+)
+
+// defaultTestConfig returns a copy of the configuration loaded from testEnv
+// alone, which tests are free to change.
+func defaultTestConfig(t *testing.T) *Config {
+	t.Helper()
+
+	defaultConfigOnce.Do(func() {
+		restore := map[string]*string{}
+
+		for key, value := range testEnv {
+			if previous, found := os.LookupEnv(key); found {
+				restore[key] = &previous
+			} else {
+				restore[key] = nil
+			}
+
+			os.Setenv(key, value)
+		}
+
+		defaultConfig = loadConfig()
+
+		for key, previous := range restore {
+			if previous == nil {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, *previous)
+			}
+		}
+	})
+
+	config := defaultConfig
+	config.apiBreaker = &apiBreaker{threshold: config.apiFailureThreshold}
+	config.knownHostsPath = filepath.Join(t.TempDir(), knownHostsName)
+
+	return &config
+}
+
+// fakeDO serves canned DigitalOcean API responses keyed by "METHOD /path"
+// and records every request it receives, and the last body sent to each.
+type fakeDO struct {
+	mu        sync.Mutex
+	responses map[string]string
+	requests  []string
+	bodies    map[string]string
+}
+
+// newFakeDO starts a fake API and returns a client for config pointed at it.
+// Unknown paths answer 404 like the real API.
+func newFakeDO(t *testing.T, config *Config, responses map[string]string) (*fakeDO, *godo.Client) {
+	t.Helper()
+
+	fake := &fakeDO{responses: responses, bodies: map[string]string{}}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.Path
+		request, _ := io.ReadAll(r.Body)
+
+		fake.mu.Lock()
+		fake.requests = append(fake.requests, key)
+		fake.bodies[key] = string(request)
+		body, found := fake.responses[key]
+		fake.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			body = `{"id":"not_found","message":"The resource you were accessing could not be found."}`
+		}
+
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	client := newDOClient(config)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	return fake, client
+}
+
+// body returns the last request body sent as key.
+func (f *fakeDO) body(key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.bodies[key]
+}
+
+// mutations lists the requests that were not reads.
+func (f *fakeDO) mutations() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var mutations []string
+
+	for _, request := range f.requests {
+		if !strings.HasPrefix(request, http.MethodGet+" ") {
+			mutations = append(mutations, request)
+		}
+	}
+
+	return mutations
+}
+
+func TestSelectSSHKey(t *testing.T) {
+	keys := []godo.Key{
+		{ID: 1, Name: "deploy", Fingerprint: "aa:aa"},
+		{ID: 2, Name: "laptop", Fingerprint: "bb:bb"},
+		{ID: 3, Name: "shared", Fingerprint: "cc:cc"},
+		{ID: 4, Name: "shared", Fingerprint: "dd:dd"},
+	}
+
+	tests := []struct {
+		name        string
+		keyName     string
+		fingerprint string
+		wantID      int
+		wantErr     error
+	}{
+		{"by fingerprint", "", "bb:bb", 2, nil},
+		{"unknown fingerprint is created", "", "ee:ee", 0, nil},
+		{"by name", "deploy", "", 1, nil},
+		{"by name and matching fingerprint", "deploy", "aa:aa", 1, nil},
+		{"name and fingerprint disagree", "deploy", "bb:bb", 0, ErrSSHKeyMismatch},
+		{"unknown name", "ci", "", 0, ErrSSHKeyNotFound},
+		{"ambiguous name", "shared", "", 0, ErrAmbiguousSSHKey},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := selectSSHKey(keys, test.keyName, test.fingerprint)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("err = %v, want %v", err, test.wantErr)
+			}
+
+			id := 0
+			if key != nil {
+				id = key.ID
+			}
+
+			if id != test.wantID {
+				t.Errorf("selected key %d, want %d", id, test.wantID)
+			}
+		})
+	}
+}
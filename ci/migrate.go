@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/digitalocean/godo"
+
+	"github.com/felipepimentel/n8n-digitalocean-cicd/ci/ssh"
+)
+
+var (
+	ErrMigrateUsage = errors.New("usage: migrate --target DROPLET [--update-dns]")
+	ErrMigration    = errors.New("migration failed")
+)
+
+// migratedVolumes are copied as-is; the database is dumped and restored
+// instead so Postgres is never copied while running.
+var migratedVolumes = []string{"n8n_data", "caddy_data"}
+
+// runMigrate moves the n8n data from the configured droplet to another one:
+// /opt/n8n (compose file, .env, Caddyfile and /files), the n8n and Caddy
+// volumes, and a pg_dump of the database. n8n is stopped on the source for
+// the whole copy and restarted there if the migration fails.
+func runMigrate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	targetName := flags.String("target", "", "name of the droplet to move the data to")
+	updateDNS := flags.Bool("update-dns", false, "point N8N_DOMAIN at the target once it is healthy")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config := loadConfig()
+
+	if err := refuseDryRun(&config, "migrate"); err != nil {
+		return err
+	}
+
+	sourceName := config.resourceName(resourceDroplet)
+
+	if *targetName == "" || *targetName == sourceName {
+		return fmt.Errorf("%w: --target must name a droplet other than %s", ErrMigrateUsage, sourceName)
+	}
+
+	if config.deployMode != deployModeCompose {
+		return fmt.Errorf("%w: migrate only supports DEPLOY_MODE=%s", ErrInvalidConfig, deployModeCompose)
+	}
+
+	stopAgent, err := prepareSSHKey(&config)
+	if err != nil {
+		return err
+	}
+	defer stopAgent()
+
+	client := newDOClient(&config)
+
+	source, err := connectDroplet(ctx, client, sourceName, &config)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	targetDroplet, err := findDroplet(ctx, client, config.region, *targetName)
+	if err != nil {
+		return err
+	}
+
+	if targetDroplet == nil {
+		return fmt.Errorf("%w: %s", ErrDropletNotFound, *targetName)
+	}
+
+	targetIP, err := targetDroplet.PublicIPv4()
+	if err != nil {
+		return fmt.Errorf("failed to get droplet IP: %w", err)
+	}
+
+	target, err := connectSSH(ctx, targetIP, config.deploySSHUser, &config)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSSHClient, err)
+	}
+	defer target.Close()
+
+	if err := migrateData(ctx, source, target, &config); err != nil {
+		return err
+	}
+
+	fmt.Printf("Migrated n8n from %s to %s; n8n stays stopped on %s\n", sourceName, *targetName, sourceName)
+
+	if *updateDNS {
+		return configureAndVerifyDNS(ctx, client, &config, targetDroplet)
+	}
+
+	fmt.Printf("Point %s at %s to finish the move\n", config.domain, targetIP)
+
+	return nil
+}
+
+// connectDroplet looks a droplet up by name and connects to it as root.
+func connectDroplet(ctx context.Context, client *godo.Client, name string, config *Config) (*ssh.Client, error) {
+	droplet, err := findDroplet(ctx, client, config.region, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if droplet == nil {
+		return nil, fmt.Errorf("%w: %s", ErrDropletNotFound, name)
+	}
+
+	dropletIP, err := droplet.PublicIPv4()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get droplet IP: %w", err)
+	}
+
+	sshClient, err := connectSSH(ctx, dropletIP, config.deploySSHUser, config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSSHClient, err)
+	}
+
+	return sshClient, nil
+}
+
+func migrateData(ctx context.Context, source, target *ssh.Client, config *Config) error {
+	fmt.Println("Stopping n8n on the source droplet...")
+
+	if err := runMigrationStep(source, fmt.Sprintf("cd /opt/n8n\n%s\n%s stop -t %d n8n",
+		composeDetectScript(config), composeCmd, stopTimeoutSeconds(config))); err != nil {
+		return err
+	}
+
+	err := copyToTarget(ctx, source, target, config)
+	if err == nil {
+		return nil
+	}
+
+	fmt.Println("Migration failed, restarting n8n on the source droplet")
+
+	if restartErr := runMigrationStep(source, fmt.Sprintf("cd /opt/n8n\n%s\n%s start n8n",
+		composeDetectScript(config), composeCmd)); restartErr != nil {
+		return fmt.Errorf("%w (and restarting the source failed: %v)", err, restartErr)
+	}
+
+	return err
+}
+
+func copyToTarget(ctx context.Context, source, target *ssh.Client, config *Config) error {
+	// The target's own stack, if any, is replaced by the source's
+	if err := runMigrationStep(target, fmt.Sprintf(`if [ -f /opt/n8n/docker-compose.yml ]; then
+	cd /opt/n8n
+	%s
+	%s --profile new-install down -v
+fi
+mkdir -p /opt/n8n`, composeDetectScript(config), composeCmd)); err != nil {
+		return err
+	}
+
+	fmt.Println("Copying /opt/n8n...")
+
+	if err := streamBetween(source, target,
+		"tar -C /opt/n8n --exclude=./backups -cz .", "tar -C /opt/n8n -xz"); err != nil {
+		return err
+	}
+
+	for _, volume := range migratedVolumes {
+		name := config.composeProject + "_" + volume
+
+		fmt.Printf("Copying volume %s...\n", name)
+
+		if err := streamBetween(source, target,
+			fmt.Sprintf("docker run --rm -v %s:/data:ro alpine tar -C /data -cz .", name),
+			fmt.Sprintf("docker volume create %[1]s >/dev/null && docker run --rm -i -v %[1]s:/data alpine tar -C /data -xz",
+				name)); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Copying the database...")
+
+	if err := runMigrationStep(target, fmt.Sprintf(`set -e
+cd /opt/n8n
+%[1]s
+%[2]s --profile new-install up -d db
+timeout 120 sh -c 'until docker exec "$(docker ps -q %[3]s | head -n 1)" pg_isready -U n8n; do sleep 2; done'`,
+		composeDetectScript(config), composeCmd, serviceContainerFilter(config, "db"))); err != nil {
+		return err
+	}
+
+	if err := streamBetween(source, target,
+		fmt.Sprintf(`docker exec "$(docker ps -q %s | head -n 1)" pg_dump -U n8n -d n8n -Fc`, serviceContainerFilter(config, "db")),
+		fmt.Sprintf(`docker exec -i "$(docker ps -q %s | head -n 1)" pg_restore -U n8n -d n8n --clean --if-exists --no-owner`,
+			serviceContainerFilter(config, "db"))); err != nil {
+		return err
+	}
+
+	fmt.Println("Starting n8n on the target droplet...")
+
+	if err := runMigrationStep(target, fmt.Sprintf(`set -e
+%[1]s
+cd /opt/n8n
+%[2]s
+%[3]s --profile new-install up -d`, registryLoginCommand(config), composeDetectScript(config), composeCmd)); err != nil {
+		return err
+	}
+
+	return waitForN8NReady(ctx, target, config, true)
+}
+
+// runMigrationStep runs a script, streaming its output.
+func runMigrationStep(sshClient *ssh.Client, script string) error {
+	code, err := sshClient.Run(script, nil, os.Stdout, os.Stderr)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMigration, err)
+	}
+
+	if code != 0 {
+		return fmt.Errorf("%w: remote step exited with %d", ErrMigration, code)
+	}
+
+	return nil
+}
+
+// streamBetween pipes the output of send on source into receive on target,
+// so the data never touches the machine running the migration's disk.
+func streamBetween(source, target *ssh.Client, send, receive string) error {
+	reader, writer := io.Pipe()
+	sent := make(chan error, 1)
+
+	go func() {
+		code, err := source.Run("set -o pipefail; "+send, nil, writer, os.Stderr)
+		if err == nil && code != 0 {
+			err = fmt.Errorf("%w: %q exited with %d on the source", ErrMigration, send, code)
+		}
+
+		writer.CloseWithError(err)
+		sent <- err
+	}()
+
+	code, err := target.Run(receive, reader, os.Stdout, os.Stderr)
+	if err == nil && code != 0 {
+		err = fmt.Errorf("%w: %q exited with %d on the target", ErrMigration, receive, code)
+	}
+
+	// Unblock the sender if the target stopped reading early
+	reader.CloseWithError(io.ErrClosedPipe)
+
+	if sendErr := <-sent; sendErr != nil {
+		return sendErr
+	}
+
+	return err
+}
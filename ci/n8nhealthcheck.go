@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultN8NHealthcheckCommand probes /healthz with node, the one binary
+// every n8n image ships; curl is not installed in it.
+const defaultN8NHealthcheckCommand = `node -e "require('http').get('http://127.0.0.1:5678/healthz', ` +
+	`(res) => process.exit(res.statusCode === 200 ? 0 : 1)).on('error', () => process.exit(1))"`
+
+const (
+	defaultN8NHealthcheckInterval = 30 * time.Second
+	defaultN8NHealthcheckTimeout  = 10 * time.Second
+	defaultN8NHealthcheckRetries  = 3
+)
+
+// n8nHealthcheck renders the n8n service's healthcheck. The command runs
+// through the container's shell; "$" is doubled so compose does not
+// interpolate it.
+func n8nHealthcheck(config *Config) string {
+	command := strings.ReplaceAll(config.n8nHealthcheckCommand, "$", "$$")
+
+	return fmt.Sprintf(`
+    healthcheck:
+      test: ["CMD-SHELL", %s]
+      interval: %s
+      timeout: %s
+      retries: %d
+      start_period: %s`, strconv.Quote(command), config.n8nHealthcheckInterval, config.n8nHealthcheckTimeout,
+		config.n8nHealthcheckRetries, config.startPeriod)
+}
+
+// validateN8NHealthcheck requires a single-line command, positive timings
+// and at least one retry.
+func validateN8NHealthcheck(config *Config) error {
+	command := strings.TrimSpace(config.n8nHealthcheckCommand)
+	if command == "" || strings.ContainsAny(command, "\r\n") {
+		return fmt.Errorf("%w: N8N_HEALTHCHECK_COMMAND must be a single non-empty line", ErrInvalidConfig)
+	}
+
+	if config.n8nHealthcheckInterval <= 0 {
+		return fmt.Errorf("%w: N8N_HEALTHCHECK_INTERVAL must be positive, got %s",
+			ErrInvalidConfig, config.n8nHealthcheckInterval)
+	}
+
+	if config.n8nHealthcheckTimeout <= 0 {
+		return fmt.Errorf("%w: N8N_HEALTHCHECK_TIMEOUT must be positive, got %s",
+			ErrInvalidConfig, config.n8nHealthcheckTimeout)
+	}
+
+	if config.n8nHealthcheckRetries < 1 {
+		return fmt.Errorf("%w: N8N_HEALTHCHECK_RETRIES must be at least 1, got %d",
+			ErrInvalidConfig, config.n8nHealthcheckRetries)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestN8NHealthcheckDefault(t *testing.T) {
+	config := defaultTestConfig(t)
+
+	compose := generateDockerComposeContent(config)
+
+	for _, want := range []string{
+		"require('http').get('http://127.0.0.1:5678/healthz'",
+		"interval: 30s",
+		"timeout: 10s",
+		"retries: 3",
+	} {
+		if !strings.Contains(compose, want) {
+			t.Errorf("compose file has no %q", want)
+		}
+	}
+
+	if strings.Contains(compose, "curl") {
+		t.Error("the default healthcheck uses curl, which the n8n image lacks")
+	}
+}
+
+func TestN8NHealthcheckOverride(t *testing.T) {
+	config := testConfig(t, map[string]string{
+		"N8N_HEALTHCHECK_COMMAND":  `wget -qO- "http://localhost:$N8N_PORT/healthz"`,
+		"N8N_HEALTHCHECK_INTERVAL": "1m",
+		"N8N_HEALTHCHECK_TIMEOUT":  "5s",
+		"N8N_HEALTHCHECK_RETRIES":  "5",
+	})
+
+	if err := validateN8NHealthcheck(config); err != nil {
+		t.Fatal(err)
+	}
+
+	healthcheck := n8nHealthcheck(config)
+
+	for _, want := range []string{
+		`test: ["CMD-SHELL", "wget -qO- \"http://localhost:$$N8N_PORT/healthz\""]`,
+		"interval: 1m0s",
+		"timeout: 5s",
+		"retries: 5",
+	} {
+		if !strings.Contains(healthcheck, want) {
+			t.Errorf("healthcheck has no %q:\n%s", want, healthcheck)
+		}
+	}
+}
+
+func TestValidateN8NHealthcheckRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Config)
+	}{
+		{"empty command", func(c *Config) { c.n8nHealthcheckCommand = "  " }},
+		{"newline", func(c *Config) { c.n8nHealthcheckCommand = "true\nrm -rf /" }},
+		{"carriage return", func(c *Config) { c.n8nHealthcheckCommand = "true\rfalse" }},
+		{"zero interval", func(c *Config) { c.n8nHealthcheckInterval = 0 }},
+		{"negative timeout", func(c *Config) { c.n8nHealthcheckTimeout = -time.Second }},
+		{"no retries", func(c *Config) { c.n8nHealthcheckRetries = 0 }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := defaultTestConfig(t)
+			test.change(config)
+
+			if err := validateN8NHealthcheck(config); !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("err = %v, want ErrInvalidConfig", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const defaultDeployPrefix = "n8n-production"
+
+// Tags every droplet carries besides its resource tag. managedTag marks
+// droplets created by this tool.
+const (
+	managedTag     = "n8n"
+	environmentTag = "production"
+)
+
+// Resource kinds understood by resourceName.
+const (
+	resourceDroplet    = "droplet"
+	resourceVPC        = "vpc"
+	resourceFirewall   = "firewall"
+	resourceSSHKey     = "key"
+	resourceVolume     = "volume"
+	resourceReservedIP = "reserved-ip"
+	resourceTag        = "tag"
+	resourceProject    = "project"
+)
+
+// deployPrefixPattern keeps prefixes valid as droplet hostnames, resource tags
+// and (lowercased) compose project names alike.
+var deployPrefixPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*$`)
+
+// resourceName derives the name of a resource from DEPLOY_PREFIX so two
+// environments with different prefixes never collide.
+func (c *Config) resourceName(kind string) string {
+	switch kind {
+	case resourceDroplet:
+		if c.dropletName != "" {
+			return c.dropletName
+		}
+
+		return c.deployPrefix
+	case resourceTag:
+		return c.deployPrefix
+	case resourceProject:
+		return strings.ToLower(c.deployPrefix)
+	default:
+		return c.deployPrefix + "-" + kind
+	}
+}
+
+func validateDeployPrefix(prefix string) error {
+	if !deployPrefixPattern.MatchString(prefix) {
+		return fmt.Errorf("%w: DEPLOY_PREFIX must contain only letters, digits and '-' "+
+			"and start with a letter or digit, got %q", ErrInvalidConfig, prefix)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResourceNamesFollowTheDeployPrefix(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.deployPrefix = "Staging-EU"
+
+	want := map[string]string{
+		resourceDroplet:    "Staging-EU",
+		resourceTag:        "Staging-EU",
+		resourceProject:    "staging-eu",
+		resourceVPC:        "Staging-EU-vpc",
+		resourceFirewall:   "Staging-EU-firewall",
+		resourceSSHKey:     "Staging-EU-key",
+		resourceVolume:     "Staging-EU-volume",
+		resourceReservedIP: "Staging-EU-reserved-ip",
+	}
+
+	for kind, name := range want {
+		if got := config.resourceName(kind); got != name {
+			t.Errorf("resourceName(%s) = %q, want %q", kind, got, name)
+		}
+	}
+
+	if volume := dataVolumeName(config); volume != "staging-eu-volume" {
+		t.Errorf("dataVolumeName = %q, want it lowercased", volume)
+	}
+}
+
+func TestDeployPrefixDefaultsAndFallback(t *testing.T) {
+	if config := defaultTestConfig(t); config.deployPrefix != defaultDeployPrefix {
+		t.Errorf("deployPrefix = %q, want the default %q", config.deployPrefix, defaultDeployPrefix)
+	}
+
+	if config := testConfig(t, map[string]string{"DROPLET_NAME": "legacy"}); config.deployPrefix != "legacy" {
+		t.Errorf("deployPrefix = %q, want DROPLET_NAME as the fallback", config.deployPrefix)
+	}
+}
+
+func TestValidateDeployPrefix(t *testing.T) {
+	for _, prefix := range []string{"n8n-production", "eu1", "A"} {
+		if err := validateDeployPrefix(prefix); err != nil {
+			t.Errorf("DEPLOY_PREFIX=%q: %v", prefix, err)
+		}
+	}
+
+	for _, prefix := range []string{"", "-n8n", "n8n_prod", "n8n.prod", "n8n prod"} {
+		if err := validateDeployPrefix(prefix); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("DEPLOY_PREFIX=%q: err = %v, want ErrInvalidConfig", prefix, err)
+		}
+	}
+}
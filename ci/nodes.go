@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+const (
+	// n8n resolves community nodes baked into the image from its own
+	// node_modules, which unlike ~/.n8n/nodes is not shadowed by the volume.
+	n8nPackageDir = "/usr/local/lib/node_modules/n8n"
+	n8nImageUser  = "node"
+	n8nImageHome  = "/home/node"
+
+	// defaultN8NUserFolder is where the n8n_data volume is mounted. n8n keeps
+	// its files in a .n8n directory below N8N_USER_FOLDER.
+	defaultN8NUserFolder = n8nImageHome + "/.n8n"
+)
+
+// userFolderPattern keeps N8N_USER_FOLDER safe to embed in the compose file.
+var userFolderPattern = regexp.MustCompile(`^/[A-Za-z0-9._/-]*$`)
+
+// communityNodePattern matches an npm package name with an optional version
+// or range, e.g. n8n-nodes-foo, @scope/n8n-nodes-bar@1.2.3.
+var communityNodePattern = regexp.MustCompile(
+	`^(@[a-z0-9-~][a-z0-9-._~]*/)?[a-z0-9-~][a-z0-9-._~]*(@[A-Za-z0-9.^~<>=*-]+)?$`)
+
+// withCommunityNodes npm-installs the given packages into the n8n image and
+// fails the build if any of them is missing afterwards.
+func withCommunityNodes(container *dagger.Container, packages []string) *dagger.Container {
+	if len(packages) == 0 {
+		return container
+	}
+
+	installArgs := append([]string{"npm", "install", "--omit=dev", "--no-audit", "--no-fund"}, packages...)
+
+	// npm ls exits non-zero when a requested package is not installed
+	verifyArgs := []string{"npm", "ls", "--depth=0"}
+	for _, pkg := range packages {
+		verifyArgs = append(verifyArgs, communityNodeName(pkg))
+	}
+
+	return container.
+		WithUser("root").
+		WithWorkdir(n8nPackageDir).
+		WithExec(installArgs, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		WithExec(verifyArgs, dagger.ContainerWithExecOpts{SkipEntrypoint: true}).
+		WithWorkdir(n8nImageHome).
+		WithUser(n8nImageUser)
+}
+
+// communityNodeName strips the version suffix from a package spec.
+func communityNodeName(spec string) string {
+	if idx := strings.LastIndex(spec, "@"); idx > 0 {
+		return spec[:idx]
+	}
+
+	return spec
+}
+
+func validateCommunityNodes(packages []string) error {
+	for _, pkg := range packages {
+		if !communityNodePattern.MatchString(pkg) {
+			return fmt.Errorf("%w: N8N_COMMUNITY_NODES entry %q is not a valid npm package", ErrInvalidConfig, pkg)
+		}
+	}
+
+	return nil
+}
+
+func validateUserFolder(folder string) error {
+	if !userFolderPattern.MatchString(folder) || path.Clean(folder) != folder || folder == "/" {
+		return fmt.Errorf("%w: N8N_USER_FOLDER must be a clean absolute path other than /, got %q",
+			ErrInvalidConfig, folder)
+	}
+
+	return nil
+}
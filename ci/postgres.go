@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const postgresImage = "postgres:13"
+
+// defaultPostgresSettings tune postgres:13 for n8n on a 2 GB droplet shared
+// with n8n and Caddy: a quarter of the memory for shared buffers and fewer
+// connections than the default 100, since n8n uses a small pool.
+var defaultPostgresSettings = map[string]string{
+	"shared_buffers":       "512MB",
+	"effective_cache_size": "1GB",
+	"work_mem":             "8MB",
+	"maintenance_work_mem": "128MB",
+	"max_connections":      "50",
+}
+
+var (
+	postgresSettingNamePattern  = regexp.MustCompile(`^[a-z][a-z0-9_.]*$`)
+	postgresSettingValuePattern = regexp.MustCompile(`^[A-Za-z0-9_.:/+-]+$`)
+)
+
+// postgresSettings merges POSTGRES_SETTINGS over the default profile, which
+// POSTGRES_DEFAULT_TUNING=false leaves out.
+func postgresSettings(config *Config) (map[string]string, error) {
+	settings := map[string]string{}
+	if config.postgresDefaultTuning {
+		maps.Copy(settings, defaultPostgresSettings)
+	}
+
+	for _, entry := range config.postgresSettings {
+		name, value, found := strings.Cut(entry, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+
+		if !found || !postgresSettingNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("%w: POSTGRES_SETTINGS entry %q must be name=value", ErrInvalidConfig, entry)
+		}
+
+		if !postgresSettingValuePattern.MatchString(value) {
+			return nil, fmt.Errorf("%w: POSTGRES_SETTINGS value for %s must be a plain value such as 256MB, got %q",
+				ErrInvalidConfig, name, value)
+		}
+
+		settings[name] = value
+	}
+
+	return settings, nil
+}
+
+// postgresCommand renders the db service's command, passing each setting as
+// -c name=value. Names are sorted so the compose file stays stable.
+func postgresCommand(config *Config) string {
+	// Validated with the rest of the configuration
+	settings, _ := postgresSettings(config)
+	if len(settings) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	args := []string{`"postgres"`}
+	for _, name := range names {
+		args = append(args, `"-c"`, fmt.Sprintf(`"%s=%s"`, name, settings[name]))
+	}
+
+	return "\n    command: [" + strings.Join(args, ", ") + "]"
+}
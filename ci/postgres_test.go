@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPostgresOverridesReachTheDBService(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.postgresSettings = []string{"shared_buffers=256MB", " max_wal_size = 2GB "}
+
+	db := generateDBServiceConfig(config)
+
+	want := `command: ["postgres", "-c", "effective_cache_size=1GB", "-c", "maintenance_work_mem=128MB", ` +
+		`"-c", "max_connections=50", "-c", "max_wal_size=2GB", "-c", "shared_buffers=256MB", "-c", "work_mem=8MB"]`
+	if !strings.Contains(db, want) {
+		t.Errorf("db service has no\n%s\nin\n%s", want, db)
+	}
+
+	if n8n := generateN8NServiceConfig(config); strings.Contains(n8n, "shared_buffers") {
+		t.Error("Postgres settings leaked into the n8n service")
+	}
+}
+
+func TestPostgresWithoutDefaultTuning(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.postgresDefaultTuning = false
+
+	if db := generateDBServiceConfig(config); strings.Contains(db, "command:") {
+		t.Errorf("db service overrides the command without any settings:\n%s", db)
+	}
+
+	config.postgresSettings = []string{"work_mem=16MB"}
+
+	if db := generateDBServiceConfig(config); !strings.Contains(db, `command: ["postgres", "-c", "work_mem=16MB"]`) {
+		t.Errorf("db service does not pass only the override:\n%s", db)
+	}
+}
+
+func TestPostgresSettingsRejectsUnsafeEntries(t *testing.T) {
+	for _, entry := range []string{"shared_buffers", "Shared=1", `work_mem=8MB" ; rm`, "work_mem=$(id)"} {
+		config := defaultTestConfig(t)
+		config.postgresSettings = []string{entry}
+
+		if _, err := postgresSettings(config); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("POSTGRES_SETTINGS=%q: err = %v, want ErrInvalidConfig", entry, err)
+		}
+	}
+}
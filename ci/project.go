@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	projectPurpose     = "Web Application"
+	projectEnvironment = "Production"
+	projectsPerPage    = 200
+)
+
+// assignToProject moves the droplet, its attached volumes, RESERVED_IP and the
+// managed domain into the DO_PROJECT project, creating the project if needed.
+func assignToProject(ctx context.Context, client *godo.Client, config *Config, droplet *godo.Droplet) error {
+	if config.doProject == "" {
+		return nil
+	}
+
+	project, err := ensureProject(ctx, client, config)
+	if err != nil {
+		return err
+	}
+
+	resources := []interface{}{droplet.URN()}
+
+	for _, volumeID := range droplet.VolumeIDs {
+		resources = append(resources, godo.ToURN("Volume", volumeID))
+	}
+
+	if config.reservedIP != "" {
+		resources = append(resources, godo.ReservedIP{IP: config.reservedIP}.URN())
+	}
+
+	// Only a DigitalOcean domain is a project resource
+	if config.manageDNS && config.dnsProvider == dnsProviderDigitalOcean {
+		rootDomain, _ := getDomainParts(config.domain)
+		resources = append(resources, godo.Domain{Name: rootDomain}.URN())
+	}
+
+	if _, _, err := client.Projects.AssignResources(ctx, project.ID, resources...); err != nil {
+		return fmt.Errorf("failed to assign resources to project %s: %w", config.doProject, err)
+	}
+
+	return nil
+}
+
+func ensureProject(ctx context.Context, client *godo.Client, config *Config) (*godo.Project, error) {
+	projects, _, err := client.Projects.List(ctx, &godo.ListOptions{PerPage: projectsPerPage})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list projects: %w", err)
+	}
+
+	for i := range projects {
+		if projects[i].Name == config.doProject {
+			return &projects[i], nil
+		}
+	}
+
+	project, _, err := client.Projects.Create(ctx, &godo.CreateProjectRequest{
+		Name:        config.doProject,
+		Description: "n8n deployment for " + config.domain,
+		Purpose:     projectPurpose,
+		Environment: projectEnvironment,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create project %s: %w", config.doProject, err)
+	}
+
+	fmt.Printf("Created project %s\n", config.doProject)
+
+	return project, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestAssignToProject(t *testing.T) {
+	const assign = "POST /v2/projects/p-1/resources"
+
+	tests := []struct {
+		name       string
+		reservedIP string
+		want       []string
+		wantAbsent []string
+	}{
+		{
+			name:       "droplet and volume",
+			want:       []string{"do:droplet:42", "do:volume:vol-1"},
+			wantAbsent: []string{"do:reservedip:"},
+		},
+		{
+			name:       "reserved IP",
+			reservedIP: "203.0.113.10",
+			want:       []string{"do:droplet:42", "do:reservedip:203.0.113.10"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := defaultTestConfig(t)
+			config.doProject = "n8n"
+			config.reservedIP = test.reservedIP
+			config.manageDNS = false
+
+			fake, client := newFakeDO(t, config, map[string]string{
+				"GET /v2/projects": `{"projects":[{"id":"p-1","name":"n8n"}]}`,
+				assign:             `{"resources":[]}`,
+			})
+
+			droplet := &godo.Droplet{ID: 42, VolumeIDs: []string{"vol-1"}}
+			if err := assignToProject(context.Background(), client, config, droplet); err != nil {
+				t.Fatal(err)
+			}
+
+			body := fake.body(assign)
+
+			for _, urn := range test.want {
+				if !strings.Contains(body, `"`+urn+`"`) {
+					t.Errorf("assigned %s, want %s", body, urn)
+				}
+			}
+
+			for _, urn := range test.wantAbsent {
+				if strings.Contains(body, urn) {
+					t.Errorf("assigned %s, want no %s", body, urn)
+				}
+			}
+		})
+	}
+}
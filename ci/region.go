@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+// regionSlugPattern matches DigitalOcean region slugs such as nyc1 or fra1.
+var regionSlugPattern = regexp.MustCompile(`^[a-z]{3}[0-9]$`)
+
+var (
+	ErrRegionUnavailable = errors.New("region is not available")
+	ErrSizeUnavailable   = errors.New("droplet size is not available in the region")
+)
+
+// applyGlobalFlags consumes flags accepted before any subcommand, such as
+// --region fra1, and returns the remaining arguments. --region overrides
+// DO_REGION for whichever command runs.
+func applyGlobalFlags(args []string) []string {
+	for len(args) > 0 {
+		value, found := strings.CutPrefix(args[0], "--region=")
+
+		switch {
+		case found:
+			args = args[1:]
+		case args[0] == "--region" && len(args) > 1:
+			value, args = args[1], args[2:]
+		default:
+			return args
+		}
+
+		os.Setenv("DO_REGION", value)
+	}
+
+	return args
+}
+
+// verifyRegionAndSize checks, before anything is created, that the region
+// accepts new droplets and offers DROPLET_SIZE, listing the sizes it does
+// offer otherwise.
+func verifyRegionAndSize(ctx context.Context, client *godo.Client, config *Config) error {
+	regions, _, err := client.Regions.List(ctx, &godo.ListOptions{PerPage: listPerPage})
+	if err != nil {
+		return fmt.Errorf("failed to list regions: %w", err)
+	}
+
+	index := slices.IndexFunc(regions, func(region godo.Region) bool { return region.Slug == config.region })
+	if index < 0 || !regions[index].Available {
+		return fmt.Errorf("%w: %s", ErrRegionUnavailable, config.region)
+	}
+
+	sizes, _, err := client.Sizes.List(ctx, &godo.ListOptions{PerPage: listPerPage})
+	if err != nil {
+		return fmt.Errorf("failed to list droplet sizes: %w", err)
+	}
+
+	var offered []string
+
+	for _, size := range sizes {
+		if !size.Available || !slices.Contains(size.Regions, config.region) {
+			continue
+		}
+
+		if size.Slug == config.dropletSize {
+			return nil
+		}
+
+		offered = append(offered, size.Slug)
+	}
+
+	return fmt.Errorf("%w: %s in %s; sizes offered there: %s",
+		ErrSizeUnavailable, config.dropletSize, config.region, strings.Join(offered, ", "))
+}
+
+func validateRegion(config *Config) error {
+	if !regionSlugPattern.MatchString(config.region) {
+		return fmt.Errorf("%w: DO_REGION must be a region slug such as %s, got %q",
+			ErrInvalidConfig, defaultRegion, config.region)
+	}
+
+	if config.dropletSize == "" {
+		return fmt.Errorf("%w: DROPLET_SIZE must not be empty", ErrInvalidConfig)
+	}
+
+	return nil
+}
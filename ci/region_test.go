@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// plannedDetail returns the detail of the plan's create for resource.
+func plannedDetail(t *testing.T, steps plan, resource string) string {
+	t.Helper()
+
+	for _, entry := range steps {
+		if entry.action == planCreate && entry.resource == resource {
+			return entry.detail
+		}
+	}
+
+	t.Fatalf("plan has no create for %s:\n%v", resource, steps)
+
+	return ""
+}
+
+func TestVPCAndDropletShareTheRegion(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"default", nil, defaultRegion},
+		{"DO_REGION", map[string]string{"DO_REGION": "fra1"}, "fra1"},
+		{"DROPLET_REGION fallback", map[string]string{"DROPLET_REGION": "sfo3"}, "sfo3"},
+		{"DO_REGION over DROPLET_REGION", map[string]string{"DO_REGION": "ams3", "DROPLET_REGION": "sfo3"}, "ams3"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := testConfig(t, test.env)
+			config.dryRun = true
+			config.sshKeyPath = filepath.Join(t.TempDir(), "id_ed25519.pub")
+
+			if err := os.WriteFile(config.sshKeyPath, []byte("ssh-ed25519 AAAA test"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			if config.region != test.want {
+				t.Fatalf("region = %q, want %q", config.region, test.want)
+			}
+
+			steps, infra := dryRun(t, config, accountResponses(config))
+
+			vpc := plannedDetail(t, steps, "vpc "+config.resourceName(resourceVPC))
+			droplet := plannedDetail(t, steps, "droplet "+config.resourceName(resourceDroplet))
+
+			if !strings.HasSuffix(vpc, " in "+test.want) {
+				t.Errorf("vpc planned as %q, want it in %s", vpc, test.want)
+			}
+
+			if !strings.Contains(droplet, " in "+test.want+" ") {
+				t.Errorf("droplet planned as %q, want it in %s", droplet, test.want)
+			}
+
+			if infra.droplet.Region.Slug != test.want {
+				t.Errorf("droplet region = %q, want %q", infra.droplet.Region.Slug, test.want)
+			}
+		})
+	}
+}
+
+func TestCreateVPCRejectsAVPCInAnotherRegion(t *testing.T) {
+	config := defaultTestConfig(t)
+
+	_, client := newFakeDO(t, config, map[string]string{
+		"GET /v2/vpcs": `{"vpcs":[{"id":"vpc-1","name":"` + config.resourceName(resourceVPC) +
+			`","region":"sfo3"}]}`,
+	})
+
+	if _, err := createVPC(context.Background(), client, config); !errors.Is(err, ErrVPCRegionMismatch) {
+		t.Fatalf("err = %v, want ErrVPCRegionMismatch", err)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	n8nRepository       = "n8n"
+	registryTagsPerPage = 200
+
+	defaultRegistryCredentialAttempts = 5
+	defaultRegistryCredentialTimeout  = 2 * time.Minute
+
+	// registryLoginUser is the user name the droplet logs in with. The
+	// registry authenticates the API token given as the password, so the
+	// token itself stays off the command line.
+	registryLoginUser = "n8n-deploy"
+)
+
+// exitRegistryUnavailable is the exit code when the account cannot have a
+// registry, so pipelines can tell it apart from transient failures.
+const exitRegistryUnavailable = 3
+
+var (
+	ErrRegistryAccessDenied = errors.New("registry access denied: check the token has registry read/write scope")
+	ErrRegistryUnavailable  = errors.New("the account cannot create a container registry")
+)
+
+// registryUnavailableHints are fragments of the API messages returned when
+// Container Registry is not enabled for the account or its limit is reached.
+var registryUnavailableHints = []string{
+	"not available",
+	"not enabled",
+	"limit",
+	"quota",
+	"exceed",
+	"already has a registry",
+}
+
+// registryAccess fetches read/write Docker credentials and the registry name.
+// A registry created moments ago can answer 404 or with empty values for a
+// while, so those are retried up to REGISTRY_CREDENTIALS_ATTEMPTS within
+// REGISTRY_CREDENTIALS_TIMEOUT; auth and permission errors fail at once.
+func registryAccess(ctx context.Context, client *godo.Client, config *Config) (*godo.DockerCredentials, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.registryCredentialTimeout)
+	defer cancel()
+
+	var credentials *godo.DockerCredentials
+
+	err := retryWithBackoff(ctx, config.registryCredentialAttempts, apiRetryDelay, func() error {
+		var err error
+
+		credentials, _, err = client.Registry.DockerCredentials(ctx, &godo.RegistryDockerCredentialsRequest{
+			ReadWrite: true,
+		})
+		if err != nil {
+			return registryError(err)
+		}
+
+		if credentials == nil || len(credentials.DockerConfigJSON) == 0 {
+			return retryable(ErrEmptyCredentials)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get registry credentials: %w", err)
+	}
+
+	var registryName string
+
+	err = retryWithBackoff(ctx, config.registryCredentialAttempts, apiRetryDelay, func() error {
+		registry, _, err := client.Registry.Get(ctx)
+		if err != nil {
+			return registryError(err)
+		}
+
+		if registry == nil || registry.Name == "" {
+			return retryable(ErrRegistryEmpty)
+		}
+
+		registryName = registry.Name
+
+		return nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get registry info: %w", err)
+	}
+
+	return credentials, registryName, nil
+}
+
+// registryError classifies a registry API error: not found means the new
+// registry is not ready yet, auth failures will not fix themselves.
+func registryError(err error) error {
+	var apiErr *godo.ErrorResponse
+	if !errors.As(err, &apiErr) || apiErr.Response == nil {
+		return err
+	}
+
+	switch apiErr.Response.StatusCode {
+	case http.StatusNotFound:
+		return retryable(err)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: %w", ErrRegistryAccessDenied, err)
+	default:
+		return err
+	}
+}
+
+// registryCreateError turns the errors DigitalOcean returns when Container
+// Registry is not enabled for the account, or the account is at its registry
+// limit, into ErrRegistryUnavailable with what to do about it.
+func registryCreateError(err error) error {
+	var apiErr *godo.ErrorResponse
+	if !errors.As(err, &apiErr) || apiErr.Response == nil {
+		return err
+	}
+
+	switch apiErr.Response.StatusCode {
+	case http.StatusPaymentRequired, http.StatusPreconditionFailed:
+	case http.StatusForbidden, http.StatusConflict, http.StatusUnprocessableEntity:
+		if !containsAny(strings.ToLower(apiErr.Message), registryUnavailableHints) {
+			return err
+		}
+	default:
+		return err
+	}
+
+	return &exitCodeError{code: exitRegistryUnavailable, err: fmt.Errorf("%w: %s. Enable Container Registry "+
+		"for the account at https://cloud.digitalocean.com/registry, or reuse the account's existing registry "+
+		"if it is at its registry limit: %w", ErrRegistryUnavailable, apiErr.Message, err)}
+}
+
+func containsAny(value string, fragments []string) bool {
+	for _, fragment := range fragments {
+		if strings.Contains(value, fragment) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dockerConfigForRegistry makes the Docker config DigitalOcean returns, whose
+// auth is keyed by its default endpoint, also apply to REGISTRY_URL.
+func dockerConfigForRegistry(data []byte, registryURL string) (string, error) {
+	if registryURL == defaultRegistryURL {
+		return string(data), nil
+	}
+
+	var dockerConfig map[string]any
+	if err := json.Unmarshal(data, &dockerConfig); err != nil {
+		return "", fmt.Errorf("failed to parse registry credentials: %w", err)
+	}
+
+	if auths, ok := dockerConfig["auths"].(map[string]any); ok {
+		if auth, found := auths[defaultRegistryURL]; found {
+			auths[registryURL] = auth
+		}
+	}
+
+	rewritten, err := json.Marshal(dockerConfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode registry credentials: %w", err)
+	}
+
+	return string(rewritten), nil
+}
+
+// validateRegistryURL accepts a hostname with an optional port, without a
+// scheme or path.
+func validateRegistryURL(registryURL string) error {
+	host, port, hasPort := strings.Cut(registryURL, ":")
+
+	if strings.Contains(registryURL, "/") {
+		return fmt.Errorf("%w: REGISTRY_URL must be a hostname without scheme or path, got %q",
+			ErrInvalidConfig, registryURL)
+	}
+
+	if hasPort {
+		if number, err := strconv.Atoi(port); err != nil || number < 1 || number > maxPort {
+			return fmt.Errorf("%w: REGISTRY_URL %q has an invalid port", ErrInvalidConfig, registryURL)
+		}
+	}
+
+	return validateHostname("REGISTRY_URL", host, minDomainParts)
+}
+
+func validateRegistryCredentialConfig(config *Config) error {
+	if config.registryCredentialAttempts < 1 {
+		return fmt.Errorf("%w: REGISTRY_CREDENTIALS_ATTEMPTS must be at least 1, got %d",
+			ErrInvalidConfig, config.registryCredentialAttempts)
+	}
+
+	if config.registryCredentialTimeout <= 0 {
+		return fmt.Errorf("%w: REGISTRY_CREDENTIALS_TIMEOUT must be positive, got %s",
+			ErrInvalidConfig, config.registryCredentialTimeout)
+	}
+
+	return nil
+}
+
+// listRepositoryTags returns every tag in the repository across all pages.
+func listRepositoryTags(ctx context.Context, client *godo.Client, registryName, repository string) ([]*godo.RepositoryTag, error) {
+	var tags []*godo.RepositoryTag
+
+	opts := &godo.ListOptions{PerPage: registryTagsPerPage}
+
+	for {
+		page, resp, err := client.Registry.ListRepositoryTags(ctx, registryName, repository, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags for %s/%s: %w", registryName, repository, err)
+		}
+
+		tags = append(tags, page...)
+
+		if resp == nil || resp.Links == nil || resp.Links.IsLastPage() {
+			return tags, nil
+		}
+
+		currentPage, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to paginate tags for %s/%s: %w", registryName, repository, err)
+		}
+
+		opts.Page = currentPage + 1
+	}
+}
+
+// listRepositoryManifests returns every manifest in the repository across
+// all pages.
+func listRepositoryManifests(ctx context.Context, client *godo.Client, registryName, repository string) ([]*godo.RepositoryManifest, error) {
+	var manifests []*godo.RepositoryManifest
+
+	opts := &godo.ListOptions{PerPage: registryTagsPerPage}
+
+	for {
+		page, resp, err := client.Registry.ListRepositoryManifests(ctx, registryName, repository, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list manifests for %s/%s: %w", registryName, repository, err)
+		}
+
+		manifests = append(manifests, page...)
+
+		if resp == nil || resp.Links == nil || resp.Links.IsLastPage() {
+			return manifests, nil
+		}
+
+		currentPage, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to paginate manifests for %s/%s: %w", registryName, repository, err)
+		}
+
+		opts.Page = currentPage + 1
+	}
+}
+
+// pruneImageTags keeps the newest keep tags of the n8n repository and deletes
+// the rest. Protected tags (such as the ones just pushed) are never deleted
+// and do not count towards keep.
+func pruneImageTags(ctx context.Context, client *godo.Client, registryName string, keep int, protected ...string) error {
+	tags, err := listRepositoryTags(ctx, client, registryName, n8nRepository)
+	if err != nil {
+		return err
+	}
+
+	isProtected := make(map[string]bool, len(protected))
+	for _, tag := range protected {
+		isProtected[tag] = true
+	}
+
+	var candidates []*godo.RepositoryTag
+
+	for _, tag := range tags {
+		// Signatures are removed with their image by garbage collection
+		if !isProtected[tag.Tag] && !isSignatureTag(tag.Tag) {
+			candidates = append(candidates, tag)
+		}
+	}
+
+	// Newest first, so everything past keep is the oldest
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].UpdatedAt.After(candidates[j].UpdatedAt)
+	})
+
+	if len(candidates) <= keep {
+		return nil
+	}
+
+	for _, tag := range candidates[keep:] {
+		if _, err := client.Registry.DeleteTag(ctx, registryName, n8nRepository, tag.Tag); err != nil {
+			return fmt.Errorf("failed to delete tag %s: %w", tag.Tag, err)
+		}
+
+		fmt.Printf("Pruned image tag %s/%s:%s (updated %s)\n",
+			registryName, n8nRepository, tag.Tag, tag.UpdatedAt.Format("2006-01-02"))
+	}
+
+	fmt.Println("Run registry garbage collection to reclaim the space used by pruned tags.")
+
+	return nil
+}
+
+// registryLoginCommand logs the droplet's Docker daemon in to the registry.
+// printf is a shell builtin, so the token reaches docker on stdin without
+// showing up in the process list or audit logs as an argument.
+func registryLoginCommand(config *Config) string {
+	return fmt.Sprintf("printf '%%s' %s | docker login %s -u %s --password-stdin",
+		shellQuote(config.doToken), config.registryURL, registryLoginUser)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/godo"
+)
+
+const testRegistryURL = "registry.example.com:5000"
+
+func TestCustomRegistryURLReachesEveryImageRef(t *testing.T) {
+	config := testConfig(t, map[string]string{"REGISTRY_URL": testRegistryURL + "/"})
+
+	if config.registryURL != testRegistryURL {
+		t.Fatalf("registryURL = %q, want the trailing slash trimmed", config.registryURL)
+	}
+
+	if err := validateRegistryURL(config.registryURL); err != nil {
+		t.Fatal(err)
+	}
+
+	image, err := deployImageFromFlags(config.registryURL, testRegistryURL+"/n8n/n8n:1.70.0", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if image.version != "1.70.0" {
+		t.Errorf("version = %q, want the tag", image.version)
+	}
+
+	if _, err := deployImageFromFlags(config.registryURL, defaultRegistryURL+"/n8n/n8n:1.70.0", "", ""); err == nil {
+		t.Error("an image in the default registry was accepted with a custom REGISTRY_URL")
+	}
+
+	if setup := generateSetupCommands(config); !strings.Contains(setup, "docker login "+testRegistryURL+" ") {
+		t.Errorf("the droplet does not log in to %s:\n%s", testRegistryURL, setup)
+	}
+
+	config.deployImage = fmt.Sprintf(renderedImage, config.registryURL, config.n8nVersion)
+	if !strings.HasPrefix(n8nServiceImage(config), testRegistryURL+"/") {
+		t.Errorf("compose image %q is not in %s", n8nServiceImage(config), testRegistryURL)
+	}
+
+	credentials := `{"auths":{"` + defaultRegistryURL + `":{"auth":"dG9rZW46dG9rZW4="}}}`
+
+	dockerConfig, err := dockerConfigForRegistry([]byte(credentials), config.registryURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed struct {
+		Auths map[string]any `json:"auths"`
+	}
+	if err := json.Unmarshal([]byte(dockerConfig), &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found := parsed.Auths[testRegistryURL]; !found {
+		t.Errorf("the registry credentials do not cover %s: %s", testRegistryURL, dockerConfig)
+	}
+}
+
+func TestValidateRegistryURL(t *testing.T) {
+	for _, registryURL := range []string{defaultRegistryURL, testRegistryURL, "registry.example.com"} {
+		if err := validateRegistryURL(registryURL); err != nil {
+			t.Errorf("validateRegistryURL(%q) = %v", registryURL, err)
+		}
+	}
+
+	for _, registryURL := range []string{
+		"https://registry.example.com",
+		"registry.example.com/n8n",
+		"registry.example.com:0",
+		"registry.example.com:http",
+		"localhost",
+		"registry_1.example.com",
+	} {
+		if err := validateRegistryURL(registryURL); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("validateRegistryURL(%q) = %v, want ErrInvalidConfig", registryURL, err)
+		}
+	}
+}
+
+func TestRegistryLoginKeepsTheTokenOffDockersCommandLine(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.doToken = "dop_v1_secret"
+
+	login := registryLoginCommand(config)
+
+	feed, docker, found := strings.Cut(login, " | ")
+	if !found || !strings.HasPrefix(feed, "printf ") {
+		t.Fatalf("the token is not piped to docker: %s", login)
+	}
+
+	if strings.Contains(docker, config.doToken) || !strings.HasSuffix(docker, "--password-stdin") {
+		t.Errorf("docker login gets the token as an argument: %s", docker)
+	}
+}
+
+func TestRegistryCreateError(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		message string
+		want    bool
+	}{
+		{"payment required", http.StatusPaymentRequired, "billing required", true},
+		{"precondition failed", http.StatusPreconditionFailed, "", true},
+		{"not available", http.StatusForbidden, "Container Registry is not available for your account", true},
+		{"not enabled", http.StatusForbidden, "registry not enabled", true},
+		{"limit", http.StatusUnprocessableEntity, "Registry limit reached", true},
+		{"quota", http.StatusUnprocessableEntity, "Quota exceeded", true},
+		{"exceed", http.StatusConflict, "would exceed the allowed number of registries", true},
+		{"existing registry", http.StatusConflict, "account already has a registry", true},
+		{"forbidden without a hint", http.StatusForbidden, "you are not authorized", false},
+		{"invalid name", http.StatusUnprocessableEntity, "name is invalid", false},
+		{"server error", http.StatusInternalServerError, "not available", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apiErr := &godo.ErrorResponse{Response: &http.Response{StatusCode: test.status}, Message: test.message}
+			err := registryCreateError(apiErr)
+
+			if got := errors.Is(err, ErrRegistryUnavailable); got != test.want {
+				t.Fatalf("errors.Is(%v, ErrRegistryUnavailable) = %t, want %t", err, got, test.want)
+			}
+
+			if !errors.Is(err, apiErr) {
+				t.Error("the API error is not wrapped")
+			}
+
+			var exitErr *exitCodeError
+			if got := errors.As(err, &exitErr) && exitErr.code == exitRegistryUnavailable; got != test.want {
+				t.Errorf("exits with %d: %t, want %t", exitRegistryUnavailable, got, test.want)
+			}
+		})
+	}
+
+	if err := registryCreateError(errors.New("connection refused")); errors.Is(err, ErrRegistryUnavailable) {
+		t.Error("a non-API error was classified as ErrRegistryUnavailable")
+	}
+}
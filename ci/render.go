@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	renderDirPerm  = 0o755
+	renderFilePerm = 0o644
+	redactedValue  = "<redacted>"
+	// renderedImage stands in for the digest the build would push
+	renderedImage = "%s/<registry>/n8n:%s@<digest>"
+)
+
+// renderedArtifact is one generated file as it lands on the droplet.
+type renderedArtifact struct {
+	name    string
+	content string
+}
+
+// secretEnvMarkers flags .env keys whose values must not be printed.
+var secretEnvMarkers = []string{"KEY", "PASSWORD", "TOKEN", "SECRET"}
+
+// runRender prints the generated artifacts, or writes them to --out, without
+// contacting DigitalOcean or the droplet.
+func runRender(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	outDir := flags.String("out", "", "write the artifacts to this directory instead of stdout")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config := loadConfig()
+
+	if err := usePlaceholderEncryptionKey(&config); err != nil {
+		return err
+	}
+
+	if err := loadN8NEnvFile(&config); err != nil {
+		return err
+	}
+
+	if err := validateConfig(&config); err != nil {
+		return err
+	}
+
+	config.deployImage = fmt.Sprintf(renderedImage, config.registryURL, config.n8nVersion)
+
+	if config.cloudflare {
+		var err error
+		if config.cloudflareRanges, err = fetchCloudflareRanges(ctx); err != nil {
+			return err
+		}
+	}
+
+	artifacts := renderArtifacts(&config)
+
+	if *outDir == "" {
+		for _, artifact := range artifacts {
+			fmt.Printf("# ==> %s <==\n%s\n", artifact.name, strings.TrimRight(artifact.content, "\n"))
+		}
+
+		return nil
+	}
+
+	if err := os.MkdirAll(*outDir, renderDirPerm); err != nil {
+		return fmt.Errorf("failed to create %s: %w", *outDir, err)
+	}
+
+	for _, artifact := range artifacts {
+		path := filepath.Join(*outDir, artifact.name)
+		if err := os.WriteFile(path, []byte(artifact.content), renderFilePerm); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+
+		fmt.Printf("Wrote %s\n", path)
+	}
+
+	return nil
+}
+
+func renderArtifacts(config *Config) []renderedArtifact {
+	artifacts := []renderedArtifact{
+		{name: "docker-compose.yml", content: generateDockerComposeContent(config) + "\n"},
+		{name: ".env", content: redactEnvFile(heredocBody(generateEnvFile(config)))},
+	}
+
+	if len(config.n8nEnv) > 0 {
+		artifacts = append(artifacts, renderedArtifact{name: "n8n.env", content: redactN8NEnv(config.n8nEnv)})
+	}
+
+	if config.backupCron != "" {
+		artifacts = append(artifacts,
+			renderedArtifact{name: "n8n-backup", content: generateScheduledBackupScript(config)},
+			renderedArtifact{name: "n8n-backup.cron", content: generateBackupCronFile(config)})
+	}
+
+	return append(artifacts,
+		renderedArtifact{name: "Caddyfile", content: generateCaddyfile(config)},
+		renderedArtifact{name: "user-data.sh", content: generateUserData(config)})
+}
+
+// heredocBody extracts the file content from a generated "cat > FILE << EOF"
+// snippet.
+func heredocBody(script string) string {
+	_, body, found := strings.Cut(script, "<< EOF\n")
+	if !found {
+		return script
+	}
+
+	body, _, _ = strings.Cut(body, "\nEOF")
+
+	return body + "\n"
+}
+
+// redactEnvFile masks secret values. Values filled in on the droplet, such as
+// $(openssl rand ...) or ${DB_PASSWORD}, are kept since they reveal nothing.
+func redactEnvFile(env string) string {
+	lines := strings.Split(env, "\n")
+
+	for i, line := range lines {
+		key, value, found := strings.Cut(line, "=")
+		if !found || value == "" || strings.HasPrefix(value, "$") {
+			continue
+		}
+
+		for _, marker := range secretEnvMarkers {
+			if strings.Contains(key, marker) {
+				lines[i] = key + "=" + redactedValue
+
+				break
+			}
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// usePlaceholderEncryptionKey fills in a throwaway encryption key when none is
+// set. The key is redacted from rendered output, so this lets artifacts be
+// produced in CI jobs that have no access to the real secret.
+func usePlaceholderEncryptionKey(config *Config) error {
+	if config.encryptionKey != "" {
+		return nil
+	}
+
+	keyBytes := make([]byte, generatedEncryptionBytes)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return fmt.Errorf("failed to generate placeholder encryption key: %w", err)
+	}
+
+	config.encryptionKey = hex.EncodeToString(keyBytes)
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	reservedIPPollDelay = 5 * time.Second
+	reservedIPTimeout   = 2 * time.Minute
+)
+
+var ErrReservedIP = errors.New("reserved IP assignment failed")
+
+// ensureReservedIP assigns RESERVED_IP to droplet unless it already is, for
+// instance after the droplet was recreated, and returns the address DNS
+// should point at: the reserved IP, or the droplet's own without one.
+func ensureReservedIP(ctx context.Context, client *godo.Client, config *Config, droplet *godo.Droplet) (string, error) {
+	if config.reservedIP == "" {
+		ip, err := droplet.PublicIPv4()
+		if err != nil {
+			return "", fmt.Errorf("failed to get droplet IP: %w", err)
+		}
+
+		return ip, nil
+	}
+
+	reserved, _, err := client.ReservedIPs.Get(ctx, config.reservedIP)
+	if err != nil {
+		return "", fmt.Errorf("failed to get reserved IP %s: %w", config.reservedIP, err)
+	}
+
+	if reserved.Region != nil && droplet.Region != nil && reserved.Region.Slug != droplet.Region.Slug {
+		return "", fmt.Errorf("%w: %s is in %s but droplet %s is in %s",
+			ErrReservedIP, reserved.IP, reserved.Region.Slug, droplet.Name, droplet.Region.Slug)
+	}
+
+	if reserved.Droplet != nil && reserved.Droplet.ID == droplet.ID {
+		return reserved.IP, nil
+	}
+
+	if reserved.Droplet != nil {
+		fmt.Printf("Moving reserved IP %s from droplet %s to %s\n", reserved.IP, reserved.Droplet.Name, droplet.Name)
+	} else {
+		fmt.Printf("Assigning reserved IP %s to droplet %s\n", reserved.IP, droplet.Name)
+	}
+
+	action, _, err := client.ReservedIPActions.Assign(ctx, reserved.IP, droplet.ID)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrReservedIP, reserved.IP, err)
+	}
+
+	deadline := time.Now().Add(reservedIPTimeout)
+
+	for action.Status != actionStatusDone {
+		if action.Status == actionStatusFailure {
+			return "", fmt.Errorf("%w: %s", ErrReservedIP, reserved.IP)
+		}
+
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("%w: %s did not complete within %s", ErrReservedIP, reserved.IP, reservedIPTimeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(reservedIPPollDelay):
+		}
+
+		if action, _, err = client.ReservedIPActions.Get(ctx, reserved.IP, action.ID); err != nil {
+			return "", fmt.Errorf("failed to check reserved IP assignment: %w", err)
+		}
+	}
+
+	return reserved.IP, nil
+}
+
+func validateReservedIP(config *Config) error {
+	if config.reservedIP == "" {
+		return nil
+	}
+
+	if ip := net.ParseIP(config.reservedIP); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("%w: RESERVED_IP must be an IPv4 address, got %q", ErrInvalidConfig, config.reservedIP)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+var ErrRestartUsage = errors.New("usage: restart [n8n|db|caddy]")
+
+// composeServices are the services defined by the generated compose file.
+var composeServices = []string{"n8n", "db", "caddy"}
+
+// runRestart restarts one service, or all of them, without regenerating any
+// configuration, then waits for n8n to report ready.
+func runRestart(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("restart", flag.ExitOnError)
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() > 1 {
+		return ErrRestartUsage
+	}
+
+	service := flags.Arg(0)
+	if service != "" && !slices.Contains(composeServices, service) {
+		return fmt.Errorf("%w: unknown service %q", ErrRestartUsage, service)
+	}
+
+	config := loadConfig()
+
+	if err := refuseDryRun(&config, "restart"); err != nil {
+		return err
+	}
+
+	stopAgent, err := prepareSSHKey(&config)
+	if err != nil {
+		return err
+	}
+	defer stopAgent()
+
+	sshClient, err := connectDroplet(ctx, newDOClient(&config), config.resourceName(resourceDroplet), &config)
+	if err != nil {
+		return err
+	}
+	defer sshClient.Close()
+
+	code, err := sshClient.Run("cd /opt/n8n\n"+restartCommand(&config, service), nil, os.Stdout, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	if code != 0 {
+		return fmt.Errorf("%w: restart exited with %d", ErrDeployment, code)
+	}
+
+	return waitForN8NReady(ctx, sshClient, &config, false)
+}
+
+// restartCommand restarts service, or every service when it is empty.
+func restartCommand(config *Config, service string) string {
+	if service != "" {
+		return restartServiceCommand(config, service)
+	}
+
+	if config.deployMode == deployModeSwarm {
+		commands := make([]string, 0, len(composeServices))
+		for _, name := range composeServices {
+			commands = append(commands, restartServiceCommand(config, name))
+		}
+
+		return strings.Join(commands, "\n")
+	}
+
+	return fmt.Sprintf("%s\n%s restart", composeDetectScript(config), composeCmd)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/digitalocean/godo"
+)
+
+const snapshotsPerPage = 200
+
+var ErrNoSnapshot = errors.New("no droplet snapshot to restore")
+
+// runRestoreSnapshot replaces the droplet with one created from a snapshot:
+// the new droplet is health-checked before DNS and the droplet name move over,
+// so a failed restore leaves the running droplet untouched.
+func runRestoreSnapshot(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("restore-snapshot", flag.ExitOnError)
+	snapshotID := flags.Int("snapshot", 0, "snapshot ID to restore (defaults to the droplet's newest snapshot)")
+	destroyOld := flags.Bool("destroy-old", false, "delete the old droplet once the restored one is healthy")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config := loadConfig()
+
+	if err := refuseDryRun(&config, "restore-snapshot"); err != nil {
+		return err
+	}
+
+	stopAgent, err := prepareSSHKey(&config)
+	if err != nil {
+		return err
+	}
+	defer stopAgent()
+
+	client := newDOClient(&config)
+	name := config.resourceName(resourceDroplet)
+
+	oldDroplet, err := findDroplet(ctx, client, config.region, name)
+	if err != nil {
+		return err
+	}
+
+	imageID, err := selectSnapshot(ctx, client, oldDroplet, *snapshotID)
+	if err != nil {
+		return err
+	}
+
+	restored, err := createDropletFromSnapshot(ctx, client, &config, imageID)
+	if err != nil {
+		return err
+	}
+
+	if err := verifyRestoredDroplet(ctx, restored, &config); err != nil {
+		return fmt.Errorf("restored droplet %d is unhealthy, old droplet left in place: %w", restored.ID, err)
+	}
+
+	if err := createFirewall(ctx, client, &config); err != nil {
+		return err
+	}
+
+	if err := reconcileFirewall(ctx, client, &config, restored); err != nil {
+		return err
+	}
+
+	if config.manageDNS {
+		if err := configureAndVerifyDNS(ctx, client, &config, restored); err != nil {
+			return err
+		}
+	}
+
+	if err := retireDroplet(ctx, client, oldDroplet, name, *destroyOld); err != nil {
+		return err
+	}
+
+	if _, _, err := client.DropletActions.Rename(ctx, restored.ID, name); err != nil {
+		return fmt.Errorf("failed to rename restored droplet: %w", err)
+	}
+
+	fmt.Printf("Restored %s from snapshot %d as droplet %d\n", name, imageID, restored.ID)
+
+	return nil
+}
+
+// selectSnapshot returns the requested snapshot, or the newest snapshot of
+// the current droplet.
+func selectSnapshot(ctx context.Context, client *godo.Client, droplet *godo.Droplet, snapshotID int) (int, error) {
+	if snapshotID != 0 {
+		return snapshotID, nil
+	}
+
+	if droplet == nil {
+		return 0, fmt.Errorf("%w: droplet not found, pass --snapshot", ErrNoSnapshot)
+	}
+
+	snapshots, _, err := client.Droplets.Snapshots(ctx, droplet.ID, &godo.ListOptions{PerPage: snapshotsPerPage})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	var newest *godo.Image
+
+	// Created is RFC 3339, so timestamps compare lexically
+	for i := range snapshots {
+		if newest == nil || snapshots[i].Created > newest.Created {
+			newest = &snapshots[i]
+		}
+	}
+
+	if newest == nil {
+		return 0, fmt.Errorf("%w: droplet %s has no snapshots", ErrNoSnapshot, droplet.Name)
+	}
+
+	fmt.Printf("Using newest snapshot %q (%d) from %s\n", newest.Name, newest.ID, newest.Created)
+
+	return newest.ID, nil
+}
+
+func createDropletFromSnapshot(ctx context.Context, client *godo.Client, config *Config, imageID int) (*godo.Droplet, error) {
+	if err := verifyRegionAndSize(ctx, client, config); err != nil {
+		return nil, err
+	}
+
+	sshKeyID, err := ensureSSHKey(ctx, client, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure SSH key: %w", err)
+	}
+
+	vpc, err := createVPC(ctx, client, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// The snapshot is already provisioned, so no user data is needed
+	createRequest := dropletCreateRequest(config, config.resourceName(resourceDroplet)+"-restore",
+		godo.DropletCreateImage{ID: imageID}, vpc.ID, sshKeyID)
+
+	droplet, _, err := client.Droplets.Create(ctx, createRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create droplet from snapshot %d: %w", imageID, err)
+	}
+
+	return waitForDropletActive(ctx, client, droplet.ID, config.dropletPowerOn)
+}
+
+func verifyRestoredDroplet(ctx context.Context, droplet *godo.Droplet, config *Config) error {
+	dropletIP, err := droplet.PublicIPv4()
+	if err != nil {
+		return fmt.Errorf("failed to get droplet IP: %w", err)
+	}
+
+	sshClient, err := connectSSH(ctx, dropletIP, config.deploySSHUser, config)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSSHClient, err)
+	}
+	defer sshClient.Close()
+
+	return waitForN8NReady(ctx, sshClient, config, true)
+}
+
+// retireDroplet deletes the replaced droplet, or renames it out of the way so
+// the restored droplet can take over its name.
+func retireDroplet(ctx context.Context, client *godo.Client, droplet *godo.Droplet, name string, destroy bool) error {
+	if droplet == nil {
+		return nil
+	}
+
+	if destroy {
+		if _, err := client.Droplets.Delete(ctx, droplet.ID); err != nil {
+			return fmt.Errorf("failed to delete old droplet %d: %w", droplet.ID, err)
+		}
+
+		fmt.Printf("Deleted old droplet %d\n", droplet.ID)
+
+		return nil
+	}
+
+	if _, _, err := client.DropletActions.Rename(ctx, droplet.ID, name+"-replaced"); err != nil {
+		return fmt.Errorf("failed to rename old droplet %d: %w", droplet.ID, err)
+	}
+
+	fmt.Printf("Old droplet %d kept as %s-replaced\n", droplet.ID, name)
+
+	return nil
+}
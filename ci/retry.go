@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	maxRetryDelay = 30 * time.Second
+
+	// SSH connection retries while a fresh droplet finishes booting.
+	sshConnectAttempts = 5
+	sshConnectDelay    = 5 * time.Second
+
+	// Registry pushes through Dagger.
+	publishAttempts   = 3
+	publishRetryDelay = 10 * time.Second
+
+	// Single DigitalOcean API calls.
+	apiAttempts   = 4
+	apiRetryDelay = 2 * time.Second
+
+	// defaultRetryBudget is how many retries a run may spend in total.
+	defaultRetryBudget = 30
+)
+
+var ErrRetryBudgetExhausted = errors.New("run retry budget exhausted")
+
+// transientMessages match failures that only surface as text, such as
+// registry errors relayed by the Dagger engine.
+var transientMessages = []string{
+	"connection reset",
+	"connection refused",
+	"i/o timeout",
+	"tls handshake timeout",
+	"unexpected eof",
+	"too many requests",
+	"bad gateway",
+	"service unavailable",
+	"gateway timeout",
+}
+
+// retryableError marks an error as transient regardless of its cause.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// retryable marks err as worth retrying, for conditions such as "not ready
+// yet" that isRetryable cannot recognize on its own.
+func retryable(err error) error {
+	return &retryableError{err: err}
+}
+
+// isRetryable reports whether err is transient: network timeouts and resets,
+// EOFs, HTTP 429 and 5xx responses. Auth, quota and validation errors are not.
+func isRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrAPIUnhealthy) {
+		return false
+	}
+
+	var marked *retryableError
+	if errors.As(err, &marked) {
+		return true
+	}
+
+	var apiErr *godo.ErrorResponse
+	if errors.As(err, &apiErr) {
+		return apiErr.Response != nil && isRetryableStatus(apiErr.Response.StatusCode)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	message := strings.ToLower(err.Error())
+	for _, transient := range transientMessages {
+		if strings.Contains(message, transient) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// retryWithBackoff calls fn until it succeeds, fails with an error that is
+// not retryable, the attempts are exhausted or ctx is done, doubling the delay
+// between attempts up to maxRetryDelay.
+func retryWithBackoff(ctx context.Context, attempts int, initialDelay time.Duration, fn func() error) error {
+	delay := initialDelay
+
+	var err error
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+
+		if !isRetryable(err) {
+			return err
+		}
+
+		if attempt == attempts {
+			break
+		}
+
+		if !spendRetry(ctx) {
+			return fmt.Errorf("%w (RETRY_BUDGET) after attempt %d: %w", ErrRetryBudgetExhausted, attempt, err)
+		}
+
+		fmt.Printf("Attempt %d/%d failed: %v (retrying in %s)\n", attempt, attempts, err, delay)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+
+	return fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+}
+
+// retryBudget counts the retries a run has left. Each step retries on its own
+// terms, so without a shared cap a flaky account could keep a run retrying
+// across every step.
+type retryBudget struct {
+	mu        sync.Mutex
+	remaining int
+}
+
+type retryBudgetKey struct{}
+
+// withRetryBudget gives the run under ctx a total of limit retries.
+func withRetryBudget(ctx context.Context, limit int) context.Context {
+	return context.WithValue(ctx, retryBudgetKey{}, &retryBudget{remaining: limit})
+}
+
+// spendRetry takes one retry from ctx's budget, reporting false once it is
+// used up. Contexts without a budget always allow the retry.
+func spendRetry(ctx context.Context) bool {
+	budget, ok := ctx.Value(retryBudgetKey{}).(*retryBudget)
+	if !ok {
+		return true
+	}
+
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	if budget.remaining == 0 {
+		return false
+	}
+
+	budget.remaining--
+
+	return true
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+func apiError(status int) error {
+	return &godo.ErrorResponse{Response: &http.Response{StatusCode: status}, Message: http.StatusText(status)}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"rate limited", apiError(http.StatusTooManyRequests), true},
+		{"server error", fmt.Errorf("failed to list: %w", apiError(http.StatusBadGateway)), true},
+		{"unauthorized", apiError(http.StatusUnauthorized), false},
+		{"validation", apiError(http.StatusUnprocessableEntity), false},
+		{"not found", apiError(http.StatusNotFound), false},
+		{"connection reset", fmt.Errorf("dial: %w", syscall.ECONNRESET), true},
+		{"eof", io.ErrUnexpectedEOF, true},
+		{"relayed message", errors.New("push failed: 503 Service Unavailable"), true},
+		{"marked", retryable(errors.New("droplet not ready")), true},
+		{"canceled", fmt.Errorf("wrapped: %w", context.Canceled), false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"breaker open", ErrAPIUnhealthy, false},
+		{"permanent", errors.New("invalid size slug"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isRetryable(test.err); got != test.want {
+				t.Errorf("isRetryable(%v) = %t, want %t", test.err, got, test.want)
+			}
+		})
+	}
+}
+
+func TestOpenBreakerExitsWithItsOwnCode(t *testing.T) {
+	breaker := &apiBreaker{threshold: 1}
+	breaker.record(nil, syscall.ECONNRESET)
+
+	err := breaker.check()
+	if !errors.Is(err, ErrAPIUnhealthy) {
+		t.Fatalf("err = %v, want ErrAPIUnhealthy", err)
+	}
+
+	var exitErr *exitCodeError
+	if !errors.As(err, &exitErr) || exitErr.code != exitAPIUnhealthy {
+		t.Errorf("err = %v, want exit code %d", err, exitAPIUnhealthy)
+	}
+
+	if isRetryable(err) {
+		t.Error("an open breaker is retried")
+	}
+}
+
+func TestRetryWithBackoffStopsOnPermanentErrors(t *testing.T) {
+	calls := 0
+	permanent := apiError(http.StatusUnauthorized)
+
+	err := retryWithBackoff(context.Background(), 5, time.Millisecond, func() error {
+		calls++
+
+		return permanent
+	})
+
+	if !errors.Is(err, permanent) || calls != 1 {
+		t.Errorf("err = %v after %d calls, want the permanent error after 1", err, calls)
+	}
+}
+
+func TestRetryWithBackoffRetriesTransientErrors(t *testing.T) {
+	calls := 0
+
+	err := retryWithBackoff(context.Background(), 3, time.Millisecond, func() error {
+		calls++
+		if calls < 3 {
+			return apiError(http.StatusServiceUnavailable)
+		}
+
+		return nil
+	})
+
+	if err != nil || calls != 3 {
+		t.Errorf("err = %v after %d calls, want success on the third", err, calls)
+	}
+
+	calls = 0
+
+	err = retryWithBackoff(context.Background(), 2, time.Millisecond, func() error {
+		calls++
+
+		return io.EOF
+	})
+
+	if !errors.Is(err, io.EOF) || calls != 2 {
+		t.Errorf("err = %v after %d calls, want EOF after giving up at 2", err, calls)
+	}
+}
+
+func TestRetryBudgetIsSharedAcrossSteps(t *testing.T) {
+	ctx := withRetryBudget(context.Background(), 1)
+	calls := 0
+
+	transient := func() error {
+		calls++
+
+		return io.EOF
+	}
+
+	// The first step spends the only retry
+	_ = retryWithBackoff(ctx, 2, time.Millisecond, transient)
+
+	calls = 0
+
+	err := retryWithBackoff(ctx, 5, time.Millisecond, transient)
+	if !errors.Is(err, ErrRetryBudgetExhausted) || calls != 1 {
+		t.Errorf("err = %v after %d calls, want ErrRetryBudgetExhausted after 1", err, calls)
+	}
+}
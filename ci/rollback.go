@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/felipepimentel/n8n-digitalocean-cicd/ci/ssh"
+)
+
+// defaultHealthWaitTimeout bounds how long the deploy script waits for the
+// services to report healthy.
+const defaultHealthWaitTimeout = 5 * time.Minute
+
+var ErrRolledBack = errors.New("deployment failed and was rolled back")
+
+// captureRunningImage returns a pullable reference to the image the running
+// n8n container was started from, pinned by digest, or "" when n8n is not
+// running or the image never came from a registry.
+func captureRunningImage(sshClient *ssh.Client, config *Config) (string, error) {
+	command := fmt.Sprintf(`N8N=$(docker ps -q %s | head -n 1)
+if [ -n "$N8N" ]; then
+	docker image inspect --format '{{range .RepoDigests}}{{println .}}{{end}}' "$(docker inspect --format '{{.Image}}' "$N8N")" | head -n 1
+fi`, serviceContainerFilter(config, "n8n"))
+
+	output, err := sshClient.ExecuteCommand(command)
+	if err != nil {
+		return "", fmt.Errorf("failed to read running n8n image: %w\nOutput: %s", err, output)
+	}
+
+	return strings.TrimSpace(output), nil
+}
+
+// rollbackDeployment puts n8n back on previous after the new version failed
+// to become healthy. With compose the n8n service is pointed at the previous
+// image, which is still on the droplet; a swarm service rolls back its own
+// spec. The configuration written by the failed deploy stays in place.
+func rollbackDeployment(ctx context.Context, sshClient *ssh.Client, config *Config, previous string, cause error) error {
+	fmt.Printf("Deploy failed, rolling n8n back to %s\n", previous)
+
+	script := fmt.Sprintf("docker service rollback %s_n8n", config.composeProject)
+	if config.deployMode != deployModeSwarm {
+		script = fmt.Sprintf(`cd /opt/n8n
+%[1]s
+sed -i "s|image: %[2]s$|image: %[3]s|" docker-compose.yml
+# Without the rewrite the rollback would just restart the failed image
+grep -qF "image: %[3]s" docker-compose.yml || { echo "n8n image %[2]s not found in docker-compose.yml" >&2; exit 1; }
+%[4]s up -d --no-deps n8n`, composeDetectScript(config), n8nServiceImage(config), previous, composeCmd)
+	}
+
+	if output, err := sshClient.ExecuteCommand(script); err != nil {
+		return fmt.Errorf("%w: %w; rollback to %s failed too: %v\nOutput: %s", ErrDeployment, cause, previous, err, output)
+	}
+
+	if err := waitForN8NReady(ctx, sshClient, config, false); err != nil {
+		return fmt.Errorf("%w: %w; n8n is unhealthy after rolling back to %s: %v", ErrDeployment, cause, previous, err)
+	}
+
+	return fmt.Errorf("%w to %s: %w", ErrRolledBack, previous, cause)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/felipepimentel/n8n-digitalocean-cicd/ci/ssh"
+	cryptossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	rollbackNewImage      = testRegistryURL + "/n8n/n8n@sha256:new"
+	rollbackPreviousImage = testRegistryURL + "/n8n/n8n@sha256:old"
+)
+
+// rollbackStubs stand in for docker and curl on the droplet: docker reports
+// $ROOT/running as the n8n container and records everything else in
+// $ROOT/calls, curl succeeds while $ROOT/healthy exists.
+var rollbackStubs = map[string]string{
+	"docker": `case "$1" in
+ps) cat "$ROOT/running" 2>/dev/null ;;
+inspect) echo sha256:image ;;
+image) printf '%s\n' "` + rollbackPreviousImage + `" "docker.io/n8nio/n8n@sha256:other" ;;
+*) echo "docker $*" >> "$ROOT/calls" ;;
+esac`,
+	"curl": `[ -f "$ROOT/healthy" ]`,
+}
+
+// sshDroplet serves SSH on localhost as a droplet stand-in rooted at root:
+// commands run under bash with /opt/n8n moved below root and the stubs ahead
+// of PATH. The returned client authenticates through a throwaway agent.
+func sshDroplet(t *testing.T, root string, stubs map[string]string) *ssh.Client {
+	t.Helper()
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not available")
+	}
+
+	bin := filepath.Join(root, "bin")
+	if err := os.MkdirAll(bin, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, body := range stubs {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+body+"\n"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	serverConfig := &cryptossh.ServerConfig{
+		PublicKeyCallback: func(cryptossh.ConnMetadata, cryptossh.PublicKey) (*cryptossh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	serverConfig.AddHostKey(testSigner(t))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	run := func(command string) *exec.Cmd {
+		cmd := exec.Command(bash, "-c", strings.ReplaceAll(command, "/opt/n8n", root+"/opt/n8n"))
+		cmd.Env = append(os.Environ(), "ROOT="+root, "PATH="+bin+":"+os.Getenv("PATH"))
+
+		return cmd
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go serveSSH(conn, serverConfig, run)
+		}
+	}()
+
+	startTestAgent(t)
+
+	client, err := ssh.NewClient("127.0.0.1", listener.Addr().(*net.TCPAddr).Port, rootUser, "",
+		ssh.WithInsecureHostKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func testSigner(t *testing.T) cryptossh.Signer {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := cryptossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer
+}
+
+// startTestAgent serves a keyring with a fresh key on SSH_AUTH_SOCK.
+func startTestAgent(t *testing.T) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	// t.TempDir can exceed the length limit of a socket path
+	dir, err := os.MkdirTemp("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	listener, err := net.Listen("unix", filepath.Join(dir, "agent.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", listener.Addr().String())
+}
+
+// serveSSH answers each session's exec request by running the command,
+// reporting its exit status the way sshd does.
+func serveSSH(conn net.Conn, config *cryptossh.ServerConfig, run func(string) *exec.Cmd) {
+	_, channels, requests, err := cryptossh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+
+		return
+	}
+
+	go cryptossh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(cryptossh.UnknownChannelType, "only sessions are served")
+
+			continue
+		}
+
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go func() {
+			defer channel.Close()
+
+			for request := range channelRequests {
+				var payload struct{ Command string }
+				if request.Type != "exec" || cryptossh.Unmarshal(request.Payload, &payload) != nil {
+					request.Reply(false, nil)
+
+					continue
+				}
+
+				request.Reply(true, nil)
+
+				cmd := run(payload.Command)
+				cmd.Stdout = channel
+				cmd.Stderr = channel.Stderr()
+
+				status := uint32(0)
+				if err := cmd.Run(); err != nil {
+					status = 255
+
+					var exitErr *exec.ExitError
+					if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+						status = uint32(exitErr.ExitCode())
+					}
+				}
+
+				channel.SendRequest("exit-status", false, cryptossh.Marshal(struct{ Status uint32 }{status}))
+
+				return
+			}
+		}()
+	}
+}
+
+// rollbackDroplet is a compose droplet whose docker-compose.yml runs image.
+func rollbackDroplet(t *testing.T, image string) (*Config, *ssh.Client, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	sshClient := sshDroplet(t, root, rollbackStubs)
+
+	if err := os.MkdirAll(filepath.Join(root, "opt/n8n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	compose := "services:\n  n8n:\n    image: " + image + "\n"
+	if err := os.WriteFile(filepath.Join(root, "opt/n8n/docker-compose.yml"), []byte(compose), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultTestConfig(t)
+	config.deployMode = deployModeCompose
+	config.composeCLI = composeCLIV2
+	config.deployImage = rollbackNewImage
+	config.healthCheckRetries = 1
+	config.healthCheckInterval = time.Millisecond
+
+	return config, sshClient, root
+}
+
+func TestCaptureRunningImage(t *testing.T) {
+	config, sshClient, root := rollbackDroplet(t, rollbackNewImage)
+
+	image, err := captureRunningImage(sshClient, config)
+	if err != nil || image != "" {
+		t.Fatalf("without a running n8n: image = %q, err = %v, want none", image, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "running"), []byte("abc123\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	image, err = captureRunningImage(sshClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if image != rollbackPreviousImage {
+		t.Errorf("image = %q, want the first repo digest %q", image, rollbackPreviousImage)
+	}
+}
+
+func TestRollbackDeploymentRewritesTheComposeImage(t *testing.T) {
+	config, sshClient, root := rollbackDroplet(t, rollbackNewImage)
+	cause := errors.New("n8n never became healthy")
+
+	if err := os.WriteFile(filepath.Join(root, "healthy"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := rollbackDeployment(context.Background(), sshClient, config, rollbackPreviousImage, cause)
+	if !errors.Is(err, ErrRolledBack) || !errors.Is(err, cause) {
+		t.Fatalf("err = %v, want ErrRolledBack wrapping the cause", err)
+	}
+
+	compose, _ := os.ReadFile(filepath.Join(root, "opt/n8n/docker-compose.yml"))
+	if !strings.Contains(string(compose), "image: "+rollbackPreviousImage+"\n") {
+		t.Errorf("docker-compose.yml was not pointed at the previous image:\n%s", compose)
+	}
+
+	calls, _ := os.ReadFile(filepath.Join(root, "calls"))
+	if !strings.Contains(string(calls), "docker compose up -d --no-deps n8n") {
+		t.Errorf("n8n was not recreated, docker calls:\n%s", calls)
+	}
+}
+
+func TestRollbackDeploymentFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		healthy bool
+		message string
+	}{
+		{"image not in docker-compose.yml", "docker.io/n8nio/n8n:1.70.0", true, "not found in docker-compose.yml"},
+		{"unhealthy after the rollback", rollbackNewImage, false, "n8n is unhealthy after rolling back"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, sshClient, root := rollbackDroplet(t, test.image)
+			cause := errors.New("n8n never became healthy")
+
+			if test.healthy {
+				if err := os.WriteFile(filepath.Join(root, "healthy"), nil, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := rollbackDeployment(context.Background(), sshClient, config, rollbackPreviousImage, cause)
+			if errors.Is(err, ErrRolledBack) {
+				t.Fatalf("err = %v, reported as rolled back", err)
+			}
+
+			if !errors.Is(err, ErrDeployment) || !errors.Is(err, cause) {
+				t.Errorf("err = %v, want ErrDeployment wrapping the cause", err)
+			}
+
+			if err != nil && !strings.Contains(err.Error(), test.message) {
+				t.Errorf("err = %v, want it to mention %q", err, test.message)
+			}
+		})
+	}
+}
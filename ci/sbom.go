@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"dagger.io/dagger"
+)
+
+const (
+	syftImage        = "anchore/syft:v1.18.1"
+	defaultSBOMDir   = "sbom"
+	sbomDigestLabel  = "dev.n8n-digitalocean-cicd.sbom.digest"
+	sbomFormatLabel  = "dev.n8n-digitalocean-cicd.sbom.format"
+	sbomFormat       = "spdx-json"
+	sbomScanPath     = "/image.tar"
+	sbomOutputPath   = "/sbom.spdx.json"
+	sbomRequiredName = "n8n"
+)
+
+var ErrSBOMIncomplete = errors.New("SBOM does not list the n8n package")
+
+// spdxDocument is the part of an SPDX JSON document the check needs.
+type spdxDocument struct {
+	Packages []struct {
+		Name string `json:"name"`
+	} `json:"packages"`
+}
+
+// withSBOM scans the built image with syft, writes the SPDX document to
+// SBOM_DIR and labels the image with the document's digest so the deployed
+// image can be matched to its SBOM.
+func withSBOM(ctx context.Context, client *dagger.Client, image *dagger.Container, config *Config) (*dagger.Container, error) {
+	sbom := client.Container().
+		From(syftImage).
+		WithMountedFile(sbomScanPath, image.AsTarball()).
+		WithExec([]string{"oci-archive:" + sbomScanPath, "-o", sbomFormat + "=" + sbomOutputPath}).
+		File(sbomOutputPath)
+
+	contents, err := sbom.Contents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate SBOM: %w", err)
+	}
+
+	if err := verifySBOM(contents); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(config.sbomDir, fmt.Sprintf("n8n-%s.spdx.json", config.n8nVersion))
+	if _, err := sbom.Export(ctx, path); err != nil {
+		return nil, fmt.Errorf("failed to write SBOM to %s: %w", path, err)
+	}
+
+	sum := sha256.Sum256([]byte(contents))
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	fmt.Printf("Wrote SBOM %s (%s)\n", path, digest)
+
+	return image.
+		WithLabel(sbomDigestLabel, digest).
+		WithLabel(sbomFormatLabel, sbomFormat), nil
+}
+
+// verifySBOM fails when the document does not include n8n itself, which
+// means syft did not scan the application layer.
+func verifySBOM(contents string) error {
+	var document spdxDocument
+	if err := json.Unmarshal([]byte(contents), &document); err != nil {
+		return fmt.Errorf("failed to parse SBOM: %w", err)
+	}
+
+	for _, pkg := range document.Packages {
+		if pkg.Name == sbomRequiredName {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w (%d packages found)", ErrSBOMIncomplete, len(document.Packages))
+}
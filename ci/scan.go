@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+const (
+	trivyImage        = "aquasec/trivy:0.58.1"
+	trivyCachePath    = "/root/.cache/trivy"
+	trivyScanPath     = "/image.tar"
+	trivyReportPath   = "/report.json"
+	trivyIgnorePath   = "/.trivyignore"
+	defaultScanFailOn = "CRITICAL"
+)
+
+// scanSeverities are trivy's severities from lowest to highest.
+var scanSeverities = []string{"UNKNOWN", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+var ErrVulnerabilitiesFound = errors.New("image has vulnerabilities at or above SCAN_FAIL_ON")
+
+// trivyReport is the part of trivy's JSON report the gate needs.
+type trivyReport struct {
+	Results []struct {
+		Target          string `json:"Target"`
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			InstalledVersion string `json:"InstalledVersion"`
+			FixedVersion     string `json:"FixedVersion"`
+			Severity         string `json:"Severity"`
+			Title            string `json:"Title"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+// scanImage runs trivy against the built image and fails when it reports
+// vulnerabilities at or above SCAN_FAIL_ON that are not in SCAN_ALLOWLIST.
+func scanImage(ctx context.Context, client *dagger.Client, image *dagger.Container, config *Config) error {
+	args := []string{
+		"image", "--input", trivyScanPath, "--no-progress", "--format", "json", "--output", trivyReportPath,
+		"--severity", strings.Join(severitiesFrom(config.scanFailOn), ","),
+	}
+
+	scanner := client.Container().
+		From(trivyImage).
+		WithMountedCache(trivyCachePath, client.CacheVolume("trivy-db")).
+		WithMountedFile(trivyScanPath, image.AsTarball())
+
+	if config.scanAllowlist != "" {
+		scanner = scanner.WithMountedFile(trivyIgnorePath, client.Host().File(config.scanAllowlist))
+		args = append(args, "--ignorefile", trivyIgnorePath)
+	}
+
+	output, err := scanner.WithExec(args).File(trivyReportPath).Contents(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to scan image: %w", err)
+	}
+
+	var report trivyReport
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		return fmt.Errorf("failed to parse scan report: %w", err)
+	}
+
+	found := 0
+
+	for _, result := range report.Results {
+		for _, vuln := range result.Vulnerabilities {
+			found++
+
+			fixed := vuln.FixedVersion
+			if fixed == "" {
+				fixed = "no fix"
+			}
+
+			fmt.Printf("%s %s %s %s (%s): %s [%s]\n",
+				vuln.Severity, vuln.VulnerabilityID, vuln.PkgName, vuln.InstalledVersion, fixed, vuln.Title, result.Target)
+		}
+	}
+
+	if found > 0 {
+		return fmt.Errorf("%w (%s): %d found", ErrVulnerabilitiesFound, config.scanFailOn, found)
+	}
+
+	fmt.Printf("Image scan passed: no %s or higher vulnerabilities\n", config.scanFailOn)
+
+	return nil
+}
+
+// severitiesFrom returns threshold and every severity above it.
+func severitiesFrom(threshold string) []string {
+	return scanSeverities[slices.Index(scanSeverities, threshold):]
+}
+
+func validateScanConfig(config *Config) error {
+	if !config.scanImage {
+		return nil
+	}
+
+	if !slices.Contains(scanSeverities, config.scanFailOn) {
+		return fmt.Errorf("%w: SCAN_FAIL_ON must be one of %s, got %q",
+			ErrInvalidConfig, strings.Join(scanSeverities, ", "), config.scanFailOn)
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// credentialsOutputName is the credentials file created in the runner's
+// temporary directory, away from the build context, when
+// CREDENTIALS_OUTPUT_FILE is not set. The build excludes it all the same.
+const credentialsOutputName = "generated-credentials.env"
+
+// surfaceGeneratedSecret hands a secret the tool generated to the user
+// without printing it in the logs: it is masked in GitHub Actions and
+// appended to a private credentials file the user is expected to collect.
+func surfaceGeneratedSecret(name, value string) error {
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		fmt.Printf("::add-mask::%s\n", value)
+	}
+
+	path := credentialsOutputFile()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, sshFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to open credentials output file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintf(file, "%s=%s\n", name, value); err != nil {
+		return fmt.Errorf("failed to write credentials output file %s: %w", path, err)
+	}
+
+	fmt.Printf("Generated %s and saved it to %s. Store it as a secret before the next run.\n", name, path)
+
+	return nil
+}
+
+// credentialsOutputFile is CREDENTIALS_OUTPUT_FILE, or a file in RUNNER_TEMP
+// or the system temporary directory.
+func credentialsOutputFile() string {
+	if path := os.Getenv("CREDENTIALS_OUTPUT_FILE"); path != "" {
+		return path
+	}
+
+	dir := os.Getenv("RUNNER_TEMP")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, credentialsOutputName)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredentialsOutputFileStaysOutOfTheBuildContext(t *testing.T) {
+	t.Setenv("CREDENTIALS_OUTPUT_FILE", "")
+	t.Setenv("RUNNER_TEMP", "")
+
+	if got, want := credentialsOutputFile(), filepath.Join(os.TempDir(), credentialsOutputName); got != want {
+		t.Errorf("credentialsOutputFile() = %s, want %s", got, want)
+	}
+
+	t.Setenv("RUNNER_TEMP", "/home/runner/work/_temp")
+
+	if got := credentialsOutputFile(); got != "/home/runner/work/_temp/"+credentialsOutputName {
+		t.Errorf("credentialsOutputFile() = %s, want it in RUNNER_TEMP", got)
+	}
+
+	t.Setenv("CREDENTIALS_OUTPUT_FILE", "secrets.env")
+
+	if got := credentialsOutputFile(); got != "secrets.env" {
+		t.Errorf("credentialsOutputFile() = %s, want CREDENTIALS_OUTPUT_FILE", got)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+const (
+	cosignImage         = "gcr.io/projectsigstore/cosign:v2.4.1"
+	cosignDockerConfig  = "/cosign-docker"
+	cosignKeyPath       = "/cosign.key"
+	cosignPublicKeyPath = "/cosign.pub"
+)
+
+// githubOIDCVars let cosign request an identity token inside GitHub Actions
+// for keyless signing.
+var githubOIDCVars = []string{"ACTIONS_ID_TOKEN_REQUEST_URL", "ACTIONS_ID_TOKEN_REQUEST_TOKEN"}
+
+var ErrImageSigning = errors.New("image signing failed")
+
+// signImage signs the pushed digest with cosign, with COSIGN_KEY or keyless
+// through the CI's OIDC identity, and verifies the signature when
+// VERIFY_SIGNATURE is set so an unverifiable image never reaches the droplet.
+func signImage(ctx context.Context, client *dagger.Client, dockerConfig *dagger.Secret,
+	config *Config, image *publishedImage,
+) error {
+	ref := image.ref + "@" + image.digest
+
+	signer := cosignContainer(client, dockerConfig)
+
+	args := []string{"sign", "--yes"}
+
+	if config.cosignKey != "" {
+		signer = signer.
+			WithMountedSecret(cosignKeyPath, client.Host().SetSecretFile("cosign_key", config.cosignKey)).
+			WithSecretVariable("COSIGN_PASSWORD", client.SetSecret("cosign_password", os.Getenv("COSIGN_PASSWORD")))
+		args = append(args, "--key", cosignKeyPath)
+	} else {
+		for _, name := range githubOIDCVars {
+			signer = signer.WithSecretVariable(name, client.SetSecret(strings.ToLower(name), os.Getenv(name)))
+		}
+	}
+
+	if _, err := signer.WithExec(append(args, ref)).Sync(ctx); err != nil {
+		return fmt.Errorf("%w: %w", ErrImageSigning, err)
+	}
+
+	image.signature = cosignSignatureRef(image)
+
+	fmt.Printf("Signed %s (signature %s)\n", ref, image.signature)
+
+	if !config.verifySignature {
+		return nil
+	}
+
+	verifyArgs := []string{"verify"}
+
+	verifier := cosignContainer(client, dockerConfig)
+	if config.cosignPublicKey != "" {
+		verifier = verifier.WithMountedFile(cosignPublicKeyPath, client.Host().File(config.cosignPublicKey))
+		verifyArgs = append(verifyArgs, "--key", cosignPublicKeyPath)
+	} else {
+		verifyArgs = append(verifyArgs,
+			"--certificate-identity", config.cosignIdentity,
+			"--certificate-oidc-issuer", config.cosignOIDCIssuer)
+	}
+
+	if _, err := verifier.WithExec(append(verifyArgs, ref)).Sync(ctx); err != nil {
+		return fmt.Errorf("%w: signature verification: %w", ErrImageSigning, err)
+	}
+
+	fmt.Printf("Verified signature of %s\n", ref)
+
+	return nil
+}
+
+func cosignContainer(client *dagger.Client, dockerConfig *dagger.Secret) *dagger.Container {
+	return client.Container().
+		From(cosignImage).
+		WithEnvVariable("DOCKER_CONFIG", cosignDockerConfig).
+		WithMountedSecret(cosignDockerConfig+"/config.json", dockerConfig)
+}
+
+// cosignSignatureRef is where cosign stores the signature: a tag named after
+// the signed digest in the image's repository.
+func cosignSignatureRef(image *publishedImage) string {
+	repository, _, _ := strings.Cut(image.ref, "@")
+	if slash, colon := strings.LastIndex(repository, "/"), strings.LastIndex(repository, ":"); colon > slash {
+		repository = repository[:colon]
+	}
+
+	return repository + ":" + strings.Replace(image.digest, ":", "-", 1) + ".sig"
+}
+
+// isSignatureTag reports whether a registry tag holds a cosign signature
+// rather than an image.
+func isSignatureTag(tag string) bool {
+	return strings.HasPrefix(tag, "sha256-") && strings.HasSuffix(tag, ".sig")
+}
+
+func validateSigningConfig(config *Config) error {
+	if !config.signImage {
+		return nil
+	}
+
+	if config.cosignKey != "" {
+		if _, err := os.Stat(config.cosignKey); err != nil {
+			return fmt.Errorf("%w: COSIGN_KEY: %v", ErrInvalidConfig, err)
+		}
+	}
+
+	if !config.verifySignature {
+		return nil
+	}
+
+	if config.cosignKey != "" && config.cosignPublicKey == "" {
+		return fmt.Errorf("%w: VERIFY_SIGNATURE with COSIGN_KEY requires COSIGN_PUBLIC_KEY", ErrInvalidConfig)
+	}
+
+	if config.cosignKey == "" && (config.cosignIdentity == "" || config.cosignOIDCIssuer == "") {
+		return fmt.Errorf("%w: VERIFY_SIGNATURE with keyless signing requires COSIGN_IDENTITY and COSIGN_OIDC_ISSUER",
+			ErrInvalidConfig)
+	}
+
+	return nil
+}
@@ -3,8 +3,11 @@ package ssh
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -17,13 +20,61 @@ const (
 
 var (
 	ErrSSHAuthSockNotSet = errors.New("SSH_AUTH_SOCK not set")
+	ErrNoHostKeyCheck    = errors.New("no known_hosts file given and host key checking not disabled")
 )
 
 type Client struct {
-	client *ssh.Client
+	client    *ssh.Client
+	bastion   *ssh.Client
+	sudo      bool
+	maxOutput int
 }
 
-func NewClient(host string, port int, user, keyPath string) (*Client, error) {
+// Option customizes how NewClient connects.
+type Option func(*options)
+
+type options struct {
+	bastionAddr     string
+	bastionUser     string
+	knownHostsPath  string
+	strictHostKeys  bool
+	insecureHostKey bool
+	sudo            bool
+	maxOutput       int
+}
+
+// WithBastion routes the connection through a jump host, like ssh -J. The
+// address may omit the port, in which case the target's port is used.
+func WithBastion(addr, user string) Option {
+	return func(o *options) {
+		o.bastionAddr = addr
+		o.bastionUser = user
+	}
+}
+
+// WithSudo runs every command through passwordless sudo, for users other
+// than root.
+func WithSudo() Option {
+	return func(o *options) {
+		o.sudo = true
+	}
+}
+
+// WithMaxOutput caps how many bytes of a command's output ExecuteCommand
+// keeps; the rest is dropped and replaced by a marker. Streams passed to Run
+// are not affected.
+func WithMaxOutput(limit int) Option {
+	return func(o *options) {
+		o.maxOutput = limit
+	}
+}
+
+func NewClient(host string, port int, user, keyPath string, opts ...Option) (*Client, error) {
+	o := options{maxOutput: DefaultMaxOutput}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	// Try to connect to SSH agent
 	socket := os.Getenv("SSH_AUTH_SOCK")
 	if socket == "" {
@@ -37,6 +88,11 @@ func NewClient(host string, port int, user, keyPath string) (*Client, error) {
 
 	agentClient := agent.NewClient(conn)
 
+	hostKeyCallback, err := o.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+
 	// Create SSH client config
 	config := &ssh.ClientConfig{
 		User: user,
@@ -44,21 +100,67 @@ func NewClient(host string, port int, user, keyPath string) (*Client, error) {
 			// Use SSH agent for authentication
 			ssh.PublicKeysCallback(agentClient.Signers),
 		},
-		// #nosec G106 -- Using InsecureIgnoreHostKey is acceptable for this use case
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: hostKeyCallback,
 		Timeout:         defaultTimeout,
 	}
 
 	// Connect to remote host
-	addr := fmt.Sprintf("%s:%d", host, port)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+	if o.bastionAddr == "" {
+		client, err := ssh.Dial("tcp", addr, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+		}
+
+		return &Client{
+			client:    client,
+			sudo:      o.sudo,
+			maxOutput: o.maxOutput,
+		}, nil
+	}
 
-	client, err := ssh.Dial("tcp", addr, config)
+	return dialThroughBastion(addr, config, &o, port)
+}
+
+// dialThroughBastion connects to the bastion with the same credentials, then
+// tunnels the SSH connection to addr through it.
+func dialThroughBastion(addr string, config *ssh.ClientConfig, o *options, port int) (*Client, error) {
+	bastionAddr := o.bastionAddr
+	if _, _, err := net.SplitHostPort(bastionAddr); err != nil {
+		bastionAddr = net.JoinHostPort(bastionAddr, strconv.Itoa(port))
+	}
+
+	bastionConfig := *config
+	if o.bastionUser != "" {
+		bastionConfig.User = o.bastionUser
+	}
+
+	bastion, err := ssh.Dial("tcp", bastionAddr, &bastionConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+		return nil, fmt.Errorf("failed to connect to bastion %s: %w", bastionAddr, err)
+	}
+
+	conn, err := bastion.Dial("tcp", addr)
+	if err != nil {
+		bastion.Close()
+
+		return nil, fmt.Errorf("failed to reach %s through bastion %s: %w", addr, bastionAddr, err)
+	}
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		bastion.Close()
+
+		return nil, fmt.Errorf("failed to connect to %s through bastion %s: %w", addr, bastionAddr, err)
 	}
 
 	return &Client{
-		client: client,
+		client:    ssh.NewClient(clientConn, chans, reqs),
+		bastion:   bastion,
+		sudo:      o.sudo,
+		maxOutput: o.maxOutput,
 	}, nil
 }
 
@@ -70,19 +172,66 @@ func (c *Client) ExecuteCommand(command string) (string, error) {
 	}
 	defer session.Close()
 
-	// Run command and capture output
-	output, err := session.CombinedOutput(command)
+	// Capture both streams like CombinedOutput, but only up to the cap
+	output := &cappedBuffer{limit: c.maxOutput}
+	session.Stdout = output
+	session.Stderr = output
+
+	if err := session.Run(c.wrap(command)); err != nil {
+		return output.String(), fmt.Errorf("failed to run command: %w", err)
+	}
+
+	return output.String(), nil
+}
+
+// Run executes command with the given streams attached and returns the remote
+// exit code. A non-zero exit code is not treated as an error.
+func (c *Client) Run(command string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	session, err := c.client.NewSession()
 	if err != nil {
-		return string(output), fmt.Errorf("failed to run command: %w", err)
+		return -1, fmt.Errorf("failed to create session: %w", err)
 	}
+	defer session.Close()
+
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
 
-	return string(output), nil
+	err = session.Run(c.wrap(command))
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
+
+	if err != nil {
+		return -1, fmt.Errorf("failed to run command: %w", err)
+	}
+
+	return 0, nil
+}
+
+// wrap hands command to a root shell when the client runs through sudo. -n
+// fails instead of prompting if the user lacks passwordless sudo.
+func (c *Client) wrap(command string) string {
+	if !c.sudo {
+		return command
+	}
+
+	return "sudo -n bash -c '" + strings.ReplaceAll(command, "'", `'\''`) + "'"
 }
 
 func (c *Client) Close() error {
+	var err error
 	if c.client != nil {
-		return c.client.Close()
+		err = c.client.Close()
+	}
+
+	if c.bastion != nil {
+		if bastionErr := c.bastion.Close(); err == nil {
+			err = bastionErr
+		}
 	}
 
-	return nil
+	return err
 }
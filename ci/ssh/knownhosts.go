@@ -0,0 +1,183 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	knownHostsDirPerm  = 0o700
+	knownHostsFilePerm = 0o600
+)
+
+// WithKnownHosts verifies host keys against the known_hosts file at path.
+// Hosts missing from the file are trusted on first use and recorded, so
+// later connections fail if their key changes.
+func WithKnownHosts(path string) Option {
+	return func(o *options) {
+		o.knownHostsPath = path
+	}
+}
+
+// WithStrictHostKeys rejects hosts missing from the known_hosts file instead
+// of trusting them on first use.
+func WithStrictHostKeys() Option {
+	return func(o *options) {
+		o.strictHostKeys = true
+	}
+}
+
+// WithInsecureHostKey accepts any host key. It exists for pipelines that
+// cannot keep a known_hosts file and must be asked for explicitly.
+func WithInsecureHostKey() Option {
+	return func(o *options) {
+		o.insecureHostKey = true
+	}
+}
+
+// hostKeyCallback picks how the server's key is checked. Without a
+// known_hosts file there is nothing to check against, so that is an error
+// unless checking was disabled on purpose.
+func (o *options) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	switch {
+	case o.insecureHostKey:
+		// #nosec G106 -- Only reached through WithInsecureHostKey
+		return ssh.InsecureIgnoreHostKey(), nil
+	case o.knownHostsPath == "":
+		return nil, ErrNoHostKeyCheck
+	case o.strictHostKeys:
+		verify, err := knownhosts.New(o.knownHostsPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load known hosts %s: %w", o.knownHostsPath, err)
+		}
+
+		return verify, nil
+	default:
+		return trustOnFirstUse(o.knownHostsPath)
+	}
+}
+
+// trustOnFirstUse wraps the known_hosts callback so unknown hosts are
+// appended instead of rejected. Mismatched and revoked keys still fail.
+func trustOnFirstUse(path string) (ssh.HostKeyCallback, error) {
+	if err := ensureKnownHostsFile(path); err != nil {
+		return nil, err
+	}
+
+	verify, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known hosts %s: %w", path, err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := verify(hostname, remote, key)
+
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			fmt.Printf("Trusting new host key for %s: %s\n", hostname, ssh.FingerprintSHA256(key))
+
+			return appendKnownHost(path, hostname, key)
+		}
+
+		return err
+	}, nil
+}
+
+// AddKnownHost records authorizedKey (in authorized_keys format) as the host
+// key for host, replacing any key previously recorded for it.
+func AddKnownHost(path, host, authorizedKey string) error {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return fmt.Errorf("failed to parse host key: %w", err)
+	}
+
+	if err := ensureKnownHostsFile(path); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read known hosts %s: %w", path, err)
+	}
+
+	// A recreated droplet can reuse an IP, so drop stale entries for it
+	normalized := knownhosts.Normalize(host)
+
+	var kept []string
+
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == normalized {
+			continue
+		}
+
+		if line != "" {
+			kept = append(kept, line)
+		}
+	}
+
+	kept = append(kept, knownhosts.Line([]string{host}, key))
+
+	if err := os.WriteFile(path, []byte(strings.Join(kept, "\n")+"\n"), knownHostsFilePerm); err != nil {
+		return fmt.Errorf("failed to write known hosts %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// GenerateHostKey creates an ed25519 host key, returning the private key in
+// OpenSSH PEM format and the public key in authorized_keys format.
+func GenerateHostKey() (privateKey, publicKey string, err error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate host key: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode host key: %w", err)
+	}
+
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode host public key: %w", err)
+	}
+
+	return string(pem.EncodeToMemory(block)), strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublic))), nil
+}
+
+func appendKnownHost(path, hostname string, key ssh.PublicKey) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, knownHostsFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to open known hosts %s: %w", path, err)
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintln(file, knownhosts.Line([]string{hostname}, key)); err != nil {
+		return fmt.Errorf("failed to record host key in %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func ensureKnownHostsFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), knownHostsDirPerm); err != nil {
+		return fmt.Errorf("failed to create known hosts directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, knownHostsFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create known hosts %s: %w", path, err)
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,123 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const testHost = "203.0.113.10:22"
+
+var testAddr = &net.TCPAddr{IP: net.ParseIP("203.0.113.10"), Port: 22}
+
+func testHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func hostKeyCallback(t *testing.T, opts ...Option) ssh.HostKeyCallback {
+	t.Helper()
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	callback, err := o.hostKeyCallback()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return callback
+}
+
+func TestStrictHostKeysRejectAnUnknownHost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, nil, knownHostsFilePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := hostKeyCallback(t, WithKnownHosts(path), WithStrictHostKeys())
+
+	var keyErr *knownhosts.KeyError
+	if err := verify(testHost, testAddr, testHostKey(t)); !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+		t.Fatalf("err = %v, want an unknown host error", err)
+	}
+
+	if data, _ := os.ReadFile(path); len(data) > 0 {
+		t.Errorf("strict checking recorded the host:\n%s", data)
+	}
+}
+
+func TestStrictHostKeysNeedTheFile(t *testing.T) {
+	var o options
+	WithKnownHosts(filepath.Join(t.TempDir(), "missing"))(&o)
+	WithStrictHostKeys()(&o)
+
+	if _, err := o.hostKeyCallback(); err == nil {
+		t.Error("strict checking accepted a missing known_hosts file")
+	}
+}
+
+func TestTrustOnFirstUseRecordsTheHost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".ssh", "known_hosts")
+	key := testHostKey(t)
+
+	if err := hostKeyCallback(t, WithKnownHosts(path))(testHost, testAddr, key); err != nil {
+		t.Fatalf("the first connection was rejected: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := knownhosts.Line([]string{testHost}, key); strings.TrimSpace(string(data)) != want {
+		t.Fatalf("known_hosts = %q, want %q", data, want)
+	}
+
+	// Loaded again, as the next connection does
+	verify := hostKeyCallback(t, WithKnownHosts(path))
+
+	if err := verify(testHost, testAddr, key); err != nil {
+		t.Errorf("the recorded key was rejected: %v", err)
+	}
+
+	var keyErr *knownhosts.KeyError
+	if err := verify(testHost, testAddr, testHostKey(t)); !errors.As(err, &keyErr) || len(keyErr.Want) == 0 {
+		t.Errorf("err = %v, want a changed key to be rejected", err)
+	}
+}
+
+func TestInsecureHostKeyNeedsNoFile(t *testing.T) {
+	if err := hostKeyCallback(t, WithInsecureHostKey())(testHost, testAddr, testHostKey(t)); err != nil {
+		t.Errorf("the host key was checked: %v", err)
+	}
+}
+
+func TestHostKeyCheckNeedsAKnownHostsFile(t *testing.T) {
+	var o options
+
+	if _, err := o.hostKeyCallback(); !errors.Is(err, ErrNoHostKeyCheck) {
+		t.Errorf("err = %v, want ErrNoHostKeyCheck", err)
+	}
+}
@@ -0,0 +1,49 @@
+package ssh
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DefaultMaxOutput is how much of a command's output ExecuteCommand keeps
+// unless WithMaxOutput says otherwise.
+const DefaultMaxOutput = 1 << 20
+
+// cappedBuffer collects stdout and stderr up to limit bytes and counts the
+// rest, so a runaway command cannot exhaust the runner's memory. The session
+// copies both streams concurrently, hence the lock.
+type cappedBuffer struct {
+	mu      sync.Mutex
+	buf     []byte
+	limit   int
+	dropped int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if room := b.limit - len(b.buf); room > 0 {
+		kept := min(room, len(p))
+		b.buf = append(b.buf, p[:kept]...)
+		b.dropped += len(p) - kept
+	} else {
+		b.dropped += len(p)
+	}
+
+	// Reporting the full length keeps the remote side from seeing a short write
+	return len(p), nil
+}
+
+// String returns the kept output, followed by a marker when any was dropped.
+func (b *cappedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.dropped == 0 {
+		return string(b.buf)
+	}
+
+	return fmt.Sprintf("%s\n[output truncated: %d bytes over the %d byte limit omitted]\n",
+		b.buf, b.dropped, b.limit)
+}
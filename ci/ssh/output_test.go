@@ -0,0 +1,64 @@
+package ssh
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCappedBufferKeepsShortOutput(t *testing.T) {
+	buffer := &cappedBuffer{limit: 16}
+
+	if n, err := buffer.Write([]byte("hello")); n != 5 || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+
+	if got := buffer.String(); got != "hello" {
+		t.Errorf("String = %q, want %q", got, "hello")
+	}
+}
+
+func TestCappedBufferTruncatesOversizedOutput(t *testing.T) {
+	buffer := &cappedBuffer{limit: 8}
+
+	for _, chunk := range []string{"12345", "67890", "abcdef"} {
+		// Every write reports its full length, so the session keeps copying
+		if n, err := buffer.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+
+	got := buffer.String()
+
+	if !strings.HasPrefix(got, "12345678\n") {
+		t.Errorf("String = %q, want the first 8 bytes kept", got)
+	}
+
+	if !strings.Contains(got, "[output truncated: 8 bytes over the 8 byte limit omitted]") {
+		t.Errorf("String = %q, want a marker counting the 8 dropped bytes", got)
+	}
+}
+
+func TestCappedBufferConcurrentWrites(t *testing.T) {
+	buffer := &cappedBuffer{limit: 100}
+
+	var wg sync.WaitGroup
+
+	for range 2 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for range 100 {
+				_, _ = buffer.Write([]byte("x"))
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if len(buffer.buf) != 100 || buffer.dropped != 100 {
+		t.Errorf("kept %d and dropped %d bytes, want 100 and 100", len(buffer.buf), buffer.dropped)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/felipepimentel/n8n-digitalocean-cicd/ci/ssh"
+)
+
+const (
+	deployStatePath = "/opt/n8n/deploy-state.json"
+	shortDigestLen  = 12
+	n8nCompareURL   = "https://github.com/n8n-io/n8n/compare/n8n@%s...n8n@%s"
+)
+
+// deployState records what the last successful deploy put on the droplet.
+type deployState struct {
+	N8NVersion  string    `json:"n8nVersion"`
+	ImageRef    string    `json:"imageRef"`
+	ImageDigest string    `json:"imageDigest"`
+	ContentKey  string    `json:"contentKey,omitempty"`
+	Signature   string    `json:"signature,omitempty"`
+	ConfigHash  string    `json:"configHash,omitempty"`
+	DeployedAt  time.Time `json:"deployedAt"`
+
+	// Pre-deploy backups taken before this deploy replaced the previous one.
+	BackupPath     string `json:"backupPath,omitempty"`
+	BackupSnapshot string `json:"backupSnapshot,omitempty"`
+}
+
+// publishedImage identifies an image pushed by buildAndPushImage.
+type publishedImage struct {
+	ref     string
+	digest  string
+	version string
+
+	// signature is the cosign signature reference when SIGN_IMAGE is set.
+	signature string
+
+	// contentKey fingerprints the build inputs, see imageContentKey. It is
+	// empty for images deployed by reference.
+	contentKey string
+
+	// prebuilt is set for images the deploy command got by reference or
+	// from a build file rather than built in the same run.
+	prebuilt bool
+}
+
+// pinnedRef is the reference the compose file runs: the tag pinned to the
+// pushed digest, so a later push to the same tag can't change what runs.
+func (image *publishedImage) pinnedRef() string {
+	if image.digest == "" {
+		return image.ref
+	}
+
+	return image.ref + "@" + image.digest
+}
+
+// readDeployState loads the state recorded on the droplet, returning nil when
+// nothing has been deployed by this tool yet.
+func readDeployState(sshClient *ssh.Client) (*deployState, error) {
+	output, err := sshClient.ExecuteCommand(fmt.Sprintf("cat %s 2>/dev/null || true", deployStatePath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deploy state: %w", err)
+	}
+
+	if strings.TrimSpace(output) == "" {
+		return nil, nil
+	}
+
+	var state deployState
+	if err := json.Unmarshal([]byte(output), &state); err != nil {
+		return nil, fmt.Errorf("failed to parse deploy state: %w", err)
+	}
+
+	return &state, nil
+}
+
+func writeDeployState(sshClient *ssh.Client, state *deployState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode deploy state: %w", err)
+	}
+
+	command := fmt.Sprintf("cat > %s << 'EOF'\n%s\nEOF", deployStatePath, data)
+	if output, err := sshClient.ExecuteCommand(command); err != nil {
+		return fmt.Errorf("failed to write deploy state: %w\nOutput: %s", err, output)
+	}
+
+	return nil
+}
+
+// deployConfigHash fingerprints the rendered deployment script and Caddyfile.
+// Values the droplet generates itself, such as the database password, are
+// rendered as commands, so the hash only changes with the configuration. The
+// image is left out: its digest changes with every build, and deployUpToDate
+// compares it through the content key or digest instead.
+func deployConfigHash(config *Config) string {
+	normalized := *config
+	normalized.deployImage = ""
+
+	sum := sha256.Sum256([]byte(generateDeploymentScript(&normalized) + generateCaddyfile(&normalized)))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// deployUpToDate reports whether the last recorded deploy used the same
+// configuration and the same image: built from the same inputs when both
+// builds recorded a content key, or with the same digest otherwise.
+func deployUpToDate(previous *deployState, image *publishedImage, configHash string) bool {
+	if previous == nil || previous.ConfigHash != configHash {
+		return false
+	}
+
+	if image.contentKey != "" && previous.ContentKey != "" {
+		return previous.ContentKey == image.contentKey
+	}
+
+	return image.digest != "" && previous.ImageDigest == image.digest
+}
+
+// logDeployChangelog summarizes what this deploy changed compared to the
+// previously recorded one.
+func logDeployChangelog(previous *deployState, image *publishedImage) {
+	switch {
+	case previous == nil:
+		fmt.Printf("Changes since last deploy: first recorded deploy of n8n %s\n", image.version)
+	case previous.N8NVersion != image.version:
+		fmt.Printf("Changes since last deploy: n8n %s → %s\n", previous.N8NVersion, image.version)
+
+		if isPinnedVersion(previous.N8NVersion) && isPinnedVersion(image.version) {
+			fmt.Printf("Release notes: "+n8nCompareURL+"\n", previous.N8NVersion, image.version)
+		}
+	case previous.ImageDigest != "" && previous.ImageDigest != image.digest:
+		fmt.Printf("Changes since last deploy: n8n %s unchanged but the image moved (%s → %s)\n",
+			image.version, shortDigest(previous.ImageDigest), shortDigest(image.digest))
+	default:
+		fmt.Printf("Changes since last deploy: none (n8n %s, %s)\n", image.version, shortDigest(image.digest))
+	}
+}
+
+// isPinnedVersion reports whether a version is a release number rather than a
+// moving tag such as latest or next.
+func isPinnedVersion(version string) bool {
+	return version != "" && version[0] >= '0' && version[0] <= '9'
+}
+
+func shortDigest(digest string) string {
+	digest = strings.TrimPrefix(digest, "sha256:")
+	if len(digest) > shortDigestLen {
+		return digest[:shortDigestLen]
+	}
+
+	return digest
+}
+
+// imageDigest extracts the digest from a fully qualified ref as returned by
+// Publish, e.g. registry/name:tag@sha256:abc.
+func imageDigest(ref string) string {
+	if _, digest, found := strings.Cut(ref, "@"); found {
+		return digest
+	}
+
+	return ""
+}
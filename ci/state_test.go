@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDeployUpToDatePrefersTheContentKey(t *testing.T) {
+	previous := &deployState{ImageDigest: "sha256:old", ContentKey: "inputs", ConfigHash: "config"}
+
+	tests := []struct {
+		name  string
+		image publishedImage
+		hash  string
+		want  bool
+	}{
+		{"rebuilt from the same inputs", publishedImage{digest: "sha256:new", contentKey: "inputs"}, "config", true},
+		{"changed inputs", publishedImage{digest: "sha256:old", contentKey: "other"}, "config", false},
+		{"changed configuration", publishedImage{digest: "sha256:new", contentKey: "inputs"}, "other", false},
+		{"deployed by digest", publishedImage{digest: "sha256:old"}, "config", true},
+		{"deployed by another digest", publishedImage{digest: "sha256:new"}, "config", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := deployUpToDate(previous, &test.image, test.hash); got != test.want {
+				t.Errorf("deployUpToDate = %t, want %t", got, test.want)
+			}
+		})
+	}
+
+	if deployUpToDate(nil, &publishedImage{digest: "sha256:old"}, "config") {
+		t.Error("a first deploy is never up to date")
+	}
+}
+
+func TestDeployConfigHashIgnoresTheImage(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.deployImage = testRegistryURL + "/n8n:1.70.0@sha256:aaa"
+	hash := deployConfigHash(config)
+
+	config.deployImage = testRegistryURL + "/n8n:1.70.0@sha256:bbb"
+	if got := deployConfigHash(config); got != hash {
+		t.Errorf("deployConfigHash changed with the image digest: %s != %s", got, hash)
+	}
+
+	config.domain = "other.example.com"
+	if got := deployConfigHash(config); got == hash {
+		t.Error("deployConfigHash did not change with the domain")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const defaultN8NPath = "/"
+
+// n8nPathPattern is "/" or slash-separated URL-safe segments, each followed by
+// a slash.
+var n8nPathPattern = regexp.MustCompile(`^/([A-Za-z0-9._~-]+/)*$`)
+
+// normalizeN8NPath adds the trailing slash n8n expects, so N8N_PATH=/n8n and
+// N8N_PATH=/n8n/ behave alike.
+func normalizeN8NPath(path string) string {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return defaultN8NPath
+	}
+
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	return path
+}
+
+func validateN8NPath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("%w: N8N_PATH must start with /, got %q", ErrInvalidConfig, path)
+	}
+
+	if !n8nPathPattern.MatchString(path) {
+		return fmt.Errorf("%w: N8N_PATH must be a plain path such as /n8n/, got %q", ErrInvalidConfig, path)
+	}
+
+	return nil
+}
+
+// n8nBaseURL is the public URL of the editor, which webhooks are built on.
+func n8nBaseURL(config *Config) string {
+	return "https://" + config.domain + config.n8nPath
+}
+
+// caddySiteRoutes proxies the whole site to n8n, or with N8N_PATH only that
+// subpath: handle_path strips the prefix before proxying, since n8n serves
+// from / and only uses N8N_PATH to build its own links, and the bare prefix
+// is redirected to its slash form so relative asset URLs resolve.
+func caddySiteRoutes(proxy string, config *Config) string {
+	if config.n8nPath == defaultN8NPath {
+		return "    " + proxy
+	}
+
+	prefix := strings.TrimSuffix(config.n8nPath, "/")
+
+	return fmt.Sprintf(`    redir %[1]s %[1]s/ 308
+    handle_path %[1]s/* {
+        %[2]s
+    }`, prefix, strings.ReplaceAll(proxy, "\n", "\n    "))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeAndValidateN8NPath(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		valid bool
+	}{
+		{"", "/", true},
+		{"  ", "/", true},
+		{"/", "/", true},
+		{"/n8n", "/n8n/", true},
+		{"/n8n/", "/n8n/", true},
+		{" /tools/n8n ", "/tools/n8n/", true},
+		{"/n8n-1.0_beta~x/", "/n8n-1.0_beta~x/", true},
+		{"n8n", "n8n/", false},
+		{"//n8n", "//n8n/", false},
+		{"/n8n//", "/n8n//", false},
+		{"/n8n?x=1", "/n8n?x=1/", false},
+		{"/n 8n", "/n 8n/", false},
+		{"/n8n%20", "/n8n%20/", false},
+		{"https://example.com/n8n", "https://example.com/n8n/", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			path := normalizeN8NPath(test.input)
+			if path != test.want {
+				t.Errorf("normalizeN8NPath(%q) = %q, want %q", test.input, path, test.want)
+			}
+
+			err := validateN8NPath(path)
+			if test.valid && err != nil {
+				t.Errorf("validateN8NPath(%q) = %v, want nil", path, err)
+			}
+
+			if !test.valid && !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("validateN8NPath(%q) = %v, want ErrInvalidConfig", path, err)
+			}
+		})
+	}
+}
+
+func TestCaddySiteRoutes(t *testing.T) {
+	config := defaultTestConfig(t)
+	proxy := "reverse_proxy n8n:5678"
+
+	if routes := caddySiteRoutes(proxy, config); routes != "    "+proxy {
+		t.Errorf("without N8N_PATH the whole site is not proxied: %q", routes)
+	}
+
+	config.n8nPath = "/n8n/"
+
+	routes := caddySiteRoutes(proxy, config)
+	for _, want := range []string{"redir /n8n /n8n/ 308", "handle_path /n8n/* {", "        " + proxy} {
+		if !strings.Contains(routes, want) {
+			t.Errorf("routes missing %q:\n%s", want, routes)
+		}
+	}
+
+	if got := n8nBaseURL(config); got != "https://"+testDomain+"/n8n/" {
+		t.Errorf("n8nBaseURL() = %q", got)
+	}
+}
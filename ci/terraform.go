@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	vpcIPRange        = "192.168.32.0/24"
+	vpcDescription    = "VPC for n8n deployment"
+	dropletImageSlug  = "docker-20-04"
+	terraformFilePerm = 0o644
+
+	// terraformName is the local name of every exported resource.
+	terraformName = "n8n"
+)
+
+// runExportTerraform prints Terraform describing the resources a run would
+// create for the current configuration, as a starting point for managing
+// them declaratively. It is a template rather than an exact round trip: the
+// existing resources still have to be imported, for which each block carries
+// the command. Like render, nothing but Cloudflare's IP list is contacted.
+func runExportTerraform(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export-terraform", flag.ExitOnError)
+	out := flags.String("out", "", "write the configuration to this file instead of stdout")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config := loadConfig()
+
+	if err := usePlaceholderEncryptionKey(&config); err != nil {
+		return err
+	}
+
+	if err := validateConfig(&config); err != nil {
+		return err
+	}
+
+	if config.cloudflare {
+		var err error
+		if config.cloudflareRanges, err = fetchCloudflareRanges(ctx); err != nil {
+			return err
+		}
+	}
+
+	hcl, err := generateTerraform(&config)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		fmt.Print(hcl)
+
+		return nil
+	}
+
+	if err := os.WriteFile(*out, []byte(hcl), terraformFilePerm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", *out, err)
+	}
+
+	fmt.Printf("Wrote %s\n", *out)
+
+	return nil
+}
+
+func generateTerraform(config *Config) (string, error) {
+	var hcl strings.Builder
+
+	fmt.Fprintf(&hcl, "# Generated by export-terraform for DEPLOY_PREFIX=%s.\n", config.deployPrefix)
+	hcl.WriteString("# Import the existing resources with the commands above each block before the first apply.\n")
+	hcl.WriteString(terraformProviders(config))
+	hcl.WriteString(terraformNetwork(config))
+
+	firewall, err := terraformFirewall(config)
+	if err != nil {
+		return "", err
+	}
+
+	hcl.WriteString(firewall)
+	hcl.WriteString(terraformDNS(config))
+
+	return hcl.String(), nil
+}
+
+func terraformProviders(config *Config) string {
+	cloudflare := config.manageDNS && config.dnsProvider == dnsProviderCloudflare
+
+	providers := `
+terraform {
+  required_providers {
+    digitalocean = {
+      source  = "digitalocean/digitalocean"
+      version = "~> 2.0"
+    }
+`
+	if cloudflare {
+		providers += `    cloudflare = {
+      source  = "cloudflare/cloudflare"
+      version = "~> 4.0"
+    }
+`
+	}
+
+	providers += `  }
+}
+
+# Reads DIGITALOCEAN_ACCESS_TOKEN, like this tool
+provider "digitalocean" {}
+`
+	if cloudflare {
+		providers += `
+# Reads CLOUDFLARE_API_TOKEN, like this tool
+provider "cloudflare" {}
+`
+	}
+
+	return providers
+}
+
+// terraformNetwork covers the VPC, SSH key, data volume, droplet and reserved
+// IP assignment. The user data is the script render writes, so the template
+// stays in sync with what this tool would boot the droplet with.
+func terraformNetwork(config *Config) string {
+	var hcl strings.Builder
+
+	fmt.Fprintf(&hcl, `
+# terraform import digitalocean_vpc.%[1]s <vpc id>
+resource "digitalocean_vpc" "%[1]s" {
+  name        = %[2]s
+  region      = %[3]s
+  ip_range    = %[4]s
+  description = %[5]s
+}
+`, terraformName, hclString(config.resourceName(resourceVPC)), hclString(config.region), hclString(vpcIPRange),
+		hclString(vpcDescription))
+
+	sshKey := hclString(config.sshFingerprint)
+	if config.sshKeyName != "" {
+		fmt.Fprintf(&hcl, `
+data "digitalocean_ssh_key" "%s" {
+  name = %s
+}
+`, terraformName, hclString(config.sshKeyName))
+
+		sshKey = "data.digitalocean_ssh_key." + terraformName + ".id"
+	}
+
+	volumes := ""
+
+	if config.volumeSizeGB > 0 {
+		fmt.Fprintf(&hcl, `
+# terraform import digitalocean_volume.%[1]s <volume id>
+resource "digitalocean_volume" "%[1]s" {
+  region      = %[2]s
+  name        = %[3]s
+  size        = %[4]d
+  description = %[5]s
+  tags        = [%[6]s]
+}
+`, terraformName, hclString(config.region), hclString(dataVolumeName(config)), config.volumeSizeGB,
+			hclString("n8n data for "+config.domain), hclString(config.resourceName(resourceTag)))
+
+		volumes = fmt.Sprintf("\n  volume_ids = [digitalocean_volume.%s.id]", terraformName)
+	}
+
+	tags := hclList([]string{managedTag, environmentTag, config.resourceName(resourceTag)})
+
+	fmt.Fprintf(&hcl, `
+# terraform import digitalocean_droplet.%[1]s <droplet id>
+resource "digitalocean_droplet" "%[1]s" {
+  name       = %[2]s
+  region     = %[3]s
+  size       = %[4]s
+  image      = %[5]s
+  vpc_uuid   = digitalocean_vpc.%[1]s.id
+  ssh_keys   = [%[6]s]
+  monitoring = true
+  ipv6       = true
+  backups    = true
+  tags       = %[7]s%[8]s
+
+  # Write it with: render --out . (the first-boot script, without the host key this tool seeds)
+  user_data = file("${path.module}/user-data.sh")
+
+  lifecycle {
+    ignore_changes = [user_data]
+  }
+}
+`, terraformName, hclString(config.resourceName(resourceDroplet)), hclString(config.region),
+		hclString(config.dropletSize), hclString(dropletImageSlug), sshKey, tags, volumes)
+
+	if config.reservedIP != "" {
+		fmt.Fprintf(&hcl, `
+# terraform import digitalocean_reserved_ip_assignment.%[1]s %[2]s,<droplet id>
+resource "digitalocean_reserved_ip_assignment" "%[1]s" {
+  ip_address = %[3]s
+  droplet_id = digitalocean_droplet.%[1]s.id
+}
+`, terraformName, config.reservedIP, hclString(config.reservedIP))
+	}
+
+	return hcl.String()
+}
+
+func terraformFirewall(config *Config) (string, error) {
+	if config.firewallID != "" {
+		return fmt.Sprintf(`
+# FIREWALL_ID=%s is managed elsewhere; attach the droplet to it there.
+`, config.firewallID), nil
+	}
+
+	request, err := firewallRequest(config)
+	if err != nil {
+		return "", err
+	}
+
+	var hcl strings.Builder
+
+	fmt.Fprintf(&hcl, `
+# terraform import digitalocean_firewall.%[1]s <firewall id>
+resource "digitalocean_firewall" "%[1]s" {
+  name        = %[2]s
+  droplet_ids = [digitalocean_droplet.%[1]s.id]
+`, terraformName, hclString(request.Name))
+
+	for _, rule := range request.InboundRules {
+		fmt.Fprintf(&hcl, `
+  inbound_rule {
+    protocol         = %s%s
+    source_addresses = %s
+  }
+`, hclString(rule.Protocol), terraformPortRange(rule.Protocol, rule.PortRange, "       "), hclList(rule.Sources.Addresses))
+	}
+
+	for _, rule := range request.OutboundRules {
+		fmt.Fprintf(&hcl, `
+  outbound_rule {
+    protocol              = %s%s
+    destination_addresses = %s
+  }
+`, hclString(rule.Protocol), terraformPortRange(rule.Protocol, rule.PortRange, "            "), hclList(rule.Destinations.Addresses))
+	}
+
+	hcl.WriteString("}\n")
+
+	return hcl.String(), nil
+}
+
+// terraformPortRange renders the port_range line, padded to line up with the
+// rule's address attribute; icmp rules have none.
+func terraformPortRange(protocol, ports, padding string) string {
+	if protocol == "icmp" || ports == "" {
+		return ""
+	}
+
+	return "\n    port_range" + padding + "= " + hclString(ports)
+}
+
+// terraformDNS describes the zone and the A and AAAA records with the
+// configured DNS_PROVIDER. The A record follows the reserved IP when one is
+// set, as the deploy does.
+func terraformDNS(config *Config) string {
+	if !config.manageDNS {
+		return "\n# MANAGE_DNS=false: the domain's records are managed elsewhere.\n"
+	}
+
+	zone, parts := getDomainParts(config.domain)
+
+	name := "@"
+	if len(parts) > minDomainParts {
+		name = sanitizeRecordName(parts[0])
+	}
+
+	ipv4 := "digitalocean_droplet." + terraformName + ".ipv4_address"
+	if config.reservedIP != "" {
+		ipv4 = hclString(config.reservedIP)
+	}
+
+	records := []dnsTarget{{recordType: recordTypeA, ip: ipv4}}
+	if config.dnsIPv6 {
+		records = append(records, dnsTarget{recordType: recordTypeAAAA,
+			ip: "digitalocean_droplet." + terraformName + ".ipv6_address"})
+	}
+
+	var hcl strings.Builder
+
+	if config.dnsProvider == dnsProviderCloudflare {
+		fmt.Fprintf(&hcl, `
+data "cloudflare_zone" "%s" {
+  name = %s
+}
+`, terraformName, hclString(zone))
+
+		for _, record := range records {
+			label := strings.ToLower(record.recordType)
+
+			fmt.Fprintf(&hcl, `
+# terraform import cloudflare_record.%[1]s <zone id>/<record id>
+resource "cloudflare_record" "%[1]s" {
+  zone_id = data.cloudflare_zone.%[2]s.id
+  type    = %[3]s
+  name    = %[4]s
+  content = %[5]s
+  ttl     = %[6]d
+}
+`, label, terraformName, hclString(record.recordType), hclString(config.domain), record.ip, config.dnsTTL)
+		}
+
+		return hcl.String()
+	}
+
+	fmt.Fprintf(&hcl, `
+# terraform import digitalocean_domain.%[1]s %[2]s
+resource "digitalocean_domain" "%[1]s" {
+  name = %[3]s
+}
+`, terraformName, zone, hclString(zone))
+
+	for _, record := range records {
+		label := strings.ToLower(record.recordType)
+
+		fmt.Fprintf(&hcl, `
+# terraform import digitalocean_record.%[1]s %[2]s,<record id>
+resource "digitalocean_record" "%[1]s" {
+  domain = digitalocean_domain.%[3]s.id
+  type   = %[4]s
+  name   = %[5]s
+  value  = %[6]s
+  ttl    = %[7]d
+}
+`, label, zone, terraformName, hclString(record.recordType), hclString(name), record.ip, config.dnsTTL)
+	}
+
+	return hcl.String()
+}
+
+// hclString quotes value as an HCL string, escaping template sequences so it
+// is taken literally.
+func hclString(value string) string {
+	quoted := strconv.Quote(value)
+	quoted = strings.ReplaceAll(quoted, "${", "$${")
+
+	return strings.ReplaceAll(quoted, "%{", "%%{")
+}
+
+func hclList(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, hclString(value))
+	}
+
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
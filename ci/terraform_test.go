@@ -0,0 +1,161 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHCLString(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"n8n-production", `"n8n-production"`},
+		{"", `""`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\path`, `"C:\\path"`},
+		{"line\nbreak", `"line\nbreak"`},
+		{"${var.token}", `"$${var.token}"`},
+		{"%{if true}", `"%%{if true}"`},
+		{"$5 and 100%", `"$5 and 100%"`},
+	}
+
+	for _, test := range tests {
+		if got := hclString(test.value); got != test.want {
+			t.Errorf("hclString(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+}
+
+func TestHCLList(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   string
+	}{
+		{nil, "[]"},
+		{[]string{"0.0.0.0/0"}, `["0.0.0.0/0"]`},
+		{[]string{"0.0.0.0/0", "::/0"}, `["0.0.0.0/0", "::/0"]`},
+		{[]string{"${x}"}, `["$${x}"]`},
+	}
+
+	for _, test := range tests {
+		if got := hclList(test.values); got != test.want {
+			t.Errorf("hclList(%q) = %s, want %s", test.values, got, test.want)
+		}
+	}
+}
+
+func TestTerraformPortRange(t *testing.T) {
+	tests := []struct {
+		protocol, ports string
+		want            string
+	}{
+		{"tcp", "443", "\n    port_range = \"443\""},
+		{"udp", "1-65535", "\n    port_range = \"1-65535\""},
+		{"icmp", "", ""},
+		{"icmp", "0", ""},
+		{"tcp", "", ""},
+	}
+
+	for _, test := range tests {
+		if got := terraformPortRange(test.protocol, test.ports, " "); got != test.want {
+			t.Errorf("terraformPortRange(%q, %q) = %q, want %q", test.protocol, test.ports, got, test.want)
+		}
+	}
+}
+
+func TestTerraformDNS(t *testing.T) {
+	tests := []struct {
+		name       string
+		domain     string
+		provider   string
+		manageDNS  bool
+		reservedIP string
+		ipv6       bool
+		want       []string
+		unwanted   []string
+	}{
+		{
+			name:      "unmanaged",
+			domain:    testDomain,
+			provider:  dnsProviderDigitalOcean,
+			manageDNS: false,
+			want:      []string{"MANAGE_DNS=false"},
+			unwanted:  []string{"resource"},
+		},
+		{
+			name:      "subdomain on DigitalOcean",
+			domain:    testDomain,
+			provider:  dnsProviderDigitalOcean,
+			manageDNS: true,
+			want: []string{`resource "digitalocean_domain" "n8n"`, `name = "example.com"`, `name   = "n8n"`,
+				"value  = digitalocean_droplet.n8n.ipv4_address"},
+			unwanted: []string{`"AAAA"`},
+		},
+		{
+			name:      "apex with IPv6",
+			domain:    "example.com",
+			provider:  dnsProviderDigitalOcean,
+			manageDNS: true,
+			ipv6:      true,
+			want:      []string{`name   = "@"`, `type   = "AAAA"`, "value  = digitalocean_droplet.n8n.ipv6_address"},
+		},
+		{
+			name:       "reserved IP",
+			domain:     testDomain,
+			provider:   dnsProviderDigitalOcean,
+			manageDNS:  true,
+			reservedIP: "203.0.113.50",
+			want:       []string{`value  = "203.0.113.50"`},
+			unwanted:   []string{"ipv4_address"},
+		},
+		{
+			name:      "Cloudflare",
+			domain:    testDomain,
+			provider:  dnsProviderCloudflare,
+			manageDNS: true,
+			want: []string{`data "cloudflare_zone" "n8n"`, `resource "cloudflare_record" "a"`,
+				`name    = "` + testDomain + `"`},
+			unwanted: []string{"digitalocean_domain"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := defaultTestConfig(t)
+			config.domain = test.domain
+			config.dnsProvider = test.provider
+			config.manageDNS = test.manageDNS
+			config.reservedIP = test.reservedIP
+			config.dnsIPv6 = test.ipv6
+
+			hcl := terraformDNS(config)
+
+			for _, want := range test.want {
+				if !strings.Contains(hcl, want) {
+					t.Errorf("missing %q:\n%s", want, hcl)
+				}
+			}
+
+			for _, unwanted := range test.unwanted {
+				if strings.Contains(hcl, unwanted) {
+					t.Errorf("unexpected %q:\n%s", unwanted, hcl)
+				}
+			}
+		})
+	}
+}
+
+func TestTerraformFirewallLeavesAnExternalFirewallAlone(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.firewallID = "fw-123"
+
+	hcl, err := terraformFirewall(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(hcl, "resource") || !strings.Contains(hcl, "FIREWALL_ID=fw-123") {
+		t.Errorf("an external firewall was exported:\n%s", hcl)
+	}
+}
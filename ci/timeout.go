@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDeployTimeout = 30 * time.Minute
+
+	// abortGracePeriod is how long a timed-out run may take to unwind and
+	// run its cleanup before the process gives up on it.
+	abortGracePeriod = 30 * time.Second
+)
+
+var ErrDeployTimeout = errors.New("deploy timed out")
+
+// stepTracker records which pipeline step is in progress so a timeout can
+// report where the run was stuck.
+type stepTracker struct {
+	mu   sync.Mutex
+	step string
+}
+
+func (t *stepTracker) start(step string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.step = step
+	fmt.Printf("==> %s\n", step)
+}
+
+func (t *stepTracker) current() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.step
+}
+
+// runWithDeadline runs fn under DEPLOY_TIMEOUT. When the deadline passes, fn
+// gets abortGracePeriod to notice the cancelled context and clean up before
+// the timeout is reported regardless.
+func runWithDeadline(ctx context.Context, timeout time.Duration, fn func(ctx context.Context, steps *stepTracker) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var steps stepTracker
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- fn(ctx, &steps)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w after %s during %q: %w", ErrDeployTimeout, timeout, steps.current(), err)
+		}
+
+		return err
+	case <-ctx.Done():
+	}
+
+	timeoutErr := fmt.Errorf("%w after %s during %q", ErrDeployTimeout, timeout, steps.current())
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("%w: %w", timeoutErr, err)
+		}
+
+		return timeoutErr
+	case <-time.After(abortGracePeriod):
+		return timeoutErr
+	}
+}
+
+func validateDeployTimeout(timeout time.Duration) error {
+	if timeout < time.Minute {
+		return fmt.Errorf("%w: DEPLOY_TIMEOUT must be at least 1m, got %s", ErrInvalidConfig, timeout)
+	}
+
+	return nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/digitalocean/godo/metrics"
+)
+
+const (
+	defaultUsageWindow = 30 * time.Minute
+	percent            = 100
+)
+
+// resourceUsage is the droplet's average utilization over the window, in
+// percent, with disk usage per mount point at the end of it.
+type resourceUsage struct {
+	cpu    float64
+	memory float64
+	disks  map[string]float64
+}
+
+// runUsage compares the droplet's utilization over a recent window with the
+// ALERT_*_THRESHOLD values, suggesting a resize when the size looks too
+// small, and lists per-container usage from docker stats.
+func runUsage(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("usage", flag.ExitOnError)
+	window := flags.Duration("window", defaultUsageWindow, "how far back to average the droplet's utilization")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config := loadConfig()
+
+	stopAgent, err := prepareSSHKey(&config)
+	if err != nil {
+		return err
+	}
+	defer stopAgent()
+
+	return printDropletUsage(ctx, newDOClient(&config), &config, *window)
+}
+
+// reportUsage prints the droplet's usage after a deploy, so an undersized
+// droplet shows up without running usage. Failures are only reported, since
+// the deploy itself already succeeded.
+func reportUsage(ctx context.Context, client *godo.Client, config *Config) {
+	if !config.usageReport {
+		return
+	}
+
+	fmt.Println()
+
+	if err := printDropletUsage(ctx, client, config, defaultUsageWindow); err != nil {
+		fmt.Printf("Warning: failed to report resource usage: %v\n", err)
+	}
+}
+
+// printDropletUsage prints the droplet's utilization over window and its
+// containers' usage. The SSH key must already be set up.
+func printDropletUsage(ctx context.Context, client *godo.Client, config *Config, window time.Duration) error {
+	droplet, err := findDroplet(ctx, client, config.region, config.resourceName(resourceDroplet))
+	if err != nil {
+		return err
+	}
+
+	if droplet == nil {
+		return fmt.Errorf("%w: %s", ErrDropletNotFound, config.resourceName(resourceDroplet))
+	}
+
+	usage, err := dropletUsage(ctx, client, droplet.ID, window)
+	if err != nil {
+		return err
+	}
+
+	printUsage(droplet, usage, window, config)
+
+	dropletIP, err := droplet.PublicIPv4()
+	if err != nil {
+		return fmt.Errorf("failed to get droplet IP: %w", err)
+	}
+
+	sshClient, err := connectSSH(ctx, dropletIP, config.deploySSHUser, config)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSSHClient, err)
+	}
+	defer sshClient.Close()
+
+	stats, err := sshClient.ExecuteCommand(
+		`docker stats --no-stream --format "table {{.Name}}\t{{.CPUPerc}}\t{{.MemUsage}}\t{{.MemPerc}}"`)
+	if err != nil {
+		return fmt.Errorf("failed to read container stats: %w\nOutput: %s", err, stats)
+	}
+
+	fmt.Printf("\nContainers:\n%s", stats)
+
+	return nil
+}
+
+func dropletUsage(ctx context.Context, client *godo.Client, dropletID int, window time.Duration) (*resourceUsage, error) {
+	end := time.Now()
+	request := &godo.DropletMetricsRequest{HostID: fmt.Sprint(dropletID), Start: end.Add(-window), End: end}
+
+	cpu, _, err := client.Monitoring.GetDropletCPU(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get CPU metrics: %w", err)
+	}
+
+	total, _, err := client.Monitoring.GetDropletTotalMemory(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get memory metrics: %w", err)
+	}
+
+	available, _, err := client.Monitoring.GetDropletAvailableMemory(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get memory metrics: %w", err)
+	}
+
+	size, _, err := client.Monitoring.GetDropletFilesystemSize(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disk metrics: %w", err)
+	}
+
+	free, _, err := client.Monitoring.GetDropletFilesystemFree(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disk metrics: %w", err)
+	}
+
+	return &resourceUsage{
+		cpu:    cpuUtilization(cpu.Data.Result),
+		memory: usedPercent(averageValue(available.Data.Result), averageValue(total.Data.Result)),
+		disks:  diskUtilization(free.Data.Result, size.Data.Result),
+	}, nil
+}
+
+// cpuUtilization derives busy time from the per-mode CPU counters: the share
+// of all CPU seconds in the window not spent idle.
+func cpuUtilization(streams []metrics.SampleStream) float64 {
+	var idle, all float64
+
+	for _, stream := range streams {
+		if len(stream.Values) < 2 {
+			continue
+		}
+
+		elapsed := float64(stream.Values[len(stream.Values)-1].Value - stream.Values[0].Value)
+		all += elapsed
+
+		if stream.Metric["mode"] == "idle" {
+			idle += elapsed
+		}
+	}
+
+	if all == 0 {
+		return -1
+	}
+
+	return (1 - idle/all) * percent
+}
+
+// averageValue averages every sample of every stream, or returns -1 without
+// any.
+func averageValue(streams []metrics.SampleStream) float64 {
+	var sum float64
+
+	count := 0
+
+	for _, stream := range streams {
+		for _, sample := range stream.Values {
+			sum += float64(sample.Value)
+			count++
+		}
+	}
+
+	if count == 0 {
+		return -1
+	}
+
+	return sum / float64(count)
+}
+
+// diskUtilization returns the used share of each mount point at the end of
+// the window.
+func diskUtilization(free, size []metrics.SampleStream) map[string]float64 {
+	sizes := map[string]float64{}
+
+	for _, stream := range size {
+		if len(stream.Values) > 0 {
+			sizes[string(stream.Metric["mountpoint"])] = float64(stream.Values[len(stream.Values)-1].Value)
+		}
+	}
+
+	disks := map[string]float64{}
+
+	for _, stream := range free {
+		mountpoint := string(stream.Metric["mountpoint"])
+		if len(stream.Values) == 0 || sizes[mountpoint] == 0 {
+			continue
+		}
+
+		disks[mountpoint] = usedPercent(float64(stream.Values[len(stream.Values)-1].Value), sizes[mountpoint])
+	}
+
+	return disks
+}
+
+// usedPercent returns the used share of total, or -1 when either value is
+// unknown (negative) or total is zero.
+func usedPercent(available, total float64) float64 {
+	if available < 0 || total <= 0 {
+		return -1
+	}
+
+	return (1 - available/total) * percent
+}
+
+func printUsage(droplet *godo.Droplet, usage *resourceUsage, window time.Duration, config *Config) {
+	fmt.Printf("Droplet %s (%s), average over the last %s:\n", droplet.Name, droplet.SizeSlug, window)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, tabwriterWidth, ' ', 0)
+
+	var warnings []string
+
+	report := func(label string, value float64, threshold int) {
+		if value < 0 {
+			fmt.Fprintf(writer, "%s\tno data\n", label)
+
+			return
+		}
+
+		fmt.Fprintf(writer, "%s\t%.1f%%\n", label, value)
+
+		if value > float64(threshold) {
+			warnings = append(warnings, fmt.Sprintf("%s is %.1f%%, above %d%%", label, value, threshold))
+		}
+	}
+
+	report("CPU", usage.cpu, config.alertCPUThreshold)
+	report("Memory", usage.memory, config.alertMemoryThreshold)
+
+	mountpoints := make([]string, 0, len(usage.disks))
+	for mountpoint := range usage.disks {
+		mountpoints = append(mountpoints, mountpoint)
+	}
+
+	sort.Strings(mountpoints)
+
+	for _, mountpoint := range mountpoints {
+		report("Disk "+mountpoint, usage.disks[mountpoint], config.alertDiskThreshold)
+	}
+
+	writer.Flush()
+
+	if usage.cpu < 0 && usage.memory < 0 {
+		fmt.Println("No monitoring data for the window; the DigitalOcean metrics agent may not be running yet")
+	}
+
+	for _, warning := range warnings {
+		fmt.Printf("Warning: %s; consider a larger DROPLET_SIZE than %s\n", warning, droplet.SizeSlug)
+	}
+}
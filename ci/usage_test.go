@@ -0,0 +1,116 @@
+package main
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/digitalocean/godo/metrics"
+)
+
+// stream builds a sample stream with one label and the given values.
+func stream(label, value string, values ...float64) metrics.SampleStream {
+	samples := make([]metrics.SamplePair, 0, len(values))
+	for i, v := range values {
+		samples = append(samples, metrics.SamplePair{Timestamp: metrics.Time(i), Value: metrics.SampleValue(v)})
+	}
+
+	return metrics.SampleStream{Metric: metrics.Metric{metrics.LabelName(label): metrics.LabelValue(value)}, Values: samples}
+}
+
+func TestCPUUtilization(t *testing.T) {
+	tests := []struct {
+		name    string
+		streams []metrics.SampleStream
+		want    float64
+	}{
+		{"no data", nil, -1},
+		{"single sample", []metrics.SampleStream{stream("mode", "idle", 100)}, -1},
+		{"all idle", []metrics.SampleStream{stream("mode", "idle", 100, 160)}, 0},
+		{
+			"quarter busy",
+			[]metrics.SampleStream{
+				stream("mode", "idle", 100, 145),
+				stream("mode", "user", 10, 20),
+				stream("mode", "system", 5, 10),
+			},
+			25,
+		},
+		{"never idle", []metrics.SampleStream{stream("mode", "user", 0, 60)}, 100},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := cpuUtilization(test.streams); got != test.want {
+				t.Errorf("cpuUtilization() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDiskUtilization(t *testing.T) {
+	tests := []struct {
+		name string
+		free []metrics.SampleStream
+		size []metrics.SampleStream
+		want map[string]float64
+	}{
+		{"no data", nil, nil, map[string]float64{}},
+		{
+			"last sample per mount point",
+			[]metrics.SampleStream{stream("mountpoint", "/", 80, 60), stream("mountpoint", "/mnt/data", 25)},
+			[]metrics.SampleStream{stream("mountpoint", "/", 100, 100), stream("mountpoint", "/mnt/data", 100)},
+			map[string]float64{"/": 40, "/mnt/data": 75},
+		},
+		{
+			"no size for the mount point",
+			[]metrics.SampleStream{stream("mountpoint", "/", 50)},
+			[]metrics.SampleStream{stream("mountpoint", "/boot", 100)},
+			map[string]float64{},
+		},
+		{
+			"no free samples",
+			[]metrics.SampleStream{stream("mountpoint", "/")},
+			[]metrics.SampleStream{stream("mountpoint", "/", 100)},
+			map[string]float64{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := diskUtilization(test.free, test.size); !maps.Equal(got, test.want) {
+				t.Errorf("diskUtilization() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUsedPercent(t *testing.T) {
+	tests := []struct {
+		name             string
+		available, total float64
+		want             float64
+	}{
+		{"half used", 1024, 2048, 50},
+		{"all free", 2048, 2048, 0},
+		{"none free", 0, 2048, 100},
+		{"no total", 1024, 0, -1},
+		{"unknown total", 1024, -1, -1},
+		{"unknown available", -1, 2048, -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := usedPercent(test.available, test.total); got != test.want {
+				t.Errorf("usedPercent(%v, %v) = %v, want %v", test.available, test.total, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMissingMemorySeriesIsUnknown(t *testing.T) {
+	total := []metrics.SampleStream{stream("host_id", "1", 2048, 2048)}
+
+	if got := usedPercent(averageValue(nil), averageValue(total)); got != -1 {
+		t.Errorf("memory without available samples = %v, want -1 (no data)", got)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/felipepimentel/n8n-digitalocean-cicd/ci/ssh"
+)
+
+// readRunningN8NVersion asks the running n8n container for its version,
+// returning an empty string when n8n is not running.
+func readRunningN8NVersion(sshClient *ssh.Client, config *Config) (string, error) {
+	command := fmt.Sprintf(`N8N=$(docker ps -q %s | head -n 1)
+if [ -n "$N8N" ]; then
+	docker exec "$N8N" n8n --version
+fi`, serviceContainerFilter(config, "n8n"))
+
+	output, err := sshClient.ExecuteCommand(command)
+	if err != nil {
+		return "", fmt.Errorf("failed to read running n8n version: %w\nOutput: %s", err, output)
+	}
+
+	return strings.TrimSpace(output), nil
+}
+
+// logVersionChange reports whether the deploy upgrades, downgrades or keeps
+// the running n8n version. n8n cannot migrate its database schema back, so
+// downgrades get a warning.
+func logVersionChange(running, target string) {
+	if running == "" {
+		fmt.Printf("n8n is not running yet, deploying %s\n", target)
+
+		return
+	}
+
+	cmp, ok := compareVersions(running, target)
+
+	switch {
+	case !ok:
+		fmt.Printf("Running n8n %s, deploying %s\n", running, target)
+	case cmp < 0:
+		fmt.Printf("Upgrading n8n %s → %s\n", running, target)
+	case cmp > 0:
+		fmt.Printf("WARNING: downgrading n8n %s → %s. n8n does not support database schema downgrades; "+
+			"restore a pre-upgrade backup if the older version fails to start.\n", running, target)
+	default:
+		fmt.Printf("n8n %s is already running, redeploying the same version\n", running)
+	}
+}
+
+// compareVersions compares dotted release numbers, returning false when
+// either side is a tag such as latest.
+func compareVersions(a, b string) (int, bool) {
+	aParts, aOK := parseVersion(a)
+	bParts, bOK := parseVersion(b)
+
+	if !aOK || !bOK {
+		return 0, false
+	}
+
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var x, y int
+		if i < len(aParts) {
+			x = aParts[i]
+		}
+
+		if i < len(bParts) {
+			y = bParts[i]
+		}
+
+		if x != y {
+			if x < y {
+				return -1, true
+			}
+
+			return 1, true
+		}
+	}
+
+	return 0, true
+}
+
+func parseVersion(version string) ([]int, bool) {
+	fields := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	parts := make([]int, 0, len(fields))
+
+	for _, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, false
+		}
+
+		parts = append(parts, n)
+	}
+
+	return parts, true
+}
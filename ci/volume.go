@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	// volumeMountPath holds docker's named volumes, so n8n, Postgres and
+	// Caddy data all live on the block volume.
+	volumeMountPath  = "/var/lib/docker/volumes"
+	maxVolumeSizeGB  = 16384
+	blkidNoSignature = 2
+)
+
+// ensureDataVolume returns the data volume, creating an unformatted one when
+// none exists. Formatting is left to the droplet so an existing filesystem is
+// never touched.
+func ensureDataVolume(ctx context.Context, client *godo.Client, config *Config) (*godo.Volume, error) {
+	name := dataVolumeName(config)
+
+	volumes, _, err := client.Storage.ListVolumes(ctx, &godo.ListVolumeParams{Name: name, Region: config.region})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+
+	if len(volumes) > 0 {
+		return &volumes[0], nil
+	}
+
+	volume, _, err := client.Storage.CreateVolume(ctx, &godo.VolumeCreateRequest{
+		Region:        config.region,
+		Name:          name,
+		Description:   "n8n data for " + config.domain,
+		SizeGigaBytes: int64(config.volumeSizeGB),
+		Tags:          []string{config.resourceName(resourceTag)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create volume %s: %w", name, err)
+	}
+
+	fmt.Printf("Created %dGB volume %s\n", config.volumeSizeGB, name)
+
+	return volume, nil
+}
+
+// warnUnattachedVolume flags an existing droplet that predates VOLUME_SIZE_GB.
+// Mounting over the docker volume directory of a running droplet would hide
+// its data, so the volume is only ever attached at creation.
+func warnUnattachedVolume(droplet *godo.Droplet, volume *godo.Volume) {
+	if !slices.Contains(droplet.VolumeIDs, volume.ID) {
+		fmt.Printf("Warning: volume %s is not attached to droplet %s; it is only attached when the droplet is created\n",
+			volume.Name, droplet.Name)
+	}
+}
+
+// dataVolumeName lowercases the resource name, as volume names may not
+// contain capitals.
+func dataVolumeName(config *Config) string {
+	return strings.ToLower(config.resourceName(resourceVolume))
+}
+
+// generateVolumeMountScript mounts the data volume through /etc/fstab. The
+// device is only formatted when blkid finds no signature at all, so a droplet
+// rebuilt against an existing volume keeps its data.
+func generateVolumeMountScript(config *Config) string {
+	if config.volumeSizeGB == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(`
+# Mount the data volume
+VOLUME_DEVICE=/dev/disk/by-id/scsi-0DO_Volume_%[1]s
+for i in $(seq 1 30); do
+	[ -e "$VOLUME_DEVICE" ] && break
+	sleep 2
+done
+
+if [ ! -e "$VOLUME_DEVICE" ]; then
+	echo "Data volume $VOLUME_DEVICE not found" >&2
+	exit 1
+fi
+
+# blkid exits %[3]d only when the device carries no signature at all
+blkid_status=0
+blkid -p "$VOLUME_DEVICE" >/dev/null 2>&1 || blkid_status=$?
+if [ "$blkid_status" -eq %[3]d ]; then
+	echo "Formatting blank data volume"
+	mkfs.ext4 -q "$VOLUME_DEVICE"
+elif [ "$blkid_status" -ne 0 ]; then
+	echo "blkid failed on $VOLUME_DEVICE (exit $blkid_status), refusing to format" >&2
+	exit 1
+fi
+
+VOLUME_UUID=$(blkid -s UUID -o value "$VOLUME_DEVICE")
+VOLUME_FSTYPE=$(blkid -s TYPE -o value "$VOLUME_DEVICE")
+if ! grep -q "^UUID=$VOLUME_UUID " /etc/fstab; then
+	echo "UUID=$VOLUME_UUID %[2]s $VOLUME_FSTYPE defaults,nofail,discard 0 2" >> /etc/fstab
+fi
+
+if ! mountpoint -q %[2]s; then
+	systemctl stop docker.socket docker
+	mkdir -p %[2]s
+	mount %[2]s
+	systemctl start docker
+fi
+`, dataVolumeName(config), volumeMountPath, blkidNoSignature)
+}
+
+func validateVolumeConfig(config *Config) error {
+	if config.volumeSizeGB < 0 || config.volumeSizeGB > maxVolumeSizeGB {
+		return fmt.Errorf("%w: VOLUME_SIZE_GB must be between 0 and %d, got %d",
+			ErrInvalidConfig, maxVolumeSizeGB, config.volumeSizeGB)
+	}
+
+	return nil
+}
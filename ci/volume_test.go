@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// runVolumeMountScript runs the mount script against a stand-in device and
+// fstab, with blkid reporting blkidStatus for the signature probe. It returns
+// whether the device was formatted, the fstab and the script's error.
+func runVolumeMountScript(t *testing.T, blkidStatus int) (bool, string, error) {
+	t.Helper()
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not available")
+	}
+
+	config := defaultTestConfig(t)
+	config.volumeSizeGB = 10
+
+	dir := t.TempDir()
+	device := filepath.Join(dir, "device")
+	fstab := filepath.Join(dir, "fstab")
+	formatted := filepath.Join(dir, "formatted")
+	bin := filepath.Join(dir, "bin")
+
+	for path, content := range map[string]string{device: "", fstab: "# fstab\n"} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stubs := map[string]string{
+		"blkid": `case "$1" in
+-p) exit ` + strconv.Itoa(blkidStatus) + ` ;;
+-s) [ "$2" = UUID ] && echo 1234-abcd || echo ext4 ;;
+esac`,
+		"mkfs.ext4":  `touch ` + formatted,
+		"mountpoint": `exit 0`,
+	}
+
+	if err := os.Mkdir(bin, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, body := range stubs {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+body+"\n"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	script := generateVolumeMountScript(config)
+	script = strings.ReplaceAll(script, "/dev/disk/by-id/scsi-0DO_Volume_"+dataVolumeName(config), device)
+	script = strings.ReplaceAll(script, "/etc/fstab", fstab)
+
+	cmd := exec.Command(bash, "-e", "-c", script)
+	cmd.Env = append(os.Environ(), "PATH="+bin+":"+os.Getenv("PATH"))
+	output, runErr := cmd.CombinedOutput()
+
+	if runErr != nil {
+		t.Logf("script output:\n%s", output)
+	}
+
+	_, statErr := os.Stat(formatted)
+
+	entries, err := os.ReadFile(fstab)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return statErr == nil, string(entries), runErr
+}
+
+func TestVolumeMountFormatsOnlyABlankVolume(t *testing.T) {
+	formatted, fstab, err := runVolumeMountScript(t, blkidNoSignature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !formatted {
+		t.Error("a blank volume was not formatted")
+	}
+
+	want := "UUID=1234-abcd " + volumeMountPath + " ext4 defaults,nofail,discard 0 2"
+	if !strings.Contains(fstab, want) {
+		t.Errorf("fstab has no %q:\n%s", want, fstab)
+	}
+
+	formatted, fstab, err = runVolumeMountScript(t, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if formatted {
+		t.Error("a volume with a filesystem was formatted")
+	}
+
+	if strings.Count(fstab, "UUID=") != 1 {
+		t.Errorf("fstab should have one entry:\n%s", fstab)
+	}
+}
+
+func TestVolumeMountRefusesWhenBlkidFails(t *testing.T) {
+	formatted, _, err := runVolumeMountScript(t, 4)
+	if err == nil {
+		t.Fatal("the script succeeded although blkid failed")
+	}
+
+	if formatted {
+		t.Error("the volume was formatted although blkid failed")
+	}
+}
+
+func TestNoVolumeMountWithoutVolumeSize(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.volumeSizeGB = 0
+
+	if script := generateVolumeMountScript(config); script != "" {
+		t.Errorf("mount script without VOLUME_SIZE_GB:\n%s", script)
+	}
+}
+
+func TestEnsureDataVolumeReusesAnExistingVolume(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.volumeSizeGB = 10
+
+	fake, client := newFakeDO(t, config, map[string]string{
+		"GET /v2/volumes": `{"volumes":[{"id":"vol-1","name":"` + dataVolumeName(config) + `"}]}`,
+	})
+
+	volume, err := ensureDataVolume(context.Background(), client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if volume.ID != "vol-1" {
+		t.Errorf("volume = %s, want the existing vol-1", volume.ID)
+	}
+
+	if mutations := fake.mutations(); len(mutations) > 0 {
+		t.Errorf("requests = %v, want none", mutations)
+	}
+}
+
+func TestEnsureDataVolumeCreatesAMissingVolume(t *testing.T) {
+	config := defaultTestConfig(t)
+	config.volumeSizeGB = 10
+
+	fake, client := newFakeDO(t, config, map[string]string{
+		"GET /v2/volumes":  `{"volumes":[]}`,
+		"POST /v2/volumes": `{"volume":{"id":"vol-2"}}`,
+	})
+
+	if _, err := ensureDataVolume(context.Background(), client, config); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(fake.mutations(), []string{"POST /v2/volumes"}) {
+		t.Errorf("requests = %v, want the volume created", fake.mutations())
+	}
+
+	if body := fake.body("POST /v2/volumes"); !strings.Contains(body, `"size_gigabytes":10`) ||
+		!strings.Contains(body, `"region":"`+config.region+`"`) {
+		t.Errorf("create request = %s", body)
+	}
+}
+
+func TestValidateVolumeConfig(t *testing.T) {
+	for _, size := range []int{-1, maxVolumeSizeGB + 1} {
+		config := defaultTestConfig(t)
+		config.volumeSizeGB = size
+
+		if err := validateVolumeConfig(config); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("VOLUME_SIZE_GB=%d: err = %v, want ErrInvalidConfig", size, err)
+		}
+	}
+}